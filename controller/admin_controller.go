@@ -3,19 +3,24 @@ package controller
 import (
 	"archive/zip"
 	"bytes"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
 	"shareway/helper"
 	"shareway/middleware"
+	"shareway/repository"
 	"shareway/schemas"
 	"shareway/service"
 	"shareway/util"
+	"shareway/util/statemachine"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 // AdminController handles authentication-related requests
@@ -27,10 +32,11 @@ type AdminController struct {
 	MapService     service.IMapService
 	VehicleService service.IVehicleService
 	UserService    service.IUsersService
+	SafetyService  service.ISafetyService
 }
 
 // NewAdminController creates a new AdminController instance
-func NewAdminController(cfg util.Config, validate *validator.Validate, adminService service.IAdminService, rideService service.IRideService, mapService service.IMapService, vehicleService service.IVehicleService, userService service.IUsersService) *AdminController {
+func NewAdminController(cfg util.Config, validate *validator.Validate, adminService service.IAdminService, rideService service.IRideService, mapService service.IMapService, vehicleService service.IVehicleService, userService service.IUsersService, safetyService service.ISafetyService) *AdminController {
 	return &AdminController{
 		cfg:            cfg,
 		validate:       validate,
@@ -39,6 +45,7 @@ func NewAdminController(cfg util.Config, validate *validator.Validate, adminServ
 		MapService:     mapService,
 		VehicleService: vehicleService,
 		UserService:    userService,
+		SafetyService:  safetyService,
 	}
 }
 
@@ -1157,6 +1164,7 @@ func (ac *AdminController) GetRideList(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 
@@ -1748,3 +1756,384 @@ func (ac *AdminController) GetReportDetails(ctx *gin.Context) {
 	ctx.Header("Content-Disposition", "attachment; filename="+zipFileName)
 	ctx.Data(http.StatusOK, "application/zip", zipBuffer.Bytes())
 }
+
+// GetRideTimeline returns the timeline of any ride
+// @Summary Get the timeline of a ride
+// @Description Get every event of a ride in chronological order (status changes, actors, locations and reasons)
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param rideID query string true "Ride ID"
+// @Success 200 {object} helper.Response{data=schemas.GetRideTimelineResponse} "Successfully got ride timeline"
+// @Failure 400 {object} helper.Response "Bad request"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /admin/get-ride-timeline [get]
+func (ac *AdminController) GetRideTimeline(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToAdminPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	log.Info().Msgf("Admin ID: %s", data.AdminID)
+
+	var req schemas.GetRideTimelineRequest
+
+	// Bind request to struct
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ac.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	rideID, err := uuid.Parse(req.RideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid ride ID format",
+			"Định dạng ID chuyến đi không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	ride, err := ac.RideService.GetRideByID(rideID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		response := helper.ErrorResponseWithMessage(err, "Ride not found", "Không tìm thấy chuyến đi")
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to get ride details", "Không thể lấy thông tin chuyến đi")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	events, err := ac.RideService.GetRideTimeline(rideID)
+	if errors.Is(err, repository.ErrRideNotFound) {
+		response := helper.ErrorResponseWithMessage(err, "Ride not found", "Không tìm thấy chuyến đi")
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to get ride timeline", "Không thể lấy lịch sử chuyến đi")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.GetRideTimelineResponse{
+		RideID: ride.ID,
+		Status: ride.Status,
+		Events: events,
+	}
+
+	response := helper.SuccessResponse(res, "Successfully got ride timeline", "Lấy lịch sử chuyến đi thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// GetRideReplay returns the path actually taken by the driver during any ride
+// @Summary Replay a ride
+// @Description Get the planned route of a ride and the path actually taken by the driver as encoded polylines
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param rideID query string true "Ride ID"
+// @Success 200 {object} helper.Response{data=schemas.GetRideReplayResponse} "Successfully got ride replay"
+// @Failure 400 {object} helper.Response "Bad request"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /admin/get-ride-replay [get]
+func (ac *AdminController) GetRideReplay(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToAdminPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	log.Info().Msgf("Admin ID: %s", data.AdminID)
+
+	var req schemas.GetRideReplayRequest
+
+	// Bind request to struct
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ac.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	rideID, err := uuid.Parse(req.RideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid ride ID format",
+			"Định dạng ID chuyến đi không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	ride, err := ac.RideService.GetRideByID(rideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to get ride details", "Không thể lấy thông tin chuyến đi")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res, err := ac.RideService.GetRideReplay(ride)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to get ride replay", "Không thể lấy lộ trình chuyến đi")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(res, "Successfully got ride replay", "Lấy lộ trình chuyến đi thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// GetSafetyAlertList returns the safety alerts raised on the ongoing rides, the SOS alerts first then the newest
+// @Summary Get the list of safety alerts
+// @Description Get the safety alerts raised when a driver left the planned route or stopped for too long and the high priority SOS alerts, filter by status to get the escalated alerts
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param page query int true "Page number"
+// @Param limit query int true "Number of alerts per page"
+// @Param status query []string false "Alert status (pending, safe, escalated, resolved)"
+// @Success 200 {object} helper.Response{data=schemas.SafetyAlertListResponse} "Successfully got safety alert list"
+// @Failure 400 {object} helper.Response "Bad request"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /admin/get-safety-alert-list [get]
+func (ac *AdminController) GetSafetyAlertList(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToAdminPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	log.Info().Msgf("Admin ID: %s", data.AdminID)
+
+	var req schemas.SafetyAlertListRequest
+
+	// Bind request to struct
+	if err := ctx.ShouldBind(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ac.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	alerts, totalAlerts, totalPages, err := ac.SafetyService.GetSafetyAlertList(req)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to get safety alert list", "Không thể lấy danh sách cảnh báo an toàn")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	alertDetails := make([]schemas.SafetyAlertDetail, 0, len(alerts))
+	for _, alert := range alerts {
+		driver := alert.Ride.RideOffer.User
+		alertDetails = append(alertDetails, schemas.SafetyAlertDetail{
+			ID:       alert.ID,
+			RideID:   alert.RideID,
+			Type:     alert.Type,
+			Priority: alert.Priority,
+			Status:   alert.Status,
+			User: schemas.UserInfo{
+				ID:            alert.User.ID,
+				PhoneNumber:   alert.User.PhoneNumber,
+				FullName:      alert.User.FullName,
+				AvatarURL:     alert.User.AvatarURL,
+				AverageRating: alert.User.AverageRating,
+				Gender:        alert.User.Gender,
+				IsMomoLinked:  alert.User.IsMomoLinked,
+				BalanceInApp:  alert.User.BalanceInApp,
+			},
+			Driver: schemas.UserInfo{
+				ID:            driver.ID,
+				PhoneNumber:   driver.PhoneNumber,
+				FullName:      driver.FullName,
+				AvatarURL:     driver.AvatarURL,
+				AverageRating: driver.AverageRating,
+				Gender:        driver.Gender,
+				IsMomoLinked:  driver.IsMomoLinked,
+				BalanceInApp:  driver.BalanceInApp,
+			},
+			Latitude:    alert.Latitude,
+			Longitude:   alert.Longitude,
+			Distance:    alert.Distance,
+			CreatedAt:   alert.CreatedAt,
+			RespondedAt: alert.RespondedAt,
+			EscalatedAt: alert.EscalatedAt,
+			ResolvedBy:  alert.ResolvedBy,
+			Note:        alert.Note,
+		})
+	}
+
+	res := schemas.SafetyAlertListResponse{
+		Alerts:      alertDetails,
+		TotalAlerts: totalAlerts,
+		TotalPages:  totalPages,
+		Limit:       req.Limit,
+		CurrentPage: req.Page,
+	}
+
+	response := helper.SuccessResponse(res, "Safety alert list retrieved successfully", "Lấy danh sách cảnh báo an toàn thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// ResolveSafetyAlert closes a safety alert once an admin has handled it
+// @Summary Resolve a safety alert
+// @Description Mark a pending or escalated safety alert as resolved with a note of what was done
+// @Tags admin
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.ResolveSafetyAlertRequest true "Resolve safety alert request"
+// @Success 200 {object} helper.Response "Successfully resolved safety alert"
+// @Failure 400 {object} helper.Response "Bad request"
+// @Failure 404 {object} helper.Response "Safety alert not found"
+// @Failure 409 {object} helper.Response "Safety alert is already closed"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /admin/resolve-safety-alert [post]
+func (ac *AdminController) ResolveSafetyAlert(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToAdminPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.ResolveSafetyAlertRequest
+
+	// Bind request to struct
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ac.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	_, err = ac.SafetyService.ResolveSafetyAlert(req, data.AdminID)
+	if errors.Is(err, repository.ErrSafetyAlertNotFound) {
+		response := helper.ErrorResponseWithMessage(err, "Safety alert not found", "Không tìm thấy cảnh báo an toàn")
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(err, "Safety alert is already closed", "Cảnh báo an toàn đã được đóng")
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(err, "Failed to resolve safety alert", "Không thể xử lý cảnh báo an toàn")
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(nil, "Safety alert resolved successfully", "Đã xử lý cảnh báo an toàn thành công")
+	helper.GinResponse(ctx, 200, response)
+}
@@ -469,20 +469,24 @@ func (ctrl *AuthController) VerifyCCCD(ctx *gin.Context) {
 
 	res := schemas.VerifyCCCDResponse{
 		User: schemas.UserResponse{
-			ID:            user.ID,
-			AvatarURL:     user.AvatarURL,
-			CreatedAt:     user.CreatedAt,
-			UpdatedAt:     user.UpdatedAt,
-			PhoneNumber:   user.PhoneNumber,
-			Email:         user.Email,
-			FullName:      user.FullName,
-			IsVerified:    user.IsVerified,
-			IsActivated:   user.IsActivated,
-			Role:          user.Role,
-			Gender:        user.Gender,
-			IsMomoLinked:  user.IsMomoLinked,
-			BalanceInApp:  user.BalanceInApp,
-			AverageRating: user.AverageRating,
+			ID:                user.ID,
+			AvatarURL:         user.AvatarURL,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+			PhoneNumber:       user.PhoneNumber,
+			Email:             user.Email,
+			FullName:          user.FullName,
+			IsVerified:        user.IsVerified,
+			IsActivated:       user.IsActivated,
+			Role:              user.Role,
+			Gender:            user.Gender,
+			IsMomoLinked:      user.IsMomoLinked,
+			BalanceInApp:      user.BalanceInApp,
+			AverageRating:     user.AverageRating,
+			ReliabilityScore:  user.ReliabilityScore,
+			CompletedRides:    user.CompletedRides,
+			LateCancellations: user.LateCancellations,
+			NoShows:           user.NoShows,
 		},
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -649,20 +653,24 @@ func (ctrl *AuthController) VerifyLoginOTP(ctx *gin.Context) {
 
 	res := schemas.VerifyLoginOTPResponse{
 		User: schemas.UserResponse{
-			ID:            user.ID,
-			AvatarURL:     user.AvatarURL,
-			CreatedAt:     user.CreatedAt,
-			UpdatedAt:     user.UpdatedAt,
-			PhoneNumber:   user.PhoneNumber,
-			Email:         user.Email,
-			FullName:      user.FullName,
-			Gender:        user.Gender,
-			IsVerified:    user.IsVerified,
-			IsActivated:   user.IsActivated,
-			Role:          user.Role,
-			IsMomoLinked:  user.IsMomoLinked,
-			BalanceInApp:  user.BalanceInApp,
-			AverageRating: user.AverageRating,
+			ID:                user.ID,
+			AvatarURL:         user.AvatarURL,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+			PhoneNumber:       user.PhoneNumber,
+			Email:             user.Email,
+			FullName:          user.FullName,
+			Gender:            user.Gender,
+			IsVerified:        user.IsVerified,
+			IsActivated:       user.IsActivated,
+			Role:              user.Role,
+			IsMomoLinked:      user.IsMomoLinked,
+			BalanceInApp:      user.BalanceInApp,
+			AverageRating:     user.AverageRating,
+			ReliabilityScore:  user.ReliabilityScore,
+			CompletedRides:    user.CompletedRides,
+			LateCancellations: user.LateCancellations,
+			NoShows:           user.NoShows,
 		},
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
@@ -1,10 +1,14 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
+	"strings"
 
 	"shareway/helper"
+	"shareway/infra/db/migration"
 	"shareway/middleware"
+	"shareway/repository"
 	"shareway/schemas"
 	"shareway/service"
 
@@ -88,7 +92,7 @@ func (ctrl *MapController) GetAutoComplete(ctx *gin.Context) {
 // @Security BearerAuth
 // @Param request body schemas.GiveRideRequest true "Give ride request details"
 // @Success 200 {object} helper.Response{data=schemas.GiveRideResponse} "Successfully created route"
-// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 400 {object} helper.Response "Invalid request body or requested seats exceed vehicle capacity"
 // @Failure 500 {object} helper.Response "Failed to create route"
 // @Router /map/give-ride [post]
 func (ctrl *MapController) CreateGiveRide(ctx *gin.Context) {
@@ -132,6 +136,15 @@ func (ctrl *MapController) CreateGiveRide(ctx *gin.Context) {
 
 	// Create a route for the driver
 	route, rideOfferID, err := ctrl.MapsService.CreateGiveRide(ctx.Request.Context(), req, data.UserID)
+	if errors.Is(err, repository.ErrSeatsExceedCapacity) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Requested seats exceed vehicle capacity",
+			"Số ghế vượt quá sức chứa của xe",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -188,21 +201,24 @@ func (ctrl *MapController) CreateGiveRide(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
 
 	// Create a response with the route and ride offer ID
 	res := schemas.GiveRideResponse{
-		Route:       route,
-		RideOfferID: rideOfferID,
-		Distance:    rideOffer.Distance,
-		Duration:    rideOffer.Duration,
-		StartTime:   rideOffer.StartTime,
-		EndTime:     rideOffer.EndTime,
-		Fare:        rideOffer.Fare,
-		Vehicle:     vehicle,
-		Waypoints:   waypointDetails,
+		Route:          route,
+		RideOfferID:    rideOfferID,
+		Distance:       rideOffer.Distance,
+		Duration:       rideOffer.Duration,
+		StartTime:      rideOffer.StartTime,
+		EndTime:        rideOffer.EndTime,
+		Fare:           rideOffer.Fare,
+		Vehicle:        vehicle,
+		Waypoints:      waypointDetails,
+		Seats:          rideOffer.Seats,
+		AvailableSeats: rideOffer.AvailableSeats,
 	}
 
 	response := helper.SuccessResponse(
@@ -213,6 +229,89 @@ func (ctrl *MapController) CreateGiveRide(ctx *gin.Context) {
 	helper.GinResponse(ctx, 200, response)
 }
 
+// QuoteRide returns the distance, duration, polyline and fare of a route without creating a ride offer
+// QuoteRide godoc
+// @Summary Get a fare quote for a route
+// @Description Returns the distance, duration, polyline and fare of the route between the origin and the destination (through the optional waypoints) for the given vehicle without creating a ride offer
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.QuoteRideRequest true "Quote request details"
+// @Success 200 {object} helper.Response{data=schemas.QuoteRideResponse} "Successfully quoted the route"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Vehicle not found"
+// @Failure 500 {object} helper.Response "Failed to quote the route"
+// @Router /map/quote [post]
+func (ctrl *MapController) QuoteRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.QuoteRideRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Quote the route, nothing is stored in the database
+	res, err := ctrl.MapsService.QuoteRide(ctx.Request.Context(), req, data.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrVehicleNotFound) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Vehicle not found",
+				"Không tìm thấy phương tiện",
+			)
+			helper.GinResponse(ctx, 404, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to quote the route",
+			"Không thể báo giá tuyến đường",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully quoted the route",
+		"Báo giá tuyến đường thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
 // CreateHitchRide receives a list of points and returns a route and polyline encoded string for the hitcher
 // CreateHitchRide godoc
 // @Summary Create a route for a passenger's hitch ride
@@ -365,7 +464,7 @@ func (ctrl *MapController) GetGeoCode(ctx *gin.Context) {
 // SuggestHitchRides returns a list of ride requests that match the business rules for the rider (ride offer)
 // SuggestHitchRides godoc
 // @Summary Suggest ride requests for a rider (ride offer)
-// @Description Returns a list of ride requests that match the business rules for the rider (ride offer)
+// @Description Returns a list of ride requests that match the business rules for the rider (ride offer), best match first. Each ride request has a match score combining the driver's detour, how close the pickup time is and the hitcher's rating
 // @Tags map
 // @Accept json
 // @Produce json
@@ -430,7 +529,8 @@ func (ctrl *MapController) SuggestHitchRides(ctx *gin.Context) {
 
 	// Convert the ride requests to the RideRequestDetail
 	var rideRequestDetails []schemas.RideRequestDetail
-	for _, rideRequest := range rideRequests {
+	for _, match := range rideRequests {
+		rideRequest := match.RideRequest
 		// Get the user details
 		user, err := ctrl.UserService.GetUserByID(rideRequest.UserID)
 		if err != nil {
@@ -468,6 +568,9 @@ func (ctrl *MapController) SuggestHitchRides(ctx *gin.Context) {
 			RiderCurrentLatitude:  rideRequest.RiderCurrentLatitude,
 			RiderCurrentLongitude: rideRequest.RiderCurrentLongitude,
 			Weight:                rideRequest.Weight,
+			SegmentFare:           match.SegmentFare,
+			Match:                 &match.Score,
+			MeetingPoint:          &match.MeetingPoint,
 		}
 		rideRequestDetails = append(rideRequestDetails, rideRequestDetail)
 	}
@@ -487,7 +590,7 @@ func (ctrl *MapController) SuggestHitchRides(ctx *gin.Context) {
 // SuggestGiveRides returns a list of ride offers that match the business rules for the hitcher (ride request)
 // SuggestGiveRides godoc
 // @Summary Suggest ride offers for a hitcher
-// @Description Returns a list of ride offers that match the business rules for the hitcher (ride request)
+// @Description Returns a list of ride offers that match the business rules for the hitcher (ride request), best match first. Each ride offer has a match score combining the driver's detour, how close the pickup time is and the driver's rating
 // @Tags map
 // @Accept json
 // @Produce json
@@ -552,7 +655,8 @@ func (ctrl *MapController) SuggestGiveRides(ctx *gin.Context) {
 
 	// Convert the ride offers to the RideOfferDetail
 	var rideOfferDetails []schemas.RideOfferDetail
-	for _, rideOffer := range rideOffers {
+	for _, match := range rideOffers {
+		rideOffer := match.RideOffer
 		// Get the user details
 		user, err := ctrl.UserService.GetUserByID(rideOffer.UserID)
 		if err != nil {
@@ -596,6 +700,7 @@ func (ctrl *MapController) SuggestGiveRides(ctx *gin.Context) {
 					Address:   waypoint.Address,
 					ID:        waypoint.ID,
 					Order:     waypoint.WaypointOrder,
+					Type:      waypoint.Type,
 				})
 			}
 		}
@@ -627,7 +732,12 @@ func (ctrl *MapController) SuggestGiveRides(ctx *gin.Context) {
 			DriverCurrentLongitude: rideOffer.DriverCurrentLongitude,
 			Status:                 rideOffer.Status,
 			Fare:                   rideOffer.Fare,
+			SegmentFare:            match.SegmentFare,
 			Waypoints:              waypointDetails,
+			Seats:                  rideOffer.Seats,
+			AvailableSeats:         rideOffer.AvailableSeats,
+			Match:                  &match.Score,
+			MeetingPoint:           &match.MeetingPoint,
 		}
 		// Append the ride offer detail to the list
 		rideOfferDetails = append(rideOfferDetails, rideOfferDetail)
@@ -644,3 +754,765 @@ func (ctrl *MapController) SuggestGiveRides(ctx *gin.Context) {
 	)
 	helper.GinResponse(ctx, 200, response)
 }
+
+// SuggestItineraries returns the journeys of the hitcher on two ride offers when no single ride offer takes them all the way
+// SuggestItineraries godoc
+// @Summary Suggest itineraries on two ride offers for a hitcher
+// @Description Returns the journeys of the hitcher (ride request) on two ride offers, earliest arrival first: the first driver drops the hitcher off at a transfer where the route of the second driver passes shortly after. Each itinerary has the fare of both legs, the total fare and the wait at the transfer
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.SuggestItinerariesRequest true "Ride request details"
+// @Success 200 {object} helper.Response{data=schemas.SuggestItinerariesResponse} "Successfully retrieved suggested itineraries"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Ride request not found"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /map/suggest-itineraries [post]
+func (ctrl *MapController) SuggestItineraries(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.SuggestItinerariesRequest
+
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	itineraries, err := ctrl.MapsService.SuggestItineraries(ctx.Request.Context(), data.UserID, req.RideRequestID)
+	if errors.Is(err, repository.ErrRideRequestNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride request not found",
+			"Không tìm thấy yêu cầu đi nhờ",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get suggested itineraries",
+			"Không thể lấy danh sách hành trình gợi ý",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	itineraryDetails := make([]schemas.ItineraryDetail, 0, len(itineraries))
+	for _, itinerary := range itineraries {
+		firstRideOffer, err := ctrl.toItineraryRideOfferDetail(itinerary.FirstRideOffer, itinerary.FirstLegFare, itinerary.MeetingPoint)
+		if err != nil {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Failed to get ride offer details",
+				"Không thể lấy thông tin chuyến đi",
+			)
+			helper.GinResponse(ctx, 500, response)
+			return
+		}
+		secondRideOffer, err := ctrl.toItineraryRideOfferDetail(itinerary.SecondRideOffer, itinerary.SecondLegFare, itinerary.Transfer.Pickup)
+		if err != nil {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Failed to get ride offer details",
+				"Không thể lấy thông tin chuyến đi",
+			)
+			helper.GinResponse(ctx, 500, response)
+			return
+		}
+
+		itineraryDetails = append(itineraryDetails, schemas.ItineraryDetail{
+			FirstRideOffer:  firstRideOffer,
+			SecondRideOffer: secondRideOffer,
+			Transfer:        itinerary.Transfer,
+			TotalFare:       itinerary.FirstLegFare + itinerary.SecondLegFare,
+			WaitTime:        itinerary.Transfer.WaitTime,
+		})
+	}
+
+	res := schemas.SuggestItinerariesResponse{
+		Itineraries: itineraryDetails,
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully retrieved suggested itineraries",
+		"Lấy danh sách hành trình gợi ý thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// SubscribeGiveRides subscribes the ride request of the hitcher to the new matching ride offers
+// SubscribeGiveRides godoc
+// @Summary Subscribe a ride request to new matching give rides
+// @Description Turn on or off the ride-offer-match alerts (websocket and FCM) sent when a driver creates a give ride matching the open ride request, useful when suggest-give-rides returns nothing
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.SubscribeGiveRidesRequest true "Ride request ID and whether to subscribe"
+// @Success 200 {object} helper.Response "Successfully updated match alerts"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Open ride request not found"
+// @Failure 500 {object} helper.Response "Failed to update match alerts"
+// @Router /map/subscribe-give-rides [post]
+func (ctrl *MapController) SubscribeGiveRides(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.SubscribeGiveRidesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.MapsService.SubscribeGiveRides(req, data.UserID); err != nil {
+		if errors.Is(err, repository.ErrRideRequestNotFound) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Open ride request not found",
+				"Không tìm thấy yêu cầu đi nhờ đang mở",
+			)
+			helper.GinResponse(ctx, 404, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to update match alerts",
+			"Không thể cập nhật thông báo chuyến đi phù hợp",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		nil,
+		"Successfully updated match alerts",
+		"Cập nhật thông báo chuyến đi phù hợp thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// SubscribeHitchRides subscribes the ride offer of the driver to the new matching ride requests
+// SubscribeHitchRides godoc
+// @Summary Subscribe a ride offer to new matching hitch rides
+// @Description Turn on or off the ride-request-match alerts (websocket and FCM) sent when a hitcher creates a hitch ride matching the open ride offer
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.SubscribeHitchRidesRequest true "Ride offer ID and whether to subscribe"
+// @Success 200 {object} helper.Response "Successfully updated match alerts"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Open ride offer not found"
+// @Failure 500 {object} helper.Response "Failed to update match alerts"
+// @Router /map/subscribe-hitch-rides [post]
+func (ctrl *MapController) SubscribeHitchRides(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.SubscribeHitchRidesRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.MapsService.SubscribeHitchRides(req, data.UserID); err != nil {
+		if errors.Is(err, repository.ErrRideOfferNotFound) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Open ride offer not found",
+				"Không tìm thấy chuyến đi đang mở",
+			)
+			helper.GinResponse(ctx, 404, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to update match alerts",
+			"Không thể cập nhật thông báo chuyến đi phù hợp",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		nil,
+		"Successfully updated match alerts",
+		"Cập nhật thông báo chuyến đi phù hợp thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// CreateRecurringGiveRide creates a give ride that repeats on some days of the week
+// CreateRecurringGiveRide godoc
+// @Summary Create a recurring give ride
+// @Description Create a give ride that repeats every week on the given days at the given departure time (GMT+7). The ride offer of each occurrence is created automatically ahead of departure
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.CreateRecurringGiveRideRequest true "Recurring give ride request details"
+// @Success 200 {object} helper.Response{data=schemas.RecurringGiveRideDetail} "Successfully created recurring give ride"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 500 {object} helper.Response "Failed to create recurring give ride"
+// @Router /map/recurring-give-ride [post]
+func (ctrl *MapController) CreateRecurringGiveRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.CreateRecurringGiveRideRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	recurringRideOffer, err := ctrl.MapsService.CreateRecurringGiveRide(req, data.UserID)
+	if err != nil {
+		if errors.Is(err, repository.ErrSeatsExceedCapacity) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Requested seats exceed vehicle capacity",
+				"Số ghế vượt quá sức chứa của xe",
+			)
+			helper.GinResponse(ctx, 400, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to create recurring give ride",
+			"Không thể tạo chuyến đi định kỳ",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		toRecurringGiveRideDetail(recurringRideOffer),
+		"Successfully created recurring give ride",
+		"Tạo chuyến đi định kỳ thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// GetRecurringGiveRides returns the recurring give rides of the user
+// GetRecurringGiveRides godoc
+// @Summary Get recurring give rides
+// @Description Get the recurring give rides of the user with their upcoming skipped occurrences
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=schemas.GetRecurringGiveRidesResponse} "Successfully got recurring give rides"
+// @Failure 500 {object} helper.Response "Failed to get recurring give rides"
+// @Router /map/get-recurring-give-rides [get]
+func (ctrl *MapController) GetRecurringGiveRides(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	recurringRideOffers, err := ctrl.MapsService.GetRecurringGiveRides(data.UserID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get recurring give rides",
+			"Không thể lấy danh sách chuyến đi định kỳ",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	recurringGiveRides := make([]schemas.RecurringGiveRideDetail, 0, len(recurringRideOffers))
+	for _, recurringRideOffer := range recurringRideOffers {
+		recurringGiveRides = append(recurringGiveRides, toRecurringGiveRideDetail(recurringRideOffer))
+	}
+
+	response := helper.SuccessResponse(
+		schemas.GetRecurringGiveRidesResponse{RecurringGiveRides: recurringGiveRides},
+		"Successfully got recurring give rides",
+		"Lấy danh sách chuyến đi định kỳ thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// SkipRecurringGiveRide skips a single occurrence of a recurring give ride
+// SkipRecurringGiveRide godoc
+// @Summary Skip an occurrence of a recurring give ride
+// @Description Skip the occurrence of a recurring give ride on the given date (GMT+7). If its ride offer was already created it is cancelled, unless a hitcher has already booked it
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.SkipRecurringGiveRideRequest true "Skip recurring give ride request details"
+// @Success 200 {object} helper.Response "Successfully skipped occurrence"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Recurring give ride not found"
+// @Failure 409 {object} helper.Response "Occurrence already booked"
+// @Failure 500 {object} helper.Response "Failed to skip occurrence"
+// @Router /map/skip-recurring-give-ride [post]
+func (ctrl *MapController) SkipRecurringGiveRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.SkipRecurringGiveRideRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.MapsService.SkipRecurringGiveRide(req, data.UserID); err != nil {
+		switch {
+		case errors.Is(err, repository.ErrRecurringRideOfferNotFound):
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Recurring give ride not found",
+				"Không tìm thấy chuyến đi định kỳ",
+			)
+			helper.GinResponse(ctx, 404, response)
+		case errors.Is(err, service.ErrInvalidOccurrenceDate):
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"The date is not an upcoming occurrence of the recurring give ride",
+				"Ngày này không phải là một chuyến sắp tới của chuyến đi định kỳ",
+			)
+			helper.GinResponse(ctx, 400, response)
+		case errors.Is(err, repository.ErrRecurringOccurrenceBooked):
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"A hitcher has already booked this occurrence, please cancel the ride instead",
+				"Đã có người đặt chuyến đi này, vui lòng hủy chuyến đi thay vì bỏ qua",
+			)
+			helper.GinResponse(ctx, 409, response)
+		default:
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Failed to skip occurrence",
+				"Không thể bỏ qua chuyến đi",
+			)
+			helper.GinResponse(ctx, 500, response)
+		}
+		return
+	}
+
+	response := helper.SuccessResponse(
+		nil,
+		"Successfully skipped occurrence",
+		"Bỏ qua chuyến đi thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// PauseRecurringGiveRide pauses a recurring give ride
+// PauseRecurringGiveRide godoc
+// @Summary Pause a recurring give ride
+// @Description Stop creating ride offers for a recurring give ride. Upcoming ride offers that nobody has booked yet are cancelled
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.RecurringGiveRideIDRequest true "Recurring give ride ID"
+// @Success 200 {object} helper.Response "Successfully paused recurring give ride"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Recurring give ride not found"
+// @Failure 500 {object} helper.Response "Failed to pause recurring give ride"
+// @Router /map/pause-recurring-give-ride [post]
+func (ctrl *MapController) PauseRecurringGiveRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.RecurringGiveRideIDRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.MapsService.PauseRecurringGiveRide(req.RecurringRideOfferID, data.UserID); err != nil {
+		if errors.Is(err, repository.ErrRecurringRideOfferNotFound) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Recurring give ride not found",
+				"Không tìm thấy chuyến đi định kỳ",
+			)
+			helper.GinResponse(ctx, 404, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to pause recurring give ride",
+			"Không thể tạm dừng chuyến đi định kỳ",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		nil,
+		"Successfully paused recurring give ride",
+		"Tạm dừng chuyến đi định kỳ thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// ResumeRecurringGiveRide resumes a paused recurring give ride
+// ResumeRecurringGiveRide godoc
+// @Summary Resume a recurring give ride
+// @Description Start creating ride offers for a paused recurring give ride again
+// @Tags map
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.RecurringGiveRideIDRequest true "Recurring give ride ID"
+// @Success 200 {object} helper.Response "Successfully resumed recurring give ride"
+// @Failure 400 {object} helper.Response "Invalid request body"
+// @Failure 404 {object} helper.Response "Recurring give ride not found"
+// @Failure 500 {object} helper.Response "Failed to resume recurring give ride"
+// @Router /map/resume-recurring-give-ride [post]
+func (ctrl *MapController) ResumeRecurringGiveRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.RecurringGiveRideIDRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate the request body
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request body",
+			"Dữ liệu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.MapsService.ResumeRecurringGiveRide(req.RecurringRideOfferID, data.UserID); err != nil {
+		if errors.Is(err, repository.ErrRecurringRideOfferNotFound) {
+			response := helper.ErrorResponseWithMessage(
+				err,
+				"Recurring give ride not found",
+				"Không tìm thấy chuyến đi định kỳ",
+			)
+			helper.GinResponse(ctx, 404, response)
+			return
+		}
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to resume recurring give ride",
+			"Không thể tiếp tục chuyến đi định kỳ",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		nil,
+		"Successfully resumed recurring give ride",
+		"Tiếp tục chuyến đi định kỳ thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// toItineraryRideOfferDetail converts a ride offer of an itinerary to its response, the segment fare is the fare
+// of its leg and the meeting point is where the hitcher gets on
+func (ctrl *MapController) toItineraryRideOfferDetail(rideOffer migration.RideOffer, fare int64, meetingPoint schemas.MeetingPoint) (schemas.RideOfferDetail, error) {
+	vehicle, err := ctrl.VehicleService.GetVehicleFromID(rideOffer.VehicleID)
+	if err != nil {
+		return schemas.RideOfferDetail{}, err
+	}
+
+	waypoints, err := ctrl.MapsService.GetAllWaypoints(rideOffer.ID)
+	if err != nil {
+		return schemas.RideOfferDetail{}, err
+	}
+	waypointDetails := make([]schemas.Waypoint, 0, len(waypoints))
+	for _, waypoint := range waypoints {
+		waypointDetails = append(waypointDetails, schemas.Waypoint{
+			Latitude:  waypoint.Latitude,
+			Longitude: waypoint.Longitude,
+			Address:   waypoint.Address,
+			ID:        waypoint.ID,
+			Order:     waypoint.WaypointOrder,
+			Type:      waypoint.Type,
+		})
+	}
+
+	return schemas.RideOfferDetail{
+		ID: rideOffer.ID,
+		User: schemas.UserInfo{
+			ID:            rideOffer.User.ID,
+			FullName:      rideOffer.User.FullName,
+			PhoneNumber:   rideOffer.User.PhoneNumber,
+			AvatarURL:     rideOffer.User.AvatarURL,
+			Gender:        rideOffer.User.Gender,
+			IsMomoLinked:  rideOffer.User.IsMomoLinked,
+			BalanceInApp:  rideOffer.User.BalanceInApp,
+			AverageRating: rideOffer.User.AverageRating,
+		},
+		Vehicle:                vehicle,
+		EncodedPolyline:        string(rideOffer.EncodedPolyline),
+		Distance:               rideOffer.Distance,
+		Duration:               rideOffer.Duration,
+		StartTime:              rideOffer.StartTime,
+		EndTime:                rideOffer.EndTime,
+		StartLatitude:          rideOffer.StartLatitude,
+		StartLongitude:         rideOffer.StartLongitude,
+		EndLatitude:            rideOffer.EndLatitude,
+		EndLongitude:           rideOffer.EndLongitude,
+		StartAddress:           rideOffer.StartAddress,
+		EndAddress:             rideOffer.EndAddress,
+		DriverCurrentLatitude:  rideOffer.DriverCurrentLatitude,
+		DriverCurrentLongitude: rideOffer.DriverCurrentLongitude,
+		Status:                 rideOffer.Status,
+		Fare:                   rideOffer.Fare,
+		SegmentFare:            fare,
+		Waypoints:              waypointDetails,
+		Seats:                  rideOffer.Seats,
+		AvailableSeats:         rideOffer.AvailableSeats,
+		MeetingPoint:           &meetingPoint,
+	}, nil
+}
+
+// toRecurringGiveRideDetail converts a recurring ride offer to its response
+func toRecurringGiveRideDetail(recurringRideOffer migration.RecurringRideOffer) schemas.RecurringGiveRideDetail {
+	skippedDates := make([]string, 0, len(recurringRideOffer.Skips))
+	for _, skip := range recurringRideOffer.Skips {
+		skippedDates = append(skippedDates, skip.Date.Format("2006-01-02"))
+	}
+
+	var preferences *schemas.MatchPreferences
+	if recurringRideOffer.CustomPreferences {
+		matchPreferences := schemas.MatchPreferences(recurringRideOffer.MatchPreferences)
+		preferences = &matchPreferences
+	}
+
+	return schemas.RecurringGiveRideDetail{
+		ID:            recurringRideOffer.ID,
+		VehicleID:     recurringRideOffer.VehicleID,
+		PlaceList:     strings.Split(recurringRideOffer.PlaceList, ","),
+		DaysOfWeek:    helper.SplitDaysOfWeek(recurringRideOffer.DaysOfWeek),
+		DepartureTime: recurringRideOffer.DepartureTime,
+		Seats:         recurringRideOffer.Seats,
+		Preferences:   preferences,
+		Amenities:     schemas.RideAmenities(recurringRideOffer.RideAmenities),
+		Status:        recurringRideOffer.Status,
+		SkippedDates:  skippedDates,
+		CreatedAt:     recurringRideOffer.CreatedAt,
+	}
+}
@@ -259,7 +259,7 @@ func (p *PaymentController) RefundRide(ctx *gin.Context) {
 	}
 
 	// Perform refund ride with momo wallet
-	err = p.PaymentService.RefundRide(data.UserID, req)
+	err = p.PaymentService.RefundRide(data.UserID, req, 0)
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -1,43 +1,56 @@
 package controller
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"log"
 
 	"shareway/helper"
+	"shareway/infra/db/migration"
 	"shareway/infra/task"
 	"shareway/infra/ws"
 	"shareway/middleware"
+	"shareway/repository"
 	"shareway/schemas"
 	"shareway/service"
+	"shareway/util/cancellation"
+	"shareway/util/polyline"
+	"shareway/util/statemachine"
 
 	"github.com/gin-gonic/gin"
 	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"gorm.io/gorm"
 )
 
 type RideController struct {
-	validate       *validator.Validate
-	hub            *ws.Hub
-	RideService    service.IRideService
-	MapsService    service.IMapService
-	UserService    service.IUsersService
-	VehicleService service.IVehicleService
-	PaymentService service.IPaymentService
-	asyncClient    *task.AsyncClient
+	validate        *validator.Validate
+	hub             *ws.Hub
+	RideService     service.IRideService
+	MapsService     service.IMapService
+	UserService     service.IUsersService
+	VehicleService  service.IVehicleService
+	PaymentService  service.IPaymentService
+	GeofenceService service.IGeofenceService
+	SafetyService   service.ISafetyService
+	asyncClient     *task.AsyncClient
 }
 
 func NewRideController(validate *validator.Validate, hub *ws.Hub, rideService service.IRideService,
 	mapService service.IMapService, userService service.IUsersService, vehicleService service.IVehicleService, paymentService service.IPaymentService,
-	asyncClient *task.AsyncClient) *RideController {
+	geofenceService service.IGeofenceService, safetyService service.ISafetyService, asyncClient *task.AsyncClient) *RideController {
 	return &RideController{
-		validate:       validate,
-		hub:            hub,
-		RideService:    rideService,
-		MapsService:    mapService,
-		UserService:    userService,
-		VehicleService: vehicleService,
-		PaymentService: paymentService,
-		asyncClient:    asyncClient,
+		validate:        validate,
+		hub:             hub,
+		RideService:     rideService,
+		MapsService:     mapService,
+		UserService:     userService,
+		VehicleService:  vehicleService,
+		PaymentService:  paymentService,
+		GeofenceService: geofenceService,
+		SafetyService:   safetyService,
+		asyncClient:     asyncClient,
 	}
 }
 
@@ -53,6 +66,7 @@ func NewRideController(validate *validator.Validate, hub *ws.Hub, rideService se
 // @Success 200 {object} helper.Response "Successfully sent ride offer request"
 // @Failure 400 {object} helper.Response "Invalid request"
 // @Failure 500 {object} helper.Response "Internal server error"
+// @Failure 403 {object} helper.Response "Match preferences not met"
 // @Router /ride/give-ride-request [post]
 func (ctrl *RideController) SendGiveRideRequest(ctx *gin.Context) {
 	// Get payload from context
@@ -131,6 +145,39 @@ func (ctrl *RideController) SendGiveRideRequest(ctx *gin.Context) {
 		return
 	}
 
+	// Get ride request details from ride_request_id
+	rideRequest, err := ctrl.RideService.GetRideRequestByID(req.RideRequestID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride request details",
+			"Không thể lấy thông tin yêu cầu chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Make sure the driver and the hitcher meet the match preferences of each other
+	matched, err := ctrl.checkMatchPreferences(rideOffer, rideRequest)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to check match preferences",
+			"Không thể kiểm tra tiêu chí ghép chuyến",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+	if !matched {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("match preferences not met"),
+			"The driver and the hitcher do not meet the match preferences of each other",
+			"Tài xế và người đi nhờ không đáp ứng tiêu chí ghép chuyến của nhau",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+
 	// Get waypoints details from ride_offer_id
 	waypoints, err := ctrl.MapsService.GetAllWaypoints(rideOffer.ID)
 	if err != nil {
@@ -152,6 +199,7 @@ func (ctrl *RideController) SendGiveRideRequest(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -184,9 +232,13 @@ func (ctrl *RideController) SendGiveRideRequest(ctx *gin.Context) {
 		EndTime:                rideOffer.EndTime,
 		Status:                 rideOffer.Status,
 		Fare:                   rideOffer.Fare,
+		SegmentFare:            helper.CalculateSegmentFare(rideOffer, rideRequest),
 		ReceiverID:             req.ReceiverID,
 		RideRequestID:          req.RideRequestID,
 		Waypoints:              waypointDetails,
+		Seats:                  rideOffer.Seats,
+		AvailableSeats:         rideOffer.AvailableSeats,
+		Amenities:              schemas.RideAmenities(rideOffer.RideAmenities),
 	}
 
 	// Send ride offer request to the receiver
@@ -286,6 +338,7 @@ func (ctrl *RideController) SendGiveRideRequest(ctx *gin.Context) {
 // @Success 200 {object} helper.Response "Successfully sent ride request"
 // @Failure 400 {object} helper.Response "Invalid request"
 // @Failure 500 {object} helper.Response "Internal server error"
+// @Failure 403 {object} helper.Response "Match preferences not met"
 // @Router /ride/hitch-ride-request [post]
 func (ctrl *RideController) SendHitchRideRequest(ctx *gin.Context) {
 	// Get payload from context
@@ -363,6 +416,27 @@ func (ctrl *RideController) SendHitchRideRequest(ctx *gin.Context) {
 		return
 	}
 
+	// Make sure the driver and the hitcher meet the match preferences of each other
+	matched, err := ctrl.checkMatchPreferences(rideOffer, rideRequest)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to check match preferences",
+			"Không thể kiểm tra tiêu chí ghép chuyến",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+	if !matched {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("match preferences not met"),
+			"The driver and the hitcher do not meet the match preferences of each other",
+			"Tài xế và người đi nhờ không đáp ứng tiêu chí ghép chuyến của nhau",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+
 	// Get vehicle details from user_id
 	vehicle, err := ctrl.VehicleService.GetVehicleFromID(rideOffer.VehicleID)
 	if err != nil {
@@ -401,9 +475,11 @@ func (ctrl *RideController) SendHitchRideRequest(ctx *gin.Context) {
 		Duration:              rideRequest.Duration,
 		StartTime:             rideRequest.StartTime,
 		EndTime:               rideRequest.EndTime,
+		SegmentFare:           helper.CalculateSegmentFare(rideOffer, rideRequest),
 		ReceiverID:            req.ReceiverID,
 		RideOfferID:           req.RideOfferID,
 		Vehicle:               vehicle,
+		Amenities:             schemas.RideAmenities(rideRequest.RideAmenities),
 	}
 
 	// Send ride request to the receiver
@@ -500,6 +576,7 @@ func (ctrl *RideController) SendHitchRideRequest(ctx *gin.Context) {
 // @Param request body schemas.AcceptGiveRideRequestRequest true "Accept give ride request details"
 // @Success 200 {object} helper.Response{data=schemas.AcceptGiveRideRequestResponse} "Successfully accepted ride offer request"
 // @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 409 {object} helper.Response "Ride offer has no available seats or can no longer be matched"
 // @Failure 500 {object} helper.Response "Internal server error"
 // @Router /ride/accept-give-ride-request [post]
 func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
@@ -543,7 +620,25 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 	}
 
 	// Create ride between driver and hitcher (because the hitcher accepted the ride offer from the driver means ride is engaged)
-	ride, err := ctrl.RideService.AcceptRideRequest(req.RideOfferID, req.RideRequestID, req.VehicleID)
+	ride, err := ctrl.RideService.AcceptRideRequest(req.RideOfferID, req.RideRequestID, req.VehicleID, data.UserID)
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offer or ride request can no longer be matched",
+			"Chuyến đi hoặc yêu cầu không còn có thể ghép được",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if errors.Is(err, repository.ErrRideOfferFull) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offer has no available seats for this request",
+			"Chuyến đi không còn chỗ trống cho yêu cầu này",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -554,6 +649,9 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 		return
 	}
 
+	// Add the pickup (the meeting point on the route) and drop-off of the hitcher to the route of the driver
+	meetingPoint := ctrl.rerouteForRideRequest(ctx.Request.Context(), &ride, data.UserID, req.ReceiverID)
+
 	// Get ride offer details from ride_offer_id
 	rideOffer, err := ctrl.RideService.GetRideOfferByID(req.RideOfferID)
 	if err != nil {
@@ -586,6 +684,7 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -677,6 +776,7 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 		},
 		RideRequestID: req.RideRequestID,
 		Waypoints:     waypointDetails,
+		MeetingPoint:  meetingPoint,
 	}
 
 	// Send the accepted ride offer to the driver (match the ride successfully)
@@ -734,6 +834,7 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 		},
 		RideRequestID: req.RideRequestID,
 		Waypoints:     waypointDetails,
+		MeetingPoint:  meetingPoint,
 	}
 
 	// Prepare the WebSocket message
@@ -815,6 +916,7 @@ func (ctrl *RideController) AcceptGiveRideRequest(ctx *gin.Context) {
 // @Param request body schemas.AcceptHitchRideRequestRequest true "Accept hitch ride request details"
 // @Success 200 {object} helper.Response{data=schemas.AcceptHitchRideRequestResponse} "Successfully accepted ride request"
 // @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 409 {object} helper.Response "Ride offer has no available seats or can no longer be matched"
 // @Failure 500 {object} helper.Response "Internal server error"
 // @Router /ride/accept-hitch-ride-request [post]
 func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
@@ -856,7 +958,25 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 	}
 
 	// Create ride between driver and hitcher (because the driver accepted the ride request from the hitcher means ride is engaged)
-	ride, err := ctrl.RideService.AcceptRideRequest(req.RideOfferID, req.RideRequestID, req.VehicleID)
+	ride, err := ctrl.RideService.AcceptRideRequest(req.RideOfferID, req.RideRequestID, req.VehicleID, data.UserID)
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offer or ride request can no longer be matched",
+			"Chuyến đi hoặc yêu cầu không còn có thể ghép được",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if errors.Is(err, repository.ErrRideOfferFull) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offer has no available seats for this request",
+			"Chuyến đi không còn chỗ trống cho yêu cầu này",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -867,6 +987,9 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 		return
 	}
 
+	// Add the pickup (the meeting point on the route) and drop-off of the hitcher to the route of the driver
+	meetingPoint := ctrl.rerouteForRideRequest(ctx.Request.Context(), &ride, data.UserID, req.ReceiverID)
+
 	// Get ride offer details from ride_offer_id
 	rideOffer, err := ctrl.RideService.GetRideOfferByID(req.RideOfferID)
 	if err != nil {
@@ -900,6 +1023,7 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -989,8 +1113,9 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 			BalanceInApp:  receiver.BalanceInApp,
 			AverageRating: receiver.AverageRating,
 		},
-		Vehicle:   vehicle,
-		Waypoints: waypointDetails,
+		Vehicle:      vehicle,
+		Waypoints:    waypointDetails,
+		MeetingPoint: meetingPoint,
 	}
 
 	// Send the accepted ride request to the hitcher (match the ride successfully)
@@ -1046,8 +1171,9 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 			BalanceInApp:  accepter.BalanceInApp,
 			AverageRating: accepter.AverageRating,
 		},
-		Vehicle:   vehicle,
-		Waypoints: waypointDetails,
+		Vehicle:      vehicle,
+		Waypoints:    waypointDetails,
+		MeetingPoint: meetingPoint,
 	}
 
 	// Prepare the WebSocket message
@@ -1118,6 +1244,190 @@ func (ctrl *RideController) AcceptHitchRideRequest(ctx *gin.Context) {
 	))
 }
 
+// BookItinerary books both legs of an itinerary on two ride offers for the hitcher
+// BookItinerary godoc
+// @Summary Book an itinerary on two ride offers
+// @Description Book both legs of an itinerary suggested by suggest-itineraries at once. Both rides, their cash transactions and the chat rooms with both drivers are created in a single database transaction, so the hitcher gets both legs or nothing is booked. Both drivers receive an itinerary-booked message
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.BookItineraryRequest true "Book itinerary details"
+// @Success 200 {object} helper.Response{data=schemas.BookItineraryResponse} "Successfully booked itinerary"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 404 {object} helper.Response "Ride request not found"
+// @Failure 409 {object} helper.Response "Ride offers no longer form an itinerary, have no available seats or can no longer be matched"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/book-itinerary [post]
+func (ctrl *RideController) BookItinerary(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.BookItineraryRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind JSON",
+			"Không thể bind JSON",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Find the transfer again, the ride offers may have changed since the itinerary was suggested
+	itinerary, err := ctrl.MapsService.GetItinerary(ctx.Request.Context(), data.UserID, req.RideRequestID, req.FirstRideOfferID, req.SecondRideOfferID)
+	if errors.Is(err, repository.ErrRideRequestNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride request not found",
+			"Không tìm thấy yêu cầu đi nhờ",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, repository.ErrItineraryNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offers no longer form an itinerary for this request",
+			"Các chuyến đi không còn tạo thành hành trình cho yêu cầu này",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get itinerary",
+			"Không thể lấy thông tin hành trình",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	firstVehicle, err := ctrl.VehicleService.GetVehicleFromID(itinerary.FirstRideOffer.VehicleID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get vehicle details",
+			"Không thể lấy thông tin phương tiện",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+	secondVehicle, err := ctrl.VehicleService.GetVehicleFromID(itinerary.SecondRideOffer.VehicleID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get vehicle details",
+			"Không thể lấy thông tin phương tiện",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Create the rides of both legs with their transactions, or none of them
+	firstRide, secondRide, err := ctrl.RideService.BookItinerary(itinerary, data.UserID)
+	if errors.Is(err, repository.ErrRideRequestNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride request not found",
+			"Không tìm thấy yêu cầu đi nhờ",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offers or ride request can no longer be matched",
+			"Các chuyến đi hoặc yêu cầu không còn có thể ghép được",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if errors.Is(err, repository.ErrRideOfferFull) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride offers have no available seats for this request",
+			"Các chuyến đi không còn chỗ trống cho yêu cầu này",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to book itinerary",
+			"Không thể đặt hành trình",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// The itinerary is booked, nothing below may fail the request or the drivers would never hear about it
+	firstRideDetail := ctrl.completeItineraryLeg(ctx.Request.Context(), &firstRide, firstVehicle, itinerary.FirstRideOffer.User, data.UserID)
+	secondRideDetail := ctrl.completeItineraryLeg(ctx.Request.Context(), &secondRide, secondVehicle, itinerary.SecondRideOffer.User, data.UserID)
+
+	// Send each driver their leg, with the hitcher as the user
+	hitcher := itinerary.RideRequest.User
+	for leg, rideDetail := range []schemas.ItineraryRideDetail{firstRideDetail, secondRideDetail} {
+		driverID := rideDetail.UserInfo.ID
+		rideDetail.UserInfo = schemas.UserInfo{
+			ID:            hitcher.ID,
+			PhoneNumber:   hitcher.PhoneNumber,
+			FullName:      hitcher.FullName,
+			AvatarURL:     hitcher.AvatarURL,
+			Gender:        hitcher.Gender,
+			IsMomoLinked:  hitcher.IsMomoLinked,
+			BalanceInApp:  hitcher.BalanceInApp,
+			AverageRating: hitcher.AverageRating,
+		}
+		go ctrl.notifyItineraryBooked(driverID, schemas.ItineraryBookedResponse{
+			Leg:      leg + 1,
+			Ride:     rideDetail,
+			Transfer: itinerary.Transfer,
+		})
+	}
+
+	res := schemas.BookItineraryResponse{
+		RideRequestID: itinerary.RideRequest.ID,
+		FirstRide:     firstRideDetail,
+		SecondRide:    secondRideDetail,
+		Transfer:      itinerary.Transfer,
+		TotalFare:     firstRide.Fare + secondRide.Fare,
+	}
+
+	// Return success response
+	helper.GinResponse(ctx, 200, helper.SuccessResponse(
+		res,
+		"Successfully booked itinerary",
+		"Đặt hành trình thành công",
+	))
+}
+
 // CancelGiveRideRequest cancels a ride offer request from the driver (the hitcher cancels the request)
 // CancelGiveRideRequest godoc
 // @Summary Cancel a ride offer request from the driver
@@ -1409,7 +1719,8 @@ func (ctrl *RideController) CancelHitchRideRequest(ctx *gin.Context) {
 // @Security BearerAuth
 // @Param request body schemas.StartRideRequest true "Start ride request"
 // @Success 200 {object} helper.Response{data=schemas.StartRideResponse} "Successfully started ride"
-// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 400 {object} helper.Response "Invalid request or too far from the pickup point without overriding the geofence"
+// @Failure 409 {object} helper.Response "Ride cannot be started in its current status"
 // @Failure 500 {object} helper.Response "Internal server error"
 // @Router /ride/start-ride [post]
 func (ctrl *RideController) StartRide(ctx *gin.Context) {
@@ -1452,6 +1763,24 @@ func (ctrl *RideController) StartRide(ctx *gin.Context) {
 
 	// Start the ride
 	ride, err := ctrl.RideService.StartRide(req, data.UserID)
+	if errors.Is(err, service.ErrOutsideGeofence) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are too far from the pickup point, provide a reason to override",
+			"Bạn đang ở quá xa điểm đón, vui lòng cung cấp lý do để tiếp tục",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride cannot be started in its current status",
+			"Không thể bắt đầu chuyến đi ở trạng thái hiện tại",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -1495,6 +1824,7 @@ func (ctrl *RideController) StartRide(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -1680,7 +2010,8 @@ func (ctrl *RideController) StartRide(ctx *gin.Context) {
 // @Security BearerAuth
 // @Param request body schemas.EndRideRequest true "End ride request"
 // @Success 200 {object} helper.Response{data=schemas.EndRideResponse} "Successfully ended ride"
-// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 400 {object} helper.Response "Invalid request or too far from the drop-off point without overriding the geofence"
+// @Failure 409 {object} helper.Response "Ride cannot be ended in its current status"
 // @Failure 500 {object} helper.Response "Internal server error"
 // @Router /ride/end-ride [post]
 func (ctrl *RideController) EndRide(ctx *gin.Context) {
@@ -1723,6 +2054,24 @@ func (ctrl *RideController) EndRide(ctx *gin.Context) {
 
 	// End the ride
 	ride, err := ctrl.RideService.EndRide(req, data.UserID)
+	if errors.Is(err, service.ErrOutsideGeofence) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are too far from the drop-off point, provide a reason to override",
+			"Bạn đang ở quá xa điểm trả, vui lòng cung cấp lý do để tiếp tục",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride cannot be ended in its current status",
+			"Không thể kết thúc chuyến đi ở trạng thái hiện tại",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
@@ -1766,6 +2115,7 @@ func (ctrl *RideController) EndRide(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -1862,6 +2212,7 @@ func (ctrl *RideController) EndRide(ctx *gin.Context) {
 		Fare:                   ride.Fare,
 		EncodedPolyline:        string(ride.EncodedPolyline),
 		Distance:               ride.Distance,
+		ActualDistance:         ride.ActualDistance,
 		Duration:               ride.Duration,
 		StartLatitude:          ride.StartLatitude,
 		StartLongitude:         ride.StartLongitude,
@@ -1904,6 +2255,7 @@ func (ctrl *RideController) EndRide(ctx *gin.Context) {
 		Fare:                   ride.Fare,
 		EncodedPolyline:        string(ride.EncodedPolyline),
 		Distance:               ride.Distance,
+		ActualDistance:         ride.ActualDistance,
 		Duration:               ride.Duration,
 		StartLatitude:          ride.StartLatitude,
 		StartLongitude:         ride.StartLongitude,
@@ -2077,6 +2429,7 @@ func (ctrl *RideController) UpdateRideLocation(ctx *gin.Context) {
 				Address:   waypoint.Address,
 				ID:        waypoint.ID,
 				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
 			})
 		}
 	}
@@ -2235,6 +2588,21 @@ func (ctrl *RideController) UpdateRideLocation(ctx *gin.Context) {
 		}
 	}()
 
+	// Push the ETA of the driver to the hitcher while the driver is on the way
+	if ride.Status == statemachine.StatusScheduled || ride.Status == statemachine.StatusOngoing {
+		go ctrl.pushDriverETA(ride, rideRequest, req.CurrentLocation)
+		go ctrl.pushGeofenceEvents(ride, rideRequest, rideOffer.UserID, req.CurrentLocation)
+	}
+
+	// Watch for route deviations and long stops while the hitcher is in the car
+	if ride.Status == statemachine.StatusOngoing {
+		go func() {
+			if err := ctrl.SafetyService.MonitorLocation(context.Background(), ride, rideRequest, req.CurrentLocation); err != nil {
+				log.Printf("Failed to monitor the safety of ride %s: %v", ride.ID, err)
+			}
+		}()
+	}
+
 	// // Send the notification message using the async client
 	// go func() {
 	// 	err = ctrl.asyncClient.EnqueueFCMNotification(notification)
@@ -2252,17 +2620,19 @@ func (ctrl *RideController) UpdateRideLocation(ctx *gin.Context) {
 	helper.GinResponse(ctx, 200, response)
 }
 
-// CancelRideByDriver cancels the ride
-// CancelRideByDriver godoc
+// CancelRide cancels the ride by the driver or the hitcher
+// CancelRide godoc
 // @Summary Cancel a ride
-// @Description Cancels the ride
+// @Description Cancels the ride by the driver or the hitcher. Cancelling is free within CANCEL_FREE_AFTER_BOOKING minutes after booking or at least CANCEL_FREE_BEFORE_START minutes before the start time, otherwise it lowers the reliability of the user and a hitcher is only refunded the fare minus CANCEL_LATE_FEE_PERCENT percent. When the driver cancels before the pickup the ride request of the hitcher goes back to the matching pool, a MoMo payment is kept on hold and the best ride offers are pushed as rematch-suggestions
 // @Tags ride
 // @Accept json
 // @Produce json
 // @Security BearerAuth
 // @Param request body schemas.CancelRideRequest true "Cancel ride request"
-// @Success 200 {object} helper.Response{data=schemas.CancelRideResponse} "Successfully canceled ride by driver"
+// @Success 200 {object} helper.Response{data=schemas.CancelRideResponse} "Successfully canceled ride"
 // @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 409 {object} helper.Response "Ride cannot be cancelled in its current status"
 // @Failure 500 {object} helper.Response "Internal server error"
 // @Router /ride/cancel-ride [post]
 func (ctrl *RideController) CancelRide(ctx *gin.Context) {
@@ -2303,7 +2673,7 @@ func (ctrl *RideController) CancelRide(ctx *gin.Context) {
 		return
 	}
 
-	// Check if payment method is momo to refund the hitcher
+	// Check if payment method is momo to keep the payment on hold for a rematch
 	transaction, err := ctrl.RideService.GetTransactionByRideID(req.RideID)
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
@@ -2339,72 +2709,78 @@ func (ctrl *RideController) CancelRide(ctx *gin.Context) {
 		return
 	}
 
-	// Check if the payment method is momo to refund the hitcher
-	if transaction.PaymentMethod == "momo" {
-		err := ctrl.PaymentService.RefundRide(
-			req.ReceiverID, schemas.RefundMomoRequest{
-				RideRequestID: rideDetail.RideRequestID,
-				RideOfferID:   rideDetail.RideOfferID,
-			},
+	// Make sure the ride can still be cancelled before refunding the hitcher
+	if err := statemachine.Validate(statemachine.EntityRide, rideDetail.Status, statemachine.StatusCancelled); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride cannot be cancelled in its current status",
+			"Không thể hủy chuyến đi ở trạng thái hiện tại",
 		)
-		if err != nil {
-			response := helper.ErrorResponseWithMessage(
-				err,
-				"Failed to refund the hitcher",
-				"Không thể hoàn tiền cho người nhận",
-			)
-			helper.GinResponse(ctx, 500, response)
-			return
-		}
-
-		// Send notification and WebSocket message to the hitcher
-		notification := schemas.Notification{
-			Title: "Chuyến đi của bạn đã bị hủy",
-			Body:  "Chuyến đi của bạn đã bị hủy, bạn đã được hoàn tiền",
-			Token: receiver.DeviceToken,
-			Data:  nil,
-		}
-
-		wsMessage := schemas.WebSocketMessage{
-			UserID:  req.ReceiverID.String(),
-			Type:    "refund-success",
-			Payload: nil,
-		}
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 
-		// Send the WebSocket message using the async client
-		go func() {
-			err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage)
-			if err != nil {
-				log.Printf("Failed to enqueue websocket message: %v", err)
-			}
-		}()
+	// Apply the cancellation policy to know whether the user who cancels is charged
+	decision, err := ctrl.RideService.EvaluateCancellation(rideDetail, data.UserID)
+	if errors.Is(err, service.ErrNotRideParticipant) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to apply the cancellation policy",
+			"Không thể áp dụng chính sách hủy chuyến",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
 
-		// Send the notification message using the async client
-		go func() {
-			err = ctrl.asyncClient.EnqueueFCMNotification(notification)
-			if err != nil {
-				log.Printf("Failed to enqueue FCM notification: %v", err)
-			}
-		}()
+	// The rides of the other legs of an itinerary are cancelled with this one
+	legRides, err := ctrl.RideService.GetItineraryLegRides(rideDetail.RideRequestID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get itinerary details",
+			"Không thể lấy thông tin hành trình",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
 	}
 
-	// Cancel the ride by the driver
-	ride, err := ctrl.RideService.CancelRide(req, data.UserID)
+	// Cancel the ride
+	ride, err := ctrl.RideService.CancelRide(req, data.UserID, decision)
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride cannot be cancelled in its current status",
+			"Không thể hủy chuyến đi ở trạng thái hiện tại",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
 	if err != nil {
 		response := helper.ErrorResponseWithMessage(
 			err,
-			"Failed to cancel ride by driver",
-			"Không thể hủy chuyến đi bởi tài xế",
+			"Failed to cancel ride",
+			"Không thể hủy chuyến đi",
 		)
 		helper.GinResponse(ctx, 500, response)
 		return
 	}
 
 	res := schemas.CancelRideResponse{
-		RideID:        ride.ID,
-		RideOfferID:   ride.RideOfferID,
-		RideRequestID: ride.RideRequestID,
-		ReceiverID:    req.ReceiverID,
+		RideID:          ride.ID,
+		RideOfferID:     ride.RideOfferID,
+		RideRequestID:   ride.RideRequestID,
+		ReceiverID:      req.ReceiverID,
+		Outcome:         decision.Outcome,
+		CancellationFee: decision.Fee,
 	}
 
 	// // Get ride offer details from ride_offer_id
@@ -2431,10 +2807,16 @@ func (ctrl *RideController) CancelRide(ctx *gin.Context) {
 	// 	return
 	// }
 
+	// Tell the other participant who cancelled the ride
+	messageType := "cancel-ride-by-driver"
+	if data.UserID == decision.HitcherID {
+		messageType = "cancel-ride-by-hitcher"
+	}
+
 	// Prepare the WebSocket message
 	wsMessage := schemas.WebSocketMessage{
 		UserID:  req.ReceiverID.String(),
-		Type:    "cancel-ride-by-driver",
+		Type:    messageType,
 		Payload: res,
 	}
 
@@ -2452,7 +2834,7 @@ func (ctrl *RideController) CancelRide(ctx *gin.Context) {
 
 	// Prepare the notification payload
 	notificationPayload := schemas.NotificationPayload{
-		Type: "cancel-ride-by-driver",
+		Type: messageType,
 		Data: resMap,
 	}
 
@@ -2492,11 +2874,22 @@ func (ctrl *RideController) CancelRide(ctx *gin.Context) {
 		}
 	}()
 
+	// Put the hitcher back in the matching pool with the best alternatives
+	if decision.Rematch {
+		go ctrl.pushRematchSuggestions(ride, decision.HitcherID, transaction.PaymentMethod == "momo")
+	}
+	go ctrl.refundCancelledRide(ride, decision)
+	go ctrl.notifyItineraryLegsCancelled(legRides)
+	go ctrl.unrouteRideRequest(ride)
+	for _, legRide := range legRides {
+		go ctrl.unrouteRideRequest(legRide)
+	}
+
 	// Return success response
 	response := helper.SuccessResponse(
 		res,
-		"Successfully canceled ride by driver",
-		"Đã hủy chuyến đi bởi tài xế thành công",
+		"Successfully canceled ride",
+		"Đã hủy chuyến đi thành công",
 	)
 	helper.GinResponse(ctx, 200, response)
 }
@@ -2753,6 +3146,7 @@ func (ctrl *RideController) GetAllPendingRide(ctx *gin.Context) {
 					Address:   waypoint.Address,
 					ID:        waypoint.ID,
 					Order:     waypoint.WaypointOrder,
+					Type:      waypoint.Type,
 				})
 			}
 		}
@@ -2786,6 +3180,8 @@ func (ctrl *RideController) GetAllPendingRide(ctx *gin.Context) {
 			EndTime:                rideOffer.EndTime,
 			Fare:                   rideOffer.Fare,
 			Waypoints:              waypointDetails,
+			Seats:                  rideOffer.Seats,
+			AvailableSeats:         rideOffer.AvailableSeats,
 		})
 	}
 
@@ -3127,6 +3523,7 @@ func (ctrl *RideController) GetRideHistory(ctx *gin.Context) {
 					Address:   waypoint.Address,
 					ID:        waypoint.ID,
 					Order:     waypoint.WaypointOrder,
+					Type:      waypoint.Type,
 				})
 			}
 		}
@@ -3333,6 +3730,7 @@ func (ctrl *RideController) GetScheduledAndOngoingRide(ctx *gin.Context) {
 					Address:   waypoint.Address,
 					ID:        waypoint.ID,
 					Order:     waypoint.WaypointOrder,
+					Type:      waypoint.Type,
 				})
 			}
 		}
@@ -3401,3 +3799,1259 @@ func (ctrl *RideController) GetScheduledAndOngoingRide(ctx *gin.Context) {
 	)
 	helper.GinResponse(ctx, 200, response)
 }
+
+// GetRideTimeline gets the timeline of a ride (who did what, when and where)
+// GetRideTimeline godoc
+// @Summary Get the timeline of a ride
+// @Description Get every event of a ride in chronological order, only the driver and the hitcher of the ride can see it
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param rideID query string true "Ride ID"
+// @Success 200 {object} helper.Response{data=schemas.GetRideTimelineResponse} "Successfully got ride timeline"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/get-ride-timeline [get]
+func (ctrl *RideController) GetRideTimeline(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.GetRideTimelineRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request",
+			"Yêu cầu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request",
+			"Yêu cầu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	rideID, err := uuid.Parse(req.RideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid ride ID format",
+			"Định dạng ID chuyến đi không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Get the ride details
+	ride, err := ctrl.RideService.GetRideByID(rideID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride details",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Get the driver id from ride_offer_id
+	rideOffer, err := ctrl.RideService.GetRideOfferByID(ride.RideOfferID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride offer details",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Get the hitcher id from ride_request_id
+	rideRequest, err := ctrl.RideService.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride request details",
+			"Không thể lấy thông tin yêu cầu chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Only the driver and the hitcher of the ride can see its timeline
+	if data.UserID != rideOffer.UserID && data.UserID != rideRequest.UserID {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("user is not a participant of the ride"),
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+
+	events, err := ctrl.RideService.GetRideTimeline(rideID)
+	if errors.Is(err, repository.ErrRideNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride timeline",
+			"Không thể lấy lịch sử chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.GetRideTimelineResponse{
+		RideID: ride.ID,
+		Status: ride.Status,
+		Events: events,
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully got ride timeline",
+		"Lấy lịch sử chuyến đi thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// GetRideReplay gets the path actually taken by the driver during a ride
+// GetRideReplay godoc
+// @Summary Replay a ride
+// @Description Get the planned route of a ride and the path actually taken by the driver as encoded polylines, only the driver and the hitcher of the ride can see it
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param rideID query string true "Ride ID"
+// @Success 200 {object} helper.Response{data=schemas.GetRideReplayResponse} "Successfully got ride replay"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/get-ride-replay [get]
+func (ctrl *RideController) GetRideReplay(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.GetRideReplayRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request",
+			"Yêu cầu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid request",
+			"Yêu cầu không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	rideID, err := uuid.Parse(req.RideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Invalid ride ID format",
+			"Định dạng ID chuyến đi không hợp lệ",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Get the ride details
+	ride, err := ctrl.RideService.GetRideByID(rideID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride details",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Get the driver id from ride_offer_id
+	rideOffer, err := ctrl.RideService.GetRideOfferByID(ride.RideOfferID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride offer details",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Get the hitcher id from ride_request_id
+	rideRequest, err := ctrl.RideService.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride request details",
+			"Không thể lấy thông tin yêu cầu chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Only the driver and the hitcher of the ride can replay it
+	if data.UserID != rideOffer.UserID && data.UserID != rideRequest.UserID {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("user is not a participant of the ride"),
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+
+	res, err := ctrl.RideService.GetRideReplay(ride)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride replay",
+			"Không thể lấy lộ trình chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully got ride replay",
+		"Lấy lộ trình chuyến đi thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// RespondSafetyCheck records the answer of the hitcher to a safety check
+// RespondSafetyCheck godoc
+// @Summary Answer a safety check
+// @Description Answer the "are you OK?" prompt sent when the driver left the planned route or stopped for too long, the alert is escalated to the admins when the hitcher is not safe
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.RespondSafetyCheckRequest true "Respond safety check request"
+// @Success 200 {object} helper.Response{data=schemas.SafetyCheckResponse} "Successfully answered safety check"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 404 {object} helper.Response "Safety alert not found"
+// @Failure 409 {object} helper.Response "Safety alert was already answered"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/respond-safety-check [post]
+func (ctrl *RideController) RespondSafetyCheck(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.RespondSafetyCheckRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind JSON",
+			"Không thể bind JSON",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	alert, err := ctrl.SafetyService.RespondSafetyCheck(req, data.UserID)
+	if errors.Is(err, repository.ErrSafetyAlertNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Safety alert not found",
+			"Không tìm thấy cảnh báo an toàn",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Safety alert was already answered",
+			"Cảnh báo an toàn đã được phản hồi",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to answer safety check",
+			"Không thể phản hồi kiểm tra an toàn",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.SafetyCheckResponse{
+		AlertID:   alert.ID,
+		RideID:    alert.RideID,
+		Type:      alert.Type,
+		Status:    alert.Status,
+		Latitude:  alert.Latitude,
+		Longitude: alert.Longitude,
+		Distance:  alert.Distance,
+		CreatedAt: alert.CreatedAt,
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully answered safety check",
+		"Phản hồi kiểm tra an toàn thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// SOS raises an emergency alert during an ongoing ride (the driver or the hitcher can press the SOS button)
+// SOS godoc
+// @Summary Press the SOS button
+// @Description Records an emergency event with the last known location on an ongoing ride, tells the trusted contacts of the user by SMS and raises a high priority alert for the admins
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.SOSRequest true "SOS request"
+// @Success 200 {object} helper.Response{data=schemas.SOSResponse} "Successfully raised SOS"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 409 {object} helper.Response "Ride is not ongoing"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/sos [post]
+func (ctrl *RideController) SOS(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.SOSRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind JSON",
+			"Không thể bind JSON",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	res, err := ctrl.SafetyService.TriggerSOS(req, data.UserID)
+	if errors.Is(err, repository.ErrRideNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, service.ErrNotRideParticipant) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+	if errors.Is(err, service.ErrRideNotOngoing) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"SOS can only be raised during an ongoing ride",
+			"Chỉ có thể gửi SOS khi chuyến đi đang diễn ra",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to raise SOS",
+			"Không thể gửi SOS",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully raised SOS",
+		"Đã gửi SOS thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// ShareRide issues a read-only link to follow a scheduled or ongoing ride
+// ShareRide godoc
+// @Summary Share a live trip link
+// @Description Issues a signed and expiring read-only token so the family of the user can follow the driver's position, the vehicle and the ETA on /share/track-ride until the ride ends
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.ShareRideRequest true "Share ride request"
+// @Success 200 {object} helper.Response{data=schemas.ShareRideResponse} "Successfully shared ride"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 409 {object} helper.Response "Ride is not scheduled or ongoing"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/share-ride [post]
+func (ctrl *RideController) ShareRide(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.ShareRideRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind JSON",
+			"Không thể bind JSON",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	res, err := ctrl.SafetyService.ShareRide(req, data.UserID)
+	if errors.Is(err, repository.ErrRideNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, service.ErrNotRideParticipant) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+	if errors.Is(err, service.ErrRideNotActive) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Only scheduled or ongoing rides can be shared",
+			"Chỉ có thể chia sẻ chuyến đi đã lên lịch hoặc đang diễn ra",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to share ride",
+			"Không thể chia sẻ chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully shared ride",
+		"Đã chia sẻ chuyến đi thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// ReportNoShow cancels a scheduled ride when the other participant did not show up at the pickup point
+// ReportNoShow godoc
+// @Summary Report a no-show
+// @Description The driver or the hitcher reports that the other participant did not show up after waiting at the pickup point for NO_SHOW_WAIT_TIME minutes past the start time. The ride is cancelled, the reliability of the other participant is lowered and a hitcher who did not show up is only refunded the fare minus NO_SHOW_FEE_PERCENT percent
+// @Tags ride
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.ReportNoShowRequest true "Report no-show request"
+// @Success 200 {object} helper.Response{data=schemas.CancelRideResponse} "Successfully reported no-show"
+// @Failure 400 {object} helper.Response "Invalid request or not at the pickup point"
+// @Failure 403 {object} helper.Response "User is not a participant of the ride"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 409 {object} helper.Response "Ride is not scheduled or the waiting time has not passed"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /ride/report-no-show [post]
+func (ctrl *RideController) ReportNoShow(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	var req schemas.ReportNoShowRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind JSON",
+			"Không thể bind JSON",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	rideDetail, err := ctrl.RideService.GetRideByID(req.RideID)
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get ride details",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	decision, err := ctrl.RideService.EvaluateNoShow(rideDetail, req, data.UserID)
+	if errors.Is(err, service.ErrNotRideParticipant) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You are not a participant of this ride",
+			"Bạn không phải là người tham gia chuyến đi này",
+		)
+		helper.GinResponse(ctx, 403, response)
+		return
+	}
+	if errors.Is(err, service.ErrOutsideGeofence) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"You must be at the pickup point to report a no-show",
+			"Bạn phải ở điểm đón để báo cáo vắng mặt",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if errors.Is(err, service.ErrRideNotScheduled) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"A no-show can only be reported on a scheduled ride",
+			"Chỉ có thể báo cáo vắng mặt cho chuyến đi đã lên lịch",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if errors.Is(err, cancellation.ErrTooEarlyForNoShow) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Please wait at the pickup point a little longer before reporting a no-show",
+			"Vui lòng chờ thêm tại điểm đón trước khi báo cáo vắng mặt",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to apply the cancellation policy",
+			"Không thể áp dụng chính sách hủy chuyến",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// The rides of the other legs of an itinerary are cancelled with this one
+	legRides, err := ctrl.RideService.GetItineraryLegRides(rideDetail.RideRequestID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get itinerary details",
+			"Không thể lấy thông tin hành trình",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	ride, err := ctrl.RideService.CancelRide(schemas.CancelRideRequest{
+		RideID: rideDetail.ID,
+		Reason: req.Reason,
+	}, data.UserID, decision)
+	if errors.Is(err, statemachine.ErrInvalidTransition) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride cannot be cancelled in its current status",
+			"Không thể hủy chuyến đi ở trạng thái hiện tại",
+		)
+		helper.GinResponse(ctx, 409, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to report no-show",
+			"Không thể báo cáo vắng mặt",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.CancelRideResponse{
+		RideID:          ride.ID,
+		RideOfferID:     ride.RideOfferID,
+		RideRequestID:   ride.RideRequestID,
+		ReceiverID:      decision.Responsible,
+		Outcome:         decision.Outcome,
+		CancellationFee: decision.Fee,
+	}
+
+	// Tell the participant who did not show up that the ride is cancelled
+	go ctrl.notifyNoShow(decision.Responsible, res)
+	go ctrl.refundCancelledRide(ride, decision)
+	go ctrl.notifyItineraryLegsCancelled(legRides)
+	go ctrl.unrouteRideRequest(ride)
+	for _, legRide := range legRides {
+		go ctrl.unrouteRideRequest(legRide)
+	}
+
+	response := helper.SuccessResponse(
+		res,
+		"Successfully reported no-show",
+		"Đã báo cáo vắng mặt thành công",
+	)
+	helper.GinResponse(ctx, 200, response)
+}
+
+// rerouteForRideRequest adds the pickup and drop-off of the hitcher of the ride to the route of the ride offer and sends
+// the new route to the given users, the ride keeps the original route if the new one cannot be computed.
+// It returns the meeting point of the hitcher on the route, nil when the route was not updated
+func (ctrl *RideController) rerouteForRideRequest(ctx context.Context, ride *migration.Ride, userIDs ...uuid.UUID) *schemas.MeetingPoint {
+	res, err := ctrl.MapsService.AddRideRequestWaypoints(ctx, ride.RideOfferID, ride.RideRequestID)
+	if err != nil {
+		log.Printf("Failed to add the pickup and drop-off of ride request %s to the route: %v", ride.RideRequestID, err)
+		return nil
+	}
+	res.RideID = ride.ID
+
+	ride.EncodedPolyline = polyline.Polyline(res.EncodedPolyline)
+	ride.Distance = res.Distance
+	ride.Duration = res.Duration
+	ride.EndTime = res.EndTime
+
+	for _, userID := range userIDs {
+		wsMessage := schemas.WebSocketMessage{
+			UserID:  userID.String(),
+			Type:    "ride-route-updated",
+			Payload: res,
+		}
+
+		go func() {
+			if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+				log.Printf("Failed to enqueue websocket message: %v", err)
+			}
+		}()
+	}
+
+	return &res.MeetingPoint
+}
+
+// unrouteRideRequest removes the pickup and drop-off of the hitcher of a ride that will not happen from the route
+// of the ride offer and sends the new route to the driver
+func (ctrl *RideController) unrouteRideRequest(ride migration.Ride) {
+	res, err := ctrl.MapsService.RemoveRideRequestWaypoints(context.Background(), ride.RideOfferID, ride.RideRequestID)
+	if errors.Is(err, repository.ErrRouteUnchanged) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to remove the pickup and drop-off of ride request %s from the route: %v", ride.RideRequestID, err)
+		return
+	}
+	res.RideID = ride.ID
+
+	rideOffer, err := ctrl.RideService.GetRideOfferByID(ride.RideOfferID)
+	if err != nil {
+		log.Printf("Failed to get ride offer %s: %v", ride.RideOfferID, err)
+		return
+	}
+
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  rideOffer.UserID.String(),
+		Type:    "ride-route-updated",
+		Payload: res,
+	}
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+}
+
+// completeItineraryLeg adds the pickup and drop-off of the hitcher to the route of the driver of a booked leg, the route
+// is kept when it cannot be updated. It returns the ride of the leg as shown to the hitcher
+func (ctrl *RideController) completeItineraryLeg(ctx context.Context, ride *migration.Ride, vehicle schemas.VehicleDetail, driver migration.User, hitcherID uuid.UUID) schemas.ItineraryRideDetail {
+	meetingPoint := ctrl.rerouteForRideRequest(ctx, ride, hitcherID, driver.ID)
+
+	rideRequest := ride.RideRequest
+	transaction := ride.Transactions[0]
+	return schemas.ItineraryRideDetail{
+		ID:            ride.ID,
+		RideOfferID:   ride.RideOfferID,
+		RideRequestID: ride.RideRequestID,
+		Status:        ride.Status,
+		StartTime:     rideRequest.StartTime,
+		EndTime:       rideRequest.EndTime,
+		StartAddress:  rideRequest.StartAddress,
+		EndAddress:    rideRequest.EndAddress,
+		Fare:          ride.Fare,
+		Transaction: schemas.TransactionDetail{
+			ID:            transaction.ID,
+			Amount:        transaction.Amount,
+			Status:        transaction.Status,
+			PaymentMethod: transaction.PaymentMethod,
+		},
+		Vehicle: vehicle,
+		UserInfo: schemas.UserInfo{
+			ID:            driver.ID,
+			PhoneNumber:   driver.PhoneNumber,
+			FullName:      driver.FullName,
+			AvatarURL:     driver.AvatarURL,
+			Gender:        driver.Gender,
+			IsMomoLinked:  driver.IsMomoLinked,
+			BalanceInApp:  driver.BalanceInApp,
+			AverageRating: driver.AverageRating,
+		},
+		MeetingPoint: meetingPoint,
+	}
+}
+
+// notifyItineraryBooked sends a driver the leg of an itinerary booked on their ride offer
+func (ctrl *RideController) notifyItineraryBooked(driverID uuid.UUID, res schemas.ItineraryBookedResponse) {
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  driverID.String(),
+		Type:    "itinerary-booked",
+		Payload: res,
+	}
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+
+	driver, err := ctrl.UserService.GetUserByID(driverID)
+	if err != nil {
+		log.Printf("Failed to get user %s to send the booked itinerary: %v", driverID, err)
+		return
+	}
+
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: "itinerary-booked",
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notification := schemas.Notification{
+		Title: "Có người đi nhờ mới trên chuyến đi của bạn",
+		Body:  fmt.Sprintf("%s đã đặt một chặng trên chuyến đi của bạn", res.Ride.UserInfo.FullName),
+		Token: driver.DeviceToken,
+		Data:  notificationPayloadMap,
+	}
+	if err := ctrl.asyncClient.EnqueueFCMNotification(notification); err != nil {
+		log.Printf("Failed to enqueue FCM notification: %v", err)
+	}
+}
+
+// pushDriverETA sends the ETA of the driver to the pickup point (or the drop-off once the ride is ongoing) to the hitcher,
+// nothing is sent when the ETA of the ride was already calculated recently
+func (ctrl *RideController) pushDriverETA(ride migration.Ride, rideRequest migration.RideRequest, currentLocation schemas.Point) {
+	res, ok, err := ctrl.MapsService.EstimateDriverETA(context.Background(), ride, rideRequest, currentLocation)
+	if err != nil {
+		log.Printf("Failed to estimate the driver ETA of ride %s: %v", ride.ID, err)
+		return
+	}
+	if !ok {
+		return
+	}
+
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  rideRequest.UserID.String(),
+		Type:    "driver-eta",
+		Payload: res,
+	}
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+}
+
+// pushGeofenceEvents sends the geofence events the driver entered with the current location to both the driver and the hitcher
+func (ctrl *RideController) pushGeofenceEvents(ride migration.Ride, rideRequest migration.RideRequest, driverID uuid.UUID, currentLocation schemas.Point) {
+	events, err := ctrl.GeofenceService.EvaluateLocation(context.Background(), ride, rideRequest, currentLocation)
+	if err != nil {
+		log.Printf("Failed to evaluate the geofence of ride %s: %v", ride.ID, err)
+	}
+
+	res := schemas.GeofenceEventResponse{
+		RideID:        ride.ID,
+		RideOfferID:   ride.RideOfferID,
+		RideRequestID: rideRequest.ID,
+		Latitude:      currentLocation.Lat,
+		Longitude:     currentLocation.Lng,
+	}
+
+	for _, event := range events {
+		for _, userID := range []uuid.UUID{driverID, rideRequest.UserID} {
+			wsMessage := schemas.WebSocketMessage{
+				UserID:  userID.String(),
+				Type:    event,
+				Payload: res,
+			}
+			if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+				log.Printf("Failed to enqueue websocket message: %v", err)
+			}
+		}
+	}
+}
+
+// checkMatchPreferences checks that the driver of the ride offer and the hitcher of the ride request meet the match preferences of each other
+// and that the ride offer allows the amenities of the ride request
+func (ctrl *RideController) checkMatchPreferences(rideOffer migration.RideOffer, rideRequest migration.RideRequest) (bool, error) {
+	driver, err := ctrl.UserService.GetUserByID(rideOffer.UserID)
+	if err != nil {
+		return false, err
+	}
+	hitcher, err := ctrl.UserService.GetUserByID(rideRequest.UserID)
+	if err != nil {
+		return false, err
+	}
+
+	return helper.IsPreferenceMatch(rideOffer, driver, rideRequest, hitcher) && helper.IsAmenityMatch(rideOffer, rideRequest), nil
+}
+
+// refundCancelledRide sends back the MoMo payment of the hitcher of a cancelled ride, minus the fee kept by the
+// cancellation policy, once the cancellation is committed. A refund that fails stays pending and is retried
+// by the expiry job (see ExpiryService.sendPendingRefunds)
+func (ctrl *RideController) refundCancelledRide(ride migration.Ride, decision cancellation.Decision) {
+	transaction, err := ctrl.RideService.GetTransactionByRideID(ride.ID)
+	if err != nil {
+		log.Printf("Failed to get the transaction of ride %s to refund the hitcher: %v", ride.ID, err)
+		return
+	}
+
+	// The payment of a hitcher who is matched again is kept on hold for the next ride
+	if transaction.PaymentMethod != "momo" || transaction.Status != statemachine.StatusRefunded || transaction.RefundedAt != nil {
+		return
+	}
+
+	if err := ctrl.PaymentService.RefundTransaction(transaction); err != nil {
+		log.Printf("Failed to refund transaction %s, it will be retried: %v", transaction.ID, err)
+		return
+	}
+
+	hitcher, err := ctrl.UserService.GetUserByID(decision.HitcherID)
+	if err != nil {
+		log.Printf("Failed to get hitcher %s to send the refund: %v", decision.HitcherID, err)
+		return
+	}
+
+	body := "Chuyến đi của bạn đã bị hủy, bạn đã được hoàn tiền"
+	if decision.Fee > 0 {
+		body = fmt.Sprintf("Chuyến đi của bạn đã bị hủy, bạn đã được hoàn tiền sau khi trừ phí hủy chuyến %d VND", decision.Fee)
+	}
+
+	// Send notification and WebSocket message to the hitcher
+	notification := schemas.Notification{
+		Title: "Chuyến đi của bạn đã bị hủy",
+		Body:  body,
+		Token: hitcher.DeviceToken,
+		Data:  nil,
+	}
+
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  decision.HitcherID.String(),
+		Type:    "refund-success",
+		Payload: nil,
+	}
+
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+	if err := ctrl.asyncClient.EnqueueFCMNotification(notification); err != nil {
+		log.Printf("Failed to enqueue FCM notification: %v", err)
+	}
+}
+
+// pushRematchSuggestions sends the best ride offers for the ride request of a hitcher whose driver cancelled
+// through the websocket and FCM queues
+func (ctrl *RideController) pushRematchSuggestions(ride migration.Ride, hitcherID uuid.UUID, paymentOnHold bool) {
+	// A leg of an itinerary is not matched again on its own, the ride request of the whole journey is
+	rideRequestID := ride.RideRequestID
+	rideRequest, err := ctrl.RideService.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		log.Printf("Failed to get ride request %s: %v", ride.RideRequestID, err)
+		return
+	}
+	if rideRequest.ParentRideRequestID != nil {
+		rideRequestID = *rideRequest.ParentRideRequestID
+	}
+
+	matches, err := ctrl.MapsService.SuggestRematchRideOffers(context.Background(), hitcherID, rideRequestID, ride.RideOfferID)
+	if err != nil {
+		log.Printf("Failed to suggest ride offers for ride request %s: %v", rideRequestID, err)
+		return
+	}
+
+	rideOfferDetails := make([]schemas.RideOfferDetail, 0, len(matches))
+	for _, match := range matches {
+		rideOffer := match.RideOffer
+		user, err := ctrl.UserService.GetUserByID(rideOffer.UserID)
+		if err != nil {
+			log.Printf("Failed to get user %s: %v", rideOffer.UserID, err)
+			continue
+		}
+		vehicle, err := ctrl.VehicleService.GetVehicleFromID(rideOffer.VehicleID)
+		if err != nil {
+			log.Printf("Failed to get vehicle %s: %v", rideOffer.VehicleID, err)
+			continue
+		}
+		waypoints, err := ctrl.MapsService.GetAllWaypoints(rideOffer.ID)
+		if err != nil {
+			log.Printf("Failed to get waypoints of ride offer %s: %v", rideOffer.ID, err)
+			continue
+		}
+		waypointDetails := make([]schemas.Waypoint, 0, len(waypoints))
+		for _, waypoint := range waypoints {
+			waypointDetails = append(waypointDetails, schemas.Waypoint{
+				Latitude:  waypoint.Latitude,
+				Longitude: waypoint.Longitude,
+				Address:   waypoint.Address,
+				ID:        waypoint.ID,
+				Order:     waypoint.WaypointOrder,
+				Type:      waypoint.Type,
+			})
+		}
+
+		rideOfferDetails = append(rideOfferDetails, schemas.RideOfferDetail{
+			ID: rideOffer.ID,
+			User: schemas.UserInfo{
+				ID:            user.ID,
+				FullName:      user.FullName,
+				PhoneNumber:   user.PhoneNumber,
+				AvatarURL:     user.AvatarURL,
+				Gender:        user.Gender,
+				IsMomoLinked:  user.IsMomoLinked,
+				BalanceInApp:  user.BalanceInApp,
+				AverageRating: user.AverageRating,
+			},
+			Vehicle:                vehicle,
+			EncodedPolyline:        string(rideOffer.EncodedPolyline),
+			Distance:               rideOffer.Distance,
+			Duration:               rideOffer.Duration,
+			StartTime:              rideOffer.StartTime,
+			EndTime:                rideOffer.EndTime,
+			StartLatitude:          rideOffer.StartLatitude,
+			StartLongitude:         rideOffer.StartLongitude,
+			EndLatitude:            rideOffer.EndLatitude,
+			EndLongitude:           rideOffer.EndLongitude,
+			StartAddress:           rideOffer.StartAddress,
+			EndAddress:             rideOffer.EndAddress,
+			DriverCurrentLatitude:  rideOffer.DriverCurrentLatitude,
+			DriverCurrentLongitude: rideOffer.DriverCurrentLongitude,
+			Status:                 rideOffer.Status,
+			Fare:                   rideOffer.Fare,
+			SegmentFare:            match.SegmentFare,
+			Waypoints:              waypointDetails,
+			Seats:                  rideOffer.Seats,
+			AvailableSeats:         rideOffer.AvailableSeats,
+			Match:                  &match.Score,
+		})
+	}
+
+	res := schemas.RematchSuggestionsResponse{
+		RideID:        ride.ID,
+		RideRequestID: rideRequestID,
+		PaymentOnHold: paymentOnHold,
+		RideOffers:    rideOfferDetails,
+	}
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  hitcherID.String(),
+		Type:    "rematch-suggestions",
+		Payload: res,
+	}
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+
+	hitcher, err := ctrl.UserService.GetUserByID(hitcherID)
+	if err != nil {
+		log.Printf("Failed to get hitcher %s to send the rematch suggestions: %v", hitcherID, err)
+		return
+	}
+
+	// The ride offers do not fit in an FCM message, the app gets them from the websocket or /map/suggest-give-rides
+	res.RideOffers = nil
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: "rematch-suggestions",
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	body := "Tài xế đã hủy chuyến, chúng tôi đang tìm chuyến đi khác cho bạn"
+	if len(rideOfferDetails) > 0 {
+		body = fmt.Sprintf("Tài xế đã hủy chuyến, chúng tôi đã tìm được %d chuyến đi khác cho bạn", len(rideOfferDetails))
+	}
+	notification := schemas.Notification{
+		Title: "Chuyến đi của bạn đã bị hủy",
+		Body:  body,
+		Token: hitcher.DeviceToken,
+		Data:  notificationPayloadMap,
+	}
+	if err := ctrl.asyncClient.EnqueueFCMNotification(notification); err != nil {
+		log.Printf("Failed to enqueue FCM notification: %v", err)
+	}
+}
+
+// notifyNoShow sends the no-show to the participant who did not show up through the websocket and FCM queues
+func (ctrl *RideController) notifyNoShow(userID uuid.UUID, res schemas.CancelRideResponse) {
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  userID.String(),
+		Type:    "ride-no-show",
+		Payload: res,
+	}
+	if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+
+	user, err := ctrl.UserService.GetUserByID(userID)
+	if err != nil {
+		log.Printf("Failed to get user %s to send the no-show: %v", userID, err)
+		return
+	}
+
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: "ride-no-show",
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notification := schemas.Notification{
+		Title: "Chuyến đi của bạn đã bị hủy",
+		Body:  "Chuyến đi đã bị hủy vì bạn không có mặt tại điểm đón",
+		Token: user.DeviceToken,
+		Data:  notificationPayloadMap,
+	}
+	if err := ctrl.asyncClient.EnqueueFCMNotification(notification); err != nil {
+		log.Printf("Failed to enqueue FCM notification: %v", err)
+	}
+}
+
+// notifyItineraryLegsCancelled tells the drivers of the other legs of an itinerary that their leg was cancelled with
+// the one the hitcher lost, their ride offers stay open for other hitchers
+func (ctrl *RideController) notifyItineraryLegsCancelled(rides []migration.Ride) {
+	for _, ride := range rides {
+		res := schemas.CancelRideResponse{
+			RideID:        ride.ID,
+			RideOfferID:   ride.RideOfferID,
+			RideRequestID: ride.RideRequestID,
+			ReceiverID:    ride.RideOffer.UserID,
+			Outcome:       cancellation.OutcomeFree,
+		}
+
+		wsMessage := schemas.WebSocketMessage{
+			UserID:  ride.RideOffer.UserID.String(),
+			Type:    "itinerary-leg-cancelled",
+			Payload: res,
+		}
+		if err := ctrl.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+			log.Printf("Failed to enqueue websocket message: %v", err)
+		}
+
+		resMap, err := helper.ConvertToStringMap(res)
+		if err != nil {
+			log.Printf("Failed to convert struct to map: %v", err)
+			continue
+		}
+		notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+			Type: "itinerary-leg-cancelled",
+			Data: resMap,
+		})
+		if err != nil {
+			log.Printf("Failed to convert struct to map: %v", err)
+			continue
+		}
+
+		notification := schemas.Notification{
+			Title: "Chuyến đi của bạn đã bị hủy",
+			Body:  "Hành khách đã hủy hành trình nối chuyến, chuyến đi của bạn vẫn mở cho người khác đặt",
+			Token: ride.RideOffer.User.DeviceToken,
+			Data:  notificationPayloadMap,
+		}
+		if err := ctrl.asyncClient.EnqueueFCMNotification(notification); err != nil {
+			log.Printf("Failed to enqueue FCM notification: %v", err)
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package controller
+
+import (
+	"errors"
+	"io"
+	"log"
+	"time"
+
+	"shareway/helper"
+	"shareway/repository"
+	"shareway/schemas"
+	"shareway/service"
+	"shareway/util"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+)
+
+// ShareController handles the public endpoints opened by the live trip links, they are not authenticated
+// and only rely on the read-only ride share token
+type ShareController struct {
+	cfg           util.Config
+	validate      *validator.Validate
+	SafetyService service.ISafetyService
+}
+
+func NewShareController(cfg util.Config, validate *validator.Validate, safetyService service.ISafetyService) *ShareController {
+	return &ShareController{
+		cfg:           cfg,
+		validate:      validate,
+		SafetyService: safetyService,
+	}
+}
+
+// TrackSharedRide streams the position of the driver of a shared ride
+// TrackSharedRide godoc
+// @Summary Follow a shared ride
+// @Description Streams (server-sent events) the driver's position, the vehicle's plate and the ETA of a shared ride every RIDE_SHARE_INTERVAL seconds. A "location" event is sent on each update and an "ended" event closes the stream when the ride is completed or cancelled or the token expires
+// @Tags share
+// @Produce text/event-stream
+// @Param token query string true "Ride share token"
+// @Success 200 {object} schemas.SharedRideLocation "Stream of location events"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 401 {object} helper.Response "Invalid or expired token"
+// @Failure 404 {object} helper.Response "Ride not found"
+// @Failure 410 {object} helper.Response "Ride is completed or cancelled"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /share/track-ride [get]
+func (ctrl *ShareController) TrackSharedRide(ctx *gin.Context) {
+	var req schemas.TrackSharedRideRequest
+	if err := ctx.ShouldBindQuery(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind query",
+			"Không thể bind query",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Check the link once before opening the stream so a dead link gets a plain error response
+	res, err := ctrl.SafetyService.TrackSharedRide(req.Token)
+	if errors.Is(err, service.ErrInvalidShareToken) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"This link is invalid or has expired",
+			"Liên kết không hợp lệ hoặc đã hết hạn",
+		)
+		helper.GinResponse(ctx, 401, response)
+		return
+	}
+	if errors.Is(err, repository.ErrRideNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Ride not found",
+			"Không tìm thấy chuyến đi",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if errors.Is(err, service.ErrRideNotActive) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"This ride has ended",
+			"Chuyến đi đã kết thúc",
+		)
+		helper.GinResponse(ctx, 410, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get shared ride",
+			"Không thể lấy thông tin chuyến đi",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	interval := time.Duration(ctrl.cfg.RideShareInterval) * time.Second
+	ctx.Stream(func(w io.Writer) bool {
+		ctx.SSEvent("location", res)
+
+		select {
+		case <-ctx.Request.Context().Done():
+			return false
+		case <-time.After(interval):
+		}
+
+		next, err := ctrl.SafetyService.TrackSharedRide(req.Token)
+		if errors.Is(err, service.ErrInvalidShareToken) || errors.Is(err, service.ErrRideNotActive) || errors.Is(err, repository.ErrRideNotFound) {
+			ctx.SSEvent("ended", gin.H{"ride_id": res.RideID, "reason": err.Error()})
+			return false
+		}
+		if err != nil {
+			// Keep the stream open and send the last known location again
+			log.Printf("Failed to get shared ride %s: %v", res.RideID, err)
+			return true
+		}
+
+		res = next
+		return true
+	})
+}
@@ -1,10 +1,13 @@
 package controller
 
 import (
+	"errors"
 	"fmt"
 
 	"shareway/helper"
+	"shareway/infra/db/migration"
 	"shareway/middleware"
+	"shareway/repository"
 	"shareway/schemas"
 	"shareway/service"
 
@@ -13,14 +16,16 @@ import (
 )
 
 type UserController struct {
-	UserService service.IUsersService
-	validate    *validator.Validate
+	UserService   service.IUsersService
+	SafetyService service.ISafetyService
+	validate      *validator.Validate
 }
 
-func NewUserController(userService service.IUsersService, validate *validator.Validate) *UserController {
+func NewUserController(userService service.IUsersService, safetyService service.ISafetyService, validate *validator.Validate) *UserController {
 	return &UserController{
-		UserService: userService,
-		validate:    validate,
+		UserService:   userService,
+		SafetyService: safetyService,
+		validate:      validate,
 	}
 }
 
@@ -68,20 +73,24 @@ func (ctrl *UserController) GetUserProfile(ctx *gin.Context) {
 
 	res := schemas.GetUserProfileResponse{
 		User: schemas.UserResponse{
-			ID:            user.ID,
-			AvatarURL:     user.AvatarURL,
-			Gender:        user.Gender,
-			CreatedAt:     user.CreatedAt,
-			UpdatedAt:     user.UpdatedAt,
-			PhoneNumber:   user.PhoneNumber,
-			Email:         user.Email,
-			FullName:      user.FullName,
-			IsVerified:    user.IsVerified,
-			IsMomoLinked:  user.IsMomoLinked,
-			IsActivated:   user.IsActivated,
-			Role:          user.Role,
-			BalanceInApp:  user.BalanceInApp,
-			AverageRating: user.AverageRating,
+			ID:                user.ID,
+			AvatarURL:         user.AvatarURL,
+			Gender:            user.Gender,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+			PhoneNumber:       user.PhoneNumber,
+			Email:             user.Email,
+			FullName:          user.FullName,
+			IsVerified:        user.IsVerified,
+			IsMomoLinked:      user.IsMomoLinked,
+			IsActivated:       user.IsActivated,
+			Role:              user.Role,
+			BalanceInApp:      user.BalanceInApp,
+			AverageRating:     user.AverageRating,
+			ReliabilityScore:  user.ReliabilityScore,
+			CompletedRides:    user.CompletedRides,
+			LateCancellations: user.LateCancellations,
+			NoShows:           user.NoShows,
 		},
 	}
 
@@ -239,20 +248,24 @@ func (ctrl *UserController) UpdateUserProfile(ctx *gin.Context) {
 
 	res := schemas.UpdateUserProfileResponse{
 		User: schemas.UserResponse{
-			ID:            user.ID,
-			Gender:        user.Gender,
-			CreatedAt:     user.CreatedAt,
-			UpdatedAt:     user.UpdatedAt,
-			AvatarURL:     user.AvatarURL,
-			IsMomoLinked:  user.IsMomoLinked,
-			PhoneNumber:   user.PhoneNumber,
-			Email:         user.Email,
-			FullName:      user.FullName,
-			IsVerified:    user.IsVerified,
-			IsActivated:   user.IsActivated,
-			Role:          user.Role,
-			BalanceInApp:  user.BalanceInApp,
-			AverageRating: user.AverageRating,
+			ID:                user.ID,
+			Gender:            user.Gender,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+			AvatarURL:         user.AvatarURL,
+			IsMomoLinked:      user.IsMomoLinked,
+			PhoneNumber:       user.PhoneNumber,
+			Email:             user.Email,
+			FullName:          user.FullName,
+			IsVerified:        user.IsVerified,
+			IsActivated:       user.IsActivated,
+			Role:              user.Role,
+			BalanceInApp:      user.BalanceInApp,
+			AverageRating:     user.AverageRating,
+			ReliabilityScore:  user.ReliabilityScore,
+			CompletedRides:    user.CompletedRides,
+			LateCancellations: user.LateCancellations,
+			NoShows:           user.NoShows,
 		},
 	}
 
@@ -339,22 +352,365 @@ func (ctrl *UserController) UpdateAvatar(ctx *gin.Context) {
 
 	res := schemas.UpdateAvatarResponse{
 		User: schemas.UserResponse{
-			ID:            user.ID,
-			CreatedAt:     user.CreatedAt,
-			UpdatedAt:     user.UpdatedAt,
-			AvatarURL:     avatarURL,
-			PhoneNumber:   user.PhoneNumber,
-			Email:         user.Email,
-			IsMomoLinked:  user.IsMomoLinked,
-			FullName:      user.FullName,
-			IsVerified:    user.IsVerified,
-			IsActivated:   user.IsActivated,
-			Role:          user.Role,
-			Gender:        user.Gender,
-			BalanceInApp:  user.BalanceInApp,
-			AverageRating: user.AverageRating,
+			ID:                user.ID,
+			CreatedAt:         user.CreatedAt,
+			UpdatedAt:         user.UpdatedAt,
+			AvatarURL:         avatarURL,
+			PhoneNumber:       user.PhoneNumber,
+			Email:             user.Email,
+			IsMomoLinked:      user.IsMomoLinked,
+			FullName:          user.FullName,
+			IsVerified:        user.IsVerified,
+			IsActivated:       user.IsActivated,
+			Role:              user.Role,
+			Gender:            user.Gender,
+			BalanceInApp:      user.BalanceInApp,
+			AverageRating:     user.AverageRating,
+			ReliabilityScore:  user.ReliabilityScore,
+			CompletedRides:    user.CompletedRides,
+			LateCancellations: user.LateCancellations,
+			NoShows:           user.NoShows,
 		}}
 
 	response := helper.SuccessResponse(res, "Successfully updated avatar", "Cập nhật ảnh đại diện thành công")
 	helper.GinResponse(ctx, 200, response)
 }
+
+// GetMatchPreferences returns the default match preferences of the user
+// GetMatchPreferences godoc
+// @Summary Get match preferences
+// @Description Get the default match preferences (same gender only, verified only, minimum rating) of the authenticated user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=schemas.MatchPreferences} "Successfully got match preferences"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /user/get-match-preferences [get]
+func (ctrl *UserController) GetMatchPreferences(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	user, err := ctrl.UserService.GetUserByID(data.UserID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get user information",
+			"Không thể lấy thông tin người dùng",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.MatchPreferences(user.MatchPreferences)
+
+	response := helper.SuccessResponse(res, "Successfully got match preferences", "Lấy tiêu chí ghép chuyến thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// UpdateMatchPreferences updates the default match preferences of the user
+// UpdateMatchPreferences godoc
+// @Summary Update match preferences
+// @Description Update the default match preferences of the authenticated user, used by the rides created without their own preferences
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.MatchPreferences true "Match preferences"
+// @Success 200 {object} helper.Response{data=schemas.MatchPreferences} "Successfully updated match preferences"
+// @Failure 400 {object} helper.Response "Invalid input"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /user/update-match-preferences [post]
+func (ctrl *UserController) UpdateMatchPreferences(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Bind request to schema
+	var req schemas.MatchPreferences
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to bind request"),
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	err = ctrl.UserService.UpdateMatchPreferences(data.UserID, migration.MatchPreferences(req))
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to update match preferences",
+			"Không thể cập nhật tiêu chí ghép chuyến",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(req, "Successfully updated match preferences", "Cập nhật tiêu chí ghép chuyến thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// AddTrustedContact registers a person to be told by SMS when the user presses the SOS button during a ride
+// AddTrustedContact godoc
+// @Summary Add a trusted contact
+// @Description Registers a trusted contact of the authenticated user, they are told by SMS when the user presses the SOS button during a ride
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.AddTrustedContactRequest true "Trusted contact information"
+// @Success 200 {object} helper.Response{data=schemas.TrustedContactDetail} "Successfully added trusted contact"
+// @Failure 400 {object} helper.Response "Invalid request or maximum number of trusted contacts reached"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /user/add-trusted-contact [post]
+func (ctrl *UserController) AddTrustedContact(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Bind request to schema
+	var req schemas.AddTrustedContactRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	contact, err := ctrl.SafetyService.AddTrustedContact(req, data.UserID)
+	if errors.Is(err, service.ErrTooManyTrustedContacts) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			fmt.Sprintf("You can add at most %d trusted contacts", service.MaxTrustedContacts),
+			fmt.Sprintf("Bạn chỉ có thể thêm tối đa %d liên hệ tin cậy", service.MaxTrustedContacts),
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to add trusted contact",
+			"Không thể thêm liên hệ tin cậy",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	res := schemas.TrustedContactDetail{
+		ID:           contact.ID,
+		FullName:     contact.FullName,
+		PhoneNumber:  contact.PhoneNumber,
+		Relationship: contact.Relationship,
+		CreatedAt:    contact.CreatedAt,
+	}
+
+	response := helper.SuccessResponse(res, "Successfully added trusted contact", "Thêm liên hệ tin cậy thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// GetTrustedContacts returns the trusted contacts of the user
+// GetTrustedContacts godoc
+// @Summary Get trusted contacts
+// @Description Returns the trusted contacts of the authenticated user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} helper.Response{data=schemas.GetTrustedContactsResponse} "Successfully got trusted contacts"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /user/get-trusted-contacts [get]
+func (ctrl *UserController) GetTrustedContacts(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	contacts, err := ctrl.SafetyService.GetTrustedContacts(data.UserID)
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to get trusted contacts",
+			"Không thể lấy danh sách liên hệ tin cậy",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	contactDetails := make([]schemas.TrustedContactDetail, 0, len(contacts))
+	for _, contact := range contacts {
+		contactDetails = append(contactDetails, schemas.TrustedContactDetail{
+			ID:           contact.ID,
+			FullName:     contact.FullName,
+			PhoneNumber:  contact.PhoneNumber,
+			Relationship: contact.Relationship,
+			CreatedAt:    contact.CreatedAt,
+		})
+	}
+
+	res := schemas.GetTrustedContactsResponse{
+		Contacts: contactDetails,
+	}
+
+	response := helper.SuccessResponse(res, "Successfully got trusted contacts", "Lấy danh sách liên hệ tin cậy thành công")
+	helper.GinResponse(ctx, 200, response)
+}
+
+// DeleteTrustedContact removes a trusted contact of the user
+// DeleteTrustedContact godoc
+// @Summary Delete a trusted contact
+// @Description Removes a trusted contact of the authenticated user
+// @Tags user
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param request body schemas.DeleteTrustedContactRequest true "Trusted contact to delete"
+// @Success 200 {object} helper.Response "Successfully deleted trusted contact"
+// @Failure 400 {object} helper.Response "Invalid request"
+// @Failure 404 {object} helper.Response "Trusted contact not found"
+// @Failure 500 {object} helper.Response "Internal server error"
+// @Router /user/delete-trusted-contact [post]
+func (ctrl *UserController) DeleteTrustedContact(ctx *gin.Context) {
+	// Get payload from context
+	payload := ctx.MustGet((middleware.AuthorizationPayloadKey))
+
+	// Convert payload to map
+	data, err := helper.ConvertToPayload(payload)
+
+	// If error occurs, return error response
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			fmt.Errorf("failed to convert payload"),
+			"Failed to convert payload",
+			"Không thể chuyển đổi payload",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	// Bind request to schema
+	var req schemas.DeleteTrustedContactRequest
+	if err := ctx.ShouldBindJSON(&req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to bind request",
+			"Không thể bind request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	// Validate request
+	if err := ctrl.validate.Struct(req); err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to validate request",
+			"Không thể validate request",
+		)
+		helper.GinResponse(ctx, 400, response)
+		return
+	}
+
+	err = ctrl.SafetyService.DeleteTrustedContact(req.ContactID, data.UserID)
+	if errors.Is(err, repository.ErrTrustedContactNotFound) {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Trusted contact not found",
+			"Không tìm thấy liên hệ tin cậy",
+		)
+		helper.GinResponse(ctx, 404, response)
+		return
+	}
+	if err != nil {
+		response := helper.ErrorResponseWithMessage(
+			err,
+			"Failed to delete trusted contact",
+			"Không thể xóa liên hệ tin cậy",
+		)
+		helper.GinResponse(ctx, 500, response)
+		return
+	}
+
+	response := helper.SuccessResponse(nil, "Successfully deleted trusted contact", "Xóa liên hệ tin cậy thành công")
+	helper.GinResponse(ctx, 200, response)
+}
@@ -4,6 +4,8 @@ import (
 	"encoding/binary"
 	"encoding/json"
 	"fmt"
+	"strconv"
+	"strings"
 
 	"shareway/util/sanctum"
 
@@ -86,3 +88,25 @@ func ConvertToAdminPayload(data interface{}) (*sanctum.SanctumTokenPayload, erro
 	}
 	return payload, nil
 }
+
+// JoinDaysOfWeek converts a list of days of the week (0 = Sunday) to a comma separated string
+func JoinDaysOfWeek(days []int) string {
+	parts := make([]string, len(days))
+	for i, day := range days {
+		parts[i] = strconv.Itoa(day)
+	}
+	return strings.Join(parts, ",")
+}
+
+// SplitDaysOfWeek converts a comma separated string of days of the week back to a list, invalid entries are ignored
+func SplitDaysOfWeek(days string) []int {
+	result := make([]int, 0, 7)
+	for _, part := range strings.Split(days, ",") {
+		day, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || day < 0 || day > 6 {
+			continue
+		}
+		result = append(result, day)
+	}
+	return result
+}
@@ -2,12 +2,14 @@ package helper
 
 import (
 	"math"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
 
 	"shareway/infra/db/migration"
 	"shareway/schemas"
+	encodedpolyline "shareway/util/polyline"
 
 	"github.com/twpayne/go-polyline"
 )
@@ -74,6 +76,36 @@ func DecodePolyline(encodedPolyline string) []schemas.Point {
 	return points
 }
 
+// EncodePolyline encodes the points into a polyline string
+func EncodePolyline(points []schemas.Point) string {
+	coords := make([][]float64, 0, len(points))
+	for _, point := range points {
+		coords = append(coords, []float64{point.Lat, point.Lng})
+	}
+
+	return string(polyline.EncodeCoords(coords))
+}
+
+// BreadcrumbPoints converts the recorded breadcrumbs of a ride into points
+func BreadcrumbPoints(breadcrumbs []migration.RideBreadcrumb) []schemas.Point {
+	points := make([]schemas.Point, 0, len(breadcrumbs))
+	for _, breadcrumb := range breadcrumbs {
+		points = append(points, schemas.Point{Lat: breadcrumb.Latitude, Lng: breadcrumb.Longitude})
+	}
+
+	return points
+}
+
+// PathDistance returns the length in kilometers of the path going through the points in order
+func PathDistance(points []schemas.Point) float64 {
+	distance := 0.0
+	for i := 1; i < len(points); i++ {
+		distance += haversineDistance(points[i-1], points[i])
+	}
+
+	return distance
+}
+
 func ConvertStringToLocation(point string) schemas.Point {
 	// Split the string into latitude and longitude
 	latLng := strings.Split(point, ",")
@@ -158,14 +190,26 @@ func pointToSegmentDistanceSq(p, v, w schemas.Point) float64 {
 		return squaredDistance(p, v) // Nếu v và w là cùng một điểm
 	}
 
+	return squaredDistance(p, projectOntoSegment(p, v, w))
+}
+
+// projectOntoSegment returns the point of the segment vw nearest to p
+func projectOntoSegment(p, v, w schemas.Point) schemas.Point {
+	dx := w.Lng - v.Lng
+	dy := w.Lat - v.Lat
+	lengthSq := dx*dx + dy*dy
+
+	if lengthSq == 0 {
+		return v
+	}
+
 	// Tính t để xác định vị trí gần nhất trên đoạn thẳng
 	t := ((p.Lng-v.Lng)*dx + (p.Lat-v.Lat)*dy) / lengthSq
 	t = math.Max(0, math.Min(1, t))
-	projection := schemas.Point{
+	return schemas.Point{
 		Lng: v.Lng + t*dx,
 		Lat: v.Lat + t*dy,
 	}
-	return squaredDistance(p, projection)
 }
 
 // Hàm tính bình phương khoảng cách giữa hai điểm
@@ -209,6 +253,26 @@ func squaredDistance(p1, p2 schemas.Point) float64 {
 // 	return minStartDistSq <= maxDistanceSq && minEndDistSq <= maxDistanceSq && startIdx < endIdx
 // }
 
+// IsAmenityMatch checks that the ride offer allows the luggage, the pet and the smoking of the hitcher of the ride request,
+// and that a hitcher who needs a helmet gets a spare one on a motorbike
+func IsAmenityMatch(offer migration.RideOffer, request migration.RideRequest) bool {
+	if request.Luggage && !offer.Luggage {
+		return false
+	}
+	if request.Pets && !offer.Pets {
+		return false
+	}
+	if request.Smoking && !offer.Smoking {
+		return false
+	}
+	return !(offer.Motorbike && request.SpareHelmet && !offer.SpareHelmet)
+}
+
+// IsMotorbike tells whether the vehicle type is a motorbike
+func IsMotorbike(vehicleType migration.VehicleType) bool {
+	return vehicleType.Category == migration.VehicleCategoryMotorbike
+}
+
 // func IsSubRoute(offerPolyline, requestPolyline []schemas.Point) bool {
 // 	// Check if the request polyline is a sub-route of the offer polyline
 // 	// If requestPolyline is longer than offerPolyline, it can't be a sub-route
@@ -281,6 +345,42 @@ func FindClosestPoints(polyline []schemas.Point, start, end schemas.Point) (star
 	return startPoint, endPoint
 }
 
+// GetBoundingBox returns the smallest box that contains every point of the polyline
+func GetBoundingBox(points []schemas.Point) schemas.BoundingBox {
+	if len(points) == 0 {
+		return schemas.BoundingBox{}
+	}
+
+	box := schemas.BoundingBox{
+		MinLat: points[0].Lat,
+		MinLng: points[0].Lng,
+		MaxLat: points[0].Lat,
+		MaxLng: points[0].Lng,
+	}
+	for _, point := range points[1:] {
+		box.MinLat = math.Min(box.MinLat, point.Lat)
+		box.MinLng = math.Min(box.MinLng, point.Lng)
+		box.MaxLat = math.Max(box.MaxLat, point.Lat)
+		box.MaxLng = math.Max(box.MaxLng, point.Lng)
+	}
+	return box
+}
+
+// ExpandBoundingBoxForMatch grows the box by the tolerance used in IsMatchRoute, so any route
+// that IsMatchRoute could accept against this box is guaranteed to intersect the expanded box.
+// Longitude is divided by cos(lat) because squaredDistance scales longitude the other way.
+func ExpandBoundingBoxForMatch(box schemas.BoundingBox) schemas.BoundingBox {
+	maxAbsLat := math.Max(math.Abs(box.MinLat), math.Abs(box.MaxLat)) + maxDistanceMatch
+	lngMargin := maxDistanceMatch / math.Cos(math.Min(maxAbsLat, 89)*degreesToRad)
+
+	return schemas.BoundingBox{
+		MinLat: box.MinLat - maxDistanceMatch,
+		MinLng: box.MinLng - lngMargin,
+		MaxLat: box.MaxLat + maxDistanceMatch,
+		MaxLng: box.MaxLng + lngMargin,
+	}
+}
+
 func IsTimeOverlap(offer migration.RideOffer, request migration.RideRequest) bool {
 	// Add a buffer of 30 minutes to the start and end time of the offer
 	// to account for the time it takes to pick up the hitchhiker and drop them off
@@ -290,6 +390,22 @@ func IsTimeOverlap(offer migration.RideOffer, request migration.RideRequest) boo
 	return offerStartTime.Before(request.StartTime) && offerEndTime.After(request.EndTime)
 }
 
+// IsPreferenceMatch checks that the driver of the offer and the hitcher of the request meet the match preferences of each other
+func IsPreferenceMatch(offer migration.RideOffer, driver migration.User, request migration.RideRequest, hitcher migration.User) bool {
+	return meetsPreferences(offer.MatchPreferences, driver, hitcher) && meetsPreferences(request.MatchPreferences, hitcher, driver)
+}
+
+// meetsPreferences checks that the other user meets the match preferences of the user
+func meetsPreferences(preferences migration.MatchPreferences, user, other migration.User) bool {
+	if preferences.SameGenderOnly && other.Gender != user.Gender {
+		return false
+	}
+	if preferences.VerifiedOnly && !other.IsVerified {
+		return false
+	}
+	return other.AverageRating >= preferences.MinRating
+}
+
 // func IsSubRoute(offerPolyline, requestPolyline []schemas.Point) bool {
 // 	if len(requestPolyline) > len(offerPolyline) {
 // 		return false
@@ -342,27 +458,423 @@ func IsTimeOverlap(offer migration.RideOffer, request migration.RideRequest) boo
 // 	return totalRadius <= maxDistance
 // }
 
+// DistanceInMeters returns the great-circle distance between two points in meters
+func DistanceInMeters(p1, p2 schemas.Point) float64 {
+	return haversineDistance(p1, p2) * 1000
+}
+
+// DistanceFromRoute returns the distance in meters between the point and the closest segment of the route
+func DistanceFromRoute(polyline []schemas.Point, point schemas.Point) float64 {
+	if len(polyline) == 0 {
+		return 0
+	}
+	if len(polyline) == 1 {
+		return DistanceInMeters(polyline[0], point)
+	}
+
+	minDistSq := math.MaxFloat64
+	for i := 1; i < len(polyline); i++ {
+		minDistSq = math.Min(minDistSq, pointToSegmentDistanceSq(point, polyline[i-1], polyline[i]))
+	}
+
+	// The squared distance is in degrees of latitude, convert it to meters
+	return math.Sqrt(minDistSq) * earthRadius * degreesToRad * 1000
+}
+
 // IsNearby check the current location is nearby the target location or not with the given distance
 func IsNearby(current, target schemas.Point, distance float64) bool {
 	return squaredDistance(current, target) <= distance*distance
 }
 
-// Get the nearest point on the route to the given point on the start and end of the route
+// Get the nearest point on the route to the given point, anywhere on the segments of the route and not only at its points
 func GetNearestPointOnRoute(polyline []schemas.Point, point schemas.Point) schemas.Point {
 	if len(polyline) == 0 {
 		return schemas.Point{}
 	}
+	if len(polyline) == 1 {
+		return polyline[0]
+	}
 
 	minDistSq := math.MaxFloat64
 	var nearestPoint schemas.Point
 
-	for _, p := range polyline {
-		distSq := squaredDistance(p, point)
+	for i := 0; i < len(polyline)-1; i++ {
+		projection := projectOntoSegment(point, polyline[i], polyline[i+1])
+		distSq := squaredDistance(projection, point)
 		if distSq < minDistSq {
 			minDistSq = distSq
-			nearestPoint = p
+			nearestPoint = projection
 		}
 	}
 
 	return nearestPoint
 }
+
+// GetMeetingPoint returns the point on the route of the driver the hitcher walks to from their start,
+// with the straight-line walking distance in kilometers (the address is left to the reverse geocoding)
+func GetMeetingPoint(offerPolyline []schemas.Point, start schemas.Point) schemas.MeetingPoint {
+	point := GetNearestPointOnRoute(offerPolyline, start)
+	return schemas.MeetingPoint{
+		Latitude:        point.Lat,
+		Longitude:       point.Lng,
+		WalkingDistance: math.Round(haversineDistance(start, point)*100) / 100,
+	}
+}
+
+// PickupPoint returns where the driver picks up the hitcher of the ride, the meeting point on the route
+// or the start of the hitcher for the rides accepted before the meeting points
+func PickupPoint(ride migration.Ride, rideRequest migration.RideRequest) schemas.Point {
+	if ride.PickupLatitude == 0 && ride.PickupLongitude == 0 {
+		return schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}
+	}
+	return schemas.Point{Lat: ride.PickupLatitude, Lng: ride.PickupLongitude}
+}
+
+// Weights and limits used to score a match between a ride offer and a ride request
+const (
+	maxDetourDistance   = 8.0              // km, IsMatchRoute accepts a pickup and a drop-off about 2 km off the route so the detour is at most 2 * (2 + 2) km
+	maxPickupTimeDiff   = 60 * time.Minute // A pickup this far from the hitcher's start time scores 0 on time fit
+	defaultAverageSpeed = 30.0             // km/h, used when the speed cannot be derived from the ride offer
+	neutralRating       = 3.0              // Rating used for users who have not been rated yet
+	detourWeight        = 0.5
+	timeFitWeight       = 0.3
+	ratingWeight        = 0.2
+)
+
+// ScoreMatch estimates the extra distance and time the driver needs to pick up and drop off the hitcher
+// (going from the closest points of the offer route to the request start and end and back) and combines it
+// with how close the estimated pickup time is to the hitcher's start time and the rating of the other user
+// into a score from 0 to 100, higher is better
+func ScoreMatch(offer migration.RideOffer, offerPolyline []schemas.Point, request migration.RideRequest, rating float64) schemas.MatchScore {
+	if len(offerPolyline) == 0 {
+		return schemas.MatchScore{}
+	}
+
+	pickup := schemas.Point{Lat: request.StartLatitude, Lng: request.StartLongitude}
+	dropoff := schemas.Point{Lat: request.EndLatitude, Lng: request.EndLongitude}
+	pickupOnRoute, dropoffOnRoute := FindClosestPoints(offerPolyline, pickup, dropoff)
+
+	detourDistance := 2 * (haversineDistance(pickupOnRoute, pickup) + haversineDistance(dropoffOnRoute, dropoff))
+
+	// Average speed of the driver on the offer route
+	routeDistance := distanceAlongRoute(offerPolyline, offerPolyline[len(offerPolyline)-1])
+	speed := defaultAverageSpeed
+	if routeDistance > 0 && offer.Duration > 0 {
+		speed = routeDistance / (float64(offer.Duration) / 3600)
+	}
+	detourDuration := time.Duration(detourDistance / speed * float64(time.Hour))
+
+	// Estimate when the driver reaches the pickup point from how far along the route it is
+	pickupTime := offer.StartTime
+	if routeDistance > 0 {
+		progress := distanceAlongRoute(offerPolyline, pickupOnRoute) / routeDistance
+		pickupTime = offer.StartTime.Add(time.Duration(progress * float64(offer.Duration) * float64(time.Second)))
+	}
+	pickupTimeDiff := pickupTime.Sub(request.StartTime)
+	if pickupTimeDiff < 0 {
+		pickupTimeDiff = -pickupTimeDiff
+	}
+
+	if rating <= 0 {
+		rating = neutralRating
+	}
+
+	detourScore := math.Max(0, 1-detourDistance/maxDetourDistance)
+	timeFitScore := math.Max(0, 1-float64(pickupTimeDiff)/float64(maxPickupTimeDiff))
+	ratingScore := math.Min(rating, 5) / 5
+	score := 100 * (detourWeight*detourScore + timeFitWeight*timeFitScore + ratingWeight*ratingScore)
+
+	return schemas.MatchScore{
+		Score:                math.Round(score*10) / 10,
+		DetourDistance:       math.Round(detourDistance*100) / 100,
+		DetourDuration:       int(detourDuration.Seconds()),
+		PickupTimeDifference: int(pickupTimeDiff.Seconds()),
+	}
+}
+
+// distanceAlongRoute returns the distance in kilometers from the start of the polyline to the given point of the polyline
+func distanceAlongRoute(polyline []schemas.Point, point schemas.Point) float64 {
+	distance := 0.0
+	for i := 1; i < len(polyline); i++ {
+		if polyline[i-1] == point {
+			break
+		}
+		distance += haversineDistance(polyline[i-1], polyline[i])
+	}
+	return distance
+}
+
+// CalculateSegmentFare returns the fare of the part of the ride offer route the hitcher actually rides on.
+// The start and end of the ride request are projected onto the offer route and the offer fare is split
+// by the share of the route between the two projected points, rounded to the nearest 1000 VND
+func CalculateSegmentFare(offer migration.RideOffer, request migration.RideRequest) int64 {
+	offerPolyline := DecodePolyline(string(offer.EncodedPolyline))
+	if len(offerPolyline) < 2 {
+		return offer.Fare
+	}
+
+	routeDistance := distanceAlongRoute(offerPolyline, offerPolyline[len(offerPolyline)-1])
+	if routeDistance <= 0 {
+		return offer.Fare
+	}
+
+	pickup := schemas.Point{Lat: request.StartLatitude, Lng: request.StartLongitude}
+	dropoff := schemas.Point{Lat: request.EndLatitude, Lng: request.EndLongitude}
+	pickupOnRoute, dropoffOnRoute := FindClosestPoints(offerPolyline, pickup, dropoff)
+
+	segmentDistance := distanceAlongRoute(offerPolyline, dropoffOnRoute) - distanceAlongRoute(offerPolyline, pickupOnRoute)
+	if segmentDistance < 0 {
+		segmentDistance = 0
+	}
+
+	fare := math.Round(float64(offer.Fare)*segmentDistance/routeDistance/1000) * 1000
+
+	// Never charge less than the minimum fare or more than the whole ride offer
+	if fare < 1000 {
+		fare = 1000
+	}
+	if fare > float64(offer.Fare) {
+		fare = float64(offer.Fare)
+	}
+
+	return int64(fare)
+}
+
+// InsertPickupAndDropoff adds the pickup and drop-off of a hitcher to the waypoints of a ride offer and orders
+// every waypoint by how far along the offer route it is, so the driver reaches them on the way. The drop-off
+// always comes after its pickup
+func InsertPickupAndDropoff(offerPolyline []schemas.Point, waypoints []migration.Waypoint, pickup, dropoff migration.Waypoint) []migration.Waypoint {
+	type orderedWaypoint struct {
+		waypoint migration.Waypoint
+		progress float64
+	}
+
+	progressOf := func(waypoint migration.Waypoint) float64 {
+		if len(offerPolyline) == 0 {
+			return 0
+		}
+		point := schemas.Point{Lat: waypoint.Latitude, Lng: waypoint.Longitude}
+		onRoute, _ := FindClosestPoints(offerPolyline, point, point)
+		return distanceAlongRoute(offerPolyline, onRoute)
+	}
+
+	ordered := make([]orderedWaypoint, 0, len(waypoints)+2)
+	for _, waypoint := range waypoints {
+		ordered = append(ordered, orderedWaypoint{waypoint: waypoint, progress: progressOf(waypoint)})
+	}
+
+	pickupProgress := progressOf(pickup)
+	ordered = append(ordered,
+		orderedWaypoint{waypoint: pickup, progress: pickupProgress},
+		orderedWaypoint{waypoint: dropoff, progress: math.Max(progressOf(dropoff), pickupProgress)},
+	)
+
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].progress < ordered[j].progress
+	})
+
+	result := make([]migration.Waypoint, 0, len(ordered))
+	for i, o := range ordered {
+		o.waypoint.WaypointOrder = i
+		result = append(result, o.waypoint)
+	}
+	return result
+}
+
+// EstimateAlongRoute estimates the distance in kilometers and the time in seconds to go from one point to another
+// following the polyline, at the average speed of the route (its distance in kilometers over its duration in seconds)
+func EstimateAlongRoute(polyline []schemas.Point, from, to schemas.Point, routeDistance float64, routeDuration int) (float64, int) {
+	if len(polyline) == 0 {
+		return 0, 0
+	}
+
+	fromOnRoute, toOnRoute := FindClosestPoints(polyline, from, to)
+	distance := math.Max(0, distanceAlongRoute(polyline, toOnRoute)-distanceAlongRoute(polyline, fromOnRoute))
+
+	speed := defaultAverageSpeed
+	if routeDistance > 0 && routeDuration > 0 {
+		speed = routeDistance / (float64(routeDuration) / 3600)
+	}
+
+	return math.Round(distance*100) / 100, int(distance / speed * 3600)
+}
+
+// Limits used to find the transfer of an itinerary on two ride offers
+const (
+	walkingSpeed = 4.5 // km/h, used to check the hitcher can walk to the second driver before they leave
+)
+
+// FindTransfer finds where the hitcher of the ride request can change from the first ride offer, whose route passes near
+// their start, to the second ride offer, whose route passes near their end. The transfer is a point of the first route
+// after the pickup where the second route passes within maxWalk kilometers before the drop-off, shortly after the first
+// driver: the hitcher has time to walk over and waits at most maxWait. The transfer with the shortest wait is returned,
+// false when there is none
+func FindTransfer(first migration.RideOffer, firstPolyline []schemas.Point, second migration.RideOffer, secondPolyline []schemas.Point,
+	request migration.RideRequest, maxWalk float64, maxWait time.Duration) (schemas.Transfer, bool) {
+	if len(firstPolyline) < 2 || len(secondPolyline) < 2 {
+		return schemas.Transfer{}, false
+	}
+
+	pickupSegment, pickup, ok := nearestSegment(firstPolyline, schemas.Point{Lat: request.StartLatitude, Lng: request.StartLongitude})
+	if !ok {
+		return schemas.Transfer{}, false
+	}
+	dropoffSegment, dropoff, ok := nearestSegment(secondPolyline, schemas.Point{Lat: request.EndLatitude, Lng: request.EndLongitude})
+	if !ok {
+		return schemas.Transfer{}, false
+	}
+
+	firstDistances := cumulativeDistances(firstPolyline)
+	secondDistances := cumulativeDistances(secondPolyline)
+	firstRouteDistance := firstDistances[len(firstDistances)-1]
+	secondRouteDistance := secondDistances[len(secondDistances)-1]
+	pickupProgress := firstDistances[pickupSegment] + haversineDistance(firstPolyline[pickupSegment], pickup)
+	dropoffProgress := secondDistances[dropoffSegment] + haversineDistance(secondPolyline[dropoffSegment], dropoff)
+
+	// The first driver must pick the hitcher up around their start time, as for a match on a single ride offer
+	pickupTimeDiff := timeAlongRoute(first, pickupProgress, firstRouteDistance).Sub(request.StartTime)
+	if pickupTimeDiff < -maxPickupTimeDiff || pickupTimeDiff > maxPickupTimeDiff {
+		return schemas.Transfer{}, false
+	}
+
+	var transfer schemas.Transfer
+	bestWait, bestWalk := time.Duration(math.MaxInt64), math.MaxFloat64
+	for i := range firstPolyline {
+		if firstDistances[i] <= pickupProgress {
+			continue
+		}
+		dropoffTime := timeAlongRoute(first, firstDistances[i], firstRouteDistance)
+
+		for j := range secondPolyline {
+			if secondDistances[j] >= dropoffProgress {
+				break
+			}
+
+			walk := haversineDistance(firstPolyline[i], secondPolyline[j])
+			if walk > maxWalk {
+				continue
+			}
+
+			pickupTime := timeAlongRoute(second, secondDistances[j], secondRouteDistance)
+			wait := pickupTime.Sub(dropoffTime)
+			if wait < time.Duration(walk/walkingSpeed*float64(time.Hour)) || wait > maxWait {
+				continue
+			}
+			if wait > bestWait || (wait == bestWait && walk >= bestWalk) {
+				continue
+			}
+
+			bestWait, bestWalk = wait, walk
+			transfer = schemas.Transfer{
+				DropoffLatitude:  firstPolyline[i].Lat,
+				DropoffLongitude: firstPolyline[i].Lng,
+				Pickup: schemas.MeetingPoint{
+					Latitude:        secondPolyline[j].Lat,
+					Longitude:       secondPolyline[j].Lng,
+					WalkingDistance: math.Round(walk*100) / 100,
+				},
+				DropoffTime: dropoffTime,
+				PickupTime:  pickupTime,
+				WaitTime:    int(wait.Seconds()),
+				ArrivalTime: timeAlongRoute(second, dropoffProgress, secondRouteDistance),
+			}
+		}
+	}
+
+	return transfer, bestWalk != math.MaxFloat64
+}
+
+// SplitRideRequest returns the two legs of the ride request changing ride offers at the transfer, as ride requests of the
+// same hitcher: from their start to the drop-off on the first route, then from the pickup on the second route to their end.
+// The legs are not saved, their addresses at the transfer are the address of the transfer pickup
+func SplitRideRequest(request migration.RideRequest, firstPolyline, secondPolyline []schemas.Point, transfer schemas.Transfer) (migration.RideRequest, migration.RideRequest) {
+	start := schemas.Point{Lat: request.StartLatitude, Lng: request.StartLongitude}
+	end := schemas.Point{Lat: request.EndLatitude, Lng: request.EndLongitude}
+	dropoff := schemas.Point{Lat: transfer.DropoffLatitude, Lng: transfer.DropoffLongitude}
+	pickup := schemas.Point{Lat: transfer.Pickup.Latitude, Lng: transfer.Pickup.Longitude}
+
+	firstLeg := rideRequestLeg(request, routeBetween(firstPolyline, start, dropoff), request.StartTime, transfer.DropoffTime)
+	firstLeg.StartAddress = request.StartAddress
+	firstLeg.EndAddress = transfer.Pickup.Address
+	firstLeg.LegOrder = 1
+
+	secondLeg := rideRequestLeg(request, routeBetween(secondPolyline, pickup, end), transfer.PickupTime, transfer.ArrivalTime)
+	secondLeg.StartAddress = transfer.Pickup.Address
+	secondLeg.EndAddress = request.EndAddress
+	secondLeg.LegOrder = 2
+
+	return firstLeg, secondLeg
+}
+
+// rideRequestLeg returns a ride request of the hitcher of the given ride request along the route of a leg
+func rideRequestLeg(request migration.RideRequest, route []schemas.Point, startTime, endTime time.Time) migration.RideRequest {
+	box := GetBoundingBox(route)
+	return migration.RideRequest{
+		UserID:                request.UserID,
+		StartLatitude:         route[0].Lat,
+		StartLongitude:        route[0].Lng,
+		EndLatitude:           route[len(route)-1].Lat,
+		EndLongitude:          route[len(route)-1].Lng,
+		RiderCurrentLatitude:  request.RiderCurrentLatitude,
+		RiderCurrentLongitude: request.RiderCurrentLongitude,
+		Status:                "created",
+		EncodedPolyline:       encodedpolyline.Polyline(EncodePolyline(route)),
+		Distance:              math.Round(PathDistance(route)*100) / 100,
+		Duration:              int(endTime.Sub(startTime).Seconds()),
+		StartTime:             startTime,
+		EndTime:               endTime,
+		Weight:                request.Weight,
+		MinLatitude:           box.MinLat,
+		MaxLatitude:           box.MaxLat,
+		MinLongitude:          box.MinLng,
+		MaxLongitude:          box.MaxLng,
+		MatchPreferences:      request.MatchPreferences,
+		RideAmenities:         request.RideAmenities,
+		ParentRideRequestID:   &request.ID,
+	}
+}
+
+// routeBetween returns the part of the polyline between the points nearest to from and to, starting at from and ending at to
+func routeBetween(polyline []schemas.Point, from, to schemas.Point) []schemas.Point {
+	fromSegment, _, _ := nearestSegment(polyline, from)
+	toSegment, _, _ := nearestSegment(polyline, to)
+
+	route := []schemas.Point{from}
+	if fromSegment < toSegment {
+		route = append(route, polyline[fromSegment+1:toSegment+1]...)
+	}
+	return append(route, to)
+}
+
+// nearestSegment returns the index of the segment of the polyline nearest to the point (between the points i and i+1),
+// the projection of the point onto it and whether it is within the match distance
+func nearestSegment(polyline []schemas.Point, point schemas.Point) (int, schemas.Point, bool) {
+	index, minDistSq := 0, math.MaxFloat64
+	var nearestPoint schemas.Point
+	for i := 0; i < len(polyline)-1; i++ {
+		projection := projectOntoSegment(point, polyline[i], polyline[i+1])
+		if distSq := squaredDistance(projection, point); distSq < minDistSq {
+			index, minDistSq, nearestPoint = i, distSq, projection
+		}
+	}
+
+	return index, nearestPoint, minDistSq <= maxDistanceSq
+}
+
+// cumulativeDistances returns the distance in kilometers from the start of the polyline to each of its points
+func cumulativeDistances(polyline []schemas.Point) []float64 {
+	distances := make([]float64, len(polyline))
+	for i := 1; i < len(polyline); i++ {
+		distances[i] = distances[i-1] + haversineDistance(polyline[i-1], polyline[i])
+	}
+	return distances
+}
+
+// timeAlongRoute estimates when the driver of the ride offer reaches the point of its route that is the given distance
+// from its start, from how far along the route it is
+func timeAlongRoute(offer migration.RideOffer, distance, routeDistance float64) time.Time {
+	if routeDistance <= 0 {
+		return offer.StartTime
+	}
+	return offer.StartTime.Add(time.Duration(distance / routeDistance * float64(offer.Duration) * float64(time.Second)))
+}
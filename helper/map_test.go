@@ -0,0 +1,110 @@
+package helper
+
+import (
+	"math/rand"
+	"testing"
+
+	"shareway/schemas"
+)
+
+// boxesIntersect mirrors the SQL pre-filter of the matcher (see withinBoundingBox in the repository)
+func boxesIntersect(box, other schemas.BoundingBox) bool {
+	return box.MinLat <= other.MaxLat && box.MaxLat >= other.MinLat && box.MinLng <= other.MaxLng && box.MaxLng >= other.MinLng
+}
+
+// straightRoute returns a polyline of n points from start to end
+func straightRoute(start, end schemas.Point, n int) []schemas.Point {
+	points := make([]schemas.Point, n)
+	for i := range points {
+		t := float64(i) / float64(n-1)
+		points[i] = schemas.Point{
+			Lat: start.Lat + (end.Lat-start.Lat)*t,
+			Lng: start.Lng + (end.Lng-start.Lng)*t,
+		}
+	}
+	return points
+}
+
+// matchFixture returns a ride offer crossing Ho Chi Minh City and ride requests spread over the south of Vietnam,
+// one in every twenty of them rides along the ride offer
+func matchFixture(requests int) ([]schemas.Point, [][]schemas.Point) {
+	offer := straightRoute(schemas.Point{Lat: 10.70, Lng: 106.60}, schemas.Point{Lat: 10.85, Lng: 106.80}, 300)
+
+	rng := rand.New(rand.NewSource(1))
+	candidates := make([][]schemas.Point, requests)
+	for i := range candidates {
+		if i%20 == 0 {
+			from := rng.Intn(len(offer) / 2)
+			to := from + 1 + rng.Intn(len(offer)/2-1)
+			candidates[i] = straightRoute(offer[from], offer[to], 60)
+			continue
+		}
+
+		start := schemas.Point{Lat: 8.5 + rng.Float64()*4, Lng: 104.5 + rng.Float64()*4}
+		end := schemas.Point{Lat: start.Lat + rng.Float64()*0.2 - 0.1, Lng: start.Lng + rng.Float64()*0.2 - 0.1}
+		candidates[i] = straightRoute(start, end, 60)
+	}
+	return offer, candidates
+}
+
+func fullScan(offer []schemas.Point, candidates [][]schemas.Point) int {
+	matches := 0
+	for _, candidate := range candidates {
+		if IsMatchRoute(offer, candidate) {
+			matches++
+		}
+	}
+	return matches
+}
+
+func boundingBoxScan(offer []schemas.Point, candidates [][]schemas.Point, boxes []schemas.BoundingBox) int {
+	offerBox := ExpandBoundingBoxForMatch(GetBoundingBox(offer))
+	matches := 0
+	for i, candidate := range candidates {
+		if boxesIntersect(offerBox, boxes[i]) && IsMatchRoute(offer, candidate) {
+			matches++
+		}
+	}
+	return matches
+}
+
+func TestBoundingBoxPreFilterKeepsEveryMatch(t *testing.T) {
+	offer, candidates := matchFixture(2000)
+	boxes := make([]schemas.BoundingBox, len(candidates))
+	for i, candidate := range candidates {
+		boxes[i] = GetBoundingBox(candidate)
+	}
+
+	full := fullScan(offer, candidates)
+	if full == 0 {
+		t.Fatal("fixture has no matching ride request")
+	}
+	if filtered := boundingBoxScan(offer, candidates, boxes); filtered != full {
+		t.Errorf("bounding box pre-filter found %d matches, full scan found %d", filtered, full)
+	}
+}
+
+// BenchmarkMatchFullScan runs the polyline matcher on every ride request
+func BenchmarkMatchFullScan(b *testing.B) {
+	offer, candidates := matchFixture(2000)
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fullScan(offer, candidates)
+	}
+}
+
+// BenchmarkMatchBoundingBox runs the polyline matcher only on the ride requests whose stored bounding box
+// intersects the expanded bounding box of the ride offer
+func BenchmarkMatchBoundingBox(b *testing.B) {
+	offer, candidates := matchFixture(2000)
+	boxes := make([]schemas.BoundingBox, len(candidates))
+	for i, candidate := range candidates {
+		boxes[i] = GetBoundingBox(candidate)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		boundingBoxScan(offer, candidates, boxes)
+	}
+}
@@ -21,6 +21,9 @@ var (
 	modelCodeRegex       = regexp.MustCompile(`(?i)Mã [Kk]iểu [Ll]oại:?\s*([^;:]+)`)
 )
 
+// motorbikeSeats is the number of passenger seats of a motorbike, the only vehicles crawled from the VR website
+const motorbikeSeats = 1
+
 type IVrCrawler interface {
 	CrawlData() error
 	CrawlPage(url string) ([]migration.VehicleType, error)
@@ -95,6 +98,8 @@ func (c *VrCrawler) UpdateOrCreateVehicles(vehicles []migration.VehicleType) err
 				}
 			} else if result.Error == nil {
 				existingVehicle.FuelConsumed = vehicle.FuelConsumed
+				existingVehicle.Seats = vehicle.Seats
+				existingVehicle.Category = vehicle.Category
 				existingVehicle.UpdatedAt = time.Now().UTC()
 				if err := tx.Save(&existingVehicle).Error; err != nil {
 					return err
@@ -174,6 +179,8 @@ func extractVehicleInfo(content string) *migration.VehicleType {
 	return &migration.VehicleType{
 		Name:         name,
 		FuelConsumed: fuelConsumption,
+		Seats:        motorbikeSeats,
+		Category:     migration.VehicleCategoryMotorbike,
 	}
 }
 
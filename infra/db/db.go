@@ -58,5 +58,10 @@ func NewDatabaseInstance(cfg util.Config) *gorm.DB {
 		log.Fatal().Err(err).Msg("Failed to seed admin user")
 	}
 
+	// Seed the car vehicle types
+	if err := migration.SeedVehicleTypes(db); err != nil {
+		log.Fatal().Err(err).Msg("Failed to seed vehicle types")
+	}
+
 	return db
 }
@@ -2,6 +2,7 @@ package migration
 
 import (
 	"shareway/util"
+	"shareway/util/pricing"
 
 	"github.com/google/uuid"
 	"golang.org/x/crypto/bcrypt"
@@ -29,6 +30,12 @@ func Migrate(db *gorm.DB) error {
 		&FavoriteLocation{},
 		&FuelPrice{},
 		&VehicleType{},
+		&RideEvent{},
+		&RideBreadcrumb{},
+		&SafetyAlert{},
+		&TrustedContact{},
+		&RecurringRideOffer{},
+		&RecurringRideOfferSkip{},
 	)
 }
 
@@ -51,7 +58,13 @@ func DropAllTables(db *gorm.DB) error {
 		&Chat{},
 		&FavoriteLocation{},
 		&FuelPrice{},
-		&VehicleType{})
+		&VehicleType{},
+		&RideEvent{},
+		&RideBreadcrumb{},
+		&SafetyAlert{},
+		&TrustedContact{},
+		&RecurringRideOffer{},
+		&RecurringRideOfferSkip{})
 }
 
 // SeedAdmin creates an admin user if it doesn't already exist
@@ -85,3 +98,38 @@ func SeedAdmin(db *gorm.DB, cfg util.Config) error {
 	// Insert admin into database
 	return db.Create(admin).Error
 }
+
+// defaultVehicleTypes are the car types drivers can register and the vehicle types that are not priced by petrol,
+// the VR crawler only fills in the petrol motorbike types
+var defaultVehicleTypes = []VehicleType{
+	{Name: "Ô tô 4 chỗ", FuelConsumed: 6.5, Seats: 3, Category: VehicleCategoryCar},
+	{Name: "Ô tô 5 chỗ", FuelConsumed: 7, Seats: 4, Category: VehicleCategoryCar},
+	{Name: "Ô tô 7 chỗ", FuelConsumed: 8.5, Seats: 6, Category: VehicleCategoryCar},
+	{Name: "Ô tô 9 chỗ", FuelConsumed: 10, Seats: 8, Category: VehicleCategoryCar},
+	{Name: "Ô tô 7 chỗ máy dầu", FuelConsumed: 7.5, Seats: 6, Category: VehicleCategoryCar, PricingStrategy: pricing.StrategyFuel, FuelType: pricing.DieselFuelType},
+	{Name: "Ô tô 16 chỗ máy dầu", FuelConsumed: 11, Seats: 15, Category: VehicleCategoryCar, PricingStrategy: pricing.StrategyFuel, FuelType: pricing.DieselFuelType},
+	{Name: "Ô tô điện 5 chỗ", Seats: 4, Category: VehicleCategoryCar, PricingStrategy: pricing.StrategyElectric, EnergyConsumed: 15},
+	{Name: "Ô tô điện 7 chỗ", Seats: 6, Category: VehicleCategoryCar, PricingStrategy: pricing.StrategyElectric, EnergyConsumed: 18},
+	{Name: "Xe máy điện", Seats: 1, Category: VehicleCategoryMotorbike, PricingStrategy: pricing.StrategyElectric, EnergyConsumed: 3},
+}
+
+// SeedVehicleTypes creates the default vehicle types if they don't already exist and keeps their number of seats,
+// category and pricing up to date
+func SeedVehicleTypes(db *gorm.DB) error {
+	for _, vehicleType := range defaultVehicleTypes {
+		if err := db.Where(VehicleType{Name: vehicleType.Name}).
+			Attrs(VehicleType{FuelConsumed: vehicleType.FuelConsumed}).
+			Assign(VehicleType{
+				Seats:           vehicleType.Seats,
+				Category:        vehicleType.Category,
+				PricingStrategy: vehicleType.PricingStrategy,
+				FuelType:        vehicleType.FuelType,
+				EnergyConsumed:  vehicleType.EnergyConsumed,
+			}).
+			FirstOrCreate(&VehicleType{}).Error; err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -43,6 +43,15 @@ type User struct {
 	AverageRating float64 `gorm:"default:5"` // Average rating of the user (default 5 because new user has no rating)
 	TotalRatings  int64   `gorm:"default:0"` // Total number of ratings received for calculating average rating
 
+	// Reliability (see util/cancellation)
+	CompletedRides    int64   `gorm:"default:0"`   // Rides completed as the driver or the hitcher
+	LateCancellations int64   `gorm:"default:0"`   // Rides the user cancelled outside the free cancellation windows
+	NoShows           int64   `gorm:"default:0"`   // Rides the user did not show up for
+	ReliabilityScore  float64 `gorm:"default:100"` // Percentage of the rides the user kept (completed over completed, late cancellations and no-shows)
+
+	// Default match preferences of the ride offers and ride requests of the user
+	MatchPreferences
+
 	Vehicles          []Vehicle          // One-to-many relationship with Vehicle
 	RatingsReceived   []Rating           `gorm:"foreignKey:RateeID"` // One-to-many relationship with Rating (received)
 	RatingsGiven      []Rating           `gorm:"foreignKey:RaterID"` // One-to-many relationship with Rating (given)
@@ -119,9 +128,13 @@ type Transaction struct {
 	Receiver      User      `gorm:"foreignKey:ReceiverID"`
 	Amount        int64     // in vnđ so cannot have decimal and 1000 is the smallest currency unit
 	PaymentMethod string    `gorm:"default:'cash'"`    // cash, momo
-	Status        string    `gorm:"default:'pending'"` // pending, completed, refunded
+	Status        string    `gorm:"default:'pending'"` // pending, completed, refunded, on_hold
 	RideID        uuid.UUID `gorm:"type:uuid"`
 	Ride          Ride      `gorm:"foreignKey:RideID"`
+	// A MoMo payment is marked refunded first and the money is sent back afterwards (retried until MoMo accepts it)
+	RefundAmount int64      `gorm:"default:0"` // What goes back to the hitcher once refunded (the amount minus the fee kept)
+	RefundedAt   *time.Time // When MoMo accepted the refund, nil while it is still to be sent
+	MomoTransID  int64      // MoMo payment the refund is sent back to
 }
 
 // Vehicle represents a vehicle in the system
@@ -159,12 +172,39 @@ type RideOffer struct {
 	EndAddress             string  `gorm:"type:text"`
 	Distance               float64 // in kilometers
 	Duration               int     // in seconds
-	Status                 string  `gorm:"default:'created'"` // created, matched, ongoing, completed, cancelled
+	Status                 string  `gorm:"default:'created'"` // created, matched, ongoing, completed, cancelled, expired
 	Rides                  []Ride  `gorm:"foreignKey:RideOfferID"`
 	StartTime              time.Time
 	EndTime                time.Time  // Time to end the ride (end time = start time + duration)
 	Fare                   int64      // Total price of the ride offer (to show to the hitchhiker)
 	Waypoints              []Waypoint `gorm:"foreignKey:RideOfferID"`
+	Seats                  int        `gorm:"default:1"`                 // Total passenger seats offered (defaults to the vehicle type capacity)
+	AvailableSeats         int        `gorm:"default:1"`                 // Seats not yet booked by an accepted ride request
+	MinLatitude            float64    `gorm:"index:idx_ride_offer_bbox"` // Bounding box of the polyline, used to pre-filter matching candidates
+	MaxLatitude            float64    `gorm:"index:idx_ride_offer_bbox"`
+	MinLongitude           float64    `gorm:"index:idx_ride_offer_bbox"`
+	MaxLongitude           float64    `gorm:"index:idx_ride_offer_bbox"`
+	RecurringRideOfferID   *uuid.UUID `gorm:"type:uuid;index"` // Set when the ride offer was materialized from a recurring ride offer
+	MatchAlerts            bool       `gorm:"default:false"`   // Notify the driver when a new ride request matches the ride offer
+	MatchPreferences                  // Requirements of the driver on the hitcher
+	RideAmenities                     // What the driver allows on the ride
+	Motorbike              bool       `gorm:"default:false"` // Set from the vehicle type, the spare helmet only matters on a motorbike
+}
+
+// MatchPreferences are the requirements of a user on the other side of a ride, checked on both sides by helper.IsPreferenceMatch
+type MatchPreferences struct {
+	SameGenderOnly bool    `gorm:"default:false"` // Only ride with users of the same gender
+	VerifiedOnly   bool    `gorm:"default:false"` // Only ride with users whose CCCD is verified
+	MinRating      float64 `gorm:"default:0"`     // Only ride with users rated at least this (0 to accept everyone)
+}
+
+// RideAmenities are what the driver allows on a ride offer and what the hitcher brings or needs on a ride request,
+// checked by helper.IsAmenityMatch
+type RideAmenities struct {
+	Luggage     bool `gorm:"default:false"` // Room for luggage / the hitcher brings luggage
+	Pets        bool `gorm:"default:false"` // Pets allowed / the hitcher brings a pet
+	Smoking     bool `gorm:"default:false"` // Smoking allowed / the hitcher smokes
+	SpareHelmet bool `gorm:"default:false"` // The driver has a spare helmet (motorbike only) / the hitcher needs a helmet
 }
 
 // Waypoint represents a waypoint of a ride offer (because a ride offer can have multiple waypoints max 5 points)
@@ -177,7 +217,9 @@ type Waypoint struct {
 	Latitude      float64
 	Longitude     float64
 	WaypointOrder int
-	Address       string `gorm:"type:text"`
+	Address       string     `gorm:"type:text"`
+	Type          string     `gorm:"default:'stop'"`  // stop (added by the driver), pickup or dropoff (of an accepted ride request)
+	RideRequestID *uuid.UUID `gorm:"type:uuid;index"` // Set on the pickup and drop-off of an accepted ride request
 }
 
 // RideRequest represents a ride request in the system
@@ -196,14 +238,23 @@ type RideRequest struct {
 	MomoTransID           int64             // MoMo transaction ID (if user paid with MoMo, then store the transaction ID here if later need to refund)
 	StartAddress          string            `gorm:"type:text"`
 	EndAddress            string            `gorm:"type:text"`
-	Status                string            `gorm:"default:'created'"` // created, matched, ongoing, completed, cancelled
+	Status                string            `gorm:"default:'created'"` // created, matched, ongoing, completed, cancelled, expired
 	Rides                 []Ride            `gorm:"foreignKey:RideRequestID"`
 	EncodedPolyline       polyline.Polyline `gorm:"type:text"`
 	Distance              float64           // in kilometers
 	Duration              int               // in seconds
 	StartTime             time.Time
-	Weight                int64     // Handle the weight of the hitchhiker for the driver to consider whom will be the best to pick up
-	EndTime               time.Time // Time to end the ride (end time = start time + duration)
+	Weight                int64      // Handle the weight of the hitchhiker for the driver to consider whom will be the best to pick up
+	EndTime               time.Time  // Time to end the ride (end time = start time + duration)
+	MinLatitude           float64    `gorm:"index:idx_ride_request_bbox"` // Bounding box of the polyline, used to pre-filter matching candidates
+	MaxLatitude           float64    `gorm:"index:idx_ride_request_bbox"`
+	MinLongitude          float64    `gorm:"index:idx_ride_request_bbox"`
+	MaxLongitude          float64    `gorm:"index:idx_ride_request_bbox"`
+	MatchAlerts           bool       `gorm:"default:false"` // Notify the hitcher when a new ride offer matches the ride request
+	MatchPreferences                 // Requirements of the hitcher on the driver
+	RideAmenities                    // What the hitcher brings or needs on the ride
+	ParentRideRequestID   *uuid.UUID `gorm:"type:uuid;index"` // Ride request of the whole journey when this one is a leg of an itinerary on two ride offers
+	LegOrder              int        // Order of the leg in the itinerary (1 or 2), 0 when the ride request is not a leg
 }
 
 // Ride represents a matched ride between an offer and a request
@@ -215,7 +266,7 @@ type Ride struct {
 	RideOffer       RideOffer   `gorm:"foreignKey:RideOfferID"`
 	RideRequestID   uuid.UUID   `gorm:"type:uuid"`
 	RideRequest     RideRequest `gorm:"foreignKey:RideRequestID"`
-	Status          string      `gorm:"default:'scheduled'"` // scheduled, ongoing, completed, cancelled
+	Status          string      `gorm:"default:'scheduled'"` // scheduled, ongoing, completed, cancelled, expired
 	StartTime       time.Time
 	EndTime         time.Time
 	Fare            int64             // Total price of the ride (to show to the hitchhiker, vnđ so cannot use float)
@@ -223,15 +274,24 @@ type Ride struct {
 	EndAddress      string            `gorm:"type:text"`
 	EncodedPolyline polyline.Polyline `gorm:"type:text"`
 	Distance        float64
+	ActualDistance  float64 // Distance actually driven between the start and the end of the ride, computed from the breadcrumbs
 	Duration        int
 	StartLatitude   float64
 	StartLongitude  float64
 	EndLatitude     float64
 	EndLongitude    float64
+	PickupLatitude  float64       // Meeting point of the hitcher on the route of the driver (see helper.GetMeetingPoint)
+	PickupLongitude float64       // Both are 0 for the rides accepted before the meeting points, see helper.PickupPoint
 	VehicleID       uuid.UUID     `gorm:"type:uuid"`
 	Vehicle         Vehicle       `gorm:"foreignKey:VehicleID"`
 	Transactions    []Transaction `gorm:"foreignKey:RideID"`
 	Ratings         []Rating      `gorm:"foreignKey:RideID"`
+
+	// Cancellation (see util/cancellation)
+	CancelledBy         *uuid.UUID `gorm:"type:uuid"` // User who cancelled the ride or reported the no-show
+	CancellationOutcome string     // free, late, no_show
+	CancellationFee     int64      // VND kept from the refund of the hitcher
+	ResponsibleUserID   *uuid.UUID `gorm:"type:uuid"` // User whose reliability was lowered by the cancellation
 }
 
 // Rating represents a rating given by a user to another user
@@ -316,10 +376,115 @@ type FuelPrice struct {
 
 // VehicleType represents a type of vehicle in the system
 type VehicleType struct {
+	ID              uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt       time.Time `gorm:"autoCreateTime"`
+	UpdatedAt       time.Time `gorm:"autoUpdateTime"`
+	Name            string    `gorm:"uniqueIndex"`
+	FuelConsumed    float64   `gorm:"default:0"`                 // liters per 100 kilometers
+	Seats           int       `gorm:"default:1"`                 // Number of passenger seats (excluding the driver)
+	Category        string    `gorm:"default:'motorbike'"`       // motorbike, car
+	PricingStrategy string    `gorm:"default:'fuel'"`            // fuel, per_km, flat_per_km, electric (see util/pricing)
+	FuelType        string    `gorm:"default:'Xăng RON 95-III'"` // Fuel type of the fuel prices used by the fuel strategy (e.g. Dầu DO 0,05S-II for diesel vehicles)
+	EnergyConsumed  float64   `gorm:"default:0"`                 // kWh per 100 kilometers, used by the electric strategy
+	Vehicles        []Vehicle // One-to-many relationship with Vehicle
+}
+
+// Categories of a vehicle type
+const (
+	VehicleCategoryMotorbike = "motorbike"
+	VehicleCategoryCar       = "car"
+)
+
+// RideEvent records what happened to a ride, who did it and where (used to build the ride timeline), and every status
+// change of a ride offer, ride request, transaction or safety alert (the audit trail of the state machine)
+type RideEvent struct {
+	ID         uuid.UUID  `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt  time.Time  `gorm:"autoCreateTime"`
+	EntityType string     `gorm:"index:idx_ride_event_entity"`           // ride, ride_offer, ride_request, transaction, safety_alert
+	EntityID   uuid.UUID  `gorm:"type:uuid;index:idx_ride_event_entity"` // ID of the ride, ride offer, ride request, transaction or safety alert
+	RideID     *uuid.UUID `gorm:"type:uuid;index"`                       // Set when the event belongs to a ride
+	Ride       Ride       `gorm:"foreignKey:RideID"`
+	ActorID    uuid.UUID  `gorm:"type:uuid"` // User who triggered the event (uuid.Nil if triggered by the system)
+	EventType  string     // ride_created, transaction_created, ride_started, ride_ended, ride_cancelled, hitcher_rated, driver_rated, ride_expired, geofence_overridden, sos_triggered, no_show_reported, status_changed, seat_booked, seat_released
+	FromStatus string     // Status of the entity before the event (empty if the event does not change its status)
+	ToStatus   string     // Status of the entity after the event (empty if the event does not change its status)
+	Latitude   float64    // Location of the actor when the event happened (0 if unknown)
+	Longitude  float64
+	Reason     string `gorm:"type:text"`
+}
+
+// RideBreadcrumb is a location of the driver recorded while a ride is ongoing (used to replay the path actually taken)
+type RideBreadcrumb struct {
+	ID        uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt time.Time `gorm:"autoCreateTime;index"`
+	RideID    uuid.UUID `gorm:"type:uuid;index"`
+	Ride      Ride      `gorm:"foreignKey:RideID"`
+	Latitude  float64
+	Longitude float64
+}
+
+// SafetyAlert is raised when the driver of an ongoing ride leaves the planned route or stops for too long,
+// the hitcher is asked whether they are OK and the alert is escalated to the admins if they are not or do not answer.
+// An SOS raised by the driver or the hitcher is escalated right away with a high priority
+type SafetyAlert struct {
+	ID          uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt   time.Time `gorm:"autoCreateTime"`
+	UpdatedAt   time.Time `gorm:"autoUpdateTime"`
+	RideID      uuid.UUID `gorm:"type:uuid;index"`
+	Ride        Ride      `gorm:"foreignKey:RideID"`
+	UserID      uuid.UUID `gorm:"type:uuid;index"` // Hitcher who is asked whether they are OK, or user who pressed the SOS button
+	User        User      `gorm:"foreignKey:UserID"`
+	Type        string    // route_deviation, long_stop, sos
+	Priority    string    `gorm:"default:'normal'"`        // normal, high
+	Status      string    `gorm:"default:'pending';index"` // pending, safe, escalated, resolved
+	Latitude    float64   // Location of the driver when the alert was raised (of the user for sos)
+	Longitude   float64
+	Distance    float64    // Meters between the driver and the planned route (0 for long_stop)
+	RespondedAt *time.Time // When the hitcher answered the prompt
+	EscalatedAt *time.Time
+	ResolvedBy  *uuid.UUID `gorm:"type:uuid"` // Admin who resolved the alert
+	Note        string     `gorm:"type:text"` // Note of the admin who resolved the alert
+}
+
+// TrustedContact is a person a user wants to be told by SMS when they press the SOS button during a ride
+type TrustedContact struct {
 	ID           uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
 	CreatedAt    time.Time `gorm:"autoCreateTime"`
 	UpdatedAt    time.Time `gorm:"autoUpdateTime"`
-	Name         string    `gorm:"uniqueIndex"`
-	FuelConsumed float64   `gorm:"default:0"` // liters per 100 kilometers
-	Vehicles     []Vehicle // One-to-many relationship with Vehicle
+	UserID       uuid.UUID `gorm:"type:uuid;index"`
+	User         User      `gorm:"foreignKey:UserID"`
+	FullName     string
+	PhoneNumber  string // E.164 format
+	Relationship string // e.g. family, friend
+}
+
+// RecurringRideOffer is a template of a ride offer that repeats on some days of the week (e.g. a daily commute)
+// and is materialized into concrete ride offers ahead of time by the scheduler
+type RecurringRideOffer struct {
+	ID            uuid.UUID                `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt     time.Time                `gorm:"autoCreateTime"`
+	UpdatedAt     time.Time                `gorm:"autoUpdateTime"`
+	UserID        uuid.UUID                `gorm:"type:uuid;index"`
+	User          User                     `gorm:"foreignKey:UserID"`
+	VehicleID     uuid.UUID                `gorm:"type:uuid"`
+	Vehicle       Vehicle                  `gorm:"foreignKey:VehicleID"`
+	PlaceList     string                   `gorm:"type:text"` // Comma separated place IDs of the route (from goong api)
+	DaysOfWeek    string                   // Comma separated days of the week (0 = Sunday, 6 = Saturday)
+	DepartureTime string                   // Departure time in GMT+7 (HH:MM)
+	Seats         int                      // Number of seats to offer (0 means the vehicle type capacity)
+	Status        string                   `gorm:"default:'active'"` // active, paused
+	Skips         []RecurringRideOfferSkip `gorm:"foreignKey:RecurringRideOfferID"`
+	RideAmenities                          // What the driver allows on the ride offers
+	// Requirements of the driver on the hitchers, only used when CustomPreferences is set (otherwise the match preferences
+	// of the user at the time each ride offer is created)
+	CustomPreferences bool             `gorm:"default:false"`
+	MatchPreferences  MatchPreferences `gorm:"embedded;embeddedPrefix:preference_"`
+}
+
+// RecurringRideOfferSkip is a single occurrence of a recurring ride offer that the driver does not want to drive
+type RecurringRideOfferSkip struct {
+	ID                   uuid.UUID `gorm:"type:uuid;primary_key;default:gen_random_uuid()"`
+	CreatedAt            time.Time `gorm:"autoCreateTime"`
+	RecurringRideOfferID uuid.UUID `gorm:"type:uuid;uniqueIndex:idx_recurring_ride_offer_skip"`
+	Date                 time.Time `gorm:"type:date;uniqueIndex:idx_recurring_ride_offer_skip"` // Date of the skipped occurrence in GMT+7
 }
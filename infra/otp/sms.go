@@ -0,0 +1,36 @@
+package otp
+
+import (
+	"shareway/util"
+
+	"github.com/twilio/twilio-go"
+	twilioApi "github.com/twilio/twilio-go/rest/api/v2010"
+)
+
+// SMSSender sends a text message to a phone number in E.164 format
+type SMSSender interface {
+	SendSMS(to, body string) error
+}
+
+// TwilioSMSSender sends the text messages from the Twilio phone number of the app
+type TwilioSMSSender struct {
+	client *twilio.RestClient
+	from   string
+}
+
+func NewSMSSender(cfg util.Config) SMSSender {
+	return &TwilioSMSSender{
+		client: NewOTPClient(cfg),
+		from:   cfg.TwilioPhoneNumber,
+	}
+}
+
+func (s *TwilioSMSSender) SendSMS(to, body string) error {
+	params := &twilioApi.CreateMessageParams{}
+	params.SetTo(to)
+	params.SetFrom(s.from)
+	params.SetBody(body)
+
+	_, err := s.client.Api.CreateMessage(params)
+	return err
+}
@@ -40,6 +40,9 @@ func (as *AsyncServer) StartAsynqServer(processor *TaskProcessor) {
 	mux := asynq.NewServeMux()
 	mux.HandleFunc(TypeWebsocketMessage, processor.HandleWebsocketMessageTask)
 	mux.HandleFunc(TypeFCMNofitication, processor.HandleFCMNotificationTask)
+	mux.HandleFunc(TypeMatchRideOffer, processor.HandleMatchRideOfferTask)
+	mux.HandleFunc(TypeMatchRideRequest, processor.HandleMatchRideRequestTask)
+	mux.HandleFunc(TypeSMS, processor.HandleSMSTask)
 
 	// Start the server in a goroutine
 	go func() {
@@ -4,17 +4,25 @@ import (
 	"encoding/json"
 	"fmt"
 
+	"shareway/infra/otp"
 	"shareway/schemas"
 	"shareway/util"
 
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 )
 
 const (
 	TypeWebsocketMessage = "websocket:message"
 	TypeFCMNofitication  = "notification:fcm"
+	TypeMatchRideOffer   = "match:ride-offer"
+	TypeMatchRideRequest = "match:ride-request"
+	TypeSMS              = "sms:send"
 )
 
+// SMSMaxRetry is how many times a text message is retried when the SMS provider fails
+const SMSMaxRetry = 5
+
 type AsyncClient struct {
 	AsynqClient *asynq.Client
 }
@@ -65,3 +73,64 @@ func (ac *AsyncClient) EnqueueFCMNotification(notification schemas.Notification)
 	)
 	return err
 }
+
+// EnqueueSMS enqueues a text message task on the critical queue, it is retried when the SMS provider fails
+func (ac *AsyncClient) EnqueueSMS(sms schemas.SMS) error {
+
+	// Marshal the task payload
+	bytes, err := json.Marshal(sms)
+	if err != nil {
+		return err
+	}
+
+	// Create a new task
+	task := asynq.NewTask(TypeSMS, bytes)
+
+	// Enqueue the task
+	_, err = ac.AsynqClient.Enqueue(task,
+		asynq.Queue("critical"),
+		asynq.MaxRetry(SMSMaxRetry),
+	)
+	return err
+}
+
+// QueuedSMSSender sends the text messages through the task queue instead of calling the SMS provider right away
+type QueuedSMSSender struct {
+	client *AsyncClient
+}
+
+func NewQueuedSMSSender(client *AsyncClient) otp.SMSSender {
+	return &QueuedSMSSender{client: client}
+}
+
+func (s *QueuedSMSSender) SendSMS(to, body string) error {
+	return s.client.EnqueueSMS(schemas.SMS{To: to, Body: body})
+}
+
+// EnqueueMatchRideOffer enqueues a task notifying the hitchers whose subscribed ride requests match the new ride offer
+func (ac *AsyncClient) EnqueueMatchRideOffer(rideOfferID uuid.UUID) error {
+	return ac.enqueueMatchAlert(TypeMatchRideOffer, rideOfferID)
+}
+
+// EnqueueMatchRideRequest enqueues a task notifying the drivers whose subscribed ride offers match the new ride request
+func (ac *AsyncClient) EnqueueMatchRideRequest(rideRequestID uuid.UUID) error {
+	return ac.enqueueMatchAlert(TypeMatchRideRequest, rideRequestID)
+}
+
+func (ac *AsyncClient) enqueueMatchAlert(taskType string, id uuid.UUID) error {
+
+	// Marshal the task payload
+	bytes, err := json.Marshal(schemas.MatchAlertTask{ID: id})
+	if err != nil {
+		return err
+	}
+
+	// Create a new task
+	task := asynq.NewTask(taskType, bytes)
+
+	// Enqueue the task, the users would be notified twice if it was retried after some alerts were sent
+	_, err = ac.AsynqClient.Enqueue(task,
+		asynq.MaxRetry(0),
+	)
+	return err
+}
@@ -6,24 +6,36 @@ import (
 	"log"
 
 	"shareway/infra/fcm"
+	"shareway/infra/otp"
 	"shareway/infra/ws"
 	"shareway/schemas"
 	"shareway/util"
 
+	"github.com/google/uuid"
 	"github.com/hibiken/asynq"
 )
 
+// MatchAlerter notifies the users whose open ride offers or ride requests subscribed to the new matches
+type MatchAlerter interface {
+	AlertRideOfferMatches(rideOfferID uuid.UUID) error
+	AlertRideRequestMatches(rideRequestID uuid.UUID) error
+}
+
 type TaskProcessor struct {
 	hub       *ws.Hub
 	cfg       util.Config
 	fcmClient *fcm.FCMClient
+	sms       otp.SMSSender
+	matcher   MatchAlerter
 }
 
-func NewTaskProcessor(hub *ws.Hub, cfg util.Config, fcmClient *fcm.FCMClient) *TaskProcessor {
+func NewTaskProcessor(hub *ws.Hub, cfg util.Config, fcmClient *fcm.FCMClient, sms otp.SMSSender, matcher MatchAlerter) *TaskProcessor {
 	return &TaskProcessor{
 		hub:       hub,
 		cfg:       cfg,
 		fcmClient: fcmClient,
+		sms:       sms,
+		matcher:   matcher,
 	}
 }
 
@@ -55,4 +67,35 @@ func (tp *TaskProcessor) HandleFCMNotificationTask(ctx context.Context, t *asynq
 	return nil
 }
 
+// Handle SMS task
+func (tp *TaskProcessor) HandleSMSTask(ctx context.Context, t *asynq.Task) error {
+	var sms schemas.SMS
+	if err := json.Unmarshal(t.Payload(), &sms); err != nil {
+		return err
+	}
+	if err := tp.sms.SendSMS(sms.To, sms.Body); err != nil {
+		return err
+	}
+	log.Printf("Sent SMS success to %s", sms.To)
+	return nil
+}
+
+// Handle match ride offer task
+func (tp *TaskProcessor) HandleMatchRideOfferTask(ctx context.Context, t *asynq.Task) error {
+	var payload schemas.MatchAlertTask
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+	return tp.matcher.AlertRideOfferMatches(payload.ID)
+}
+
+// Handle match ride request task
+func (tp *TaskProcessor) HandleMatchRideRequestTask(ctx context.Context, t *asynq.Task) error {
+	var payload schemas.MatchAlertTask
+	if err := json.Unmarshal(t.Payload(), &payload); err != nil {
+		return err
+	}
+	return tp.matcher.AlertRideRequestMatches(payload.ID)
+}
+
 // RegisterTasks registers all tasks that this processor can handle.
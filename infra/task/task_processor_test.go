@@ -0,0 +1,69 @@
+package task
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"testing"
+
+	"shareway/schemas"
+
+	"github.com/hibiken/asynq"
+)
+
+// fakeSMSSender records the text messages instead of sending them
+type fakeSMSSender struct {
+	sent []schemas.SMS
+	err  error
+}
+
+func (s *fakeSMSSender) SendSMS(to, body string) error {
+	if s.err != nil {
+		return s.err
+	}
+	s.sent = append(s.sent, schemas.SMS{To: to, Body: body})
+	return nil
+}
+
+func TestHandleSMSTask(t *testing.T) {
+	sms := &fakeSMSSender{}
+	processor := &TaskProcessor{sms: sms}
+
+	payload, err := json.Marshal(schemas.SMS{To: "+84901111111", Body: "[ShareWay] KHẨN CẤP"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processor.HandleSMSTask(context.Background(), asynq.NewTask(TypeSMS, payload)); err != nil {
+		t.Fatalf("HandleSMSTask() error = %v", err)
+	}
+
+	if len(sms.sent) != 1 || sms.sent[0].To != "+84901111111" || sms.sent[0].Body != "[ShareWay] KHẨN CẤP" {
+		t.Errorf("HandleSMSTask() sent %+v", sms.sent)
+	}
+}
+
+func TestHandleSMSTaskReturnsProviderErrors(t *testing.T) {
+	// The error makes asynq retry the task
+	providerErr := errors.New("sms provider unavailable")
+	processor := &TaskProcessor{sms: &fakeSMSSender{err: providerErr}}
+
+	payload, err := json.Marshal(schemas.SMS{To: "+84901111111", Body: "body"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := processor.HandleSMSTask(context.Background(), asynq.NewTask(TypeSMS, payload)); !errors.Is(err, providerErr) {
+		t.Errorf("HandleSMSTask() error = %v, want %v", err, providerErr)
+	}
+}
+
+func TestHandleSMSTaskRejectsInvalidPayload(t *testing.T) {
+	sms := &fakeSMSSender{}
+	processor := &TaskProcessor{sms: sms}
+
+	if err := processor.HandleSMSTask(context.Background(), asynq.NewTask(TypeSMS, []byte("not json"))); err == nil {
+		t.Error("HandleSMSTask() error = nil, want an unmarshal error")
+	}
+	if len(sms.sent) != 0 {
+		t.Errorf("HandleSMSTask() sent %+v, want nothing", sms.sent)
+	}
+}
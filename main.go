@@ -10,6 +10,7 @@ import (
 	"shareway/infra/crawler"
 	"shareway/infra/db"
 	"shareway/infra/fcm"
+	"shareway/infra/otp"
 	"shareway/infra/task"
 	"shareway/infra/ws"
 	"shareway/router"
@@ -66,16 +67,9 @@ func main() {
 		return
 	}
 
-	// Initialize the Asynq task processor
-	taskProcessor := task.NewTaskProcessor(hub, cfg, fcmClient)
-
 	// Initialize the Asynq Client
 	asynqClient := task.NewAsynqClient(cfg)
 
-	// Start the Asynq server
-	asynqServer := task.NewAsynqServer(cfg)
-	asynqServer.StartAsynqServer(taskProcessor)
-
 	// Create a scheduler
 	scheduler, err := gocron.NewScheduler()
 	if err != nil {
@@ -147,6 +141,57 @@ func main() {
 	serviceFactory := service.NewServiceFactory(database, cfg, maker, redisClient, hub, asynqClient, cloudinaryService, sanctumToken)
 	services := serviceFactory.CreateServices()
 
+	// Initialize the Asynq task processor, the match alerts are evaluated by the services
+	taskProcessor := task.NewTaskProcessor(hub, cfg, fcmClient, otp.NewSMSSender(cfg), services.MatchAlertService)
+
+	// Start the Asynq server
+	asynqServer := task.NewAsynqServer(cfg)
+	asynqServer.StartAsynqServer(taskProcessor)
+
+	// Add job to scheduler to create the ride offers of recurring give rides ahead of departure
+	_, err = scheduler.NewJob(
+		gocron.CronJob(`*/15 * * * *`, false), // Run every 15 minutes
+		gocron.NewTask(
+			services.MapService.MaterializeRecurringGiveRides,
+		),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create cron job")
+	}
+
+	// Add job to scheduler to expire the ride offers, ride requests and rides whose time window has passed
+	_, err = scheduler.NewJob(
+		gocron.CronJob(`*/5 * * * *`, false), // Run every 5 minutes
+		gocron.NewTask(
+			services.ExpiryService.ExpireStaleRides,
+		),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create cron job")
+	}
+
+	// Add job to scheduler to propose the best pairs of the open ride offers and ride requests to both sides
+	_, err = scheduler.NewJob(
+		gocron.CronJob(`*/10 * * * *`, false), // Run every 10 minutes
+		gocron.NewTask(
+			services.MatchAlertService.ProposeBatchMatches,
+		),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create cron job")
+	}
+
+	// Add job to scheduler to escalate to the admins the safety checks the hitchers did not answer
+	_, err = scheduler.NewJob(
+		gocron.CronJob(`* * * * *`, false), // Run every minute
+		gocron.NewTask(
+			services.SafetyService.EscalateUnansweredSafetyAlerts,
+		),
+	)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Could not create cron job")
+	}
+
 	// Create new API server
 	server, err := router.NewAPIServer(
 		maker,
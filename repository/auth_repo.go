@@ -30,6 +30,7 @@ type IAuthRepository interface {
 	UpdateUserProfile(userID uuid.UUID, fullName string, email string, gender string) error
 	UpdateAvatar(userID uuid.UUID, avatarURL string) error
 	GetTotalTransactionsForUser(userID uuid.UUID) (int64, error)
+	UpdateMatchPreferences(userID uuid.UUID, preferences migration.MatchPreferences) error
 }
 
 // AuthRepository implements IAuthRepository
@@ -512,5 +513,27 @@ func (r *AuthRepository) GetTotalTransactionsForUser(userID uuid.UUID) (int64, e
 	return totalTransactions, err
 }
 
+// UpdateMatchPreferences updates the default match preferences of the user with the given user ID
+func (r *AuthRepository) UpdateMatchPreferences(userID uuid.UUID, preferences migration.MatchPreferences) error {
+	// Use a map so the false and zero values are updated as well
+	result := r.db.Model(&migration.User{}).
+		Where("id = ?", userID).
+		Updates(map[string]interface{}{
+			"same_gender_only": preferences.SameGenderOnly,
+			"verified_only":    preferences.VerifiedOnly,
+			"min_rating":       preferences.MinRating,
+		})
+
+	if result.Error != nil {
+		return fmt.Errorf("failed to update match preferences: %w", result.Error)
+	}
+
+	if result.RowsAffected == 0 {
+		return errors.New("user not found")
+	}
+
+	return nil
+}
+
 // Ensure AuthRepository implements IAuthRepository
 var _ IAuthRepository = (*AuthRepository)(nil)
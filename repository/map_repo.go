@@ -10,23 +10,80 @@ import (
 	"shareway/infra/db/migration"
 	"shareway/schemas"
 	"shareway/util/polyline"
+	"shareway/util/statemachine"
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type IMapsRepository interface {
-	CreateGiveRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, vehicleID uuid.UUID) (uuid.UUID, error)
-	CreateHitchRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, weight int64) (uuid.UUID, error)
+	CreateGiveRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, vehicleID uuid.UUID, seats int, fare int64, preferences migration.MatchPreferences, amenities migration.RideAmenities, recurringRideOfferID *uuid.UUID) (uuid.UUID, error)
+	CreateHitchRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, weight int64, preferences migration.MatchPreferences, amenities migration.RideAmenities) (uuid.UUID, error)
 	GetRideOfferDetails(rideOfferID uuid.UUID) (migration.RideOffer, error)
 	GetRideRequestDetails(rideRequestID uuid.UUID) (migration.RideRequest, error)
-	SuggestRideRequests(userID uuid.UUID, rideOfferID uuid.UUID) ([]migration.RideRequest, error)
-	SuggestRideOffers(userID uuid.UUID, rideRequestID uuid.UUID) ([]migration.RideOffer, error)
+	SuggestRideRequests(userID uuid.UUID, rideOfferID uuid.UUID) ([]RideRequestMatch, error)
+	SuggestRideOffers(userID uuid.UUID, rideRequestID uuid.UUID) ([]RideOfferMatch, error)
 	GetRideByID(rideID uuid.UUID) (migration.Ride, error)
 	GetAllWaypoints(rideOfferID uuid.UUID) ([]migration.Waypoint, error)
+	GetUserVehicle(vehicleID, userID uuid.UUID) (migration.Vehicle, error)
+	GetFuelPrice(fuelType string) (float64, error)
+	UpdateRideOfferRoute(rideOfferID uuid.UUID, reroute RerouteFunc) (migration.RideOffer, []migration.Waypoint, schemas.GoongDirectionsResponse, error)
+	CreateRecurringRideOffer(recurringRideOffer migration.RecurringRideOffer) (migration.RecurringRideOffer, error)
+	GetRecurringRideOffersByUser(userID uuid.UUID) ([]migration.RecurringRideOffer, error)
+	GetRecurringRideOfferByID(recurringRideOfferID, userID uuid.UUID) (migration.RecurringRideOffer, error)
+	GetActiveRecurringRideOffers() ([]migration.RecurringRideOffer, error)
+	IsRecurringOccurrenceHandled(recurringRideOfferID uuid.UUID, date time.Time, startTime time.Time) (bool, error)
+	SkipRecurringRideOfferOccurrence(recurringRideOffer migration.RecurringRideOffer, date time.Time, startTime time.Time) error
+	SetRecurringRideOfferStatus(recurringRideOffer migration.RecurringRideOffer, status string) error
+	SetRideOfferMatchAlerts(rideOfferID, userID uuid.UUID, enabled bool) error
+	SetRideRequestMatchAlerts(rideRequestID, userID uuid.UUID, enabled bool) error
+	GetOpenRideOffers(from, to time.Time) ([]migration.RideOffer, error)
+	GetOpenRideRequests(from, to time.Time) ([]migration.RideRequest, error)
+	GetUserMatchPreferences(userID uuid.UUID) (migration.MatchPreferences, error)
+	SuggestItineraries(userID uuid.UUID, rideRequestID uuid.UUID, maxWalk float64, maxWait time.Duration) ([]Itinerary, error)
+	GetItinerary(userID uuid.UUID, rideRequestID, firstRideOfferID, secondRideOfferID uuid.UUID, maxWalk float64, maxWait time.Duration) (Itinerary, error)
 }
 
+// RideRequestMatch is a ride request suggested for a ride offer with its match score and the fare the hitcher would pay
+type RideRequestMatch struct {
+	RideRequest  migration.RideRequest
+	Score        schemas.MatchScore
+	SegmentFare  int64
+	MeetingPoint schemas.MeetingPoint
+}
+
+// RideOfferMatch is a ride offer suggested for a ride request with its match score and the fare the hitcher would pay
+type RideOfferMatch struct {
+	RideOffer    migration.RideOffer
+	Score        schemas.MatchScore
+	SegmentFare  int64
+	MeetingPoint schemas.MeetingPoint
+}
+
+// Itinerary is a journey of a ride request on two ride offers, the hitcher changes from the first one to the second one at the transfer
+type Itinerary struct {
+	RideRequest     migration.RideRequest
+	FirstRideOffer  migration.RideOffer
+	SecondRideOffer migration.RideOffer
+	FirstLeg        migration.RideRequest // Part of the journey on the first ride offer, only saved when the itinerary is booked
+	SecondLeg       migration.RideRequest // Part of the journey on the second ride offer, only saved when the itinerary is booked
+	Transfer        schemas.Transfer
+	MeetingPoint    schemas.MeetingPoint // Where the hitcher meets the first driver
+	FirstLegFare    int64
+	SecondLegFare   int64
+}
+
+var (
+	ErrItineraryNotFound = errors.New("ride offers do not form an itinerary for the ride request")
+	ErrRouteUnchanged    = errors.New("route of the ride offer is unchanged")
+	ErrRouteStale        = errors.New("route of the ride offer changed while it was recomputed")
+)
+
+// timeOverlapBuffer mirrors the buffer used by helper.IsTimeOverlap
+const timeOverlapBuffer = 30 * time.Minute
+
 type MapsRepository struct {
 	db *gorm.DB
 }
@@ -35,13 +92,15 @@ func NewMapsRepository(db *gorm.DB) IMapsRepository {
 	return &MapsRepository{db: db}
 }
 
-func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, vehicleID uuid.UUID) (uuid.UUID, error) {
+func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, vehicleID uuid.UUID, seats int, fare int64, preferences migration.MatchPreferences, amenities migration.RideAmenities, recurringRideOfferID *uuid.UUID) (uuid.UUID, error) {
 	log.Debug().
 		Interface("route", route).
 		Str("userID", userID.String()).
 		Interface("currentLocation", currentLocation).
 		Time("startTime", startTime).
 		Str("vehicleID", vehicleID.String()).
+		Int("seats", seats).
+		Int64("fare", fare).
 		Msg("CreateGiveRide function called")
 
 	if len(route.Routes) == 0 || len(route.Routes[0].Legs) == 0 {
@@ -81,6 +140,26 @@ func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, u
 		}
 		log.Debug().Interface("vehicle", vehicle).Msg("Fetched vehicle")
 
+		// The number of seats offered defaults to the capacity of the vehicle type
+		// and can only be lowered by the driver (e.g. to keep a seat for luggage)
+		capacity := vehicle.VehicleType.Seats
+		if capacity < 1 {
+			capacity = 1
+		}
+		if seats == 0 {
+			seats = capacity
+		}
+		if seats > capacity {
+			log.Warn().Int("seats", seats).Int("capacity", capacity).Msg("Requested seats exceed vehicle capacity")
+			return ErrSeatsExceedCapacity
+		}
+
+		// The spare helmet only matters on a motorbike
+		motorbike := helper.IsMotorbike(vehicle.VehicleType)
+		if !motorbike {
+			amenities.SpareHelmet = false
+		}
+
 		// var existingRideOfferCount int64
 		// err := tx.Model(&migration.RideOffer{}).
 		// 	Where("user_id = ? AND ((start_time BETWEEN ? AND ?) OR (end_time BETWEEN ? AND ?) OR (start_time <= ? AND end_time >= ?))",
@@ -143,33 +222,6 @@ func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, u
 		// 	return errors.New("ride request already exists for the user in that time frame")
 		// }
 
-		var fuelPrice float64
-		if err := tx.Model(&migration.FuelPrice{}).
-			Select("price").
-			Where("fuel_type = ?", "Xăng RON 95-III").
-			First(&fuelPrice).Error; err != nil {
-			log.Error().Err(err).Msg("Failed to fetch fuel price")
-			return fmt.Errorf("failed to fetch fuel price: %w", err)
-		}
-		log.Debug().Float64("fuelPrice", fuelPrice).Msg("Fetched fuel price")
-
-		// Calculate the initial fare as a float64 for precision
-		fare := (vehicle.FuelConsumed / 100) * fuelPrice * float64(totalDistance)
-		log.Debug().Float64("fare", fare).Msg("Calculated initial fare")
-
-		// Round the fare to the nearest 1000 VND
-		roundedFare := math.Round(fare/1000) * 1000
-		log.Debug().Float64("roundedFare", roundedFare).Msg("Rounded fare to nearest 1000 VND")
-
-		// Ensure the minimum fare is 1000 VND
-		if roundedFare < 1000 {
-			roundedFare = 1000
-		}
-
-		// Convert the rounded fare to int64
-		realFare := int64(roundedFare)
-		log.Debug().Int64("realFare", realFare).Msg("Final fare as int64")
-
 		decodePolyline := helper.DecodePolyline(firstRoute.Overview_polyline.Points)
 		startLocation := schemas.Point{
 			Lat: firstLeg.Start_location.Lat,
@@ -186,6 +238,9 @@ func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, u
 			Interface("newEndLocation", newEndLocation).
 			Msg("Found closest points on route")
 
+		// Store the bounding box of the route so the matcher can pre-filter candidates in SQL
+		box := helper.GetBoundingBox(decodePolyline)
+
 		rideOffer := migration.RideOffer{
 			UserID:                 userID,
 			StartLatitude:          newStartLocaton.Lat,
@@ -203,7 +258,17 @@ func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, u
 			StartTime:              startTime,
 			EndTime:                endTime,
 			VehicleID:              vehicleID,
-			Fare:                   realFare,
+			Fare:                   fare,
+			Seats:                  seats,
+			AvailableSeats:         seats,
+			MinLatitude:            box.MinLat,
+			MaxLatitude:            box.MaxLat,
+			MinLongitude:           box.MinLng,
+			MaxLongitude:           box.MaxLng,
+			MatchPreferences:       preferences,
+			RideAmenities:          amenities,
+			Motorbike:              motorbike,
+			RecurringRideOfferID:   recurringRideOfferID,
 		}
 
 		if err := tx.Create(&rideOffer).Error; err != nil {
@@ -421,7 +486,7 @@ func (r *MapsRepository) CreateGiveRide(route schemas.GoongDirectionsResponse, u
 // 	return rideOfferID, nil
 // }
 
-func (r *MapsRepository) CreateHitchRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, weight int64) (uuid.UUID, error) {
+func (r *MapsRepository) CreateHitchRide(route schemas.GoongDirectionsResponse, userID uuid.UUID, currentLocation schemas.Point, startTime time.Time, weight int64, preferences migration.MatchPreferences, amenities migration.RideAmenities) (uuid.UUID, error) {
 	log.Debug().
 		Interface("route", route).
 		Str("userID", userID.String()).
@@ -537,6 +602,9 @@ func (r *MapsRepository) CreateHitchRide(route schemas.GoongDirectionsResponse,
 			Interface("newEndLocation", newEndLocation).
 			Msg("Found closest points on route")
 
+		// Store the bounding box of the route so the matcher can pre-filter candidates in SQL
+		box := helper.GetBoundingBox(decodePolyline)
+
 		rideRequest := migration.RideRequest{
 			UserID:                userID,
 			StartLatitude:         newStartLocaton.Lat,
@@ -553,7 +621,13 @@ func (r *MapsRepository) CreateHitchRide(route schemas.GoongDirectionsResponse,
 			Duration:              totalDuration,
 			StartTime:             startTime,
 			EndTime:               endTime,
-Weight: weight,
+			Weight:                weight,
+			MinLatitude:           box.MinLat,
+			MaxLatitude:           box.MaxLat,
+			MinLongitude:          box.MinLng,
+			MaxLongitude:          box.MaxLng,
+			MatchPreferences:      preferences,
+			RideAmenities:         amenities,
 		}
 
 		if err := tx.Create(&rideRequest).Error; err != nil {
@@ -685,7 +759,7 @@ Weight: weight,
 
 func (r *MapsRepository) GetRideOfferDetails(rideOfferID uuid.UUID) (migration.RideOffer, error) {
 	rideOffer := migration.RideOffer{}
-	if err := r.db.Preload("Vehicle").First(&rideOffer, rideOfferID).Error; err != nil {
+	if err := r.db.Preload("User").Preload("Vehicle").First(&rideOffer, rideOfferID).Error; err != nil {
 		return migration.RideOffer{}, err
 	}
 	return rideOffer, nil
@@ -693,76 +767,264 @@ func (r *MapsRepository) GetRideOfferDetails(rideOfferID uuid.UUID) (migration.R
 
 func (r *MapsRepository) GetRideRequestDetails(rideRequestID uuid.UUID) (migration.RideRequest, error) {
 	rideRequest := migration.RideRequest{}
-	if err := r.db.First(&rideRequest, rideRequestID).Error; err != nil {
+	if err := r.db.Preload("User").First(&rideRequest, rideRequestID).Error; err != nil {
 		return migration.RideRequest{}, err
 	}
 	return rideRequest, nil
 }
 
-func (r *MapsRepository) SuggestRideRequests(userID uuid.UUID, rideOfferID uuid.UUID) ([]migration.RideRequest, error) {
+func (r *MapsRepository) SuggestRideRequests(userID uuid.UUID, rideOfferID uuid.UUID) ([]RideRequestMatch, error) {
 	// Fetch the ride offer details
 	rideOffer, err := r.GetRideOfferDetails(rideOfferID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Fetch the ride requests that have status "created"
+	// No need to suggest anything if all seats of the ride offer are already booked
+	if rideOffer.AvailableSeats < 1 {
+		return []RideRequestMatch{}, nil
+	}
+
+	// Fetch the ride requests that have status "created" and could geographically and timely match the ride offer,
+	// the precise polyline matching below is only run on these candidates
+	offerPolyline := helper.DecodePolyline(string(rideOffer.EncodedPolyline))
+	offerBox := helper.ExpandBoundingBoxForMatch(helper.GetBoundingBox(offerPolyline))
 	var rideRequests []migration.RideRequest
-	if err := r.db.Where("status = ?", "created").Find(&rideRequests).Error; err != nil {
+	query := r.db.Preload("User").
+		Where("status = ? AND user_id <> ?", "created", userID).
+		Where("start_time > ? AND end_time < ?", rideOffer.StartTime.Add(-timeOverlapBuffer), rideOffer.EndTime.Add(timeOverlapBuffer))
+	if err := withinBoundingBox(query, offerBox).Find(&rideRequests).Error; err != nil {
 		return nil, err
 	}
 
-	var filteredRideRequests []migration.RideRequest
-	offerPolyline := helper.DecodePolyline(string(rideOffer.EncodedPolyline))
-	const maxDistance = 2.0 // km
+	var filteredRideRequests []RideRequestMatch
 
 	for _, rideRequest := range rideRequests {
 		requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
 
 		if rideRequest.UserID != userID && helper.IsMatchRoute(offerPolyline, requestPolyline) &&
-			helper.IsTimeOverlap(rideOffer, rideRequest) {
-			filteredRideRequests = append(filteredRideRequests, rideRequest)
+			helper.IsTimeOverlap(rideOffer, rideRequest) && helper.IsPreferenceMatch(rideOffer, rideOffer.User, rideRequest, rideRequest.User) &&
+			helper.IsAmenityMatch(rideOffer, rideRequest) {
+			filteredRideRequests = append(filteredRideRequests, RideRequestMatch{
+				RideRequest:  rideRequest,
+				Score:        helper.ScoreMatch(rideOffer, offerPolyline, rideRequest, rideRequest.User.AverageRating),
+				SegmentFare:  helper.CalculateSegmentFare(rideOffer, rideRequest),
+				MeetingPoint: helper.GetMeetingPoint(offerPolyline, schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}),
+			})
 		}
 	}
 
-// Sort by the weight of the ride request
+	// Sort by the match score, then by the weight of the ride request
 	sort.SliceStable(filteredRideRequests, func(i, j int) bool {
-		return filteredRideRequests[i].Weight > filteredRideRequests[j].Weight
+		if filteredRideRequests[i].Score.Score != filteredRideRequests[j].Score.Score {
+			return filteredRideRequests[i].Score.Score > filteredRideRequests[j].Score.Score
+		}
+		return filteredRideRequests[i].RideRequest.Weight > filteredRideRequests[j].RideRequest.Weight
 	})
 
 	return filteredRideRequests, nil
 }
 
 // SuggestRideOffers suggests ride offers that match the given ride request
-func (r *MapsRepository) SuggestRideOffers(userID uuid.UUID, rideRequestID uuid.UUID) ([]migration.RideOffer, error) {
+func (r *MapsRepository) SuggestRideOffers(userID uuid.UUID, rideRequestID uuid.UUID) ([]RideOfferMatch, error) {
 	// Fetch the ride request details
 	rideRequest, err := r.GetRideRequestDetails(rideRequestID)
 	if err != nil {
 		return nil, err
 	}
 
-	// Fetch the ride offers that have status "created"
+	// Fetch the ride offers that have status "created", still have seats left and could geographically
+	// and timely match the ride request, the precise polyline matching below is only run on these candidates
+	requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
+	requestBox := helper.ExpandBoundingBoxForMatch(helper.GetBoundingBox(requestPolyline))
 	var rideOffers []migration.RideOffer
-	if err := r.db.Where("status = ?", "created").Find(&rideOffers).Error; err != nil {
+	query := r.db.Preload("User").
+		Where("status = ? AND available_seats > 0 AND user_id <> ?", "created", userID).
+		Where("start_time < ? AND end_time > ?", rideRequest.StartTime.Add(timeOverlapBuffer), rideRequest.EndTime.Add(-timeOverlapBuffer))
+	if err := withinBoundingBox(query, requestBox).Find(&rideOffers).Error; err != nil {
 		return nil, err
 	}
 
-	var filteredRideOffers []migration.RideOffer
-	requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
-	const maxDistance = 2.0 // km
+	var filteredRideOffers []RideOfferMatch
 
 	for _, rideOffer := range rideOffers {
 		offerPolyline := helper.DecodePolyline(string(rideOffer.EncodedPolyline))
 
 		if rideOffer.UserID != userID && helper.IsMatchRoute(offerPolyline, requestPolyline) &&
-			helper.IsTimeOverlap(rideOffer, rideRequest) {
-			filteredRideOffers = append(filteredRideOffers, rideOffer)
+			helper.IsTimeOverlap(rideOffer, rideRequest) && helper.IsPreferenceMatch(rideOffer, rideOffer.User, rideRequest, rideRequest.User) &&
+			helper.IsAmenityMatch(rideOffer, rideRequest) {
+			filteredRideOffers = append(filteredRideOffers, RideOfferMatch{
+				RideOffer:    rideOffer,
+				Score:        helper.ScoreMatch(rideOffer, offerPolyline, rideRequest, rideOffer.User.AverageRating),
+				SegmentFare:  helper.CalculateSegmentFare(rideOffer, rideRequest),
+				MeetingPoint: helper.GetMeetingPoint(offerPolyline, schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}),
+			})
 		}
 	}
 
+	// Sort by the match score so the best ride offers come first
+	sort.SliceStable(filteredRideOffers, func(i, j int) bool {
+		return filteredRideOffers[i].Score.Score > filteredRideOffers[j].Score.Score
+	})
+
 	return filteredRideOffers, nil
 }
 
+// SuggestItineraries returns the journeys of the ride request on two ride offers, for a hitcher no single ride offer
+// takes all the way: the first ride offer passes near their start, the second one near their end and the hitcher
+// changes from one to the other at a transfer (see helper.FindTransfer). The itineraries arriving first come first
+func (r *MapsRepository) SuggestItineraries(userID uuid.UUID, rideRequestID uuid.UUID, maxWalk float64, maxWait time.Duration) ([]Itinerary, error) {
+	rideRequest, err := r.GetRideRequestDetails(rideRequestID)
+	if err != nil {
+		return nil, err
+	}
+	if rideRequest.UserID != userID {
+		return nil, ErrRideRequestNotFound
+	}
+
+	// The first ride offers pass near the start of the hitcher around their start time
+	start := schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}
+	var firstRideOffers []migration.RideOffer
+	query := r.db.Preload("User").
+		Where("status = ? AND available_seats > 0 AND user_id <> ?", "created", userID).
+		Where("start_time < ? AND end_time > ?", rideRequest.StartTime.Add(timeOverlapBuffer), rideRequest.StartTime.Add(-timeOverlapBuffer))
+	if err := withinBoundingBox(query, helper.ExpandBoundingBoxForMatch(helper.GetBoundingBox([]schemas.Point{start}))).Find(&firstRideOffers).Error; err != nil {
+		return nil, err
+	}
+	if len(firstRideOffers) == 0 {
+		return nil, nil
+	}
+
+	// The second ride offers pass near the end of the hitcher and reach the transfer before the longest wait is over
+	latestDropoff := firstRideOffers[0].EndTime
+	for _, rideOffer := range firstRideOffers {
+		if rideOffer.EndTime.After(latestDropoff) {
+			latestDropoff = rideOffer.EndTime
+		}
+	}
+	end := schemas.Point{Lat: rideRequest.EndLatitude, Lng: rideRequest.EndLongitude}
+	var secondRideOffers []migration.RideOffer
+	query = r.db.Preload("User").
+		Where("status = ? AND available_seats > 0 AND user_id <> ?", "created", userID).
+		Where("start_time < ? AND end_time > ?", latestDropoff.Add(maxWait), rideRequest.StartTime)
+	if err := withinBoundingBox(query, helper.ExpandBoundingBoxForMatch(helper.GetBoundingBox([]schemas.Point{end}))).Find(&secondRideOffers).Error; err != nil {
+		return nil, err
+	}
+
+	// A ride offer taking the hitcher all the way is already suggested on its own
+	requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
+	firstRideOffers, firstPolylines := itineraryLegCandidates(firstRideOffers, rideRequest, requestPolyline)
+	secondRideOffers, secondPolylines := itineraryLegCandidates(secondRideOffers, rideRequest, requestPolyline)
+
+	var itineraries []Itinerary
+	for i, first := range firstRideOffers {
+		for j, second := range secondRideOffers {
+			if first.ID == second.ID || first.UserID == second.UserID {
+				continue
+			}
+			if itinerary, ok := newItinerary(rideRequest, first, firstPolylines[i], second, secondPolylines[j], maxWalk, maxWait); ok {
+				itineraries = append(itineraries, itinerary)
+			}
+		}
+	}
+
+	// Sort by the arrival time, then by the total fare
+	sort.SliceStable(itineraries, func(i, j int) bool {
+		if !itineraries[i].Transfer.ArrivalTime.Equal(itineraries[j].Transfer.ArrivalTime) {
+			return itineraries[i].Transfer.ArrivalTime.Before(itineraries[j].Transfer.ArrivalTime)
+		}
+		return itineraries[i].FirstLegFare+itineraries[i].SecondLegFare < itineraries[j].FirstLegFare+itineraries[j].SecondLegFare
+	})
+
+	return itineraries, nil
+}
+
+// GetItinerary returns the journey of the ride request on the two given ride offers, as suggested by SuggestItineraries.
+// It returns ErrItineraryNotFound when the ride offers no longer form an itinerary for the ride request
+func (r *MapsRepository) GetItinerary(userID uuid.UUID, rideRequestID, firstRideOfferID, secondRideOfferID uuid.UUID, maxWalk float64, maxWait time.Duration) (Itinerary, error) {
+	rideRequest, err := r.GetRideRequestDetails(rideRequestID)
+	if err != nil {
+		return Itinerary{}, err
+	}
+	if rideRequest.UserID != userID {
+		return Itinerary{}, ErrRideRequestNotFound
+	}
+
+	first, err := r.GetRideOfferDetails(firstRideOfferID)
+	if err != nil {
+		return Itinerary{}, err
+	}
+	second, err := r.GetRideOfferDetails(secondRideOfferID)
+	if err != nil {
+		return Itinerary{}, err
+	}
+	if first.ID == second.ID || first.UserID == second.UserID || first.UserID == userID || second.UserID == userID {
+		return Itinerary{}, ErrItineraryNotFound
+	}
+
+	requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
+	candidates, polylines := itineraryLegCandidates([]migration.RideOffer{first, second}, rideRequest, requestPolyline)
+	if len(candidates) != 2 {
+		return Itinerary{}, ErrItineraryNotFound
+	}
+
+	itinerary, ok := newItinerary(rideRequest, first, polylines[0], second, polylines[1], maxWalk, maxWait)
+	if !ok {
+		return Itinerary{}, ErrItineraryNotFound
+	}
+
+	return itinerary, nil
+}
+
+// itineraryLegCandidates keeps the ride offers that can take a leg of the ride request, with their decoded polylines.
+// Both sides must meet the preferences and amenities of each other, and a ride offer taking the hitcher all the way
+// is a single match instead
+func itineraryLegCandidates(rideOffers []migration.RideOffer, rideRequest migration.RideRequest, requestPolyline []schemas.Point) ([]migration.RideOffer, [][]schemas.Point) {
+	var candidates []migration.RideOffer
+	var polylines [][]schemas.Point
+	for _, rideOffer := range rideOffers {
+		offerPolyline := helper.DecodePolyline(string(rideOffer.EncodedPolyline))
+		if helper.IsMatchRoute(offerPolyline, requestPolyline) || !helper.IsPreferenceMatch(rideOffer, rideOffer.User, rideRequest, rideRequest.User) ||
+			!helper.IsAmenityMatch(rideOffer, rideRequest) {
+			continue
+		}
+		candidates = append(candidates, rideOffer)
+		polylines = append(polylines, offerPolyline)
+	}
+
+	return candidates, polylines
+}
+
+// newItinerary returns the journey of the ride request on the two ride offers, false when there is no transfer between them
+func newItinerary(rideRequest migration.RideRequest, first migration.RideOffer, firstPolyline []schemas.Point, second migration.RideOffer, secondPolyline []schemas.Point, maxWalk float64, maxWait time.Duration) (Itinerary, bool) {
+	transfer, ok := helper.FindTransfer(first, firstPolyline, second, secondPolyline, rideRequest, maxWalk, maxWait)
+	if !ok {
+		return Itinerary{}, false
+	}
+
+	firstLeg, secondLeg := helper.SplitRideRequest(rideRequest, firstPolyline, secondPolyline, transfer)
+	return Itinerary{
+		RideRequest:     rideRequest,
+		FirstRideOffer:  first,
+		SecondRideOffer: second,
+		FirstLeg:        firstLeg,
+		SecondLeg:       secondLeg,
+		Transfer:        transfer,
+		MeetingPoint:    helper.GetMeetingPoint(firstPolyline, schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}),
+		FirstLegFare:    helper.CalculateSegmentFare(first, firstLeg),
+		SecondLegFare:   helper.CalculateSegmentFare(second, secondLeg),
+	}, true
+}
+
+// withinBoundingBox keeps the rows whose stored bounding box intersects the given box.
+// Rows created before bounding boxes were stored have an empty box and are always kept,
+// so they are still checked by the precise matcher
+func withinBoundingBox(query *gorm.DB, box schemas.BoundingBox) *gorm.DB {
+	return query.Where("((min_latitude <= ? AND max_latitude >= ? AND min_longitude <= ? AND max_longitude >= ?) OR "+
+		"(min_latitude = 0 AND max_latitude = 0 AND min_longitude = 0 AND max_longitude = 0))",
+		box.MaxLat, box.MinLat, box.MaxLng, box.MinLng)
+}
+
 func (r *MapsRepository) GetRideByID(rideID uuid.UUID) (migration.Ride, error) {
 	ride := migration.Ride{}
 	if err := r.db.Preload("RideOffer").Preload("RideRequest").First(&ride, rideID).Error; err != nil {
@@ -782,5 +1044,401 @@ func (r *MapsRepository) GetAllWaypoints(rideOfferID uuid.UUID) ([]migration.Way
 	return waypoints, nil
 }
 
+// GetUserVehicle returns the vehicle of the user with its vehicle type
+func (r *MapsRepository) GetUserVehicle(vehicleID, userID uuid.UUID) (migration.Vehicle, error) {
+	var vehicle migration.Vehicle
+	if err := r.db.Preload("VehicleType").Where("id = ? AND user_id = ?", vehicleID, userID).First(&vehicle).Error; err != nil {
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return migration.Vehicle{}, ErrVehicleNotFound
+		}
+		return migration.Vehicle{}, err
+	}
+
+	return vehicle, nil
+}
+
+// GetFuelPrice returns the latest crawled price of the given fuel type (VND per liter)
+func (r *MapsRepository) GetFuelPrice(fuelType string) (float64, error) {
+	var fuelPrice float64
+	if err := r.db.Model(&migration.FuelPrice{}).
+		Select("price").
+		Where("fuel_type = ?", fuelType).
+		First(&fuelPrice).Error; err != nil {
+		return 0, err
+	}
+
+	return fuelPrice, nil
+}
+
+// RerouteFunc builds the new waypoints of a ride offer from its current ones and returns the route through them
+type RerouteFunc func(rideOffer migration.RideOffer, waypoints []migration.Waypoint) (schemas.GoongDirectionsResponse, []migration.Waypoint, error)
+
+// MaxRerouteAttempts is how many times the route of a ride offer is computed again when another reroute of the same
+// ride offer is saved first
+const MaxRerouteAttempts = 3
+
+// UpdateRideOfferRoute hands the waypoints of the ride offer to reroute and replaces the route and the waypoints
+// of the ride offer with the result (the route legs end at the waypoints in order), then copies the new route
+// to the rides of the ride offer that are not finished yet. reroute calls the Goong API so it runs outside of any
+// transaction, the ride offer is only locked to check that nothing changed since it was read and to save the result.
+// When a concurrent reroute was saved in the meantime the route is computed again from the new waypoints so neither
+// drops the other's waypoints
+func (r *MapsRepository) UpdateRideOfferRoute(rideOfferID uuid.UUID, reroute RerouteFunc) (migration.RideOffer, []migration.Waypoint, schemas.GoongDirectionsResponse, error) {
+	for attempt := 0; attempt < MaxRerouteAttempts; attempt++ {
+		var rideOffer migration.RideOffer
+		if err := r.db.First(&rideOffer, rideOfferID).Error; err != nil {
+			return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, err
+		}
+
+		var current []migration.Waypoint
+		if err := r.db.Where("ride_offer_id = ?", rideOfferID).Order("waypoint_order").Find(&current).Error; err != nil {
+			return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, err
+		}
+
+		route, waypoints, err := reroute(rideOffer, current)
+		if err != nil {
+			return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, err
+		}
+		if len(route.Routes) == 0 || len(route.Routes[0].Legs) != len(waypoints)+1 {
+			return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, errors.New("invalid route data")
+		}
+
+		rideOffer, err = r.saveRideOfferRoute(rideOffer, current, route, waypoints)
+		if errors.Is(err, ErrRouteStale) {
+			log.Warn().Str("ride_offer_id", rideOfferID.String()).Int("attempt", attempt+1).Msg("Route of the ride offer changed while it was recomputed")
+			continue
+		}
+		if err != nil {
+			return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, err
+		}
+
+		return rideOffer, waypoints, route, nil
+	}
+
+	return migration.RideOffer{}, nil, schemas.GoongDirectionsResponse{}, ErrRouteStale
+}
+
+// saveRideOfferRoute locks the ride offer and replaces its route and waypoints, it returns ErrRouteStale when the
+// status, the route or the waypoints of the ride offer are no longer the ones the route was computed from
+func (r *MapsRepository) saveRideOfferRoute(previous migration.RideOffer, previousWaypoints []migration.Waypoint, route schemas.GoongDirectionsResponse, waypoints []migration.Waypoint) (migration.RideOffer, error) {
+	var rideOffer migration.RideOffer
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rideOffer, previous.ID).Error; err != nil {
+			return err
+		}
+
+		var current []migration.Waypoint
+		if err := tx.Where("ride_offer_id = ?", previous.ID).Order("waypoint_order").Find(&current).Error; err != nil {
+			return err
+		}
+		if rideOffer.Status != previous.Status || rideOffer.EncodedPolyline != previous.EncodedPolyline || !sameWaypoints(current, previousWaypoints) {
+			return ErrRouteStale
+		}
+
+		firstRoute := route.Routes[0]
+		totalDistance, totalDuration := 0, 0
+		for i, leg := range firstRoute.Legs {
+			totalDistance += leg.Distance.Value
+			totalDuration += leg.Duration.Value
+
+			// Use the address Goong resolved for the waypoints the driver did not name
+			if i < len(waypoints) && waypoints[i].Address == "" {
+				waypoints[i].Address = leg.End_address
+			}
+		}
+		distance := math.Round(float64(totalDistance)/10) / 100
+
+		endTime := rideOffer.StartTime.Add(time.Duration(totalDuration) * time.Second)
+		box := helper.GetBoundingBox(helper.DecodePolyline(firstRoute.Overview_polyline.Points))
+
+		if err := tx.Model(&rideOffer).Updates(map[string]interface{}{
+			"encoded_polyline": firstRoute.Overview_polyline.Points,
+			"distance":         distance,
+			"duration":         totalDuration,
+			"end_time":         endTime,
+			"min_latitude":     box.MinLat,
+			"max_latitude":     box.MaxLat,
+			"min_longitude":    box.MinLng,
+			"max_longitude":    box.MaxLng,
+		}).Error; err != nil {
+			return err
+		}
+		if err := tx.First(&rideOffer, previous.ID).Error; err != nil {
+			return err
+		}
+
+		if err := tx.Where("ride_offer_id = ?", previous.ID).Delete(&migration.Waypoint{}).Error; err != nil {
+			return err
+		}
+		for i := range waypoints {
+			waypoints[i].RideOfferID = previous.ID
+			waypoints[i].WaypointOrder = i
+		}
+		if len(waypoints) > 0 {
+			if err := tx.Create(&waypoints).Error; err != nil {
+				return err
+			}
+		}
+
+		// The driver follows one route for every hitcher of the ride offer
+		return tx.Model(&migration.Ride{}).
+			Where("ride_offer_id = ? AND status IN ?", previous.ID, []string{statemachine.StatusScheduled, statemachine.StatusOngoing}).
+			Updates(map[string]interface{}{
+				"encoded_polyline": firstRoute.Overview_polyline.Points,
+				"distance":         distance,
+				"duration":         totalDuration,
+				"end_time":         endTime,
+			}).Error
+	})
+	if err != nil {
+		return migration.RideOffer{}, err
+	}
+
+	return rideOffer, nil
+}
+
+// sameWaypoints reports whether both lists hold the same waypoints in the same order
+func sameWaypoints(waypoints, other []migration.Waypoint) bool {
+	if len(waypoints) != len(other) {
+		return false
+	}
+	for i := range waypoints {
+		if waypoints[i].ID != other[i].ID {
+			return false
+		}
+	}
+	return true
+}
+
+// Statuses of a recurring ride offer
+const (
+	RecurringRideOfferActive = "active"
+	RecurringRideOfferPaused = "paused"
+)
+
+var (
+	ErrRecurringRideOfferNotFound = errors.New("recurring ride offer not found")
+	ErrRecurringOccurrenceBooked  = errors.New("occurrence of the recurring ride offer already has booked seats")
+)
+
+// CreateRecurringRideOffer creates a recurring ride offer for a vehicle of the user
+func (r *MapsRepository) CreateRecurringRideOffer(recurringRideOffer migration.RecurringRideOffer) (migration.RecurringRideOffer, error) {
+	var vehicle migration.Vehicle
+	if err := r.db.Preload("VehicleType").Where("id = ? AND user_id = ?", recurringRideOffer.VehicleID, recurringRideOffer.UserID).First(&vehicle).Error; err != nil {
+		log.Error().Err(err).Msg("Failed to fetch vehicle")
+		return migration.RecurringRideOffer{}, err
+	}
+
+	// Check the seats now so the driver does not find out on the first materialized occurrence
+	capacity := vehicle.VehicleType.Seats
+	if capacity < 1 {
+		capacity = 1
+	}
+	if recurringRideOffer.Seats > capacity {
+		return migration.RecurringRideOffer{}, ErrSeatsExceedCapacity
+	}
+
+	recurringRideOffer.Status = RecurringRideOfferActive
+	if err := r.db.Create(&recurringRideOffer).Error; err != nil {
+		log.Error().Err(err).Msg("Failed to create recurring ride offer")
+		return migration.RecurringRideOffer{}, err
+	}
+
+	return recurringRideOffer, nil
+}
+
+// GetRecurringRideOffersByUser gets the recurring ride offers of a user with their upcoming skipped occurrences
+func (r *MapsRepository) GetRecurringRideOffersByUser(userID uuid.UUID) ([]migration.RecurringRideOffer, error) {
+	var recurringRideOffers []migration.RecurringRideOffer
+	err := r.db.Preload("Skips", func(db *gorm.DB) *gorm.DB {
+		return db.Where("date >= CURRENT_DATE").Order("date")
+	}).
+		Where("user_id = ?", userID).
+		Order("created_at DESC").
+		Find(&recurringRideOffers).Error
+	if err != nil {
+		return nil, err
+	}
+	return recurringRideOffers, nil
+}
+
+// GetRecurringRideOfferByID gets a recurring ride offer of a user
+func (r *MapsRepository) GetRecurringRideOfferByID(recurringRideOfferID, userID uuid.UUID) (migration.RecurringRideOffer, error) {
+	var recurringRideOffer migration.RecurringRideOffer
+	err := r.db.Where("id = ? AND user_id = ?", recurringRideOfferID, userID).First(&recurringRideOffer).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return migration.RecurringRideOffer{}, ErrRecurringRideOfferNotFound
+	}
+	if err != nil {
+		return migration.RecurringRideOffer{}, err
+	}
+	return recurringRideOffer, nil
+}
+
+// GetActiveRecurringRideOffers gets every recurring ride offer that is not paused
+func (r *MapsRepository) GetActiveRecurringRideOffers() ([]migration.RecurringRideOffer, error) {
+	var recurringRideOffers []migration.RecurringRideOffer
+	if err := r.db.Where("status = ?", RecurringRideOfferActive).Find(&recurringRideOffers).Error; err != nil {
+		return nil, err
+	}
+	return recurringRideOffers, nil
+}
+
+// IsRecurringOccurrenceHandled reports whether an occurrence of a recurring ride offer was skipped
+// or has already been materialized into a ride offer
+func (r *MapsRepository) IsRecurringOccurrenceHandled(recurringRideOfferID uuid.UUID, date time.Time, startTime time.Time) (bool, error) {
+	var skipCount int64
+	if err := r.db.Model(&migration.RecurringRideOfferSkip{}).
+		Where("recurring_ride_offer_id = ? AND date = ?", recurringRideOfferID, date).
+		Count(&skipCount).Error; err != nil {
+		return false, err
+	}
+	if skipCount > 0 {
+		return true, nil
+	}
+
+	var rideOfferCount int64
+	if err := r.db.Model(&migration.RideOffer{}).
+		Where("recurring_ride_offer_id = ? AND start_time = ?", recurringRideOfferID, startTime).
+		Count(&rideOfferCount).Error; err != nil {
+		return false, err
+	}
+	return rideOfferCount > 0, nil
+}
+
+// SkipRecurringRideOfferOccurrence skips a single occurrence of a recurring ride offer,
+// the ride offer of the occurrence is cancelled if it has already been materialized
+func (r *MapsRepository) SkipRecurringRideOfferOccurrence(recurringRideOffer migration.RecurringRideOffer, date time.Time, startTime time.Time) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		skip := migration.RecurringRideOfferSkip{
+			RecurringRideOfferID: recurringRideOffer.ID,
+			Date:                 date,
+		}
+		if err := tx.Clauses(clause.OnConflict{DoNothing: true}).Create(&skip).Error; err != nil {
+			return err
+		}
+
+		var rideOffer migration.RideOffer
+		err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("recurring_ride_offer_id = ? AND start_time = ? AND status <> ?", recurringRideOffer.ID, startTime, statemachine.StatusCancelled).
+			First(&rideOffer).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			// Not materialized yet, the scheduler will not create it
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+
+		// Hitchers already booked this occurrence, the driver has to cancel their rides instead
+		if rideOffer.Status != statemachine.StatusCreated || rideOffer.AvailableSeats < rideOffer.Seats {
+			return ErrRecurringOccurrenceBooked
+		}
+
+		return transitionStatus(tx, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCancelled, recurringRideOffer.UserID)
+	})
+}
+
+// SetRecurringRideOfferStatus pauses or resumes a recurring ride offer, pausing also cancels
+// the upcoming materialized ride offers that nobody has booked yet
+func (r *MapsRepository) SetRecurringRideOfferStatus(recurringRideOffer migration.RecurringRideOffer, status string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Model(&migration.RecurringRideOffer{}).
+			Where("id = ?", recurringRideOffer.ID).
+			Update("status", status).Error; err != nil {
+			return err
+		}
+
+		if status != RecurringRideOfferPaused {
+			return nil
+		}
+
+		var rideOffers []migration.RideOffer
+		if err := tx.Where("recurring_ride_offer_id = ? AND status = ? AND available_seats = seats AND start_time > ?",
+			recurringRideOffer.ID, statemachine.StatusCreated, time.Now()).
+			Find(&rideOffers).Error; err != nil {
+			return err
+		}
+
+		for _, rideOffer := range rideOffers {
+			if err := transitionStatus(tx, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCancelled, recurringRideOffer.UserID); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+// SetRideOfferMatchAlerts turns on or off the alerts of the new ride requests matching an open ride offer of the user
+func (r *MapsRepository) SetRideOfferMatchAlerts(rideOfferID, userID uuid.UUID, enabled bool) error {
+	result := r.db.Model(&migration.RideOffer{}).
+		Where("id = ? AND user_id = ? AND status = ?", rideOfferID, userID, statemachine.StatusCreated).
+		Update("match_alerts", enabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRideOfferNotFound
+	}
+
+	return nil
+}
+
+// SetRideRequestMatchAlerts turns on or off the alerts of the new ride offers matching an open ride request of the user
+func (r *MapsRepository) SetRideRequestMatchAlerts(rideRequestID, userID uuid.UUID, enabled bool) error {
+	result := r.db.Model(&migration.RideRequest{}).
+		Where("id = ? AND user_id = ? AND status = ?", rideRequestID, userID, statemachine.StatusCreated).
+		Update("match_alerts", enabled)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrRideRequestNotFound
+	}
+
+	return nil
+}
+
+// GetOpenRideOffers fetches the ride offers starting in the given time window that still have seats left, oldest first
+func (r *MapsRepository) GetOpenRideOffers(from, to time.Time) ([]migration.RideOffer, error) {
+	var rideOffers []migration.RideOffer
+	err := r.db.Preload("User").
+		Where("status = ? AND available_seats > 0 AND start_time BETWEEN ? AND ?", statemachine.StatusCreated, from, to).
+		Order("created_at ASC").
+		Find(&rideOffers).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rideOffers, nil
+}
+
+// GetOpenRideRequests fetches the ride requests starting in the given time window that are not matched yet, oldest first
+func (r *MapsRepository) GetOpenRideRequests(from, to time.Time) ([]migration.RideRequest, error) {
+	var rideRequests []migration.RideRequest
+	err := r.db.Preload("User").
+		Where("status = ? AND start_time BETWEEN ? AND ?", statemachine.StatusCreated, from, to).
+		Order("created_at ASC").
+		Find(&rideRequests).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return rideRequests, nil
+}
+
+// GetUserMatchPreferences fetches the default match preferences of the user
+func (r *MapsRepository) GetUserMatchPreferences(userID uuid.UUID) (migration.MatchPreferences, error) {
+	var user migration.User
+	if err := r.db.Select("same_gender_only", "verified_only", "min_rating").First(&user, userID).Error; err != nil {
+		return migration.MatchPreferences{}, err
+	}
+
+	return user.MatchPreferences, nil
+}
+
 // Make sure to implement the IMapsRepository interface
 var _ IMapsRepository = (*MapsRepository)(nil)
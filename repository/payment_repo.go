@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"time"
+
 	"shareway/infra/db/migration"
 
 	"github.com/google/uuid"
@@ -25,6 +27,8 @@ type IPaymentRepository interface {
 	GetUserByID(userID uuid.UUID) (migration.User, error)
 	GetRideOfferByID(rideOfferID uuid.UUID) (migration.RideOffer, error)
 	GetRideRequestByID(rideRequestID uuid.UUID) (migration.RideRequest, error)
+	GetRideTransaction(rideOfferID, rideRequestID uuid.UUID) (migration.Transaction, error)
+	MarkTransactionRefunded(transactionID uuid.UUID) error
 }
 
 func (p *PaymentRepository) StoreRequestID(requestID string, userID uuid.UUID, walletPhoneNumber string) error {
@@ -70,3 +74,24 @@ func (p *PaymentRepository) GetRideRequestByID(rideRequestID uuid.UUID) (migrati
 
 	return rideRequest, nil
 }
+
+// GetRideTransaction fetches the transaction of the latest ride booked for the ride request on the ride offer,
+// with the amount the hitcher was charged when the ride was booked
+func (p *PaymentRepository) GetRideTransaction(rideOfferID, rideRequestID uuid.UUID) (migration.Transaction, error) {
+	var transaction migration.Transaction
+	if err := p.db.Joins("JOIN rides ON rides.id = transactions.ride_id").
+		Where("rides.ride_offer_id = ? AND rides.ride_request_id = ?", rideOfferID, rideRequestID).
+		Order("rides.created_at DESC").
+		First(&transaction).Error; err != nil {
+		return transaction, err
+	}
+
+	return transaction, nil
+}
+
+// MarkTransactionRefunded records that MoMo accepted the refund of a refunded transaction
+func (p *PaymentRepository) MarkTransactionRefunded(transactionID uuid.UUID) error {
+	return p.db.Model(&migration.Transaction{}).
+		Where("id = ? AND refunded_at IS NULL", transactionID).
+		Update("refunded_at", time.Now().UTC()).Error
+}
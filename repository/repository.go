@@ -19,6 +19,7 @@ type RepositoryContainer struct {
 	AdminRepository        IAdminRepository
 	PaymentRepository      IPaymentRepository
 	IPNRepository          IIPNRepository
+	SafetyRepository       ISafetyRepository
 	// Add other repositories here as needed
 }
 
@@ -51,6 +52,7 @@ func (f *RepositoryFactory) CreateRepositories() *RepositoryContainer {
 		AdminRepository:        f.createAdminRepository(),
 		PaymentRepository:      f.createPaymentRepository(),
 		IPNRepository:          f.createIPNRepository(),
+		SafetyRepository:       f.createSafetyRepository(),
 		// Initialize other repositories here
 	}
 }
@@ -105,4 +107,9 @@ func (f *RepositoryFactory) createIPNRepository() IIPNRepository {
 	return NewIPNRepository(f.db, f.redisClient)
 }
 
+// createSafetyRepository initializes and returns the Safety repository
+func (f *RepositoryFactory) createSafetyRepository() ISafetyRepository {
+	return NewSafetyRepository(f.db)
+}
+
 // Add methods for creating other repositories as needed
@@ -5,12 +5,16 @@ import (
 	"math"
 	"time"
 
+	"shareway/helper"
 	"shareway/infra/db/migration"
 	"shareway/schemas"
+	"shareway/util/cancellation"
+	"shareway/util/statemachine"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
 )
 
 type IRideRepository interface {
@@ -20,12 +24,14 @@ type IRideRepository interface {
 	GetRideOfferByID(rideOfferID uuid.UUID) (migration.RideOffer, error)
 	GetRideRequestByID(rideRequestID uuid.UUID) (migration.RideRequest, error)
 	GetTransactionByRideID(rideID uuid.UUID) (migration.Transaction, error)
-	AcceptRideRequest(rideOfferID, rideRequestID, vehicleID uuid.UUID) (migration.Ride, error)
+	AcceptRideRequest(rideOfferID, rideRequestID, vehicleID, userID uuid.UUID) (migration.Ride, error)
+	BookItinerary(itinerary Itinerary, userID uuid.UUID) (migration.Ride, migration.Ride, error)
+	GetItineraryLegRides(rideRequestID uuid.UUID) ([]migration.Ride, error)
 	CreateRideTransaction(rideID uuid.UUID, Fare int64, paymentMethod string, payerID uuid.UUID, receiverID uuid.UUID) (migration.Transaction, error)
 	StartRide(req schemas.StartRideRequest, userID uuid.UUID) (migration.Ride, error)
 	EndRide(req schemas.EndRideRequest, userID uuid.UUID) (migration.Ride, error)
 	UpdateRideLocation(req schemas.UpdateRideLocationRequest, userID uuid.UUID) (migration.Ride, error)
-	CancelRide(req schemas.CancelRideRequest, userID uuid.UUID) (migration.Ride, error)
+	CancelRide(req schemas.CancelRideRequest, userID uuid.UUID, decision cancellation.Decision) (migration.Ride, error)
 	GetAllPendingRide(userID uuid.UUID) ([]migration.RideOffer, []migration.RideRequest, error)
 	GetRideByID(rideID uuid.UUID) (migration.Ride, error)
 	RatingRideHitcher(req schemas.RatingRideHitcherRequest, userID uuid.UUID) error
@@ -34,6 +40,15 @@ type IRideRepository interface {
 	GetTotalRidesForUser(userID uuid.UUID) (int64, error)
 	GetTotalRidesForVehicle(vehicleID uuid.UUID) (int64, error)
 	GetScheduledAndOngoingRide(userID uuid.UUID) ([]migration.Ride, error)
+	GetRideTimeline(rideID uuid.UUID) ([]schemas.RideEventDetail, error)
+	GetRideBreadcrumbs(rideID uuid.UUID) ([]migration.RideBreadcrumb, error)
+	GetStaleRideOffers(before time.Time) ([]migration.RideOffer, error)
+	GetStaleRideRequests(before time.Time) ([]migration.RideRequest, error)
+	GetUnstartedRides(before time.Time) ([]migration.Ride, error)
+	ExpireRideOffer(rideOfferID uuid.UUID) error
+	ExpireRideRequest(rideRequestID uuid.UUID) error
+	ExpireRide(rideID uuid.UUID) error
+	GetPendingRefunds() ([]migration.Transaction, error)
 }
 
 type RideRepository struct {
@@ -45,13 +60,38 @@ func NewRideRepository(db *gorm.DB, redis *redis.Client) IRideRepository {
 	return &RideRepository{db: db, redis: redis}
 }
 
+// Event types stored in the ride events table
+const (
+	RideEventCreated            = "ride_created"
+	RideEventTransactionCreated = "transaction_created"
+	RideEventStarted            = "ride_started"
+	RideEventEnded              = "ride_ended"
+	RideEventCancelled          = "ride_cancelled"
+	RideEventHitcherRated       = "hitcher_rated"
+	RideEventDriverRated        = "driver_rated"
+	RideEventExpired            = "ride_expired"
+	RideEventGeofenceOverridden = "geofence_overridden"
+	RideEventSOS                = "sos_triggered"
+	RideEventNoShow             = "no_show_reported"
+	RideEventStatusChanged      = "status_changed"
+	RideEventSeatBooked         = "seat_booked"
+	RideEventSeatReleased       = "seat_released"
+)
+
 var (
 	ErrRideOfferNotFound   = errors.New("ride offer not found")
 	ErrRideRequestNotFound = errors.New("ride request not found")
+	ErrRideOfferFull       = errors.New("ride offer has no available seats")
+	ErrSeatsExceedCapacity = errors.New("requested seats exceed vehicle capacity")
 )
 
 // CreateNewChatRoom creates a new chat room between two users
 func (r *RideRepository) CreateNewChatRoom(userID1, userID2 uuid.UUID) error {
+	return createChatRoom(r.db, userID1, userID2)
+}
+
+// createChatRoom creates a chat room between two users within the given transaction, unless they already have one
+func createChatRoom(tx *gorm.DB, userID1, userID2 uuid.UUID) error {
 	// Create a new chat room
 	var chatRoom migration.Room
 	// Ensure user IDs are in a consistent order
@@ -60,7 +100,7 @@ func (r *RideRepository) CreateNewChatRoom(userID1, userID2 uuid.UUID) error {
 	}
 
 	// Check if the chat room already exists
-	err := r.db.Model(&migration.Room{}).
+	err := tx.Model(&migration.Room{}).
 		Where("user1_id = ? AND user2_id = ?", userID1, userID2).
 		Or("user1_id = ? AND user2_id = ?", userID2, userID1).
 		First(&chatRoom).Error
@@ -75,7 +115,7 @@ func (r *RideRepository) CreateNewChatRoom(userID1, userID2 uuid.UUID) error {
 		LastMessageText: "Hai bạn đã được kết nối. Bắt đầu trò chuyện!",
 		LastMessageID:   uuid.New(),
 	}
-	if err := r.db.Create(&chatRoom).Error; err != nil {
+	if err := tx.Create(&chatRoom).Error; err != nil {
 		return err
 	}
 
@@ -169,102 +209,134 @@ func (r *RideRepository) GetRideRequestByID(rideRequestID uuid.UUID) (migration.
 }
 
 // AcceptGiveRideRequest accepts a give ride request
-func (r *RideRepository) AcceptRideRequest(rideOfferID, rideRequestID, vehicleID uuid.UUID) (migration.Ride, error) {
+func (r *RideRepository) AcceptRideRequest(rideOfferID, rideRequestID, vehicleID, userID uuid.UUID) (migration.Ride, error) {
 	var ride migration.Ride
 
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		// Get the ride offer by ID with only necessary fields
-		var rideOffer migration.RideOffer
-		err := tx.Select("user_id, start_time, end_time, status, fare, start_address, end_address, encoded_polyline, distance, duration, start_latitude, start_longitude, end_latitude, end_longitude").
-			Where("id = ?", rideOfferID).
-			First(&rideOffer).Error
-		if err != nil {
-			return err
-		}
+		var err error
+		ride, err = r.acceptRideRequest(tx, rideOfferID, rideRequestID, vehicleID, userID)
+		return err
+	})
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// // Check if the ride offer is already matched
-		// if rideOffer.Status == "matched" {
-		// 	return errors.New("ride offer is already matched")
-		// }
+	if err != nil {
+		return migration.Ride{}, err
+	}
 
-		// Get the ride request by ID with only necessary fields
-		var rideRequest migration.RideRequest
-		err = tx.Select("user_id, start_time, end_time, status, start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude, encoded_polyline, distance, duration").
-			Where("id = ?", rideRequestID).
-			First(&rideRequest).Error
-		if err != nil {
-			return err
-		}
+	return ride, nil
+}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// // Check if the ride request is already matched
-		// if rideRequest.Status == "matched" {
-		// 	return errors.New("ride request is already matched")
-		// }
+// acceptRideRequest books a seat of the ride offer for the ride request and creates their ride within the given transaction
+func (r *RideRepository) acceptRideRequest(tx *gorm.DB, rideOfferID, rideRequestID, vehicleID, userID uuid.UUID) (migration.Ride, error) {
+	// Get the ride offer by ID with only necessary fields
+	// Lock the row so concurrent accepts cannot book the same seat twice
+	var rideOffer migration.RideOffer
+	err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+		Select("id, user_id, start_time, end_time, status, fare, start_address, end_address, encoded_polyline, distance, duration, start_latitude, start_longitude, end_latitude, end_longitude, seats, available_seats").
+		Where("id = ?", rideOfferID).
+		First(&rideOffer).Error
+	if err != nil {
+		return migration.Ride{}, err
+	}
 
-		// Create a new ride
-		ride = migration.Ride{
-			RideOfferID:     rideOfferID,
-			RideRequestID:   rideRequestID,
-			Status:          "scheduled",
-			StartTime:       rideOffer.StartTime,
-			EndTime:         rideOffer.EndTime,
-			Fare:            rideOffer.Fare,
-			StartAddress:    rideOffer.StartAddress,
-			EndAddress:      rideOffer.EndAddress,
-			EncodedPolyline: rideOffer.EncodedPolyline,
-			Distance:        rideOffer.Distance,
-			Duration:        rideOffer.Duration,
-			StartLatitude:   rideOffer.StartLatitude,
-			StartLongitude:  rideOffer.StartLongitude,
-			EndLatitude:     rideOffer.EndLatitude,
-			EndLongitude:    rideOffer.EndLongitude,
-			VehicleID:       vehicleID,
-		}
+	// Check if the ride offer still has a seat left
+	if rideOffer.AvailableSeats < 1 {
+		return migration.Ride{}, ErrRideOfferFull
+	}
 
-		// Create the ride
-		if err := tx.Create(&ride).Error; err != nil {
-			return err
-		}
+	// Get the ride request by ID with only necessary fields
+	var rideRequest migration.RideRequest
+	err = tx.Select("id, user_id, start_time, end_time, status, start_address, end_address, start_latitude, start_longitude, end_latitude, end_longitude, encoded_polyline, distance, duration").
+		Where("id = ?", rideRequestID).
+		First(&rideRequest).Error
+	if err != nil {
+		return migration.Ride{}, err
+	}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
+	// Check that both sides can still be matched before creating the ride
+	if err := statemachine.Validate(statemachine.EntityRideRequest, rideRequest.Status, statemachine.StatusMatched); err != nil {
+		return migration.Ride{}, err
+	}
+	if rideOffer.Status != statemachine.StatusCreated {
+		return migration.Ride{}, &statemachine.TransitionError{Entity: statemachine.EntityRideOffer, From: rideOffer.Status, To: statemachine.StatusMatched}
+	}
 
-		// // Update ride offer status
-		// if err := tx.Model(&migration.RideOffer{}).Where("id = ?", rideOfferID).Update("status", "matched").Error; err != nil {
-		// 	return err
-		// }
+	// The hitcher walks to the meeting point on the route of the driver to be picked up
+	pickup := helper.GetMeetingPoint(helper.DecodePolyline(string(rideOffer.EncodedPolyline)), schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude})
+
+	// Create a new ride, the hitcher only pays for the part of the route they ride on
+	ride := migration.Ride{
+		RideOfferID:     rideOfferID,
+		RideRequestID:   rideRequestID,
+		Status:          statemachine.StatusScheduled,
+		StartTime:       rideOffer.StartTime,
+		EndTime:         rideOffer.EndTime,
+		Fare:            helper.CalculateSegmentFare(rideOffer, rideRequest),
+		StartAddress:    rideOffer.StartAddress,
+		EndAddress:      rideOffer.EndAddress,
+		EncodedPolyline: rideOffer.EncodedPolyline,
+		Distance:        rideOffer.Distance,
+		Duration:        rideOffer.Duration,
+		StartLatitude:   rideOffer.StartLatitude,
+		StartLongitude:  rideOffer.StartLongitude,
+		EndLatitude:     rideOffer.EndLatitude,
+		EndLongitude:    rideOffer.EndLongitude,
+		PickupLatitude:  pickup.Latitude,
+		PickupLongitude: pickup.Longitude,
+		VehicleID:       vehicleID,
+	}
 
-		// // Update ride request status
-		// if err := tx.Model(&migration.RideRequest{}).Where("id = ?", rideRequestID).Update("status", "matched").Error; err != nil {
-		// 	return err
-		// }
+	// Create the ride
+	if err := tx.Create(&ride).Error; err != nil {
+		return migration.Ride{}, err
+	}
 
-		// Before creating the chat room, verify both users exist
-		var userCount int64
-		err = tx.Model(&migration.User{}).
-			Where("id IN ?", []uuid.UUID{rideOffer.UserID, rideRequest.UserID}).
-			Count(&userCount).Error
-		if err != nil {
-			return err
-		}
-		if userCount != 2 {
-			return errors.New("one or both users do not exist")
-		}
+	if err := recordRideEvent(tx, migration.RideEvent{
+		RideID:    &ride.ID,
+		ActorID:   userID,
+		EventType: RideEventCreated,
+		ToStatus:  ride.Status,
+	}); err != nil {
+		return migration.Ride{}, err
+	}
 
-		// Create new chat room (between the driver and the hitcher of the ride)
-		// When a ride is accepted, a chat room is created between the driver and the hitcher of the ride
-		// then system automatically sends a message to the chat room to notify the hitcher that the ride is accepted
-		if err := r.CreateNewChatRoom(rideOffer.UserID, rideRequest.UserID); err != nil {
-			return err
+	// Book one seat on the ride offer, the offer is only matched once every seat is taken
+	// so it keeps showing up in the suggestions while there is still room for other hitchers
+	if err := tx.Model(&migration.RideOffer{}).Where("id = ?", rideOfferID).Update("available_seats", rideOffer.AvailableSeats-1).Error; err != nil {
+		return migration.Ride{}, err
+	}
+	if err := recordSeatEvent(tx, ride, RideEventSeatBooked, userID); err != nil {
+		return migration.Ride{}, err
+	}
+	if rideOffer.AvailableSeats-1 == 0 {
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOfferID, rideOffer.Status, statemachine.StatusMatched, userID); err != nil {
+			return migration.Ride{}, err
 		}
+	}
 
-		return nil
-	})
+	// Update ride request status
+	if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequestID, rideRequest.Status, statemachine.StatusMatched, userID); err != nil {
+		return migration.Ride{}, err
+	}
 
+	// Before creating the chat room, verify both users exist
+	var userCount int64
+	err = tx.Model(&migration.User{}).
+		Where("id IN ?", []uuid.UUID{rideOffer.UserID, rideRequest.UserID}).
+		Count(&userCount).Error
 	if err != nil {
 		return migration.Ride{}, err
 	}
+	if userCount != 2 {
+		return migration.Ride{}, errors.New("one or both users do not exist")
+	}
+
+	// Create new chat room (between the driver and the hitcher of the ride)
+	// When a ride is accepted, a chat room is created between the driver and the hitcher of the ride
+	// then system automatically sends a message to the chat room to notify the hitcher that the ride is accepted.
+	// The chat room is created in the transaction of the ride so it is rolled back with it
+	if err := createChatRoom(tx, rideOffer.UserID, rideRequest.UserID); err != nil {
+		return migration.Ride{}, err
+	}
 
 	return ride, nil
 }
@@ -274,25 +346,55 @@ func (r *RideRepository) CreateRideTransaction(rideID uuid.UUID, Fare int64, pay
 	var transaction migration.Transaction
 
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		// Create a new transaction
-		transaction = migration.Transaction{
-			RideID:        rideID,
-			Amount:        Fare,
-			Status:        "pending",
-			PaymentMethod: paymentMethod,
-			PayerID:       payerID,
-			ReceiverID:    receiverID,
-		}
+		var err error
+		transaction, err = createRideTransaction(tx, rideID, Fare, paymentMethod, payerID, receiverID)
+		return err
+	})
 
-		// Create the transaction
-		if err := tx.Create(&transaction).Error; err != nil {
-			return err
+	if err != nil {
+		return migration.Transaction{}, err
+	}
+
+	return transaction, nil
+}
+
+// createRideTransaction creates the transaction of a ride within the given transaction
+func createRideTransaction(tx *gorm.DB, rideID uuid.UUID, Fare int64, paymentMethod string, payerID uuid.UUID, receiverID uuid.UUID) (migration.Transaction, error) {
+	var transaction migration.Transaction
+
+	// The MoMo payment held from a ride the driver cancelled for the same ride request goes back in full,
+	// the new ride is paid on its own
+	held, err := heldTransaction(tx, tx.Model(&migration.Ride{}).Select("ride_request_id").Where("id = ?", rideID))
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return migration.Transaction{}, err
+	}
+	if err == nil {
+		if err := refundTransaction(tx, held, 0, payerID); err != nil {
+			return migration.Transaction{}, err
 		}
+	}
 
-		return nil
-	})
+	// Create a new transaction
+	transaction = migration.Transaction{
+		RideID:        rideID,
+		Amount:        Fare,
+		Status:        "pending",
+		PaymentMethod: paymentMethod,
+		PayerID:       payerID,
+		ReceiverID:    receiverID,
+	}
 
-	if err != nil {
+	// Create the transaction
+	if err := tx.Create(&transaction).Error; err != nil {
+		return migration.Transaction{}, err
+	}
+
+	if err := recordRideEvent(tx, migration.RideEvent{
+		RideID:    &rideID,
+		ActorID:   payerID,
+		EventType: RideEventTransactionCreated,
+		Reason:    transaction.PaymentMethod,
+	}); err != nil {
 		return migration.Transaction{}, err
 	}
 
@@ -330,45 +432,52 @@ func (r *RideRepository) StartRide(req schemas.StartRideRequest, userID uuid.UUI
 			return err
 		}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// // Check if the ride is already started
-		// if ride.Status == "ongoing" {
-		// 	return errors.New("ride is already started")
-		// }
-
-		// // Check if the ride is already ended
-		// if ride.Status == "completed" {
-		// 	return errors.New("ride is already ended")
-		// }
-
-		// // Check if the ride is already cancelled
-		// if ride.Status == "cancelled" {
-		// 	return errors.New("ride is already cancelled")
-		// }
-
-		// Check if the current location of the driver and hitcher is near less than 100 meters
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING AND DEMO PURPOSES
-		// if !helper.IsNearby(schemas.Point{Lat: rideOffer.DriverCurrentLatitude, Lng: rideOffer.DriverCurrentLongitude}, schemas.Point{Lat: rideRequest.RiderCurrentLatitude, Lng: rideRequest.RiderCurrentLongitude}, 0.0001) {
-		// 	return errors.New("driver and rider are not nearby") // Make sure cannot fake the location
-		// }
+		// The geofence radius is checked by the service, keep the reason when the driver overrides it
+		if req.OverrideGeofence {
+			if err := recordRideEvent(tx, migration.RideEvent{
+				RideID:    &ride.ID,
+				ActorID:   userID,
+				EventType: RideEventGeofenceOverridden,
+				Latitude:  req.CurrentLocation.Lat,
+				Longitude: req.CurrentLocation.Lng,
+				Reason:    req.OverrideReason,
+			}); err != nil {
+				return err
+			}
+		}
 
 		// TODO: In the future must check start time and end time of the ride to prevent early start or late start
 
-		// Update the ride offer status to ongoing
-		if err := tx.Model(&migration.RideOffer{}).Where("id = ?", ride.RideOfferID).Update("status", "ongoing").Error; err != nil {
+		// Update the ride status to started
+		if err := transitionRide(tx, ride, statemachine.StatusOngoing, migration.RideEvent{
+			ActorID:   userID,
+			EventType: RideEventStarted,
+			Latitude:  req.CurrentLocation.Lat,
+			Longitude: req.CurrentLocation.Lng,
+		}); err != nil {
 			return err
 		}
 
-		// Update the ride request status to ongoing
-		if err := tx.Model(&migration.RideRequest{}).Where("id = ?", ride.RideRequestID).Update("status", "ongoing").Error; err != nil {
+		// The path of the ride starts where the driver started it
+		if err := recordBreadcrumb(tx, ride.ID, req.CurrentLocation); err != nil {
 			return err
 		}
 
-		// Update the ride status to started
-		if err := tx.Model(&migration.Ride{}).Where("id = ?", req.RideID).Update("status", "ongoing").Error; err != nil {
+		// Update the ride request status to ongoing
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, statemachine.StatusOngoing, userID); err != nil {
+			return err
+		}
+		if err := progressItinerary(tx, ride.ID, rideRequest, userID); err != nil {
 			return err
 		}
 
+		// Update the ride offer status to ongoing (it is already ongoing if another hitcher on the same ride offer was picked up first)
+		if rideOffer.Status != statemachine.StatusOngoing {
+			if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusOngoing, userID); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 
@@ -434,51 +543,83 @@ func (r *RideRepository) EndRide(req schemas.EndRideRequest, userID uuid.UUID) (
 			return err
 		}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// // Check if the ride is already ended
-		// if ride.Status == "completed" {
-		// 	return errors.New("ride is already ended")
-		// }
+		// The geofence radius is checked by the service, keep the reason when the driver overrides it
+		if req.OverrideGeofence {
+			if err := recordRideEvent(tx, migration.RideEvent{
+				RideID:    &ride.ID,
+				ActorID:   userID,
+				EventType: RideEventGeofenceOverridden,
+				Latitude:  req.CurrentLocation.Lat,
+				Longitude: req.CurrentLocation.Lng,
+				Reason:    req.OverrideReason,
+			}); err != nil {
+				return err
+			}
+		}
 
-		// // Check if the ride is already cancelled
-		// if ride.Status == "cancelled" {
-		// 	return errors.New("ride is already cancelled")
-		// }
+		// Update the ride status to ended
+		if err := transitionRide(tx, ride, statemachine.StatusCompleted, migration.RideEvent{
+			ActorID:   userID,
+			EventType: RideEventEnded,
+			Latitude:  req.CurrentLocation.Lat,
+			Longitude: req.CurrentLocation.Lng,
+		}); err != nil {
+			return err
+		}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// Check if the current location of the driver and the hitcher end location is near less than 100 meters
-		// if !helper.IsNearby(schemas.Point{Lat: rideOffer.DriverCurrentLatitude, Lng: rideOffer.DriverCurrentLongitude}, schemas.Point{Lat: rideRequest.EndLatitude, Lng: rideRequest.EndLongitude}, 0.0001) {
-		// 	return errors.New("driver not nearby the end location") // Make sure cannot fake the location
-		// }
+		// Close the path of the ride where the driver ended it and keep the distance actually driven
+		if err := recordBreadcrumb(tx, ride.ID, req.CurrentLocation); err != nil {
+			return err
+		}
+		actualDistance, err := breadcrumbDistance(tx, ride.ID)
+		if err != nil {
+			return err
+		}
+		if err := tx.Model(&migration.Ride{}).Where("id = ?", ride.ID).Update("actual_distance", actualDistance).Error; err != nil {
+			return err
+		}
+		ride.ActualDistance = actualDistance
 
-		// Update the ride offer status to ended
-		if err := tx.Model(&migration.RideOffer{}).Where("id = ?", ride.RideOfferID).Update("status", "completed").Error; err != nil {
+		// Update the ride offer status to ended once every hitcher on the ride offer has been dropped off
+		otherActiveRides, err := r.countOtherActiveRides(tx, ride.RideOfferID, ride.ID)
+		if err != nil {
 			return err
 		}
+		if otherActiveRides == 0 {
+			if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCompleted, userID); err != nil {
+				return err
+			}
+		}
 
 		// Update the ride request status to ended
-		if err := tx.Model(&migration.RideRequest{}).Where("id = ?", ride.RideRequestID).Update("status", "completed").Error; err != nil {
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, statemachine.StatusCompleted, userID); err != nil {
+			return err
+		}
+		if err := progressItinerary(tx, ride.ID, rideRequest, userID); err != nil {
 			return err
 		}
 
 		// Update the transaction status to completed
-		if err := tx.Model(&migration.Transaction{}).Where("ride_id = ?", req.RideID).Update("status", "completed").Error; err != nil {
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityTransaction, &migration.Transaction{}, transaction.ID, transaction.Status, statemachine.StatusCompleted, userID); err != nil {
+			return err
+		}
+
+		// Both participants kept the ride
+		if err := updateReliability(tx, rideOffer.UserID, "completed_rides"); err != nil {
+			return err
+		}
+		if err := updateReliability(tx, rideRequest.UserID, "completed_rides"); err != nil {
 			return err
 		}
 
 		// Only update the balance in app if the payment method is momo else do nothing
 		if transaction.PaymentMethod == "momo" {
-			// Update the driver's current balance in app (add the fare)
-			if err := tx.Model(&migration.User{}).Where("id = ?", rideOffer.UserID).Update("balance_in_app", gorm.Expr("balance_in_app + ?", rideOffer.Fare)).Error; err != nil {
+			// Update the driver's current balance in app (add the fare of the ride)
+			if err := tx.Model(&migration.User{}).Where("id = ?", rideOffer.UserID).Update("balance_in_app", gorm.Expr("balance_in_app + ?", ride.Fare)).Error; err != nil {
 				return err
 			}
 		}
 
-		// Update the ride status to ended
-		if err := tx.Model(&migration.Ride{}).Where("id = ?", req.RideID).Update("status", "completed").Error; err != nil {
-			return err
-		}
-
 		return nil
 	})
 
@@ -546,6 +687,13 @@ func (r *RideRepository) UpdateRideLocation(req schemas.UpdateRideLocationReques
 			return err
 		}
 
+		// Keep the path actually taken while the ride is ongoing
+		if ride.Status == statemachine.StatusOngoing {
+			if err := recordBreadcrumb(tx, ride.ID, req.CurrentLocation); err != nil {
+				return err
+			}
+		}
+
 		return nil
 	})
 
@@ -557,74 +705,280 @@ func (r *RideRepository) UpdateRideLocation(req schemas.UpdateRideLocationReques
 }
 
 // CancelRideByDriver cancels a ride by the driver
-func (r *RideRepository) CancelRide(req schemas.CancelRideRequest, userID uuid.UUID) (migration.Ride, error) {
+func (r *RideRepository) CancelRide(req schemas.CancelRideRequest, userID uuid.UUID, decision cancellation.Decision) (migration.Ride, error) {
 	var ride migration.Ride
 	err := r.db.Transaction(func(tx *gorm.DB) error {
-		// Get the ride by ID
-		err := tx.Model(&migration.Ride{}).
-			Where("id = ?", req.RideID).
-			First(&ride).Error
+		var rideRequest migration.RideRequest
+		var err error
+		ride, rideRequest, err = r.cancelRide(tx, req, userID, decision)
 		if err != nil {
 			return err
 		}
 
-		// Get the ride offer by ID
-		var rideOffer migration.RideOffer
-		err = tx.Model(&migration.RideOffer{}).
-			Where("id = ?", ride.RideOfferID).
-			First(&rideOffer).Error
-		if err != nil {
-			return err
-		}
+		// The other leg of an itinerary cannot be ridden without this one
+		return r.releaseItinerary(tx, ride.ID, rideRequest, statemachine.StatusCancelled, decision.Rematch, userID)
+	})
 
-		// Get the ride request by ID
-		var rideRequest migration.RideRequest
-		err = tx.Model(&migration.RideRequest{}).
-			Where("id = ?", ride.RideRequestID).
-			First(&rideRequest).Error
-		if err != nil {
-			return err
-		}
+	if err != nil {
+		return migration.Ride{}, err
+	}
 
-		// TODO: COMMENTED OUT FOR NOW FOR BETTER TESTING
-		// // Check if the ride is already ended
-		// if ride.Status == "completed" {
-		// 	return errors.New("ride is already ended")
-		// }
+	return ride, nil
+}
 
-		// // Check if the ride is already cancelled
-		// if ride.Status == "cancelled" {
-		// 	return errors.New("ride is already cancelled")
-		// }
+// cancelRide cancels a ride within the given transaction and returns it with its ride request
+func (r *RideRepository) cancelRide(tx *gorm.DB, req schemas.CancelRideRequest, userID uuid.UUID, decision cancellation.Decision) (migration.Ride, migration.RideRequest, error) {
+	// Get the ride by ID
+	var ride migration.Ride
+	err := tx.Model(&migration.Ride{}).
+		Where("id = ?", req.RideID).
+		First(&ride).Error
+	if err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
 
-		// Update the ride offer status to cancelled
-		if err := tx.Model(&migration.RideOffer{}).Where("id = ?", ride.RideOfferID).Update("status", "cancelled").Error; err != nil {
-			return err
+	// Get the ride offer by ID
+	var rideOffer migration.RideOffer
+	err = tx.Model(&migration.RideOffer{}).
+		Where("id = ?", ride.RideOfferID).
+		First(&rideOffer).Error
+	if err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+
+	// Get the ride request by ID
+	var rideRequest migration.RideRequest
+	err = tx.Model(&migration.RideRequest{}).
+		Where("id = ?", ride.RideRequestID).
+		First(&rideRequest).Error
+	if err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+
+	// Update the ride status to cancelled
+	// The cancel request has no location so use the last known location of the driver
+	eventType := RideEventCancelled
+	if decision.Outcome == cancellation.OutcomeNoShow {
+		eventType = RideEventNoShow
+	}
+	if err := transitionRide(tx, ride, statemachine.StatusCancelled, migration.RideEvent{
+		ActorID:   userID,
+		EventType: eventType,
+		Latitude:  rideOffer.DriverCurrentLatitude,
+		Longitude: rideOffer.DriverCurrentLongitude,
+		Reason:    req.Reason,
+	}); err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+
+	// Keep the outcome of the cancellation policy and lower the reliability of the user responsible for it
+	updates := map[string]interface{}{
+		"cancelled_by":         userID,
+		"cancellation_outcome": decision.Outcome,
+		"cancellation_fee":     decision.Fee,
+	}
+	if decision.Responsible != uuid.Nil {
+		updates["responsible_user_id"] = decision.Responsible
+		counter := "late_cancellations"
+		if decision.Outcome == cancellation.OutcomeNoShow {
+			counter = "no_shows"
+		}
+		if err := updateReliability(tx, decision.Responsible, counter); err != nil {
+			return migration.Ride{}, migration.RideRequest{}, err
 		}
+	}
+	if err := tx.Model(&migration.Ride{}).Where("id = ?", ride.ID).Updates(updates).Error; err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
 
-		// Update the ride request status to cancelled
-		if err := tx.Model(&migration.RideRequest{}).Where("id = ?", ride.RideRequestID).Update("status", "cancelled").Error; err != nil {
-			return err
+	// Give the seat back to the ride offer
+	if err := releaseSeat(tx, ride, userID); err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+
+	// Only cancel the ride offer if this was the last hitcher on it and it is not kept open, otherwise
+	// re-open the ride offer so the freed seat can be booked again
+	otherActiveRides, err := r.countOtherActiveRides(tx, ride.RideOfferID, ride.ID)
+	if err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+	if otherActiveRides == 0 && !decision.Reopen {
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCancelled, userID); err != nil {
+			return migration.Ride{}, migration.RideRequest{}, err
+		}
+	} else if rideOffer.Status == statemachine.StatusMatched {
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCreated, userID); err != nil {
+			return migration.Ride{}, migration.RideRequest{}, err
 		}
+	}
 
-		// Update the ride status to cancelled
-		if err := tx.Model(&migration.Ride{}).Where("id = ?", req.RideID).Update("status", "cancelled").Error; err != nil {
-			return err
+	// Update the ride request status to cancelled, or put it back in the matching pool when the driver left the hitcher stranded.
+	// A leg of an itinerary is not matched again on its own, the ride request of the whole journey is (see releaseItinerary)
+	rideRequestStatus := statemachine.StatusCancelled
+	if decision.Rematch && rideRequest.ParentRideRequestID == nil {
+		rideRequestStatus = statemachine.StatusCreated
+	}
+	if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, rideRequestStatus, userID); err != nil {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+
+	// Update the transaction status to refunded (if the transaction has been created for the ride)
+	var transaction migration.Transaction
+	err = tx.Model(&migration.Transaction{}).
+		Where("ride_id = ?", ride.ID).
+		First(&transaction).Error
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		return migration.Ride{}, migration.RideRequest{}, err
+	}
+	if err == nil {
+		// A MoMo payment is kept on hold for the next ride of the hitcher instead of being refunded. Otherwise it is
+		// marked refunded here and the money is sent back once the cancellation is committed (see GetPendingRefunds)
+		if rideRequestStatus == statemachine.StatusCreated && transaction.PaymentMethod == "momo" {
+			err = transitionStatusOfRide(tx, ride.ID, statemachine.EntityTransaction, &migration.Transaction{}, transaction.ID, transaction.Status, statemachine.StatusOnHold, userID)
+		} else {
+			err = refundTransaction(tx, transaction, decision.Fee, userID)
+		}
+		if err != nil {
+			return migration.Ride{}, migration.RideRequest{}, err
 		}
 
-		// Update the transaction status to refunded
-		if err := tx.Model(&migration.Transaction{}).Where("ride_id = ?", req.RideID).Update("status", "refunded").Error; err != nil {
-			return err
+		// The fee kept from the refund of the hitcher goes to the driver
+		if transaction.PaymentMethod == "momo" && decision.Fee > 0 {
+			if err := tx.Model(&migration.User{}).Where("id = ?", rideOffer.UserID).Update("balance_in_app", gorm.Expr("balance_in_app + ?", decision.Fee)).Error; err != nil {
+				return migration.Ride{}, migration.RideRequest{}, err
+			}
 		}
+	}
 
-		return nil
+	return ride, rideRequest, nil
+}
+
+// updateReliability increments one of the reliability counters of a user (completed_rides, late_cancellations or
+// no_shows) and recomputes the reliability score from them
+func updateReliability(tx *gorm.DB, userID uuid.UUID, counter string) error {
+	if err := tx.Model(&migration.User{}).Where("id = ?", userID).Update(counter, gorm.Expr(counter+" + 1")).Error; err != nil {
+		return err
+	}
+
+	return tx.Model(&migration.User{}).Where("id = ?", userID).
+		Update("reliability_score", gorm.Expr("ROUND(100.0 * completed_rides / (completed_rides + late_cancellations + no_shows), 1)")).Error
+}
+
+// recordRideEvent appends an event to the timeline of a ride or to the status history of another entity
+func recordRideEvent(tx *gorm.DB, event migration.RideEvent) error {
+	if event.EntityType == "" && event.RideID != nil {
+		event.EntityType = string(statemachine.EntityRide)
+		event.EntityID = *event.RideID
+	}
+	return tx.Create(&event).Error
+}
+
+// releaseSeat gives the seat of a ride back to its ride offer
+func releaseSeat(tx *gorm.DB, ride migration.Ride, changedBy uuid.UUID) error {
+	result := tx.Model(&migration.RideOffer{}).
+		Where("id = ? AND available_seats < seats", ride.RideOfferID).
+		Update("available_seats", gorm.Expr("available_seats + 1"))
+	if result.Error != nil || result.RowsAffected == 0 {
+		return result.Error
+	}
+
+	return recordSeatEvent(tx, ride, RideEventSeatReleased, changedBy)
+}
+
+// recordSeatEvent records on the timeline of a ride that its seat was taken from or given back to its ride offer
+func recordSeatEvent(tx *gorm.DB, ride migration.Ride, eventType string, changedBy uuid.UUID) error {
+	return recordRideEvent(tx, migration.RideEvent{
+		EntityType: string(statemachine.EntityRideOffer),
+		EntityID:   ride.RideOfferID,
+		RideID:     &ride.ID,
+		ActorID:    changedBy,
+		EventType:  eventType,
 	})
+}
 
-	if err != nil {
-		return migration.Ride{}, err
+// recordBreadcrumb appends a location of the driver to the path of a ride
+func recordBreadcrumb(tx *gorm.DB, rideID uuid.UUID, location schemas.Point) error {
+	return tx.Create(&migration.RideBreadcrumb{
+		RideID:    rideID,
+		Latitude:  location.Lat,
+		Longitude: location.Lng,
+	}).Error
+}
+
+// breadcrumbDistance returns the length in kilometers of the path recorded for a ride
+func breadcrumbDistance(tx *gorm.DB, rideID uuid.UUID) (float64, error) {
+	var breadcrumbs []migration.RideBreadcrumb
+	if err := tx.Where("ride_id = ?", rideID).Order("created_at ASC").Find(&breadcrumbs).Error; err != nil {
+		return 0, err
 	}
 
-	return ride, nil
+	return helper.PathDistance(helper.BreadcrumbPoints(breadcrumbs)), nil
+}
+
+// transitionStatus moves a ride offer, ride request, transaction or safety alert to a new status if the state machine
+// allows it and records the change in the ride events table. Rides go through transitionRide
+func transitionStatus(tx *gorm.DB, entity statemachine.Entity, model interface{}, id uuid.UUID, from, to string, changedBy uuid.UUID) error {
+	return transitionStatusOfRide(tx, uuid.Nil, entity, model, id, from, to, changedBy)
+}
+
+// transitionStatusOfRide is transitionStatus for a change made on behalf of a ride, which shows up on its timeline
+func transitionStatusOfRide(tx *gorm.DB, rideID uuid.UUID, entity statemachine.Entity, model interface{}, id uuid.UUID, from, to string, changedBy uuid.UUID) error {
+	if err := updateStatus(tx, entity, model, id, from, to); err != nil {
+		return err
+	}
+
+	event := migration.RideEvent{
+		EntityType: string(entity),
+		EntityID:   id,
+		ActorID:    changedBy,
+		EventType:  RideEventStatusChanged,
+		FromStatus: from,
+		ToStatus:   to,
+	}
+	if rideID != uuid.Nil {
+		event.RideID = &rideID
+	}
+	return recordRideEvent(tx, event)
+}
+
+// transitionRide moves a ride to a new status if the state machine allows it and records the given event of the
+// ride timeline for the change
+func transitionRide(tx *gorm.DB, ride migration.Ride, to string, event migration.RideEvent) error {
+	if err := updateStatus(tx, statemachine.EntityRide, &migration.Ride{}, ride.ID, ride.Status, to); err != nil {
+		return err
+	}
+
+	event.RideID = &ride.ID
+	event.FromStatus = ride.Status
+	event.ToStatus = to
+	return recordRideEvent(tx, event)
+}
+
+// updateStatus updates the status of a row if the state machine allows it
+func updateStatus(tx *gorm.DB, entity statemachine.Entity, model interface{}, id uuid.UUID, from, to string) error {
+	if err := statemachine.Validate(entity, from, to); err != nil {
+		return err
+	}
+
+	// Only update the row if its status has not been changed by another request in the meantime
+	result := tx.Model(model).Where("id = ? AND status = ?", id, from).Update("status", to)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return &statemachine.TransitionError{Entity: entity, From: from, To: to}
+	}
+
+	return nil
+}
+
+// countOtherActiveRides counts the scheduled or ongoing rides of a ride offer except the given ride
+func (r *RideRepository) countOtherActiveRides(tx *gorm.DB, rideOfferID, rideID uuid.UUID) (int64, error) {
+	var count int64
+	err := tx.Model(&migration.Ride{}).
+		Where("ride_offer_id = ? AND id <> ? AND status IN ?", rideOfferID, rideID, []string{statemachine.StatusScheduled, statemachine.StatusOngoing}).
+		Count(&count).Error
+	return count, err
 }
 
 // GetAllPendingRide fetches all pending rides for a user
@@ -704,6 +1058,14 @@ func (r *RideRepository) RatingRideHitcher(req schemas.RatingRideHitcherRequest,
 			return err
 		}
 
+		if err := recordRideEvent(tx, migration.RideEvent{
+			RideID:    &ride.ID,
+			ActorID:   userID,
+			EventType: RideEventHitcherRated,
+		}); err != nil {
+			return err
+		}
+
 		// Update the receiver's rating average and total rating count
 		var ratee migration.User
 		if err := tx.First(&ratee, req.ReceiverID).Error; err != nil {
@@ -758,6 +1120,14 @@ func (r *RideRepository) RatingRideDriver(req schemas.RatingRideDriverRequest, u
 			return err
 		}
 
+		if err := recordRideEvent(tx, migration.RideEvent{
+			RideID:    &ride.ID,
+			ActorID:   userID,
+			EventType: RideEventDriverRated,
+		}); err != nil {
+			return err
+		}
+
 		// Update the receiver's rating average and total rating count
 		var ratee migration.User
 		if err := tx.First(&ratee, req.ReceiverID).Error; err != nil {
@@ -843,5 +1213,376 @@ func (r *RideRepository) GetScheduledAndOngoingRide(userID uuid.UUID) ([]migrati
 	return rides, nil
 }
 
+// GetRideTimeline fetches every event of a ride in chronological order with the name of the user who triggered it,
+// including the changes of its ride offer, ride request and transaction made on behalf of the ride
+func (r *RideRepository) GetRideTimeline(rideID uuid.UUID) ([]schemas.RideEventDetail, error) {
+	var rideCount int64
+	if err := r.db.Model(&migration.Ride{}).Where("id = ?", rideID).Count(&rideCount).Error; err != nil {
+		return nil, err
+	}
+	if rideCount == 0 {
+		return nil, ErrRideNotFound
+	}
+
+	var events []schemas.RideEventDetail
+	err := r.db.Model(&migration.RideEvent{}).
+		Select("ride_events.id, ride_events.ride_id, ride_events.entity_type, ride_events.entity_id, ride_events.actor_id, COALESCE(users.full_name, '') AS actor_name, "+
+			"ride_events.event_type, ride_events.from_status, ride_events.to_status, ride_events.latitude, ride_events.longitude, "+
+			"ride_events.reason, ride_events.created_at").
+		Joins("LEFT JOIN users ON users.id = ride_events.actor_id").
+		Where("ride_events.ride_id = ?", rideID).
+		Order("ride_events.created_at ASC").
+		Scan(&events).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// GetRideBreadcrumbs fetches the recorded path of a ride in chronological order
+func (r *RideRepository) GetRideBreadcrumbs(rideID uuid.UUID) ([]migration.RideBreadcrumb, error) {
+	var breadcrumbs []migration.RideBreadcrumb
+	err := r.db.Where("ride_id = ?", rideID).
+		Order("created_at ASC").
+		Find(&breadcrumbs).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return breadcrumbs, nil
+}
+
+// GetStaleRideOffers fetches the ride offers that nobody booked and whose time window ended before the given time
+func (r *RideRepository) GetStaleRideOffers(before time.Time) ([]migration.RideOffer, error) {
+	var rideOffers []migration.RideOffer
+	err := r.db.Preload("User").
+		Where("status = ? AND end_time < ?", statemachine.StatusCreated, before).
+		Where("NOT EXISTS (SELECT 1 FROM rides WHERE rides.ride_offer_id = ride_offers.id AND rides.status IN ?)",
+			[]string{statemachine.StatusScheduled, statemachine.StatusOngoing}).
+		Find(&rideOffers).Error
+	if err != nil {
+		return nil, err
+	}
+	return rideOffers, nil
+}
+
+// GetStaleRideRequests fetches the ride requests that were not matched and whose time window ended before the given time
+func (r *RideRepository) GetStaleRideRequests(before time.Time) ([]migration.RideRequest, error) {
+	var rideRequests []migration.RideRequest
+	err := r.db.Preload("User").
+		Where("status = ? AND end_time < ?", statemachine.StatusCreated, before).
+		Find(&rideRequests).Error
+	if err != nil {
+		return nil, err
+	}
+	return rideRequests, nil
+}
+
+// GetUnstartedRides fetches the scheduled rides that should have ended before the given time but were never started
+func (r *RideRepository) GetUnstartedRides(before time.Time) ([]migration.Ride, error) {
+	var rides []migration.Ride
+	err := r.db.Preload("RideOffer.User").
+		Preload("RideRequest.User").
+		Where("status = ? AND end_time < ?", statemachine.StatusScheduled, before).
+		Find(&rides).Error
+	if err != nil {
+		return nil, err
+	}
+	return rides, nil
+}
+
+// ExpireRideOffer expires a ride offer that nobody booked
+func (r *RideRepository) ExpireRideOffer(rideOfferID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var rideOffer migration.RideOffer
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rideOffer, rideOfferID).Error; err != nil {
+			return err
+		}
+
+		return transitionStatus(tx, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusExpired, uuid.Nil)
+	})
+}
+
+// ExpireRideRequest expires a ride request that was not matched
+func (r *RideRepository) ExpireRideRequest(rideRequestID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var rideRequest migration.RideRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rideRequest, rideRequestID).Error; err != nil {
+			return err
+		}
+
+		if err := transitionStatus(tx, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, statemachine.StatusExpired, uuid.Nil); err != nil {
+			return err
+		}
+
+		// The hitcher was never matched again since the driver cancelled, the payment kept on hold goes back in full
+		held, err := heldTransaction(tx, rideRequest.ID)
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return refundTransaction(tx, held, 0, uuid.Nil)
+	})
+}
+
+// GetPendingRefunds fetches the refunded MoMo transactions whose money has not been sent back yet, with their ride
+func (r *RideRepository) GetPendingRefunds() ([]migration.Transaction, error) {
+	var transactions []migration.Transaction
+	err := r.db.Preload("Ride").
+		Where("status = ? AND payment_method = ? AND refunded_at IS NULL", statemachine.StatusRefunded, "momo").
+		Find(&transactions).Error
+	if err != nil {
+		return nil, err
+	}
+	return transactions, nil
+}
+
+// refundTransaction marks a transaction refunded and records what goes back to the hitcher (the amount minus the fee
+// kept), the money of a MoMo payment is sent back afterwards by the payment service (see GetPendingRefunds)
+func refundTransaction(tx *gorm.DB, transaction migration.Transaction, fee int64, changedBy uuid.UUID) error {
+	if err := transitionStatusOfRide(tx, transaction.RideID, statemachine.EntityTransaction, &migration.Transaction{}, transaction.ID, transaction.Status, statemachine.StatusRefunded, changedBy); err != nil {
+		return err
+	}
+
+	// Keep the MoMo payment the money goes back to, the ride request gets a new one when the hitcher pays again
+	return tx.Model(&migration.Transaction{}).Where("id = ?", transaction.ID).Updates(map[string]interface{}{
+		"refund_amount": max(transaction.Amount-fee, 0),
+		"momo_trans_id": tx.Model(&migration.RideRequest{}).Select("momo_trans_id").
+			Where("id = (?)", tx.Model(&migration.Ride{}).Select("ride_request_id").Where("id = ?", transaction.RideID)),
+	}).Error
+}
+
+// heldTransaction fetches the transaction on hold of the given ride request (an ID or a subquery selecting it)
+func heldTransaction(tx *gorm.DB, rideRequestID interface{}) (migration.Transaction, error) {
+	var transaction migration.Transaction
+	err := tx.Joins("JOIN rides ON rides.id = transactions.ride_id").
+		Where("rides.ride_request_id = (?) AND transactions.status = ?", rideRequestID, statemachine.StatusOnHold).
+		First(&transaction).Error
+
+	return transaction, err
+}
+
+// ExpireRide expires a scheduled ride that was never started, together with its ride request,
+// its ride offer (if no other hitcher is still on it) and its transaction
+func (r *RideRepository) ExpireRide(rideID uuid.UUID) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		var ride migration.Ride
+		if err := tx.First(&ride, rideID).Error; err != nil {
+			return err
+		}
+
+		var rideOffer migration.RideOffer
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&rideOffer, ride.RideOfferID).Error; err != nil {
+			return err
+		}
+
+		var rideRequest migration.RideRequest
+		if err := tx.First(&rideRequest, ride.RideRequestID).Error; err != nil {
+			return err
+		}
+
+		if err := transitionRide(tx, ride, statemachine.StatusExpired, migration.RideEvent{
+			EventType: RideEventExpired,
+			Reason:    "ride was not started before the end of its time window",
+		}); err != nil {
+			return err
+		}
+
+		// Give the seat back and only expire the ride offer once no other hitcher is on it
+		if err := releaseSeat(tx, ride, uuid.Nil); err != nil {
+			return err
+		}
+
+		otherActiveRides, err := r.countOtherActiveRides(tx, ride.RideOfferID, ride.ID)
+		if err != nil {
+			return err
+		}
+		if otherActiveRides == 0 {
+			if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusExpired, uuid.Nil); err != nil {
+				return err
+			}
+		} else if rideOffer.Status == statemachine.StatusMatched {
+			if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideOffer, &migration.RideOffer{}, rideOffer.ID, rideOffer.Status, statemachine.StatusCreated, uuid.Nil); err != nil {
+				return err
+			}
+		}
+
+		if err := transitionStatusOfRide(tx, ride.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, statemachine.StatusExpired, uuid.Nil); err != nil {
+			return err
+		}
+
+		// The hitcher did not get the ride so the transaction (if any) is refunded in full
+		var transaction migration.Transaction
+		err = tx.Where("ride_id = ?", ride.ID).First(&transaction).Error
+		if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+			return err
+		}
+		if err == nil {
+			if err := refundTransaction(tx, transaction, 0, uuid.Nil); err != nil {
+				return err
+			}
+		}
+
+		// The other leg of an itinerary cannot be ridden without this one
+		return r.releaseItinerary(tx, ride.ID, rideRequest, statemachine.StatusExpired, false, uuid.Nil)
+	})
+}
+
+// BookItinerary saves the two legs of an itinerary as ride requests of their own, accepts them on their ride offers and
+// creates the cash transactions of both rides, all in one transaction so the hitcher gets both legs or none of them.
+// The ride request of the whole journey is locked first and matched so the same itinerary cannot be booked twice.
+// The rides are returned with the ride request of their leg and their transaction
+func (r *RideRepository) BookItinerary(itinerary Itinerary, userID uuid.UUID) (migration.Ride, migration.Ride, error) {
+	var firstRide, secondRide migration.Ride
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var rideRequest migration.RideRequest
+		if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).
+			Where("id = ? AND user_id = ?", itinerary.RideRequest.ID, userID).
+			First(&rideRequest).Error; err != nil {
+			if errors.Is(err, gorm.ErrRecordNotFound) {
+				return ErrRideRequestNotFound
+			}
+			return err
+		}
+
+		firstLeg, secondLeg := itinerary.FirstLeg, itinerary.SecondLeg
+		firstLeg.ParentRideRequestID = &rideRequest.ID
+		secondLeg.ParentRideRequestID = &rideRequest.ID
+		if err := tx.Create(&firstLeg).Error; err != nil {
+			return err
+		}
+		if err := tx.Create(&secondLeg).Error; err != nil {
+			return err
+		}
+
+		var err error
+		firstRide, err = r.acceptRideRequest(tx, itinerary.FirstRideOffer.ID, firstLeg.ID, itinerary.FirstRideOffer.VehicleID, userID)
+		if err != nil {
+			return err
+		}
+		secondRide, err = r.acceptRideRequest(tx, itinerary.SecondRideOffer.ID, secondLeg.ID, itinerary.SecondRideOffer.VehicleID, userID)
+		if err != nil {
+			return err
+		}
+
+		// The match of the whole journey is recorded on the timeline of the first leg
+		if err := transitionStatusOfRide(tx, firstRide.ID, statemachine.EntityRideRequest, &migration.RideRequest{}, rideRequest.ID, rideRequest.Status, statemachine.StatusMatched, userID); err != nil {
+			return err
+		}
+
+		firstTransaction, err := createRideTransaction(tx, firstRide.ID, firstRide.Fare, "cash", userID, itinerary.FirstRideOffer.UserID)
+		if err != nil {
+			return err
+		}
+		secondTransaction, err := createRideTransaction(tx, secondRide.ID, secondRide.Fare, "cash", userID, itinerary.SecondRideOffer.UserID)
+		if err != nil {
+			return err
+		}
+
+		// Return the rides with their leg and transaction so nothing has to be fetched again once they are booked
+		firstRide.RideRequest, firstRide.Transactions = firstLeg, []migration.Transaction{firstTransaction}
+		secondRide.RideRequest, secondRide.Transactions = secondLeg, []migration.Transaction{secondTransaction}
+		return nil
+	})
+	if err != nil {
+		return migration.Ride{}, migration.Ride{}, err
+	}
+
+	return firstRide, secondRide, nil
+}
+
+// GetItineraryLegRides fetches the scheduled rides of the other legs of the itinerary the ride request is a leg of,
+// with the drivers of their ride offers
+func (r *RideRepository) GetItineraryLegRides(rideRequestID uuid.UUID) ([]migration.Ride, error) {
+	var rides []migration.Ride
+	err := r.db.Preload("RideOffer.User").
+		Joins("JOIN ride_requests ON ride_requests.id = rides.ride_request_id").
+		Where("ride_requests.parent_ride_request_id = (?) AND ride_requests.id <> ? AND rides.status = ?",
+			r.db.Model(&migration.RideRequest{}).Select("parent_ride_request_id").Where("id = ?", rideRequestID), rideRequestID, statemachine.StatusScheduled).
+		Find(&rides).Error
+
+	return rides, err
+}
+
+// releaseItinerary follows up on a leg of an itinerary that will not be ridden (cancelled or expired): the scheduled rides
+// of the other legs are cancelled free of charge and keep their ride offers open, and the ride request of the whole
+// journey goes back to the matching pool when the hitcher is to be matched again or ends with the leg otherwise.
+// The change of the ride request of the journey is recorded on the timeline of the ride of the leg
+func (r *RideRepository) releaseItinerary(tx *gorm.DB, rideID uuid.UUID, leg migration.RideRequest, status string, rematch bool, userID uuid.UUID) error {
+	if leg.ParentRideRequestID == nil {
+		return nil
+	}
+
+	var parent migration.RideRequest
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&parent, *leg.ParentRideRequestID).Error; err != nil {
+		return err
+	}
+
+	var rides []migration.Ride
+	if err := tx.Joins("JOIN ride_requests ON ride_requests.id = rides.ride_request_id").
+		Where("ride_requests.parent_ride_request_id = ? AND ride_requests.id <> ? AND rides.status = ?", parent.ID, leg.ID, statemachine.StatusScheduled).
+		Find(&rides).Error; err != nil {
+		return err
+	}
+	for _, ride := range rides {
+		if _, _, err := r.cancelRide(tx, schemas.CancelRideRequest{
+			RideID: ride.ID,
+			Reason: "The other leg of the itinerary was " + status,
+		}, userID, cancellation.Decision{Outcome: cancellation.OutcomeFree, HitcherID: parent.UserID, Reopen: true}); err != nil {
+			return err
+		}
+	}
+
+	// The hitcher may already be past the transfer when the second leg is lost, the journey can only be cancelled then
+	switch {
+	case parent.Status == statemachine.StatusMatched && rematch:
+		status = statemachine.StatusCreated
+	case parent.Status == statemachine.StatusOngoing:
+		status = statemachine.StatusCancelled
+	case parent.Status != statemachine.StatusMatched:
+		return nil
+	}
+
+	return transitionStatusOfRide(tx, rideID, statemachine.EntityRideRequest, &migration.RideRequest{}, parent.ID, parent.Status, status, userID)
+}
+
+// progressItinerary moves the ride request of the whole journey along with its legs: it is ongoing once the first
+// leg starts and completed once every leg is completed. The change is recorded on the timeline of the ride of the leg
+func progressItinerary(tx *gorm.DB, rideID uuid.UUID, leg migration.RideRequest, userID uuid.UUID) error {
+	if leg.ParentRideRequestID == nil {
+		return nil
+	}
+
+	var parent migration.RideRequest
+	if err := tx.Clauses(clause.Locking{Strength: "UPDATE"}).First(&parent, *leg.ParentRideRequestID).Error; err != nil {
+		return err
+	}
+
+	if parent.Status == statemachine.StatusMatched {
+		return transitionStatusOfRide(tx, rideID, statemachine.EntityRideRequest, &migration.RideRequest{}, parent.ID, parent.Status, statemachine.StatusOngoing, userID)
+	}
+	if parent.Status != statemachine.StatusOngoing {
+		return nil
+	}
+
+	var unfinishedLegs int64
+	if err := tx.Model(&migration.RideRequest{}).
+		Where("parent_ride_request_id = ? AND status <> ?", parent.ID, statemachine.StatusCompleted).
+		Count(&unfinishedLegs).Error; err != nil {
+		return err
+	}
+	if unfinishedLegs > 0 {
+		return nil
+	}
+
+	return transitionStatusOfRide(tx, rideID, statemachine.EntityRideRequest, &migration.RideRequest{}, parent.ID, parent.Status, statemachine.StatusCompleted, userID)
+}
+
 // Make sure the RideRepository implements the IRideRepository interface
 var _ IRideRepository = (*RideRepository)(nil)
@@ -0,0 +1,300 @@
+package repository
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"time"
+
+	"shareway/infra/db/migration"
+	"shareway/schemas"
+	"shareway/util/statemachine"
+
+	"github.com/google/uuid"
+	"gorm.io/gorm"
+)
+
+// Priorities of the safety alerts
+const (
+	SafetyAlertPriorityNormal = "normal"
+	SafetyAlertPriorityHigh   = "high"
+)
+
+type SafetyRepository struct {
+	db *gorm.DB
+}
+
+func NewSafetyRepository(db *gorm.DB) ISafetyRepository {
+	return &SafetyRepository{db: db}
+}
+
+type ISafetyRepository interface {
+	CreateSafetyAlert(alert migration.SafetyAlert) (migration.SafetyAlert, error)
+	GetSafetyAlertByID(alertID uuid.UUID) (migration.SafetyAlert, error)
+	RespondSafetyAlert(alertID, userID uuid.UUID, safe bool) (migration.SafetyAlert, error)
+	GetUnansweredSafetyAlerts(before time.Time) ([]migration.SafetyAlert, error)
+	EscalateSafetyAlert(alertID uuid.UUID) (migration.SafetyAlert, error)
+	ResolveSafetyAlert(alertID, adminID uuid.UUID, note string) (migration.SafetyAlert, error)
+	GetSafetyAlertList(req schemas.SafetyAlertListRequest) ([]migration.SafetyAlert, int64, int64, error)
+	GetRideWithParticipants(rideID uuid.UUID) (migration.Ride, error)
+	CreateSOSAlert(alert migration.SafetyAlert) (migration.SafetyAlert, error)
+	CreateTrustedContact(contact migration.TrustedContact) (migration.TrustedContact, error)
+	GetTrustedContacts(userID uuid.UUID) ([]migration.TrustedContact, error)
+	CountTrustedContacts(userID uuid.UUID) (int64, error)
+	DeleteTrustedContact(contactID, userID uuid.UUID) error
+}
+
+var (
+	ErrSafetyAlertNotFound    = errors.New("safety alert not found")
+	ErrRideNotFound           = errors.New("ride not found")
+	ErrTrustedContactNotFound = errors.New("trusted contact not found")
+)
+
+// CreateSafetyAlert creates a pending safety alert and returns it with the hitcher loaded
+func (r *SafetyRepository) CreateSafetyAlert(alert migration.SafetyAlert) (migration.SafetyAlert, error) {
+	alert.Priority = SafetyAlertPriorityNormal
+	alert.Status = statemachine.StatusPending
+	if err := r.db.Create(&alert).Error; err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return r.GetSafetyAlertByID(alert.ID)
+}
+
+// GetSafetyAlertByID fetches a safety alert with its hitcher
+func (r *SafetyRepository) GetSafetyAlertByID(alertID uuid.UUID) (migration.SafetyAlert, error) {
+	var alert migration.SafetyAlert
+	err := r.db.Preload("User").
+		Where("id = ?", alertID).
+		First(&alert).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return migration.SafetyAlert{}, ErrSafetyAlertNotFound
+	}
+	if err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return alert, nil
+}
+
+// RespondSafetyAlert records the answer of the hitcher, the alert is escalated to the admins when they are not safe
+func (r *SafetyRepository) RespondSafetyAlert(alertID, userID uuid.UUID, safe bool) (migration.SafetyAlert, error) {
+	var alert migration.SafetyAlert
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		err := tx.Where("id = ? AND user_id = ?", alertID, userID).
+			First(&alert).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSafetyAlertNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		updates := map[string]interface{}{"responded_at": now}
+		to := statemachine.StatusSafe
+		if !safe {
+			to = statemachine.StatusEscalated
+			updates["escalated_at"] = now
+		}
+
+		if err := transitionStatus(tx, statemachine.EntitySafetyAlert, &migration.SafetyAlert{}, alert.ID, alert.Status, to, userID); err != nil {
+			return err
+		}
+
+		return tx.Model(&migration.SafetyAlert{}).Where("id = ?", alert.ID).Updates(updates).Error
+	})
+
+	if err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return r.GetSafetyAlertByID(alertID)
+}
+
+// GetUnansweredSafetyAlerts fetches the pending safety alerts raised before the given time
+func (r *SafetyRepository) GetUnansweredSafetyAlerts(before time.Time) ([]migration.SafetyAlert, error) {
+	var alerts []migration.SafetyAlert
+	err := r.db.Preload("User").
+		Where("status = ? AND created_at < ?", statemachine.StatusPending, before).
+		Find(&alerts).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return alerts, nil
+}
+
+// EscalateSafetyAlert escalates a pending safety alert to the admins (used when the hitcher does not answer in time)
+func (r *SafetyRepository) EscalateSafetyAlert(alertID uuid.UUID) (migration.SafetyAlert, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := transitionStatus(tx, statemachine.EntitySafetyAlert, &migration.SafetyAlert{}, alertID, statemachine.StatusPending, statemachine.StatusEscalated, uuid.Nil); err != nil {
+			return err
+		}
+
+		return tx.Model(&migration.SafetyAlert{}).Where("id = ?", alertID).Update("escalated_at", time.Now()).Error
+	})
+
+	if err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return r.GetSafetyAlertByID(alertID)
+}
+
+// ResolveSafetyAlert closes a safety alert once an admin has handled it
+func (r *SafetyRepository) ResolveSafetyAlert(alertID, adminID uuid.UUID, note string) (migration.SafetyAlert, error) {
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		var alert migration.SafetyAlert
+		err := tx.Where("id = ?", alertID).First(&alert).Error
+		if errors.Is(err, gorm.ErrRecordNotFound) {
+			return ErrSafetyAlertNotFound
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := transitionStatus(tx, statemachine.EntitySafetyAlert, &migration.SafetyAlert{}, alert.ID, alert.Status, statemachine.StatusResolved, adminID); err != nil {
+			return err
+		}
+
+		return tx.Model(&migration.SafetyAlert{}).Where("id = ?", alert.ID).Updates(map[string]interface{}{
+			"resolved_by": adminID,
+			"note":        note,
+		}).Error
+	})
+
+	if err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return r.GetSafetyAlertByID(alertID)
+}
+
+// GetSafetyAlertList gets the list of safety alerts for the admin panel, the high priority ones first then the newest
+func (r *SafetyRepository) GetSafetyAlertList(req schemas.SafetyAlertListRequest) ([]migration.SafetyAlert, int64, int64, error) {
+	var alerts []migration.SafetyAlert
+	var totalAlerts int64
+
+	query := r.db.Model(&migration.SafetyAlert{}).
+		Preload("User").
+		Preload("Ride.RideOffer.User")
+
+	if len(req.Status) > 0 {
+		status := strings.Split(req.Status[0], ",")
+		query = query.Where("safety_alerts.status IN (?)", status)
+	}
+
+	if err := query.Count(&totalAlerts).Error; err != nil {
+		return alerts, 0, 0, err
+	}
+
+	// Apply pagination, the high priority alerts (SOS) go first
+	offset := (req.Page - 1) * req.Limit
+	if err := query.Offset(offset).Limit(req.Limit).
+		Order("CASE WHEN safety_alerts.priority = 'high' THEN 0 ELSE 1 END").
+		Order("safety_alerts.created_at DESC").
+		Find(&alerts).Error; err != nil {
+		return alerts, 0, 0, err
+	}
+
+	totalPages := int64(math.Ceil(float64(totalAlerts) / float64(req.Limit)))
+	return alerts, totalAlerts, totalPages, nil
+}
+
+// GetRideWithParticipants fetches a ride with the driver, the hitcher and the vehicle
+func (r *SafetyRepository) GetRideWithParticipants(rideID uuid.UUID) (migration.Ride, error) {
+	var ride migration.Ride
+	err := r.db.Preload("RideOffer.User").
+		Preload("RideRequest.User").
+		Preload("Vehicle").
+		Where("id = ?", rideID).
+		First(&ride).Error
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return migration.Ride{}, ErrRideNotFound
+	}
+	if err != nil {
+		return migration.Ride{}, err
+	}
+
+	return ride, nil
+}
+
+// CreateSOSAlert records the SOS in the ride timeline and raises a high priority alert that is escalated to the admins right away
+func (r *SafetyRepository) CreateSOSAlert(alert migration.SafetyAlert) (migration.SafetyAlert, error) {
+	now := time.Now()
+	alert.Priority = SafetyAlertPriorityHigh
+	alert.Status = statemachine.StatusEscalated
+	alert.EscalatedAt = &now
+
+	err := r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Create(&alert).Error; err != nil {
+			return err
+		}
+
+		return recordRideEvent(tx, migration.RideEvent{
+			RideID:    &alert.RideID,
+			ActorID:   alert.UserID,
+			EventType: RideEventSOS,
+			Latitude:  alert.Latitude,
+			Longitude: alert.Longitude,
+		})
+	})
+
+	if err != nil {
+		return migration.SafetyAlert{}, err
+	}
+
+	return r.GetSafetyAlertByID(alert.ID)
+}
+
+// CreateTrustedContact adds a trusted contact to the user
+func (r *SafetyRepository) CreateTrustedContact(contact migration.TrustedContact) (migration.TrustedContact, error) {
+	if err := r.db.Create(&contact).Error; err != nil {
+		return migration.TrustedContact{}, err
+	}
+
+	return contact, nil
+}
+
+// GetTrustedContacts fetches the trusted contacts of the user in the order they were added
+func (r *SafetyRepository) GetTrustedContacts(userID uuid.UUID) ([]migration.TrustedContact, error) {
+	var contacts []migration.TrustedContact
+	err := r.db.Where("user_id = ?", userID).
+		Order("created_at ASC").
+		Find(&contacts).Error
+
+	if err != nil {
+		return nil, err
+	}
+
+	return contacts, nil
+}
+
+// CountTrustedContacts counts the trusted contacts of the user
+func (r *SafetyRepository) CountTrustedContacts(userID uuid.UUID) (int64, error) {
+	var count int64
+	err := r.db.Model(&migration.TrustedContact{}).
+		Where("user_id = ?", userID).
+		Count(&count).Error
+
+	return count, err
+}
+
+// DeleteTrustedContact removes a trusted contact of the user
+func (r *SafetyRepository) DeleteTrustedContact(contactID, userID uuid.UUID) error {
+	result := r.db.Where("id = ? AND user_id = ?", contactID, userID).
+		Delete(&migration.TrustedContact{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrTrustedContactNotFound
+	}
+
+	return nil
+}
+
+// Make sure the SafetyRepository implements the ISafetyRepository interface
+var _ ISafetyRepository = (*SafetyRepository)(nil)
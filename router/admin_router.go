@@ -15,6 +15,7 @@ func SetupAdminRouter(group *gin.RouterGroup, server *APIServer) {
 		server.Service.MapService,
 		server.Service.VehicleService,
 		server.Service.UserService,
+		server.Service.SafetyService,
 	)
 	group.GET("/get-profile", adminController.GetAdminProfile)
 	group.GET("/get-dashboard-general-data", adminController.GetDashboardGeneralData)
@@ -24,8 +25,12 @@ func SetupAdminRouter(group *gin.RouterGroup, server *APIServer) {
 	group.GET("/get-vehicle-dashboard-data", adminController.GetVehicleDashboardData)
 	group.GET("/get-user-list", adminController.GetUserList)
 	group.GET("/get-ride-list", adminController.GetRideList)
+	group.GET("/get-ride-timeline", adminController.GetRideTimeline)
+	group.GET("/get-ride-replay", adminController.GetRideReplay)
 	group.GET("/get-vehicle-list", adminController.GetVehicleList)
 	group.GET("/get-transaction-list", adminController.GetTransactionList)
 	group.GET("/get-report-details", adminController.GetReportDetails)
+	group.GET("/get-safety-alert-list", adminController.GetSafetyAlertList)
+	group.POST("/resolve-safety-alert", adminController.ResolveSafetyAlert)
 	group.POST("/logout", adminController.AdminLogout)
 }
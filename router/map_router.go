@@ -19,6 +19,9 @@ func SetupMapRouter(group *gin.RouterGroup, server *APIServer) {
 	// CreateGiveRide request
 	group.POST("/give-ride", mapController.CreateGiveRide)
 
+	// QuoteRide request (price a route without creating a ride offer)
+	group.POST("/quote", mapController.QuoteRide)
+
 	// CreateHitchRide request
 	group.POST("/hitch-ride", mapController.CreateHitchRide)
 
@@ -31,4 +34,18 @@ func SetupMapRouter(group *gin.RouterGroup, server *APIServer) {
 	// SuggestRideOffers request
 	group.POST("/suggest-give-rides", mapController.SuggestGiveRides)
 
+	// Itineraries on two ride offers request (the hitcher changes drivers at a transfer)
+	group.POST("/suggest-itineraries", mapController.SuggestItineraries)
+
+	// Match alerts requests (notify the user when a new ride offer or ride request matches)
+	group.POST("/subscribe-give-rides", mapController.SubscribeGiveRides)
+	group.POST("/subscribe-hitch-rides", mapController.SubscribeHitchRides)
+
+	// Recurring give ride requests
+	group.POST("/recurring-give-ride", mapController.CreateRecurringGiveRide)
+	group.GET("/get-recurring-give-rides", mapController.GetRecurringGiveRides)
+	group.POST("/skip-recurring-give-ride", mapController.SkipRecurringGiveRide)
+	group.POST("/pause-recurring-give-ride", mapController.PauseRecurringGiveRide)
+	group.POST("/resume-recurring-give-ride", mapController.ResumeRecurringGiveRide)
+
 }
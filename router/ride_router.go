@@ -15,21 +15,30 @@ func SetupRideRouter(group *gin.RouterGroup, server *APIServer) {
 		server.Service.UserService,
 		server.Service.VehicleService,
 		server.Service.PaymentService,
+		server.Service.GeofenceService,
+		server.Service.SafetyService,
 		server.AsyncClient,
 	)
 	group.POST("/give-ride-request", rideController.SendGiveRideRequest)
 	group.POST("/hitch-ride-request", rideController.SendHitchRideRequest)
 	group.POST("/accept-give-ride-request", rideController.AcceptGiveRideRequest)
 	group.POST("/accept-hitch-ride-request", rideController.AcceptHitchRideRequest)
+	group.POST("/book-itinerary", rideController.BookItinerary)
 	group.POST("/cancel-give-ride-request", rideController.CancelGiveRideRequest)
 	group.POST("/cancel-hitch-ride-request", rideController.CancelHitchRideRequest)
 	group.POST("/start-ride", rideController.StartRide)
 	group.POST("/end-ride", rideController.EndRide)
 	group.POST("/update-ride-location", rideController.UpdateRideLocation)
 	group.POST("/cancel-ride", rideController.CancelRide)
+	group.POST("/report-no-show", rideController.ReportNoShow)
 	group.GET("/get-all-pending-ride", rideController.GetAllPendingRide)
 	group.POST("/rating-ride-hitcher", rideController.RatingRideHitcher)
 	group.POST("/rating-ride-driver", rideController.RatingRideDriver)
 	group.GET("/get-ride-history", rideController.GetRideHistory)
 	group.GET("/get-scheduled-and-ongoing-ride", rideController.GetScheduledAndOngoingRide)
+	group.GET("/get-ride-timeline", rideController.GetRideTimeline)
+	group.GET("/get-ride-replay", rideController.GetRideReplay)
+	group.POST("/respond-safety-check", rideController.RespondSafetyCheck)
+	group.POST("/sos", rideController.SOS)
+	group.POST("/share-ride", rideController.ShareRide)
 }
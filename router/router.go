@@ -108,6 +108,8 @@ func (server *APIServer) SetupRouter() {
 	SetupPaymentRouter(server.router.Group("/payment", middleware.AuthMiddleware(server.Maker)), server)
 	// IPN routes for payment gateway IPN handling
 	SetupIPNRouter(server.router.Group("/ipn"), server)
+	// Share routes for following a shared ride with a read-only token
+	SetupShareRouter(server.router.Group("/share"), server)
 	// Admin routes for admin management
 	SetupAuthAdminRouter(server.router.Group("/admin/auth"), server)
 	// Admin routes for admin management
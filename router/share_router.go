@@ -0,0 +1,18 @@
+package router
+
+import (
+	controller "shareway/controller"
+
+	"github.com/gin-gonic/gin"
+)
+
+// SetupShareRouter sets up the public routes opened by the live trip links
+func SetupShareRouter(group *gin.RouterGroup, server *APIServer) {
+	shareController := controller.NewShareController(
+		server.Cfg,
+		server.Validate,
+		server.Service.SafetyService,
+	)
+	// Follow the position of the driver of a shared ride (read-only token, no authentication)
+	group.GET("/track-ride", shareController.TrackSharedRide)
+}
@@ -10,6 +10,7 @@ import (
 func SetupUserRouter(group *gin.RouterGroup, server *APIServer) {
 	userController := controller.NewUserController(
 		server.Service.UserService,
+		server.Service.SafetyService,
 		server.Validate,
 	)
 	// GetUserProfile Request
@@ -20,4 +21,11 @@ func SetupUserRouter(group *gin.RouterGroup, server *APIServer) {
 	group.POST("/update-profile", userController.UpdateUserProfile)
 	// UpdateAvatar Request
 	group.POST("/update-avatar", userController.UpdateAvatar)
+	// Default match preferences of the rides created by the user
+	group.GET("/get-match-preferences", userController.GetMatchPreferences)
+	group.POST("/update-match-preferences", userController.UpdateMatchPreferences)
+	// Trusted contacts told by SMS when the user presses the SOS button
+	group.POST("/add-trusted-contact", userController.AddTrustedContact)
+	group.GET("/get-trusted-contacts", userController.GetTrustedContacts)
+	group.POST("/delete-trusted-contact", userController.DeleteTrustedContact)
 }
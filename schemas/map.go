@@ -69,12 +69,43 @@ type Point struct {
 	Lng float64 `json:"lng"` // Longitude
 }
 
+// Define BoundingBox struct
+type BoundingBox struct {
+	MinLat float64 `json:"min_lat"` // South edge
+	MinLng float64 `json:"min_lng"` // West edge
+	MaxLat float64 `json:"max_lat"` // North edge
+	MaxLng float64 `json:"max_lng"` // East edge
+}
+
 // Define GiveRideRequest struct
 type GiveRideRequest struct {
 	// Points []Point `json:"points" binding:"required"` // List of points for the route
 	PlaceList []string  `json:"place_list" binding:"required"`                               // List of places for the route (place_id) from goong api
 	StartTime string    `json:"start_time,omitempty"`                                        // Start time of the ride (if not provided, the ride is immediate)
 	VehicleID uuid.UUID `json:"vehicle_id" binding:"required,uuid" validate:"required,uuid"` // Vehicle ID for the ride that user has registered
+	Seats     int       `json:"seats,omitempty" validate:"omitempty,min=1"`                  // Number of seats to offer (if not provided, use the vehicle type capacity)
+	// Requirements on the hitchers of the ride (if not provided, use the match preferences of the user)
+	Preferences *MatchPreferences `json:"preferences,omitempty" validate:"omitempty"`
+	// What the driver allows on the ride (the spare helmet is only kept for a motorbike)
+	Amenities RideAmenities `json:"amenities"`
+	// Recurring ride offer the ride offer is materialized from (set by the scheduler, never by the client)
+	RecurringRideOfferID *uuid.UUID `json:"-"`
+}
+
+// Define RideAmenities struct (what the driver allows on a ride offer, what the hitcher brings or needs on a ride request)
+type RideAmenities struct {
+	Luggage     bool `json:"luggage"`      // Room for luggage / the hitcher brings luggage
+	Pets        bool `json:"pets"`         // Pets allowed / the hitcher brings a pet
+	Smoking     bool `json:"smoking"`      // Smoking allowed / the hitcher smokes
+	SpareHelmet bool `json:"spare_helmet"` // The driver has a spare helmet (motorbike only) / the hitcher needs a helmet
+}
+
+// Define MeetingPoint struct (where the hitcher meets the driver on the route of the ride offer)
+type MeetingPoint struct {
+	Latitude        float64 `json:"latitude"`
+	Longitude       float64 `json:"longitude"`
+	Address         string  `json:"address"`          // Reverse geocoded address, empty when it could not be found
+	WalkingDistance float64 `json:"walking_distance"` // Straight-line distance the hitcher walks from their start (in kilometers)
 }
 
 // Define
@@ -141,15 +172,34 @@ type GoongDirectionsResponse struct {
 
 // Define GiveRideResponse struct
 type GiveRideResponse struct {
-	Route       GoongDirectionsResponse `json:"route"`
-	RideOfferID uuid.UUID               `json:"ride_offer_id"`
-	Distance    float64                 `json:"distance"`
-	Duration    int                     `json:"duration"`
-	StartTime   time.Time               `json:"start_time"`
-	EndTime     time.Time               `json:"end_time"`
-	Fare        int64                   `json:"fare"`
-	Vehicle     VehicleDetail           `json:"vehicle"`
-	Waypoints   []Waypoint              `json:"waypoints"`
+	Route          GoongDirectionsResponse `json:"route"`
+	RideOfferID    uuid.UUID               `json:"ride_offer_id"`
+	Distance       float64                 `json:"distance"`
+	Duration       int                     `json:"duration"`
+	StartTime      time.Time               `json:"start_time"`
+	EndTime        time.Time               `json:"end_time"`
+	Fare           int64                   `json:"fare"`
+	Vehicle        VehicleDetail           `json:"vehicle"`
+	Waypoints      []Waypoint              `json:"waypoints"`
+	Seats          int                     `json:"seats"`
+	AvailableSeats int                     `json:"available_seats"`
+}
+
+// Define QuoteRideRequest struct
+type QuoteRideRequest struct {
+	Origin      string    `json:"origin" binding:"required" validate:"required"`               // Origin of the route (place_id) from goong api
+	Destination string    `json:"destination" binding:"required" validate:"required"`          // Destination of the route (place_id) from goong api
+	Waypoints   []string  `json:"waypoints,omitempty" validate:"omitempty,dive,required"`      // Places to stop at between the origin and the destination (place_id)
+	VehicleID   uuid.UUID `json:"vehicle_id" binding:"required,uuid" validate:"required,uuid"` // Vehicle ID used to price the ride
+}
+
+// Define QuoteRideResponse struct
+type QuoteRideResponse struct {
+	Distance        float64   `json:"distance"` // kilometers
+	Duration        int       `json:"duration"` // seconds
+	EncodedPolyline string    `json:"encoded_polyline"`
+	Fare            int64     `json:"fare"`
+	VehicleID       uuid.UUID `json:"vehicle_id"`
 }
 
 // Define HitchRideRequest struct
@@ -158,7 +208,10 @@ type HitchRideRequest struct {
 	PlaceList []string `json:"place_list" binding:"required"` // List of places for the route (place_id) from goong api
 	StartTime string   `json:"start_time,omitempty"`          // Start time of the ride (if not provided, the ride is immediate)
 	Weight    int64    `json:"weight" binding:"required"`     // Weight of the rider to consider
-
+	// Requirements on the driver of the ride (if not provided, use the match preferences of the user)
+	Preferences *MatchPreferences `json:"preferences,omitempty" validate:"omitempty"`
+	// What the hitcher brings or needs on the ride
+	Amenities RideAmenities `json:"amenities"`
 }
 
 // Define HitchRideResponse struct
@@ -279,24 +332,26 @@ type UserInfo struct {
 
 // Define RideRequestDetail struct
 type RideRequestDetail struct {
-	ID                    uuid.UUID `json:"ride_request_id"`
-	User                  UserInfo  `json:"user"`
-	StartLatitude         float64   `json:"start_latitude"`
-	StartLongitude        float64   `json:"start_longitude"`
-	EndLatitude           float64   `json:"end_latitude"`
-	EndLongitude          float64   `json:"end_longitude"`
-	RiderCurrentLatitude  float64   `json:"rider_current_latitude"`
-	RiderCurrentLongitude float64   `json:"rider_current_longitude"`
-	StartAddress          string    `json:"start_address"`
-	EndAddress            string    `json:"end_address"`
-	Status                string    `json:"status"`
-	EncodedPolyline       string    `json:"encoded_polyline"`
-	Distance              float64   `json:"distance"`
-	Duration              int       `json:"duration"`
-	StartTime             time.Time `json:"start_time"`
-	EndTime               time.Time `json:"end_time"`
-	Weight                int64     `json:"weight"`
-
+	ID                    uuid.UUID     `json:"ride_request_id"`
+	User                  UserInfo      `json:"user"`
+	StartLatitude         float64       `json:"start_latitude"`
+	StartLongitude        float64       `json:"start_longitude"`
+	EndLatitude           float64       `json:"end_latitude"`
+	EndLongitude          float64       `json:"end_longitude"`
+	RiderCurrentLatitude  float64       `json:"rider_current_latitude"`
+	RiderCurrentLongitude float64       `json:"rider_current_longitude"`
+	StartAddress          string        `json:"start_address"`
+	EndAddress            string        `json:"end_address"`
+	Status                string        `json:"status"`
+	EncodedPolyline       string        `json:"encoded_polyline"`
+	Distance              float64       `json:"distance"`
+	Duration              int           `json:"duration"`
+	StartTime             time.Time     `json:"start_time"`
+	EndTime               time.Time     `json:"end_time"`
+	Weight                int64         `json:"weight"`
+	SegmentFare           int64         `json:"segment_fare,omitempty"`  // Fare of the part of the route the hitcher rides on, only set in the suggestions
+	Match                 *MatchScore   `json:"match,omitempty"`         // Only set in the suggestions
+	MeetingPoint          *MeetingPoint `json:"meeting_point,omitempty"` // Only set in the suggestions
 }
 
 // Define SuggestRideOfferRequest struct
@@ -309,6 +364,36 @@ type SuggestRideOfferResponse struct {
 	RideOffers []RideOfferDetail `json:"ride_offers"`
 }
 
+// Define SuggestItinerariesRequest struct
+type SuggestItinerariesRequest struct {
+	RideRequestID uuid.UUID `json:"ride_request_id" binding:"required,uuid" validate:"required,uuid"` // Ride request ID for which the user wants an itinerary on two ride offers
+}
+
+// Define SuggestItinerariesResponse struct
+type SuggestItinerariesResponse struct {
+	Itineraries []ItineraryDetail `json:"itineraries"`
+}
+
+// Define Transfer struct (where the hitcher changes from the first ride offer to the second one of an itinerary)
+type Transfer struct {
+	DropoffLatitude  float64      `json:"dropoff_latitude"` // Where the first driver drops the hitcher off
+	DropoffLongitude float64      `json:"dropoff_longitude"`
+	Pickup           MeetingPoint `json:"pickup"`       // Where the second driver picks the hitcher up, the walking distance is from the drop-off
+	DropoffTime      time.Time    `json:"dropoff_time"` // Estimated time the first driver reaches the drop-off
+	PickupTime       time.Time    `json:"pickup_time"`  // Estimated time the second driver reaches the pickup
+	WaitTime         int          `json:"wait_time"`    // Time the hitcher waits between the drop-off and the pickup (in seconds)
+	ArrivalTime      time.Time    `json:"arrival_time"` // Estimated time the second driver reaches the end of the hitcher
+}
+
+// Define ItineraryDetail struct (a journey of the hitcher on two ride offers, the segment fare of each ride offer is the fare of its leg)
+type ItineraryDetail struct {
+	FirstRideOffer  RideOfferDetail `json:"first_ride_offer"`
+	SecondRideOffer RideOfferDetail `json:"second_ride_offer"`
+	Transfer        Transfer        `json:"transfer"`
+	TotalFare       int64           `json:"total_fare"` // Sum of the fares of both legs
+	WaitTime        int             `json:"wait_time"`  // Time the hitcher waits at the transfer (in seconds)
+}
+
 // Define Waypoint struct
 type Waypoint struct {
 	ID        uuid.UUID `json:"waypoint_id"`
@@ -316,6 +401,7 @@ type Waypoint struct {
 	Longitude float64   `json:"longitude"`
 	Address   string    `json:"address"`
 	Order     int       `json:"order"`
+	Type      string    `json:"type"` // stop, pickup or dropoff
 }
 
 // Define RideOfferDetail struct
@@ -338,5 +424,107 @@ type RideOfferDetail struct {
 	EndTime                time.Time     `json:"end_time"`
 	Status                 string        `json:"status"`
 	Fare                   int64         `json:"fare"`
+	SegmentFare            int64         `json:"segment_fare,omitempty"` // Fare of the part of the route the hitcher rides on, only set in the suggestions
 	Waypoints              []Waypoint    `json:"waypoints"`
+	Seats                  int           `json:"seats"`
+	AvailableSeats         int           `json:"available_seats"`
+	Match                  *MatchScore   `json:"match,omitempty"`         // Only set in the suggestions
+	MeetingPoint           *MeetingPoint `json:"meeting_point,omitempty"` // Only set in the suggestions
+}
+
+// Define CreateRecurringGiveRideRequest struct
+type CreateRecurringGiveRideRequest struct {
+	PlaceList     []string  `json:"place_list" binding:"required" validate:"required,min=2"`                                 // List of places for the route (place_id) from goong api
+	VehicleID     uuid.UUID `json:"vehicle_id" binding:"required,uuid" validate:"required,uuid"`                             // Vehicle ID for the ride that user has registered
+	DaysOfWeek    []int     `json:"days_of_week" binding:"required" validate:"required,min=1,max=7,unique,dive,min=0,max=6"` // Days of the week the ride repeats on (0 = Sunday, 6 = Saturday)
+	DepartureTime string    `json:"departure_time" binding:"required" validate:"required,datetime=15:04"`                    // Departure time in GMT+7 (HH:MM)
+	Seats         int       `json:"seats,omitempty" validate:"omitempty,min=1"`                                              // Number of seats to offer (if not provided, use the vehicle type capacity)
+	// Requirements on the hitchers of the rides (if not provided, use the match preferences of the user when each ride is created)
+	Preferences *MatchPreferences `json:"preferences,omitempty" validate:"omitempty"`
+	// What the driver allows on the rides (the spare helmet is only kept for a motorbike)
+	Amenities RideAmenities `json:"amenities"`
+}
+
+// Define RecurringGiveRideDetail struct
+type RecurringGiveRideDetail struct {
+	ID            uuid.UUID         `json:"recurring_ride_offer_id"`
+	VehicleID     uuid.UUID         `json:"vehicle_id"`
+	PlaceList     []string          `json:"place_list"`
+	DaysOfWeek    []int             `json:"days_of_week"`
+	DepartureTime string            `json:"departure_time"`
+	Seats         int               `json:"seats"`
+	Preferences   *MatchPreferences `json:"preferences,omitempty"`
+	Amenities     RideAmenities     `json:"amenities"`
+	Status        string            `json:"status"`
+	SkippedDates  []string          `json:"skipped_dates"` // Upcoming skipped occurrences (YYYY-MM-DD)
+	CreatedAt     time.Time         `json:"created_at"`
+}
+
+// Define GetRecurringGiveRidesResponse struct
+type GetRecurringGiveRidesResponse struct {
+	RecurringGiveRides []RecurringGiveRideDetail `json:"recurring_give_rides"`
+}
+
+// Define SkipRecurringGiveRideRequest struct
+type SkipRecurringGiveRideRequest struct {
+	RecurringRideOfferID uuid.UUID `json:"recurring_ride_offer_id" binding:"required,uuid" validate:"required,uuid"`
+	Date                 string    `json:"date" binding:"required" validate:"required,datetime=2006-01-02"` // Date of the occurrence to skip in GMT+7 (YYYY-MM-DD)
+}
+
+// Define RecurringGiveRideIDRequest struct (used to pause and resume a recurring give ride)
+type RecurringGiveRideIDRequest struct {
+	RecurringRideOfferID uuid.UUID `json:"recurring_ride_offer_id" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define MatchScore struct (how well a ride offer and a ride request fit together)
+type MatchScore struct {
+	Score                float64 `json:"score"`                  // From 0 to 100, higher is better
+	DetourDistance       float64 `json:"detour_distance"`        // Extra distance for the driver to pick up and drop off the hitcher (km)
+	DetourDuration       int     `json:"detour_duration"`        // Extra time for the driver to pick up and drop off the hitcher (seconds)
+	PickupTimeDifference int     `json:"pickup_time_difference"` // Difference between the estimated pickup time and the hitcher's start time (seconds)
+}
+
+// Define SubscribeGiveRidesRequest struct (the hitcher is notified of the new ride offers matching the ride request)
+type SubscribeGiveRidesRequest struct {
+	RideRequestID uuid.UUID `json:"ride_request_id" binding:"required,uuid" validate:"required,uuid"`
+	Subscribe     *bool     `json:"subscribe" binding:"required" validate:"required"` // false to stop the alerts
+}
+
+// Define SubscribeHitchRidesRequest struct (the driver is notified of the new ride requests matching the ride offer)
+type SubscribeHitchRidesRequest struct {
+	RideOfferID uuid.UUID `json:"ride_offer_id" binding:"required,uuid" validate:"required,uuid"`
+	Subscribe   *bool     `json:"subscribe" binding:"required" validate:"required"` // false to stop the alerts
+}
+
+// Define MatchAlertResponse struct (sent when a new ride offer or ride request matches a subscribed one)
+type MatchAlertResponse struct {
+	RideOfferID   uuid.UUID  `json:"ride_offer_id"`
+	RideRequestID uuid.UUID  `json:"ride_request_id"`
+	User          UserInfo   `json:"user"`          // Driver of the new ride offer or hitcher of the new ride request
+	StartAddress  string     `json:"start_address"` // Route of the new ride offer or ride request
+	EndAddress    string     `json:"end_address"`
+	StartTime     time.Time  `json:"start_time"`
+	SegmentFare   int64      `json:"segment_fare"` // Fare of the part of the route the hitcher rides on
+	Match         MatchScore `json:"match"`
+}
+
+// Define MatchAlertTask struct (payload of the task matching a new ride offer or ride request against the subscribed ones)
+type MatchAlertTask struct {
+	ID uuid.UUID `json:"id"` // ID of the new ride offer or ride request
+}
+
+// Define MatchProposalResponse struct (sent to the driver and the hitcher paired by the batch matcher)
+// The hitcher accepts it with /ride/accept-give-ride-request and the driver with /ride/accept-hitch-ride-request
+type MatchProposalResponse struct {
+	RideOfferID   uuid.UUID  `json:"ride_offer_id"`
+	RideRequestID uuid.UUID  `json:"ride_request_id"`
+	VehicleID     uuid.UUID  `json:"vehicle_id"`
+	ReceiverID    uuid.UUID  `json:"receiver_id"` // The other side of the proposal, to pass to the accept request
+	Driver        UserInfo   `json:"driver"`
+	Hitcher       UserInfo   `json:"hitcher"`
+	StartAddress  string     `json:"start_address"` // Route of the hitcher
+	EndAddress    string     `json:"end_address"`
+	StartTime     time.Time  `json:"start_time"`
+	SegmentFare   int64      `json:"segment_fare"`
+	Match         MatchScore `json:"match"`
 }
@@ -10,6 +10,12 @@ type Notification struct {
 	Data  map[string]string `json:"data,omitempty"` // Additional data to be sent with the notification (optional)
 }
 
+// SMS represents a text message to be sent to a phone number
+type SMS struct {
+	To   string `json:"to"` // Phone number in E.164 format
+	Body string `json:"body"`
+}
+
 // CreateNotificationRequest represents the request to create a new notification
 type CreateNotificationRequest struct {
 	Title string            `json:"title" binding:"required" validate:"required"`
@@ -21,6 +21,11 @@ type UserResponse struct {
 	Role          string    `json:"role"`
 	Gender        string    `json:"gender"`
 	AverageRating float64   `json:"average_rating"`
+	// Percentage of the rides the user kept, lowered by late cancellations and no-shows
+	ReliabilityScore  float64 `json:"reliability_score"`
+	CompletedRides    int64   `json:"completed_rides"`
+	LateCancellations int64   `json:"late_cancellations"`
+	NoShows           int64   `json:"no_shows"`
 }
 
 type AdminResponse struct {
@@ -40,9 +40,13 @@ type SendGiveRideRequestResponse struct {
 	EndTime                time.Time     `json:"end_time"`
 	Status                 string        `json:"status"`
 	Fare                   int64         `json:"fare"`
+	SegmentFare            int64         `json:"segment_fare"` // Fare of the part of the route the hitcher rides on
 	ReceiverID             uuid.UUID     `json:"receiver_id"`
 	RideRequestID          uuid.UUID     `json:"ride_request_id"`
 	Waypoints              []Waypoint    `json:"waypoints"`
+	Seats                  int           `json:"seats"`
+	AvailableSeats         int           `json:"available_seats"`
+	Amenities              RideAmenities `json:"amenities"` // What the driver allows on the ride
 }
 
 // Define SendHitchRideRequestRequest schema
@@ -76,8 +80,10 @@ type SendHitchRideRequestResponse struct {
 	Duration              int           `json:"duration"`
 	StartTime             time.Time     `json:"start_time"`
 	EndTime               time.Time     `json:"end_time"`
+	SegmentFare           int64         `json:"segment_fare"` // Fare of the part of the route the hitcher rides on
 	ReceiverID            uuid.UUID     `json:"receiver_id"`
 	RideOfferID           uuid.UUID     `json:"ride_offer_id"`
+	Amenities             RideAmenities `json:"amenities"` // What the hitcher brings or needs on the ride
 }
 
 // Define AcceptRideGiveRequestRequest schema
@@ -128,6 +134,7 @@ type AcceptGiveRideRequestResponse struct {
 	UserInfo               UserInfo          `json:"user"`
 	ReceiverID             uuid.UUID         `json:"receiver_id"`
 	Waypoints              []Waypoint        `json:"waypoints"`
+	MeetingPoint           *MeetingPoint     `json:"meeting_point,omitempty"` // Pickup point of the hitcher on the route, not set when the route could not be updated
 }
 
 // Define AcceptHitchRideRequestRequest schema
@@ -171,6 +178,7 @@ type AcceptHitchRideRequestResponse struct {
 	RiderCurrentLatitude   float64           `json:"rider_current_latitude"`
 	RiderCurrentLongitude  float64           `json:"rider_current_longitude"`
 	Waypoints              []Waypoint        `json:"waypoints"`
+	MeetingPoint           *MeetingPoint     `json:"meeting_point,omitempty"` // Pickup point of the hitcher on the route, not set when the route could not be updated
 }
 
 type CancelGiveRideRequestRequest struct {
@@ -215,6 +223,9 @@ type StartRideRequest struct {
 	// Current user location
 	CurrentLocation Point     `json:"currentLocation" binding:"required" validate:"required"`
 	VehicleID       uuid.UUID `json:"vehicleID,omitempty" binding:"omitempty,uuid" validate:"omitempty,uuid"`
+	// Set to start the ride away from the pickup point of the hitcher (the reason is kept in the ride timeline)
+	OverrideGeofence bool   `json:"overrideGeofence,omitempty"`
+	OverrideReason   string `json:"overrideReason,omitempty" validate:"required_if=OverrideGeofence true"`
 }
 
 // Define StartRideResponse schema
@@ -253,6 +264,9 @@ type EndRideRequest struct {
 	// Current user location
 	CurrentLocation Point     `json:"currentLocation" binding:"required" validate:"required"`
 	VehicleID       uuid.UUID `json:"vehicleID,omitempty" binding:"omitempty,uuid" validate:"omitempty,uuid"`
+	// Set to end the ride away from the drop-off point of the hitcher (the reason is kept in the ride timeline)
+	OverrideGeofence bool   `json:"overrideGeofence,omitempty"`
+	OverrideReason   string `json:"overrideReason,omitempty" validate:"required_if=OverrideGeofence true"`
 }
 
 // Define EndRideResponse schema
@@ -270,6 +284,7 @@ type EndRideResponse struct {
 	Fare                   int64             `json:"fare"`
 	EncodedPolyline        string            `json:"encoded_polyline"`
 	Distance               float64           `json:"distance"`
+	ActualDistance         float64           `json:"actual_distance"`
 	Duration               int               `json:"duration"`
 	Transaction            TransactionDetail `json:"transaction"`
 	StartLatitude          float64           `json:"start_latitude"`
@@ -328,14 +343,79 @@ type CancelRideRequest struct {
 	// The receiver id (the hitcher) who received the cancel request
 	ReceiverID uuid.UUID `json:"receiverID" binding:"required,uuid" validate:"required,uuid"`
 	VehicleID  uuid.UUID `json:"vehicleID,omitempty" binding:"omitempty,uuid" validate:"omitempty,uuid"`
+	// The reason why the ride is cancelled (optional, kept in the ride timeline)
+	Reason string `json:"reason,omitempty" binding:"omitempty,max=500" validate:"omitempty,max=500"`
 }
 
 type CancelRideResponse struct {
 	// Send back id of ride offer ride request to update ui
-	RideID        uuid.UUID `json:"ride_id"`
-	ReceiverID    uuid.UUID `json:"receiver_id"`
-	RideOfferID   uuid.UUID `json:"ride_offer_id"`
-	RideRequestID uuid.UUID `json:"ride_request_id"`
+	RideID          uuid.UUID `json:"ride_id"`
+	ReceiverID      uuid.UUID `json:"receiver_id"`
+	RideOfferID     uuid.UUID `json:"ride_offer_id"`
+	RideRequestID   uuid.UUID `json:"ride_request_id"`
+	Outcome         string    `json:"outcome"`          // free, late, no_show
+	CancellationFee int64     `json:"cancellation_fee"` // VND kept from the refund of the hitcher
+}
+
+// Define ReportNoShowRequest schema
+type ReportNoShowRequest struct {
+	// Ride ID of the scheduled ride
+	RideID uuid.UUID `json:"rideID" binding:"required,uuid" validate:"required,uuid"`
+	// Current user location, must be at the pickup point of the hitcher
+	CurrentLocation Point `json:"currentLocation" binding:"required" validate:"required"`
+	// Optional details kept in the ride timeline
+	Reason string `json:"reason,omitempty" binding:"omitempty,max=500" validate:"omitempty,max=500"`
+}
+
+// Define RematchSuggestionsResponse schema (sent to a hitcher whose driver cancelled, the ride request is matched again)
+type RematchSuggestionsResponse struct {
+	RideID        uuid.UUID         `json:"ride_id"` // Cancelled ride
+	RideRequestID uuid.UUID         `json:"ride_request_id"`
+	PaymentOnHold bool              `json:"payment_on_hold"` // The MoMo payment is kept for the next ride instead of being refunded
+	RideOffers    []RideOfferDetail `json:"ride_offers"`     // Best alternatives, not sent through FCM
+}
+
+// Define BookItineraryRequest schema (the hitcher books both legs of a suggested itinerary)
+type BookItineraryRequest struct {
+	// The ID of the ride request of the whole journey (current user is the hitcher)
+	RideRequestID uuid.UUID `json:"rideRequestID" binding:"required,uuid" validate:"required,uuid"`
+	// The ID of the ride offer of the first leg
+	FirstRideOfferID uuid.UUID `json:"firstRideOfferID" binding:"required,uuid" validate:"required,uuid"`
+	// The ID of the ride offer of the second leg
+	SecondRideOfferID uuid.UUID `json:"secondRideOfferID" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define ItineraryRideDetail schema (the ride booked for one leg of an itinerary)
+type ItineraryRideDetail struct {
+	ID            uuid.UUID         `json:"ride_id"`
+	RideOfferID   uuid.UUID         `json:"ride_offer_id"`
+	RideRequestID uuid.UUID         `json:"ride_request_id"` // Ride request of the leg
+	Status        string            `json:"status"`
+	StartTime     time.Time         `json:"start_time"`
+	EndTime       time.Time         `json:"end_time"`
+	StartAddress  string            `json:"start_address"` // Where the hitcher gets on
+	EndAddress    string            `json:"end_address"`   // Where the hitcher gets off
+	Fare          int64             `json:"fare"`
+	Transaction   TransactionDetail `json:"transaction"`
+	Vehicle       VehicleDetail     `json:"vehicle"`
+	UserInfo      UserInfo          `json:"user"`                    // The driver for the hitcher, the hitcher for the driver
+	MeetingPoint  *MeetingPoint     `json:"meeting_point,omitempty"` // Pickup point of the hitcher on the route, not set when the route could not be updated
+}
+
+// Define BookItineraryResponse schema
+type BookItineraryResponse struct {
+	RideRequestID uuid.UUID           `json:"ride_request_id"`
+	FirstRide     ItineraryRideDetail `json:"first_ride"`
+	SecondRide    ItineraryRideDetail `json:"second_ride"`
+	Transfer      Transfer            `json:"transfer"`
+	TotalFare     int64               `json:"total_fare"`
+}
+
+// Define ItineraryBookedResponse schema (sent to each driver of an itinerary once both legs are booked)
+type ItineraryBookedResponse struct {
+	Leg      int                 `json:"leg"` // 1 when the driver takes the hitcher to the transfer, 2 when the driver takes them from it
+	Ride     ItineraryRideDetail `json:"ride"`
+	Transfer Transfer            `json:"transfer"`
 }
 
 // Define GetPendingRide schema
@@ -444,3 +524,98 @@ type ValidRideDetail struct {
 type GetScheduledAndOngoingRideResponse struct {
 	ValidRide []ValidRideDetail `json:"valid_ride"`
 }
+
+// Define GetRideTimelineRequest schema
+type GetRideTimelineRequest struct {
+	// The ID of the ride to get the timeline
+	RideID string `form:"rideID" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define RideEventDetail schema
+type RideEventDetail struct {
+	ID         uuid.UUID `json:"event_id"`
+	RideID     uuid.UUID `json:"ride_id"`
+	EntityType string    `json:"entity_type"` // ride, ride_offer, ride_request or transaction
+	EntityID   uuid.UUID `json:"entity_id"`
+	ActorID    uuid.UUID `json:"actor_id"`
+	ActorName  string    `json:"actor_name"`
+	EventType  string    `json:"event_type"`
+	FromStatus string    `json:"from_status"`
+	ToStatus   string    `json:"to_status"`
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	Reason     string    `json:"reason"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// Define GetRideTimelineResponse schema
+type GetRideTimelineResponse struct {
+	RideID uuid.UUID         `json:"ride_id"`
+	Status string            `json:"status"`
+	Events []RideEventDetail `json:"events"`
+}
+
+// Define GetRideReplayRequest schema
+type GetRideReplayRequest struct {
+	// The ID of the ride to replay
+	RideID string `form:"rideID" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define RideBreadcrumbDetail schema
+type RideBreadcrumbDetail struct {
+	Latitude   float64   `json:"latitude"`
+	Longitude  float64   `json:"longitude"`
+	RecordedAt time.Time `json:"recorded_at"`
+}
+
+// Define GetRideReplayResponse schema (the planned route of the ride and the path actually taken by the driver)
+type GetRideReplayResponse struct {
+	RideID          uuid.UUID              `json:"ride_id"`
+	Status          string                 `json:"status"`
+	PlannedPolyline string                 `json:"planned_polyline"`
+	PlannedDistance float64                `json:"planned_distance"`
+	ActualPolyline  string                 `json:"actual_polyline"`
+	ActualDistance  float64                `json:"actual_distance"`
+	Breadcrumbs     []RideBreadcrumbDetail `json:"breadcrumbs"`
+}
+
+// Define RideExpiredResponse schema (sent when a ride offer, ride request or ride expires, the unrelated ids are empty)
+type RideExpiredResponse struct {
+	RideID        uuid.UUID `json:"ride_id"`
+	RideOfferID   uuid.UUID `json:"ride_offer_id"`
+	RideRequestID uuid.UUID `json:"ride_request_id"`
+}
+
+// Define RideRouteUpdatedResponse schema (sent through websocket when the pickup and drop-off of a hitcher are added to the route)
+type RideRouteUpdatedResponse struct {
+	RideID          uuid.UUID    `json:"ride_id"`
+	RideOfferID     uuid.UUID    `json:"ride_offer_id"`
+	RideRequestID   uuid.UUID    `json:"ride_request_id"`
+	EncodedPolyline string       `json:"encoded_polyline"`
+	Distance        float64      `json:"distance"`
+	Duration        int          `json:"duration"`
+	EndTime         time.Time    `json:"end_time"`
+	PickupTime      time.Time    `json:"pickup_time"`  // Estimated time the driver reaches the pickup point of the hitcher
+	DropoffTime     time.Time    `json:"dropoff_time"` // Estimated time the driver reaches the drop-off point of the hitcher
+	Waypoints       []Waypoint   `json:"waypoints"`
+	MeetingPoint    MeetingPoint `json:"meeting_point"` // Where the hitcher walks to on the route, the pickup waypoint of the hitcher
+}
+
+// Define DriverETAResponse schema (sent through websocket to the hitcher while the driver is on the way)
+type DriverETAResponse struct {
+	RideID        uuid.UUID `json:"ride_id"`
+	RideRequestID uuid.UUID `json:"ride_request_id"`
+	Target        string    `json:"target"`   // pickup before the ride starts, dropoff once it is ongoing
+	Distance      float64   `json:"distance"` // Remaining distance to the target (km)
+	Duration      int       `json:"duration"` // Remaining time to the target (seconds)
+	ETA           time.Time `json:"eta"`
+}
+
+// Define GeofenceEventResponse schema (sent through websocket when the driver enters the geofence of the pickup or drop-off point)
+type GeofenceEventResponse struct {
+	RideID        uuid.UUID `json:"ride_id"`
+	RideOfferID   uuid.UUID `json:"ride_offer_id"`
+	RideRequestID uuid.UUID `json:"ride_request_id"`
+	Latitude      float64   `json:"latitude"` // Location of the driver when the event happened
+	Longitude     float64   `json:"longitude"`
+}
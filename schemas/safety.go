@@ -0,0 +1,155 @@
+package schemas
+
+import (
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Define SafetyCheckResponse schema (sent to the hitcher to ask whether they are OK and when the alert changes status)
+type SafetyCheckResponse struct {
+	AlertID   uuid.UUID `json:"alert_id"`
+	RideID    uuid.UUID `json:"ride_id"`
+	Type      string    `json:"type"`   // route_deviation, long_stop
+	Status    string    `json:"status"` // pending, safe, escalated, resolved
+	Latitude  float64   `json:"latitude"`
+	Longitude float64   `json:"longitude"`
+	Distance  float64   `json:"distance"` // Meters between the driver and the planned route
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Define RespondSafetyCheckRequest schema
+type RespondSafetyCheckRequest struct {
+	// ID of the safety alert the hitcher answers
+	AlertID uuid.UUID `json:"alertID" binding:"required,uuid" validate:"required,uuid"`
+	// Whether the hitcher is OK, the alert is escalated to the admins when they are not
+	Safe *bool `json:"safe" binding:"required" validate:"required"`
+}
+
+// Define SafetyAlertListRequest schema
+type SafetyAlertListRequest struct {
+	Page   int      `form:"page" binding:"required,min=1"`          // Page number for pagination
+	Limit  int      `form:"limit" binding:"required,min=1,max=100"` // Limit number for pagination (max 100)
+	Status []string `form:"status"`                                 // Optional filter for alert status
+}
+
+// Define SafetyAlertDetail schema
+type SafetyAlertDetail struct {
+	ID          uuid.UUID  `json:"alert_id"`
+	RideID      uuid.UUID  `json:"ride_id"`
+	Type        string     `json:"type"`     // route_deviation, long_stop, sos
+	Priority    string     `json:"priority"` // normal, high
+	Status      string     `json:"status"`
+	User        UserInfo   `json:"user"` // Hitcher asked whether they are OK, or user who pressed the SOS button
+	Driver      UserInfo   `json:"driver"`
+	Latitude    float64    `json:"latitude"`
+	Longitude   float64    `json:"longitude"`
+	Distance    float64    `json:"distance"`
+	CreatedAt   time.Time  `json:"created_at"`
+	RespondedAt *time.Time `json:"responded_at"`
+	EscalatedAt *time.Time `json:"escalated_at"`
+	ResolvedBy  *uuid.UUID `json:"resolved_by"`
+	Note        string     `json:"note"`
+}
+
+// Define SafetyAlertListResponse schema
+type SafetyAlertListResponse struct {
+	TotalPages  int64               `json:"total_pages"`
+	CurrentPage int                 `json:"current_page"`
+	Limit       int                 `json:"limit"`
+	TotalAlerts int64               `json:"total_alerts"`
+	Alerts      []SafetyAlertDetail `json:"alerts"`
+}
+
+// Define ResolveSafetyAlertRequest schema
+type ResolveSafetyAlertRequest struct {
+	AlertID uuid.UUID `json:"alertID" binding:"required,uuid" validate:"required,uuid"`
+	Note    string    `json:"note"`
+}
+
+// Define SOSRequest schema
+type SOSRequest struct {
+	// Ride ID of the ongoing ride
+	RideID uuid.UUID `json:"rideID" binding:"required,uuid" validate:"required,uuid"`
+	// Current user location, the last known location of the driver is used when it is not sent
+	CurrentLocation Point `json:"currentLocation"`
+}
+
+// Define SOSResponse schema
+type SOSResponse struct {
+	AlertID          uuid.UUID `json:"alert_id"`
+	RideID           uuid.UUID `json:"ride_id"`
+	Latitude         float64   `json:"latitude"`
+	Longitude        float64   `json:"longitude"`
+	NotifiedContacts int       `json:"notified_contacts"` // Number of trusted contacts the SMS was queued for
+	CreatedAt        time.Time `json:"created_at"`
+}
+
+// Define AddTrustedContactRequest schema
+type AddTrustedContactRequest struct {
+	FullName     string `json:"fullName" binding:"required" validate:"required"`
+	PhoneNumber  string `json:"phoneNumber" binding:"required,e164" validate:"required,e164"`
+	Relationship string `json:"relationship"`
+}
+
+// Define DeleteTrustedContactRequest schema
+type DeleteTrustedContactRequest struct {
+	ContactID uuid.UUID `json:"contactID" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define TrustedContactDetail schema
+type TrustedContactDetail struct {
+	ID           uuid.UUID `json:"contact_id"`
+	FullName     string    `json:"full_name"`
+	PhoneNumber  string    `json:"phone_number"`
+	Relationship string    `json:"relationship"`
+	CreatedAt    time.Time `json:"created_at"`
+}
+
+// Define GetTrustedContactsResponse schema
+type GetTrustedContactsResponse struct {
+	Contacts []TrustedContactDetail `json:"contacts"`
+}
+
+// Define RideSharePayload schema (payload of the read-only token used to follow a ride)
+type RideSharePayload struct {
+	ID        uuid.UUID `json:"id"`
+	RideID    uuid.UUID `json:"ride_id"`
+	SharedBy  uuid.UUID `json:"shared_by"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// Define ShareRideRequest schema
+type ShareRideRequest struct {
+	// Ride ID of the scheduled or ongoing ride to share
+	RideID uuid.UUID `json:"rideID" binding:"required,uuid" validate:"required,uuid"`
+}
+
+// Define ShareRideResponse schema
+type ShareRideResponse struct {
+	RideID    uuid.UUID `json:"ride_id"`
+	Token     string    `json:"token"` // Read-only token to pass to /share/track-ride
+	ExpiredAt time.Time `json:"expired_at"`
+}
+
+// Define TrackSharedRideRequest schema
+type TrackSharedRideRequest struct {
+	Token string `form:"token" binding:"required" validate:"required"`
+}
+
+// Define SharedRideLocation schema (streamed to whoever follows a shared ride)
+type SharedRideLocation struct {
+	RideID       uuid.UUID `json:"ride_id"`
+	Status       string    `json:"status"` // scheduled, ongoing
+	DriverName   string    `json:"driver_name"`
+	VehicleName  string    `json:"vehicle_name"`
+	LicensePlate string    `json:"license_plate"`
+	Latitude     float64   `json:"latitude"` // Last known location of the driver
+	Longitude    float64   `json:"longitude"`
+	Target       string    `json:"target"`   // pickup before the ride starts, dropoff once it is ongoing
+	Distance     float64   `json:"distance"` // Remaining distance to the target (km)
+	Duration     int       `json:"duration"` // Remaining time to the target (seconds)
+	ETA          time.Time `json:"eta"`
+	UpdatedAt    time.Time `json:"updated_at"` // Time the driver sent the location
+}
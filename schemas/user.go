@@ -31,3 +31,10 @@ type UpdateAvatarRequest struct {
 type UpdateAvatarResponse struct {
 	User UserResponse `json:"user" binding:"required"`
 }
+
+// Define MatchPreferences struct (requirements of the user on the other side of a ride)
+type MatchPreferences struct {
+	SameGenderOnly bool    `json:"same_gender_only"`                                        // Only ride with users of the same gender
+	VerifiedOnly   bool    `json:"verified_only"`                                           // Only ride with users whose CCCD is verified
+	MinRating      float64 `json:"min_rating" binding:"min=0,max=5" validate:"min=0,max=5"` // Only ride with users rated at least this (0 to accept everyone)
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"log"
+	"time"
+
+	"shareway/helper"
+	"shareway/infra/db/migration"
+	"shareway/infra/task"
+	"shareway/repository"
+	"shareway/schemas"
+	"shareway/util"
+)
+
+type IExpiryService interface {
+	ExpireStaleRides() error
+}
+
+// ExpiryService expires the ride offers, ride requests and rides whose time window has passed
+type ExpiryService struct {
+	repo           repository.IRideRepository
+	paymentService IPaymentService
+	mapService     IMapService
+	asyncClient    *task.AsyncClient
+	cfg            util.Config
+}
+
+func NewExpiryService(repo repository.IRideRepository, paymentService IPaymentService, mapService IMapService, asyncClient *task.AsyncClient, cfg util.Config) IExpiryService {
+	return &ExpiryService{
+		repo:           repo,
+		paymentService: paymentService,
+		mapService:     mapService,
+		asyncClient:    asyncClient,
+		cfg:            cfg,
+	}
+}
+
+// ExpireStaleRides expires the scheduled rides that were never started, then the ride offers and ride requests
+// that were never matched, notifies their owners and sends back the MoMo refunds still pending.
+// It is run periodically by the scheduler
+func (s *ExpiryService) ExpireStaleRides() error {
+	before := time.Now().Add(-time.Duration(s.cfg.RideExpiryGracePeriod) * time.Minute)
+
+	// Rides go first because expiring them releases their ride offers
+	rides, err := s.repo.GetUnstartedRides(before)
+	if err != nil {
+		return err
+	}
+	for _, ride := range rides {
+		// The rides of the other legs of an itinerary are cancelled with this one
+		legRides, err := s.repo.GetItineraryLegRides(ride.RideRequestID)
+		if err != nil {
+			log.Printf("Failed to get the itinerary of ride %s: %v", ride.ID, err)
+			continue
+		}
+
+		if err := s.repo.ExpireRide(ride.ID); err != nil {
+			log.Printf("Failed to expire ride %s: %v", ride.ID, err)
+			continue
+		}
+
+		s.unrouteRideRequest(ride)
+		for _, legRide := range legRides {
+			s.unrouteRideRequest(legRide)
+			s.notifyExpired(legRide.RideOffer.User.ID.String(), legRide.RideOffer.User.DeviceToken, "itinerary-leg-cancelled",
+				schemas.RideExpiredResponse{RideID: legRide.ID, RideOfferID: legRide.RideOfferID, RideRequestID: legRide.RideRequestID},
+				"Chuyến đi của bạn đã bị hủy", "Chặng trước của hành trình nối chuyến đã hết hạn, chuyến đi của bạn vẫn mở cho người khác đặt")
+		}
+
+		res := schemas.RideExpiredResponse{
+			RideID:        ride.ID,
+			RideOfferID:   ride.RideOfferID,
+			RideRequestID: ride.RideRequestID,
+		}
+		s.notifyExpired(ride.RideOffer.User.ID.String(), ride.RideOffer.User.DeviceToken, "ride-expired", res,
+			"Chuyến đi đã hết hạn", "Chuyến đi của bạn đã hết hạn vì không được bắt đầu đúng giờ")
+		s.notifyExpired(ride.RideRequest.User.ID.String(), ride.RideRequest.User.DeviceToken, "ride-expired", res,
+			"Chuyến đi đã hết hạn", "Chuyến đi của bạn đã hết hạn vì không được bắt đầu đúng giờ")
+	}
+
+	rideOffers, err := s.repo.GetStaleRideOffers(before)
+	if err != nil {
+		return err
+	}
+	for _, rideOffer := range rideOffers {
+		if err := s.repo.ExpireRideOffer(rideOffer.ID); err != nil {
+			log.Printf("Failed to expire ride offer %s: %v", rideOffer.ID, err)
+			continue
+		}
+
+		s.notifyExpired(rideOffer.UserID.String(), rideOffer.User.DeviceToken, "ride-offer-expired",
+			schemas.RideExpiredResponse{RideOfferID: rideOffer.ID},
+			"Chuyến đi đã hết hạn", "Không có ai đặt chuyến đi của bạn, chuyến đi đã hết hạn")
+	}
+
+	rideRequests, err := s.repo.GetStaleRideRequests(before)
+	if err != nil {
+		return err
+	}
+	for _, rideRequest := range rideRequests {
+		// The payment kept on hold since the driver cancelled is refunded with the ride request
+		if err := s.repo.ExpireRideRequest(rideRequest.ID); err != nil {
+			log.Printf("Failed to expire ride request %s: %v", rideRequest.ID, err)
+			continue
+		}
+
+		s.notifyExpired(rideRequest.UserID.String(), rideRequest.User.DeviceToken, "ride-request-expired",
+			schemas.RideExpiredResponse{RideRequestID: rideRequest.ID},
+			"Yêu cầu đã hết hạn", "Không tìm được tài xế cho yêu cầu của bạn, yêu cầu đã hết hạn")
+	}
+
+	// Send back the money of the MoMo payments refunded above, or by an earlier run whose refund failed
+	s.sendPendingRefunds()
+
+	return nil
+}
+
+// sendPendingRefunds refunds the hitchers of the MoMo payments marked refunded whose money was not sent back yet.
+// A payment is marked refunded before the money is sent, so a refund that fails is retried on the next run and
+// a refund that went through is never sent twice
+func (s *ExpiryService) sendPendingRefunds() {
+	transactions, err := s.repo.GetPendingRefunds()
+	if err != nil {
+		log.Printf("Failed to get pending refunds: %v", err)
+		return
+	}
+
+	for _, transaction := range transactions {
+		if err := s.paymentService.RefundTransaction(transaction); err != nil {
+			log.Printf("Failed to refund transaction %s: %v", transaction.ID, err)
+		}
+	}
+}
+
+// unrouteRideRequest removes the pickup and drop-off of the hitcher of a ride that will not happen from the route
+// of the ride offer and sends the new route to the driver
+func (s *ExpiryService) unrouteRideRequest(ride migration.Ride) {
+	res, err := s.mapService.RemoveRideRequestWaypoints(context.Background(), ride.RideOfferID, ride.RideRequestID)
+	if errors.Is(err, repository.ErrRouteUnchanged) {
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to remove the pickup and drop-off of ride request %s from the route: %v", ride.RideRequestID, err)
+		return
+	}
+	res.RideID = ride.ID
+
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  ride.RideOffer.UserID.String(),
+		Type:    "ride-route-updated",
+		Payload: res,
+	}
+	if err := s.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+}
+
+// notifyExpired sends the expiry to the user through the websocket and FCM queues
+func (s *ExpiryService) notifyExpired(userID, deviceToken, messageType string, res schemas.RideExpiredResponse, title, body string) {
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  userID,
+		Type:    messageType,
+		Payload: res,
+	}
+
+	go func() {
+		if err := s.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+			log.Printf("Failed to enqueue websocket message: %v", err)
+		}
+	}()
+
+	if deviceToken == "" {
+		return
+	}
+
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: messageType,
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notification := schemas.Notification{
+		Title: title,
+		Body:  body,
+		Token: deviceToken,
+		Data:  notificationPayloadMap,
+	}
+
+	go func() {
+		if err := s.asyncClient.EnqueueFCMNotification(notification); err != nil {
+			log.Printf("Failed to enqueue FCM notification: %v", err)
+		}
+	}()
+}
@@ -0,0 +1,82 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"shareway/helper"
+	"shareway/infra/db/migration"
+	"shareway/schemas"
+	"shareway/util"
+	"shareway/util/statemachine"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Geofence events emitted while the driver moves along a ride
+const (
+	GeofenceEventArrivedPickup       = "driver-arrived-pickup"
+	GeofenceEventArrivingDestination = "arriving-destination"
+	GeofenceEventArrivedDestination  = "arrived-destination"
+)
+
+// GeofenceEventDuration is how long an emitted event is remembered so it is not emitted twice for the same ride
+const GeofenceEventDuration = 24 * time.Hour
+
+type IGeofenceService interface {
+	EvaluateLocation(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, location schemas.Point) ([]string, error)
+}
+
+// GeofenceService turns the location updates of a ride into arrival events around the pickup and drop-off points
+type GeofenceService struct {
+	redisClient *redis.Client
+	cfg         util.Config
+}
+
+func NewGeofenceService(redisClient *redis.Client, cfg util.Config) IGeofenceService {
+	return &GeofenceService{
+		redisClient: redisClient,
+		cfg:         cfg,
+	}
+}
+
+// EvaluateLocation returns the geofence events the driver entered with the given location.
+// Each event is emitted at most once per ride
+func (s *GeofenceService) EvaluateLocation(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, location schemas.Point) ([]string, error) {
+	radius := float64(s.cfg.GeofenceRadius)
+
+	var entered []string
+	switch ride.Status {
+	case statemachine.StatusScheduled:
+		if helper.DistanceInMeters(location, helper.PickupPoint(ride, rideRequest)) <= radius {
+			entered = append(entered, GeofenceEventArrivedPickup)
+		}
+	case statemachine.StatusOngoing:
+		dropoff := schemas.Point{Lat: rideRequest.EndLatitude, Lng: rideRequest.EndLongitude}
+		distance := helper.DistanceInMeters(location, dropoff)
+		if distance <= float64(s.cfg.GeofenceArrivingRadius) {
+			entered = append(entered, GeofenceEventArrivingDestination)
+		}
+		if distance <= radius {
+			entered = append(entered, GeofenceEventArrivedDestination)
+		}
+	}
+
+	var events []string
+	for _, event := range entered {
+		key := fmt.Sprintf("ride:geofence:%s:%s", ride.ID, event)
+		ok, err := s.redisClient.SetNX(ctx, key, 1, GeofenceEventDuration).Result()
+		if err != nil {
+			return events, err
+		}
+		if ok {
+			events = append(events, event)
+		}
+	}
+
+	return events, nil
+}
+
+// Make sure the GeofenceService implements the IGeofenceService interface
+var _ IGeofenceService = (*GeofenceService)(nil)
@@ -3,56 +3,98 @@ package service
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"log"
 	"math"
 	"net/http"
 	"net/url"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 	_ "time/tzdata"
 
 	"shareway/helper"
 	"shareway/infra/db/migration"
+	"shareway/infra/task"
 	"shareway/repository"
 	"shareway/schemas"
 	"shareway/util"
+	"shareway/util/pricing"
+	"shareway/util/statemachine"
 
 	"github.com/google/uuid"
 	"github.com/redis/go-redis/v9"
 )
 
 const (
-	MaxRetry = 5 // Maximum number of retries for fetching data from Goong API
+	MaxRetry                  = 5                // Maximum number of retries for fetching data from Goong API
+	RecurringGiveRideLeadTime = 48 * time.Hour   // How long before departure an occurrence of a recurring give ride is published as a ride offer
+	DirectionsCacheDuration   = 10 * time.Minute // How long the Goong directions of a quoted route are cached
+	AddressCacheDuration      = 24 * time.Hour   // How long the reverse-geocoded address of a meeting point is cached
+	MeetingPointWorkers       = 4                // Maximum number of meeting points reverse-geocoded at once
+	DirectionsTimeout         = 10 * time.Second // How long a route may take to be fetched from the Goong directions API, retries included
+)
+
+// Types of the waypoints of a ride offer
+const (
+	WaypointTypeStop    = "stop"
+	WaypointTypePickup  = "pickup"
+	WaypointTypeDropoff = "dropoff"
+)
+
+var (
+	ErrInvalidOccurrenceDate = errors.New("date is not an upcoming occurrence of the recurring give ride")
 )
 
 type IMapService interface {
 	GetAutoComplete(ctx context.Context, input string, limit int, location string, radius int, moreCompound bool, currentLocation string) (schemas.GoongAutoCompleteResponse, error)
 	CreateGiveRide(ctx context.Context, input schemas.GiveRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error)
 	CreateHitchRide(ctx context.Context, input schemas.HitchRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error)
+	QuoteRide(ctx context.Context, input schemas.QuoteRideRequest, userID uuid.UUID) (schemas.QuoteRideResponse, error)
+	AddRideRequestWaypoints(ctx context.Context, rideOfferID, rideRequestID uuid.UUID) (schemas.RideRouteUpdatedResponse, error)
+	RemoveRideRequestWaypoints(ctx context.Context, rideOfferID, rideRequestID uuid.UUID) (schemas.RideRouteUpdatedResponse, error)
+	EstimateDriverETA(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, currentLocation schemas.Point) (schemas.DriverETAResponse, bool, error)
 	GetGeoCode(ctx context.Context, point schemas.Point, currentLocation schemas.Point) (schemas.GeoCodeLocationResponse, error)
+	GetMeetingPoint(ctx context.Context, rideOffer migration.RideOffer, rideRequest migration.RideRequest) schemas.MeetingPoint
 	GetLocationFromPlaceID(ctx context.Context, placeID string) (schemas.Point, error)
 	GetRideOfferDetails(ctx context.Context, rideOfferID uuid.UUID) (migration.RideOffer, error)
 	GetRideRequestDetails(ctx context.Context, rideRequestID uuid.UUID) (migration.RideRequest, error)
 	GetDistanceFromCurrentLocation(ctx context.Context, currentLocation schemas.Point, destinationPoint []schemas.Point) (schemas.GoongDistanceMatrixResponse, error)
-	SuggestRideRequests(ctx context.Context, userID uuid.UUID, rideOfferID uuid.UUID) ([]migration.RideRequest, error)
-	SuggestRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]migration.RideOffer, error)
+	SuggestRideRequests(ctx context.Context, userID uuid.UUID, rideOfferID uuid.UUID) ([]repository.RideRequestMatch, error)
+	SuggestRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]repository.RideOfferMatch, error)
+	SubscribeGiveRides(input schemas.SubscribeGiveRidesRequest, userID uuid.UUID) error
+	SubscribeHitchRides(input schemas.SubscribeHitchRidesRequest, userID uuid.UUID) error
+	SuggestRematchRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID, cancelledRideOfferID uuid.UUID) ([]repository.RideOfferMatch, error)
+	SuggestItineraries(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]repository.Itinerary, error)
+	GetItinerary(ctx context.Context, userID uuid.UUID, rideRequestID, firstRideOfferID, secondRideOfferID uuid.UUID) (repository.Itinerary, error)
 	GetAllWaypoints(rideOfferID uuid.UUID) ([]migration.Waypoint, error)
+	CreateRecurringGiveRide(input schemas.CreateRecurringGiveRideRequest, userID uuid.UUID) (migration.RecurringRideOffer, error)
+	GetRecurringGiveRides(userID uuid.UUID) ([]migration.RecurringRideOffer, error)
+	SkipRecurringGiveRide(input schemas.SkipRecurringGiveRideRequest, userID uuid.UUID) error
+	PauseRecurringGiveRide(recurringRideOfferID, userID uuid.UUID) error
+	ResumeRecurringGiveRide(recurringRideOfferID, userID uuid.UUID) error
+	MaterializeRecurringGiveRides() error
 }
 
 type MapService struct {
 	repo        repository.IMapsRepository
 	cfg         util.Config
 	redisClient *redis.Client
+	pricing     *pricing.Engine
+	asyncClient *task.AsyncClient
 }
 
-func NewMapService(repo repository.IMapsRepository, cfg util.Config, redisClient *redis.Client) IMapService {
+func NewMapService(repo repository.IMapsRepository, cfg util.Config, redisClient *redis.Client, pricing *pricing.Engine, asyncClient *task.AsyncClient) IMapService {
 	return &MapService{
 		repo:        repo,
 		cfg:         cfg,
 		redisClient: redisClient,
+		pricing:     pricing,
+		asyncClient: asyncClient,
 	}
 }
 
@@ -209,20 +251,34 @@ func (s *MapService) GetAutoComplete(ctx context.Context, input string, limit in
 	return response, nil
 }
 
-// CreateGiveRide creates a ride offer based on the given input
-func (s *MapService) CreateGiveRide(ctx context.Context, input schemas.GiveRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error) {
-	points := make([]schemas.Point, len(input.PlaceList))
-	for i, placeID := range input.PlaceList {
+// getDirections resolves the place IDs to points and fetches the route between them from the Goong directions API
+func (s *MapService) getDirections(ctx context.Context, placeList []string) ([]schemas.Point, schemas.GoongDirectionsResponse, error) {
+	points := make([]schemas.Point, len(placeList))
+	for i, placeID := range placeList {
 		point, err := s.GetLocationFromPlaceID(ctx, placeID)
 		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to get location for place ID %s: %w", placeID, err)
+			return nil, schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to get location for place ID %s: %w", placeID, err)
 		}
 		points[i] = point
 	}
 
+	response, err := s.fetchDirections(ctx, points)
+	if err != nil {
+		return nil, schemas.GoongDirectionsResponse{}, err
+	}
+
+	return points, response, nil
+}
+
+// fetchDirections fetches the route from the first point to the last one through the points in between from the Goong directions API,
+// giving up after DirectionsTimeout or when the context is done
+func (s *MapService) fetchDirections(ctx context.Context, points []schemas.Point) (schemas.GoongDirectionsResponse, error) {
+	ctx, cancel := context.WithTimeout(ctx, DirectionsTimeout)
+	defer cancel()
+
 	baseURL, err := url.Parse(fmt.Sprintf("%s/direction", s.cfg.GoongApiURL))
 	if err != nil {
-		return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("invalid base URL: %w", err)
+		return schemas.GoongDirectionsResponse{}, fmt.Errorf("invalid base URL: %w", err)
 	}
 
 	params := url.Values{
@@ -245,35 +301,55 @@ func (s *MapService) CreateGiveRide(ctx context.Context, input schemas.GiveRideR
 	retryDelay := time.Second
 
 	for i := 0; i < maxRetries; i++ {
-		resp, err := http.Get(url)
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
 		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to fetch from Goong API: %w", err)
+			return schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to create request: %w", err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to fetch from Goong API: %w", err)
 		}
-		defer resp.Body.Close()
 
 		if resp.StatusCode == http.StatusTooManyRequests {
-			time.Sleep(retryDelay)
+			resp.Body.Close()
+			select {
+			case <-ctx.Done():
+				return schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to fetch from Goong API: %w", ctx.Err())
+			case <-time.After(retryDelay):
+			}
 			retryDelay *= 2 // Exponential backoff
 			continue
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			resp.Body.Close()
+			return schemas.GoongDirectionsResponse{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		}
 
 		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
 		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to read response body: %w", err)
+			return schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to read response body: %w", err)
 		}
 
 		if err := json.Unmarshal(body, &response); err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to unmarshal response: %w", err)
+			return schemas.GoongDirectionsResponse{}, fmt.Errorf("failed to unmarshal response: %w", err)
 		}
 
 		// If we've reached here, we've successfully got and parsed the response
 		break
 	}
 
+	return response, nil
+}
+
+// CreateGiveRide creates a ride offer based on the given input
+func (s *MapService) CreateGiveRide(ctx context.Context, input schemas.GiveRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error) {
+	points, response, err := s.getDirections(ctx, input.PlaceList)
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
+	}
+
 	currentLocation := schemas.Point{
 		Lat: points[0].Lat,
 		Lng: points[0].Lng,
@@ -301,77 +377,281 @@ func (s *MapService) CreateGiveRide(ctx context.Context, input schemas.GiveRideR
 		startTime = time.Now().UTC()
 	}
 
-	rideOfferID, err := s.repo.CreateGiveRide(response, userID, currentLocation, startTime, input.VehicleID)
+	fare, err := s.calculateFare(response, input.VehicleID, userID)
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
+	}
+
+	preferences, err := s.matchPreferences(input.Preferences, userID)
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
+	}
+
+	rideOfferID, err := s.repo.CreateGiveRide(response, userID, currentLocation, startTime, input.VehicleID, input.Seats, fare, preferences, migration.RideAmenities(input.Amenities), input.RecurringRideOfferID)
 	if err != nil {
 		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
 	}
 
+	// Notify the hitchers waiting for a matching ride offer
+	if err := s.asyncClient.EnqueueMatchRideOffer(rideOfferID); err != nil {
+		log.Printf("Failed to enqueue match alerts of ride offer %s: %v", rideOfferID, err)
+	}
+
 	return response, rideOfferID, nil
 }
 
-// CreateHitchRide creates a hitch ride request based on the given input
-func (s *MapService) CreateHitchRide(ctx context.Context, input schemas.HitchRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error) {
-	points := make([]schemas.Point, len(input.PlaceList))
-	for i, placeID := range input.PlaceList {
-		point, err := s.GetLocationFromPlaceID(ctx, placeID)
+// matchPreferences returns the match preferences given for a ride, or the default ones of the user
+func (s *MapService) matchPreferences(preferences *schemas.MatchPreferences, userID uuid.UUID) (migration.MatchPreferences, error) {
+	if preferences != nil {
+		return migration.MatchPreferences(*preferences), nil
+	}
+
+	return s.repo.GetUserMatchPreferences(userID)
+}
+
+// calculateFare prices the route with the pricing strategy of the vehicle type
+func (s *MapService) calculateFare(route schemas.GoongDirectionsResponse, vehicleID, userID uuid.UUID) (int64, error) {
+	if len(route.Routes) == 0 {
+		return 0, errors.New("invalid route data")
+	}
+
+	vehicle, err := s.repo.GetUserVehicle(vehicleID, userID)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get vehicle: %w", err)
+	}
+
+	totalDistance := 0
+	for _, leg := range route.Routes[0].Legs {
+		totalDistance += leg.Distance.Value
+	}
+
+	// The distance is priced to the meter. Fares used to be computed on the distance truncated to whole kilometers,
+	// so a route is now up to one kilometer's worth more expensive than it was before the pricing strategies
+	trip := pricing.Trip{
+		Distance:       float64(totalDistance) / 1000,
+		FuelConsumed:   vehicle.FuelConsumed,
+		EnergyConsumed: vehicle.VehicleType.EnergyConsumed,
+	}
+
+	// Only the fuel strategy depends on the crawled fuel prices
+	if pricing.UsesFuelPrice(vehicle.VehicleType.PricingStrategy) {
+		fuelType := vehicle.VehicleType.FuelType
+		if fuelType == "" {
+			fuelType = pricing.DefaultFuelType
+		}
+
+		trip.FuelPrice, err = s.repo.GetFuelPrice(fuelType)
 		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to get location for place ID %s: %w", placeID, err)
+			return 0, fmt.Errorf("failed to fetch fuel price of %s: %w", fuelType, err)
 		}
-		points[i] = point
 	}
 
-	baseURL, err := url.Parse(fmt.Sprintf("%s/direction", s.cfg.GoongApiURL))
+	return s.pricing.Calculate(vehicle.VehicleType.PricingStrategy, trip)
+}
+
+// QuoteRide prices the route between the given places for the vehicle without creating a ride offer
+func (s *MapService) QuoteRide(ctx context.Context, input schemas.QuoteRideRequest, userID uuid.UUID) (schemas.QuoteRideResponse, error) {
+	placeList := append([]string{input.Origin}, input.Waypoints...)
+	placeList = append(placeList, input.Destination)
+
+	response, err := s.getCachedDirections(ctx, placeList)
 	if err != nil {
-		return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("invalid base URL: %w", err)
+		return schemas.QuoteRideResponse{}, err
+	}
+	if len(response.Routes) == 0 || len(response.Routes[0].Legs) == 0 {
+		return schemas.QuoteRideResponse{}, errors.New("invalid route data")
 	}
 
-	params := url.Values{
-		"api_key": {s.cfg.GoongAPIKey},
-		"origin":  {fmt.Sprintf("%f,%f", points[0].Lat, points[0].Lng)},
-		"vehicle": {"hd"},
+	fare, err := s.calculateFare(response, input.VehicleID, userID)
+	if err != nil {
+		return schemas.QuoteRideResponse{}, err
 	}
 
-	destinations := make([]string, len(points)-1)
-	for i := 1; i < len(points); i++ {
-		destinations[i-1] = fmt.Sprintf("%f,%f", points[i].Lat, points[i].Lng)
+	totalDistance, totalDuration := 0, 0
+	for _, leg := range response.Routes[0].Legs {
+		totalDistance += leg.Distance.Value
+		totalDuration += leg.Duration.Value
 	}
-	params.Set("destination", strings.Join(destinations, ";"))
 
-	baseURL.RawQuery = params.Encode()
-	url := baseURL.String()
+	return schemas.QuoteRideResponse{
+		Distance:        math.Round(float64(totalDistance)/10) / 100,
+		Duration:        totalDuration,
+		EncodedPolyline: response.Routes[0].Overview_polyline.Points,
+		Fare:            fare,
+		VehicleID:       input.VehicleID,
+	}, nil
+}
+
+// getCachedDirections returns the Goong directions of the route from the cache,
+// or fetches and caches them so repeated quotes of the same route do not hit the API
+func (s *MapService) getCachedDirections(ctx context.Context, placeList []string) (schemas.GoongDirectionsResponse, error) {
+	cacheKey := fmt.Sprintf("map:directions:%s", strings.Join(placeList, ";"))
 
 	var response schemas.GoongDirectionsResponse
-	maxRetries := MaxRetry
-	retryDelay := time.Second
+	cached, err := s.redisClient.Get(ctx, cacheKey).Bytes()
+	if err == nil {
+		if err := json.Unmarshal(cached, &response); err == nil {
+			return response, nil
+		}
+	} else if err != redis.Nil {
+		log.Printf("Failed to get cached directions: %v", err)
+	}
 
-	for i := 0; i < maxRetries; i++ {
-		resp, err := http.Get(url)
+	_, response, err = s.getDirections(ctx, placeList)
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, err
+	}
+
+	// Only cache usable routes so a failed lookup is retried on the next quote
+	if len(response.Routes) > 0 {
+		data, err := json.Marshal(response)
+		if err == nil {
+			err = s.redisClient.Set(ctx, cacheKey, data, DirectionsCacheDuration).Err()
+		}
 		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to fetch from Goong API: %w", err)
+			log.Printf("Failed to cache directions: %v", err)
 		}
-		defer resp.Body.Close()
+	}
 
-		if resp.StatusCode == http.StatusTooManyRequests {
-			time.Sleep(retryDelay)
-			retryDelay *= 2 // Exponential backoff
-			continue
-		}
+	return response, nil
+}
 
-		if resp.StatusCode != http.StatusOK {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+// AddRideRequestWaypoints adds the pickup and drop-off of an accepted ride request to the waypoints of the ride offer,
+// recomputes the route through every waypoint with Goong and stores it on the ride offer and its rides
+func (s *MapService) AddRideRequestWaypoints(ctx context.Context, rideOfferID, rideRequestID uuid.UUID) (schemas.RideRouteUpdatedResponse, error) {
+	rideOffer, err := s.repo.GetRideOfferDetails(rideOfferID)
+	if err != nil {
+		return schemas.RideRouteUpdatedResponse{}, err
+	}
+
+	rideRequest, err := s.repo.GetRideRequestDetails(rideRequestID)
+	if err != nil {
+		return schemas.RideRouteUpdatedResponse{}, err
+	}
+
+	// The driver picks up the hitcher at the meeting point on the route instead of driving to the start of the hitcher
+	meetingPoint := s.GetMeetingPoint(ctx, rideOffer, rideRequest)
+	pickup := migration.Waypoint{
+		Latitude:      meetingPoint.Latitude,
+		Longitude:     meetingPoint.Longitude,
+		Address:       meetingPoint.Address,
+		Type:          WaypointTypePickup,
+		RideRequestID: &rideRequest.ID,
+	}
+	if pickup.Address == "" {
+		pickup.Address = rideRequest.StartAddress
+	}
+	dropoff := migration.Waypoint{
+		Latitude:      rideRequest.EndLatitude,
+		Longitude:     rideRequest.EndLongitude,
+		Address:       rideRequest.EndAddress,
+		Type:          WaypointTypeDropoff,
+		RideRequestID: &rideRequest.ID,
+	}
+
+	rideOffer, waypoints, route, err := s.repo.UpdateRideOfferRoute(rideOfferID, func(rideOffer migration.RideOffer, waypoints []migration.Waypoint) (schemas.GoongDirectionsResponse, []migration.Waypoint, error) {
+		waypoints = helper.InsertPickupAndDropoff(helper.DecodePolyline(string(rideOffer.EncodedPolyline)), waypoints, pickup, dropoff)
+		route, err := s.fetchRouteThroughWaypoints(ctx, rideOffer, waypoints)
+		return route, waypoints, err
+	})
+	if err != nil {
+		return schemas.RideRouteUpdatedResponse{}, err
+	}
+
+	res := routeUpdatedResponse(rideOffer, rideRequest.ID, route, waypoints)
+	res.MeetingPoint = meetingPoint
+	return res, nil
+}
+
+// RemoveRideRequestWaypoints removes the pickup and drop-off of a ride request that will not be served
+// (cancelled, no-show, expired or rematched) from the waypoints of the ride offer and recomputes the route
+// through the remaining waypoints. It returns repository.ErrRouteUnchanged when there is nothing to remove
+// or the ride offer is already finished
+func (s *MapService) RemoveRideRequestWaypoints(ctx context.Context, rideOfferID, rideRequestID uuid.UUID) (schemas.RideRouteUpdatedResponse, error) {
+	rideOffer, waypoints, route, err := s.repo.UpdateRideOfferRoute(rideOfferID, func(rideOffer migration.RideOffer, waypoints []migration.Waypoint) (schemas.GoongDirectionsResponse, []migration.Waypoint, error) {
+		switch rideOffer.Status {
+		case statemachine.StatusCompleted, statemachine.StatusCancelled, statemachine.StatusExpired:
+			return schemas.GoongDirectionsResponse{}, nil, repository.ErrRouteUnchanged
 		}
 
-		body, err := io.ReadAll(resp.Body)
-		if err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to read response body: %w", err)
+		remaining := make([]migration.Waypoint, 0, len(waypoints))
+		for _, waypoint := range waypoints {
+			if waypoint.RideRequestID != nil && *waypoint.RideRequestID == rideRequestID {
+				continue
+			}
+			remaining = append(remaining, waypoint)
+		}
+		if len(remaining) == len(waypoints) {
+			return schemas.GoongDirectionsResponse{}, nil, repository.ErrRouteUnchanged
 		}
 
-		if err := json.Unmarshal(body, &response); err != nil {
-			return schemas.GoongDirectionsResponse{}, uuid.Nil, fmt.Errorf("failed to unmarshal response: %w", err)
+		route, err := s.fetchRouteThroughWaypoints(ctx, rideOffer, remaining)
+		return route, remaining, err
+	})
+	if err != nil {
+		return schemas.RideRouteUpdatedResponse{}, err
+	}
+
+	return routeUpdatedResponse(rideOffer, rideRequestID, route, waypoints), nil
+}
+
+// fetchRouteThroughWaypoints returns the route that starts and ends where the driver's route does
+// and goes through every waypoint in order
+func (s *MapService) fetchRouteThroughWaypoints(ctx context.Context, rideOffer migration.RideOffer, waypoints []migration.Waypoint) (schemas.GoongDirectionsResponse, error) {
+	points := make([]schemas.Point, 0, len(waypoints)+2)
+	points = append(points, schemas.Point{Lat: rideOffer.StartLatitude, Lng: rideOffer.StartLongitude})
+	for _, waypoint := range waypoints {
+		points = append(points, schemas.Point{Lat: waypoint.Latitude, Lng: waypoint.Longitude})
+	}
+	points = append(points, schemas.Point{Lat: rideOffer.EndLatitude, Lng: rideOffer.EndLongitude})
+
+	return s.fetchDirections(ctx, points)
+}
+
+// routeUpdatedResponse describes the new route of the ride offer and when the driver reaches
+// the pickup and drop-off of the ride request on it
+func routeUpdatedResponse(rideOffer migration.RideOffer, rideRequestID uuid.UUID, route schemas.GoongDirectionsResponse, waypoints []migration.Waypoint) schemas.RideRouteUpdatedResponse {
+	res := schemas.RideRouteUpdatedResponse{
+		RideOfferID:     rideOffer.ID,
+		RideRequestID:   rideRequestID,
+		EncodedPolyline: string(rideOffer.EncodedPolyline),
+		Distance:        rideOffer.Distance,
+		Duration:        rideOffer.Duration,
+		EndTime:         rideOffer.EndTime,
+		Waypoints:       make([]schemas.Waypoint, 0, len(waypoints)),
+	}
+
+	// Leg i of the route ends at waypoint i, so the time the driver reaches a waypoint is the sum of the legs before it
+	arrival := rideOffer.StartTime
+	for i, waypoint := range waypoints {
+		arrival = arrival.Add(time.Duration(route.Routes[0].Legs[i].Duration.Value) * time.Second)
+		if waypoint.RideRequestID != nil && *waypoint.RideRequestID == rideRequestID {
+			switch waypoint.Type {
+			case WaypointTypePickup:
+				res.PickupTime = arrival
+			case WaypointTypeDropoff:
+				res.DropoffTime = arrival
+			}
 		}
 
-		// If we've reached here, we've successfully got and parsed the response
-		break
+		res.Waypoints = append(res.Waypoints, schemas.Waypoint{
+			ID:        waypoint.ID,
+			Latitude:  waypoint.Latitude,
+			Longitude: waypoint.Longitude,
+			Address:   waypoint.Address,
+			Order:     waypoint.WaypointOrder,
+			Type:      waypoint.Type,
+		})
+	}
+
+	return res
+}
+
+// CreateHitchRide creates a hitch ride request based on the given input
+func (s *MapService) CreateHitchRide(ctx context.Context, input schemas.HitchRideRequest, userID uuid.UUID) (schemas.GoongDirectionsResponse, uuid.UUID, error) {
+	points, response, err := s.getDirections(ctx, input.PlaceList)
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
 	}
 
 	currentLocation := schemas.Point{
@@ -403,19 +683,73 @@ func (s *MapService) CreateHitchRide(ctx context.Context, input schemas.HitchRid
 		startTime = time.Now().UTC()
 	}
 
-	rideRequestID, err := s.repo.CreateHitchRide(response, userID, currentLocation, startTime, input.Weight)
+	preferences, err := s.matchPreferences(input.Preferences, userID)
 	if err != nil {
 		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
 	}
 
+	rideRequestID, err := s.repo.CreateHitchRide(response, userID, currentLocation, startTime, input.Weight, preferences, migration.RideAmenities(input.Amenities))
+	if err != nil {
+		return schemas.GoongDirectionsResponse{}, uuid.Nil, err
+	}
+
+	// Notify the drivers waiting for a matching ride request
+	if err := s.asyncClient.EnqueueMatchRideRequest(rideRequestID); err != nil {
+		log.Printf("Failed to enqueue match alerts of ride request %s: %v", rideRequestID, err)
+	}
+
 	return response, rideRequestID, nil
 }
 
 // GetGeoCode returns the geocode information for the given point
 func (s *MapService) GetGeoCode(ctx context.Context, point schemas.Point, currentLocation schemas.Point) (schemas.GeoCodeLocationResponse, error) {
+	response, err := s.reverseGeocode(point)
+	if err != nil {
+		return schemas.GeoCodeLocationResponse{}, err
+	}
+
+	optimizedResults := schemas.GeoCodeLocationResponse{
+		Results: make([]schemas.GeoCodeLocation, len(response.Results)),
+	}
+	destinationPoints := make([]schemas.Point, len(response.Results))
+
+	for i, result := range response.Results {
+		addressParts := strings.SplitN(result.FormattedAddress, ",", 2)
+		optimizedResults.Results[i] = schemas.GeoCodeLocation{
+			PlaceID:          result.PlaceID,
+			FormattedAddress: result.FormattedAddress,
+			Latitude:         result.Geometry.Location.Lat,
+			Longitude:        result.Geometry.Location.Lng,
+			MainAddress:      strings.TrimSpace(addressParts[0]),
+			SecondaryAddress: strings.TrimSpace(strings.Join(addressParts[1:], ",")),
+		}
+		destinationPoints[i] = schemas.Point{
+			Lat: result.Geometry.Location.Lat,
+			Lng: result.Geometry.Location.Lng,
+		}
+	}
+
+	// Calculate the distance from the current location
+	distanceMatrix, err := s.GetDistanceFromCurrentLocation(ctx, currentLocation, destinationPoints)
+	if err != nil {
+		return schemas.GeoCodeLocationResponse{}, err
+	}
+
+	for i := range optimizedResults.Results {
+		// Convert to km and round to 2 decimal places
+		distanceKm := float64(distanceMatrix.Rows[0].Elements[i].Distance.Value) / 1000
+		roundedDistance := math.Round(distanceKm*100) / 100
+		optimizedResults.Results[i].Distance = roundedDistance
+	}
+
+	return optimizedResults, nil
+}
+
+// reverseGeocode fetches the addresses Goong finds at the given point
+func (s *MapService) reverseGeocode(point schemas.Point) (schemas.GoongReverseGeocodeResponse, error) {
 	baseURL, err := url.Parse(fmt.Sprintf("%s/geocode", s.cfg.GoongApiURL))
 	if err != nil {
-		return schemas.GeoCodeLocationResponse{}, fmt.Errorf("invalid base URL: %w", err)
+		return schemas.GoongReverseGeocodeResponse{}, fmt.Errorf("invalid base URL: %w", err)
 	}
 
 	params := url.Values{
@@ -432,7 +766,7 @@ func (s *MapService) GetGeoCode(ctx context.Context, point schemas.Point, curren
 	for i := 0; i < maxRetries; i++ {
 		resp, err := http.Get(url)
 		if err != nil {
-			return schemas.GeoCodeLocationResponse{}, fmt.Errorf("http get error: %w", err)
+			return schemas.GoongReverseGeocodeResponse{}, fmt.Errorf("http get error: %w", err)
 		}
 		defer resp.Body.Close()
 
@@ -443,57 +777,23 @@ func (s *MapService) GetGeoCode(ctx context.Context, point schemas.Point, curren
 		}
 
 		if resp.StatusCode != http.StatusOK {
-			return schemas.GeoCodeLocationResponse{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+			return schemas.GoongReverseGeocodeResponse{}, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 		}
 
 		body, err := io.ReadAll(resp.Body)
 		if err != nil {
-			return schemas.GeoCodeLocationResponse{}, fmt.Errorf("read body error: %w", err)
+			return schemas.GoongReverseGeocodeResponse{}, fmt.Errorf("read body error: %w", err)
 		}
 
 		if err := json.Unmarshal(body, &response); err != nil {
-			return schemas.GeoCodeLocationResponse{}, fmt.Errorf("unmarshal error: %w", err)
+			return schemas.GoongReverseGeocodeResponse{}, fmt.Errorf("unmarshal error: %w", err)
 		}
 
 		// If we've reached here, we've successfully got and parsed the response
 		break
 	}
 
-	optimizedResults := schemas.GeoCodeLocationResponse{
-		Results: make([]schemas.GeoCodeLocation, len(response.Results)),
-	}
-	destinationPoints := make([]schemas.Point, len(response.Results))
-
-	for i, result := range response.Results {
-		addressParts := strings.SplitN(result.FormattedAddress, ",", 2)
-		optimizedResults.Results[i] = schemas.GeoCodeLocation{
-			PlaceID:          result.PlaceID,
-			FormattedAddress: result.FormattedAddress,
-			Latitude:         result.Geometry.Location.Lat,
-			Longitude:        result.Geometry.Location.Lng,
-			MainAddress:      strings.TrimSpace(addressParts[0]),
-			SecondaryAddress: strings.TrimSpace(strings.Join(addressParts[1:], ",")),
-		}
-		destinationPoints[i] = schemas.Point{
-			Lat: result.Geometry.Location.Lat,
-			Lng: result.Geometry.Location.Lng,
-		}
-	}
-
-	// Calculate the distance from the current location
-	distanceMatrix, err := s.GetDistanceFromCurrentLocation(ctx, currentLocation, destinationPoints)
-	if err != nil {
-		return schemas.GeoCodeLocationResponse{}, err
-	}
-
-	for i := range optimizedResults.Results {
-		// Convert to km and round to 2 decimal places
-		distanceKm := float64(distanceMatrix.Rows[0].Elements[i].Distance.Value) / 1000
-		roundedDistance := math.Round(distanceKm*100) / 100
-		optimizedResults.Results[i].Distance = roundedDistance
-	}
-
-	return optimizedResults, nil
+	return response, nil
 }
 
 // GetDistanceFromCurrentLocation returns the distance matrix from the current location to the destination points
@@ -556,6 +856,47 @@ func (s *MapService) GetDistanceFromCurrentLocation(ctx context.Context, current
 	return schemas.GoongDistanceMatrixResponse{}, fmt.Errorf("max retries reached, unable to get distance matrix")
 }
 
+// EstimateDriverETA estimates how long the driver needs from the current location to the pickup point of the hitcher,
+// or to the drop-off once the ride is ongoing. The ETA of a ride is recalculated at most once per DriverETAInterval,
+// the returned bool is false when it was skipped
+func (s *MapService) EstimateDriverETA(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, currentLocation schemas.Point) (schemas.DriverETAResponse, bool, error) {
+	throttleKey := fmt.Sprintf("ride:eta:%s", ride.ID)
+	ok, err := s.redisClient.SetNX(ctx, throttleKey, 1, time.Duration(s.cfg.DriverETAInterval)*time.Second).Result()
+	if err != nil {
+		return schemas.DriverETAResponse{}, false, err
+	}
+	if !ok {
+		return schemas.DriverETAResponse{}, false, nil
+	}
+
+	res := schemas.DriverETAResponse{
+		RideID:        ride.ID,
+		RideRequestID: rideRequest.ID,
+		Target:        WaypointTypePickup,
+	}
+	target := helper.PickupPoint(ride, rideRequest)
+	if ride.Status == statemachine.StatusOngoing {
+		res.Target = WaypointTypeDropoff
+		target = schemas.Point{Lat: rideRequest.EndLatitude, Lng: rideRequest.EndLongitude}
+	}
+
+	distanceMatrix, err := s.GetDistanceFromCurrentLocation(ctx, currentLocation, []schemas.Point{target})
+	if err == nil && len(distanceMatrix.Rows) > 0 && len(distanceMatrix.Rows[0].Elements) > 0 && distanceMatrix.Rows[0].Elements[0].Status == "OK" {
+		element := distanceMatrix.Rows[0].Elements[0]
+		res.Distance = math.Round(float64(element.Distance.Value)/10) / 100
+		res.Duration = element.Duration.Value
+	} else {
+		// Fall back to the remaining part of the stored route at the average speed of the ride
+		if err != nil {
+			log.Printf("Failed to get distance matrix for the ETA of ride %s: %v", ride.ID, err)
+		}
+		res.Distance, res.Duration = helper.EstimateAlongRoute(helper.DecodePolyline(string(ride.EncodedPolyline)), currentLocation, target, ride.Distance, ride.Duration)
+	}
+	res.ETA = time.Now().Add(time.Duration(res.Duration) * time.Second)
+
+	return res, true, nil
+}
+
 // GetRideOfferDetails returns the ride offer details for the given ride offer ID
 func (s *MapService) GetRideOfferDetails(ctx context.Context, rideOfferID uuid.UUID) (migration.RideOffer, error) {
 	return s.repo.GetRideOfferDetails(rideOfferID)
@@ -566,14 +907,162 @@ func (s *MapService) GetRideRequestDetails(ctx context.Context, rideRequestID uu
 	return s.repo.GetRideRequestDetails(rideRequestID)
 }
 
-// SuggestRideRequests returns the suggested ride requests for the given user and ride offer
-func (s *MapService) SuggestRideRequests(ctx context.Context, userID uuid.UUID, rideOfferID uuid.UUID) ([]migration.RideRequest, error) {
-	return s.repo.SuggestRideRequests(userID, rideOfferID)
+// SuggestRideRequests returns the suggested ride requests for the given user and ride offer,
+// with the address of the meeting point of each hitcher on the route
+func (s *MapService) SuggestRideRequests(ctx context.Context, userID uuid.UUID, rideOfferID uuid.UUID) ([]repository.RideRequestMatch, error) {
+	matches, err := s.repo.SuggestRideRequests(userID, rideOfferID)
+	if err != nil {
+		return nil, err
+	}
+
+	meetingPoints := make([]*schemas.MeetingPoint, len(matches))
+	for i := range matches {
+		meetingPoints[i] = &matches[i].MeetingPoint
+	}
+	s.locateMeetingPoints(ctx, meetingPoints)
+
+	return matches, nil
+}
+
+// SuggestRideOffers returns the suggested ride offers for the given user and ride request,
+// with the address of the meeting point of the hitcher on each route
+func (s *MapService) SuggestRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]repository.RideOfferMatch, error) {
+	matches, err := s.repo.SuggestRideOffers(userID, rideRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	meetingPoints := make([]*schemas.MeetingPoint, len(matches))
+	for i := range matches {
+		meetingPoints[i] = &matches[i].MeetingPoint
+	}
+	s.locateMeetingPoints(ctx, meetingPoints)
+
+	return matches, nil
+}
+
+// GetMeetingPoint returns where the hitcher of the ride request meets the driver on the route of the ride offer
+// (the point of the route nearest to the start of the hitcher), with its address and the walking distance to it
+func (s *MapService) GetMeetingPoint(ctx context.Context, rideOffer migration.RideOffer, rideRequest migration.RideRequest) schemas.MeetingPoint {
+	start := schemas.Point{Lat: rideRequest.StartLatitude, Lng: rideRequest.StartLongitude}
+	meetingPoint := helper.GetMeetingPoint(helper.DecodePolyline(string(rideOffer.EncodedPolyline)), start)
+	s.locateMeetingPoint(ctx, &meetingPoint)
+
+	return meetingPoint
+}
+
+// locateMeetingPoints sets the address of the meeting points, with at most MeetingPointWorkers Goong calls at once
+func (s *MapService) locateMeetingPoints(ctx context.Context, meetingPoints []*schemas.MeetingPoint) {
+	workers := make(chan struct{}, MeetingPointWorkers)
+	var wg sync.WaitGroup
+	for _, meetingPoint := range meetingPoints {
+		wg.Add(1)
+		workers <- struct{}{}
+		go func(meetingPoint *schemas.MeetingPoint) {
+			defer wg.Done()
+			defer func() { <-workers }()
+			s.locateMeetingPoint(ctx, meetingPoint)
+		}(meetingPoint)
+	}
+	wg.Wait()
 }
 
-// SuggestRideOffers returns the suggested ride offers for the given user and ride request
-func (s *MapService) SuggestRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]migration.RideOffer, error) {
-	return s.repo.SuggestRideOffers(userID, rideRequestID)
+// locateMeetingPoint reverse-geocodes the meeting point and sets its address, the walking distance stays the
+// straight-line distance from the start of the hitcher. Addresses are cached since the same points of a route
+// come back in every suggestion
+func (s *MapService) locateMeetingPoint(ctx context.Context, meetingPoint *schemas.MeetingPoint) {
+	cacheKey := fmt.Sprintf("map:address:%.5f,%.5f", meetingPoint.Latitude, meetingPoint.Longitude)
+	address, err := s.redisClient.Get(ctx, cacheKey).Result()
+	if err == nil {
+		meetingPoint.Address = address
+		return
+	} else if err != redis.Nil {
+		log.Printf("Failed to get cached address: %v", err)
+	}
+
+	geoCode, err := s.reverseGeocode(schemas.Point{Lat: meetingPoint.Latitude, Lng: meetingPoint.Longitude})
+	if err != nil {
+		log.Printf("Failed to reverse geocode the meeting point %f,%f: %v", meetingPoint.Latitude, meetingPoint.Longitude, err)
+		return
+	}
+	if len(geoCode.Results) == 0 {
+		return
+	}
+
+	meetingPoint.Address = geoCode.Results[0].FormattedAddress
+	if err := s.redisClient.Set(ctx, cacheKey, meetingPoint.Address, AddressCacheDuration).Err(); err != nil {
+		log.Printf("Failed to cache address: %v", err)
+	}
+}
+
+// SuggestItineraries returns the journeys of the ride request on two ride offers, the hitcher waits at most
+// TRANSFER_MAX_WAIT minutes and walks at most TRANSFER_MAX_WALK meters at the transfer
+func (s *MapService) SuggestItineraries(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID) ([]repository.Itinerary, error) {
+	itineraries, err := s.repo.SuggestItineraries(userID, rideRequestID, float64(s.cfg.TransferMaxWalk)/1000, time.Duration(s.cfg.TransferMaxWait)*time.Minute)
+	if err != nil {
+		return nil, err
+	}
+
+	meetingPoints := make([]*schemas.MeetingPoint, 0, 2*len(itineraries))
+	for i := range itineraries {
+		meetingPoints = append(meetingPoints, &itineraries[i].MeetingPoint, &itineraries[i].Transfer.Pickup)
+	}
+	s.locateMeetingPoints(ctx, meetingPoints)
+	for i := range itineraries {
+		setTransferAddress(&itineraries[i])
+	}
+
+	return itineraries, nil
+}
+
+// GetItinerary returns the journey of the ride request on the two ride offers, with the same limits as the suggestions
+func (s *MapService) GetItinerary(ctx context.Context, userID uuid.UUID, rideRequestID, firstRideOfferID, secondRideOfferID uuid.UUID) (repository.Itinerary, error) {
+	itinerary, err := s.repo.GetItinerary(userID, rideRequestID, firstRideOfferID, secondRideOfferID, float64(s.cfg.TransferMaxWalk)/1000, time.Duration(s.cfg.TransferMaxWait)*time.Minute)
+	if err != nil {
+		return repository.Itinerary{}, err
+	}
+
+	s.locateMeetingPoints(ctx, []*schemas.MeetingPoint{&itinerary.MeetingPoint, &itinerary.Transfer.Pickup})
+	setTransferAddress(&itinerary)
+	return itinerary, nil
+}
+
+// setTransferAddress uses the reverse-geocoded address of the transfer as where the first leg ends and the second leg starts
+func setTransferAddress(itinerary *repository.Itinerary) {
+	itinerary.FirstLeg.EndAddress = itinerary.Transfer.Pickup.Address
+	itinerary.SecondLeg.StartAddress = itinerary.Transfer.Pickup.Address
+}
+
+// SubscribeGiveRides turns on or off the alerts of the new ride offers matching the ride request of the hitcher
+func (s *MapService) SubscribeGiveRides(input schemas.SubscribeGiveRidesRequest, userID uuid.UUID) error {
+	return s.repo.SetRideRequestMatchAlerts(input.RideRequestID, userID, *input.Subscribe)
+}
+
+// SubscribeHitchRides turns on or off the alerts of the new ride requests matching the ride offer of the driver
+func (s *MapService) SubscribeHitchRides(input schemas.SubscribeHitchRidesRequest, userID uuid.UUID) error {
+	return s.repo.SetRideOfferMatchAlerts(input.RideOfferID, userID, *input.Subscribe)
+}
+
+// SuggestRematchRideOffers returns the best ride offers for a ride request whose driver cancelled, without the cancelled ride offer
+func (s *MapService) SuggestRematchRideOffers(ctx context.Context, userID uuid.UUID, rideRequestID uuid.UUID, cancelledRideOfferID uuid.UUID) ([]repository.RideOfferMatch, error) {
+	matches, err := s.repo.SuggestRideOffers(userID, rideRequestID)
+	if err != nil {
+		return nil, err
+	}
+
+	// The matches are sorted from the best one
+	rematches := make([]repository.RideOfferMatch, 0, s.cfg.RematchSuggestionLimit)
+	for _, match := range matches {
+		if len(rematches) == s.cfg.RematchSuggestionLimit {
+			break
+		}
+		if match.RideOffer.ID == cancelledRideOfferID {
+			continue
+		}
+		rematches = append(rematches, match)
+	}
+
+	return rematches, nil
 }
 
 // GetAllWaypoints returns all waypoints for the given ride offer ID
@@ -583,3 +1072,142 @@ func (s *MapService) GetAllWaypoints(rideOfferID uuid.UUID) ([]migration.Waypoin
 
 // Make sure MapsService implements IMapsService
 var _ IMapService = (*MapService)(nil)
+
+// CreateRecurringGiveRide creates a recurring give ride, its ride offers are created by the scheduler ahead of each departure
+func (s *MapService) CreateRecurringGiveRide(input schemas.CreateRecurringGiveRideRequest, userID uuid.UUID) (migration.RecurringRideOffer, error) {
+	recurringRideOffer := migration.RecurringRideOffer{
+		UserID:        userID,
+		VehicleID:     input.VehicleID,
+		PlaceList:     strings.Join(input.PlaceList, ","),
+		DaysOfWeek:    helper.JoinDaysOfWeek(input.DaysOfWeek),
+		DepartureTime: input.DepartureTime,
+		Seats:         input.Seats,
+		RideAmenities: migration.RideAmenities(input.Amenities),
+	}
+	if input.Preferences != nil {
+		recurringRideOffer.CustomPreferences = true
+		recurringRideOffer.MatchPreferences = migration.MatchPreferences(*input.Preferences)
+	}
+
+	return s.repo.CreateRecurringRideOffer(recurringRideOffer)
+}
+
+// GetRecurringGiveRides returns the recurring give rides of the user
+func (s *MapService) GetRecurringGiveRides(userID uuid.UUID) ([]migration.RecurringRideOffer, error) {
+	return s.repo.GetRecurringRideOffersByUser(userID)
+}
+
+// SkipRecurringGiveRide skips a single occurrence of a recurring give ride
+func (s *MapService) SkipRecurringGiveRide(input schemas.SkipRecurringGiveRideRequest, userID uuid.UUID) error {
+	recurringRideOffer, err := s.repo.GetRecurringRideOfferByID(input.RecurringRideOfferID, userID)
+	if err != nil {
+		return err
+	}
+
+	location, err := time.LoadLocation("Asia/Bangkok") // GMT+7
+	if err != nil {
+		return fmt.Errorf("failed to load location: %w", err)
+	}
+
+	date, err := time.ParseInLocation("2006-01-02", input.Date, location)
+	if err != nil {
+		return fmt.Errorf("failed to parse date: %w", err)
+	}
+
+	departure, err := time.Parse("15:04", recurringRideOffer.DepartureTime)
+	if err != nil {
+		return fmt.Errorf("failed to parse departure time: %w", err)
+	}
+
+	startTime := time.Date(date.Year(), date.Month(), date.Day(), departure.Hour(), departure.Minute(), 0, 0, location)
+	if startTime.Before(time.Now()) || !slices.Contains(helper.SplitDaysOfWeek(recurringRideOffer.DaysOfWeek), int(startTime.Weekday())) {
+		return ErrInvalidOccurrenceDate
+	}
+
+	return s.repo.SkipRecurringRideOfferOccurrence(recurringRideOffer, occurrenceDate(startTime), startTime.UTC())
+}
+
+// PauseRecurringGiveRide stops creating ride offers for a recurring give ride until it is resumed
+func (s *MapService) PauseRecurringGiveRide(recurringRideOfferID, userID uuid.UUID) error {
+	recurringRideOffer, err := s.repo.GetRecurringRideOfferByID(recurringRideOfferID, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.SetRecurringRideOfferStatus(recurringRideOffer, repository.RecurringRideOfferPaused)
+}
+
+// ResumeRecurringGiveRide starts creating ride offers for a paused recurring give ride again
+func (s *MapService) ResumeRecurringGiveRide(recurringRideOfferID, userID uuid.UUID) error {
+	recurringRideOffer, err := s.repo.GetRecurringRideOfferByID(recurringRideOfferID, userID)
+	if err != nil {
+		return err
+	}
+
+	return s.repo.SetRecurringRideOfferStatus(recurringRideOffer, repository.RecurringRideOfferActive)
+}
+
+// MaterializeRecurringGiveRides creates the ride offers of every active recurring give ride departing within the lead time,
+// it is run periodically by the scheduler so occurrences that fail (e.g. Goong API is down) are retried on the next run
+func (s *MapService) MaterializeRecurringGiveRides() error {
+	location, err := time.LoadLocation("Asia/Bangkok") // GMT+7
+	if err != nil {
+		return fmt.Errorf("failed to load location: %w", err)
+	}
+
+	recurringRideOffers, err := s.repo.GetActiveRecurringRideOffers()
+	if err != nil {
+		return fmt.Errorf("failed to get active recurring ride offers: %w", err)
+	}
+
+	now := time.Now().In(location)
+	for _, recurringRideOffer := range recurringRideOffers {
+		departure, err := time.Parse("15:04", recurringRideOffer.DepartureTime)
+		if err != nil {
+			log.Printf("Invalid departure time of recurring ride offer %s: %v", recurringRideOffer.ID, err)
+			continue
+		}
+		days := helper.SplitDaysOfWeek(recurringRideOffer.DaysOfWeek)
+
+		for day := 0; day <= int(RecurringGiveRideLeadTime/(24*time.Hour)); day++ {
+			date := now.AddDate(0, 0, day)
+			startTime := time.Date(date.Year(), date.Month(), date.Day(), departure.Hour(), departure.Minute(), 0, 0, location)
+			if startTime.Before(now) || startTime.After(now.Add(RecurringGiveRideLeadTime)) || !slices.Contains(days, int(startTime.Weekday())) {
+				continue
+			}
+
+			handled, err := s.repo.IsRecurringOccurrenceHandled(recurringRideOffer.ID, occurrenceDate(startTime), startTime.UTC())
+			if err != nil {
+				log.Printf("Failed to check occurrence of recurring ride offer %s at %s: %v", recurringRideOffer.ID, startTime, err)
+				continue
+			}
+			if handled {
+				continue
+			}
+
+			input := schemas.GiveRideRequest{
+				PlaceList:            strings.Split(recurringRideOffer.PlaceList, ","),
+				StartTime:            startTime.Format("2006-01-02T15:04:05"),
+				VehicleID:            recurringRideOffer.VehicleID,
+				Seats:                recurringRideOffer.Seats,
+				Amenities:            schemas.RideAmenities(recurringRideOffer.RideAmenities),
+				RecurringRideOfferID: &recurringRideOffer.ID,
+			}
+			if recurringRideOffer.CustomPreferences {
+				preferences := schemas.MatchPreferences(recurringRideOffer.MatchPreferences)
+				input.Preferences = &preferences
+			}
+			// The ride offer is created already linked, so the occurrence is never created twice
+			if _, _, err := s.CreateGiveRide(context.Background(), input, recurringRideOffer.UserID); err != nil {
+				log.Printf("Failed to create ride offer of recurring ride offer %s at %s: %v", recurringRideOffer.ID, startTime, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// occurrenceDate returns the calendar date (in GMT+7) of an occurrence as stored in the skip table
+func occurrenceDate(startTime time.Time) time.Time {
+	return time.Date(startTime.Year(), startTime.Month(), startTime.Day(), 0, 0, 0, 0, time.UTC)
+}
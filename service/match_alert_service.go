@@ -0,0 +1,306 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"shareway/helper"
+	"shareway/infra/db/migration"
+	"shareway/infra/task"
+	"shareway/repository"
+	"shareway/schemas"
+	"shareway/util"
+	"shareway/util/assignment"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+type IMatchAlertService interface {
+	AlertRideOfferMatches(rideOfferID uuid.UUID) error
+	AlertRideRequestMatches(rideRequestID uuid.UUID) error
+	ProposeBatchMatches() error
+}
+
+// MatchAlertService notifies the users who subscribed their open ride offer or ride request to the new matches,
+// so a hitcher who got no suggestion does not have to keep asking for them. It runs in the asynq task processor.
+// It also runs the batch matcher that pairs all the open ride offers and ride requests at once
+type MatchAlertService struct {
+	repo        repository.IMapsRepository
+	redisClient *redis.Client
+	asyncClient *task.AsyncClient
+	cfg         util.Config
+}
+
+func NewMatchAlertService(repo repository.IMapsRepository, redisClient *redis.Client, asyncClient *task.AsyncClient, cfg util.Config) IMatchAlertService {
+	return &MatchAlertService{
+		repo:        repo,
+		redisClient: redisClient,
+		asyncClient: asyncClient,
+		cfg:         cfg,
+	}
+}
+
+// AlertRideOfferMatches notifies the hitchers whose subscribed ride requests match the new ride offer
+func (s *MatchAlertService) AlertRideOfferMatches(rideOfferID uuid.UUID) error {
+	rideOffer, err := s.repo.GetRideOfferDetails(rideOfferID)
+	if err != nil {
+		return err
+	}
+
+	// The suggestions already match the routes (helper.IsMatchRoute) and the time windows (helper.IsTimeOverlap)
+	matches, err := s.repo.SuggestRideRequests(rideOffer.UserID, rideOfferID)
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if !match.RideRequest.MatchAlerts {
+			continue
+		}
+
+		s.notify(match.RideRequest.User, "ride-offer-match", schemas.MatchAlertResponse{
+			RideOfferID:   rideOffer.ID,
+			RideRequestID: match.RideRequest.ID,
+			User:          toUserInfo(rideOffer.User),
+			StartAddress:  rideOffer.StartAddress,
+			EndAddress:    rideOffer.EndAddress,
+			StartTime:     rideOffer.StartTime,
+			SegmentFare:   match.SegmentFare,
+			Match:         match.Score,
+		}, "Có chuyến đi mới phù hợp", fmt.Sprintf("Tài xế %s vừa tạo chuyến đi phù hợp với yêu cầu của bạn", rideOffer.User.FullName))
+	}
+
+	return nil
+}
+
+// AlertRideRequestMatches notifies the drivers whose subscribed ride offers match the new ride request
+func (s *MatchAlertService) AlertRideRequestMatches(rideRequestID uuid.UUID) error {
+	rideRequest, err := s.repo.GetRideRequestDetails(rideRequestID)
+	if err != nil {
+		return err
+	}
+
+	// The suggestions already match the routes (helper.IsMatchRoute) and the time windows (helper.IsTimeOverlap)
+	matches, err := s.repo.SuggestRideOffers(rideRequest.UserID, rideRequestID)
+	if err != nil {
+		return err
+	}
+
+	for _, match := range matches {
+		if !match.RideOffer.MatchAlerts {
+			continue
+		}
+
+		s.notify(match.RideOffer.User, "ride-request-match", schemas.MatchAlertResponse{
+			RideOfferID:   match.RideOffer.ID,
+			RideRequestID: rideRequest.ID,
+			User:          toUserInfo(rideRequest.User),
+			StartAddress:  rideRequest.StartAddress,
+			EndAddress:    rideRequest.EndAddress,
+			StartTime:     rideRequest.StartTime,
+			SegmentFare:   match.SegmentFare,
+			Match:         match.Score,
+		}, "Có yêu cầu đi nhờ mới phù hợp", fmt.Sprintf("%s vừa tạo yêu cầu đi nhờ phù hợp với chuyến đi của bạn", rideRequest.User.FullName))
+	}
+
+	return nil
+}
+
+// ProposeBatchMatches pairs the open ride offers and ride requests starting in the next BATCH_MATCHING_WINDOW minutes
+// so the total cost of the pairs is the lowest (the cost is the opposite of the match score, which accounts for the
+// detour, the time fit and the rating of the driver) and sends each pair to both sides as a proposal they can accept.
+// A pair is proposed only once, an unanswered proposal keeps its seat in the next runs until it expires after the window
+func (s *MatchAlertService) ProposeBatchMatches() error {
+	now := time.Now()
+	window := time.Duration(s.cfg.BatchMatchingWindow) * time.Minute
+
+	rideOffers, err := s.repo.GetOpenRideOffers(now, now.Add(window))
+	if err != nil {
+		return err
+	}
+	rideRequests, err := s.repo.GetOpenRideRequests(now, now.Add(window))
+	if err != nil {
+		return err
+	}
+	if len(rideOffers) == 0 || len(rideRequests) == 0 {
+		return nil
+	}
+
+	ctx := context.Background()
+	reserved := s.getReservedSeats(ctx, rideOffers, rideRequests)
+
+	offerPolylines := make([][]schemas.Point, len(rideOffers))
+	capacity := make([]int, len(rideOffers))
+	for j, rideOffer := range rideOffers {
+		offerPolylines[j] = helper.DecodePolyline(string(rideOffer.EncodedPolyline))
+		capacity[j] = rideOffer.AvailableSeats
+	}
+	for _, j := range reserved {
+		capacity[j]--
+	}
+
+	// Same rules as the suggestions, the pairs that do not match are infeasible
+	cost := make([][]float64, len(rideRequests))
+	scores := make([][]schemas.MatchScore, len(rideRequests))
+	for i, rideRequest := range rideRequests {
+		requestPolyline := helper.DecodePolyline(string(rideRequest.EncodedPolyline))
+		cost[i] = make([]float64, len(rideOffers))
+		scores[i] = make([]schemas.MatchScore, len(rideOffers))
+		for j, rideOffer := range rideOffers {
+			cost[i][j] = assignment.Infeasible
+			if _, ok := reserved[i]; ok {
+				continue
+			}
+			if rideOffer.UserID == rideRequest.UserID || !helper.IsMatchRoute(offerPolylines[j], requestPolyline) ||
+				!helper.IsTimeOverlap(rideOffer, rideRequest) || !helper.IsPreferenceMatch(rideOffer, rideOffer.User, rideRequest, rideRequest.User) ||
+				!helper.IsAmenityMatch(rideOffer, rideRequest) {
+				continue
+			}
+			scores[i][j] = helper.ScoreMatch(rideOffer, offerPolylines[j], rideRequest, rideOffer.User.AverageRating)
+			cost[i][j] = 100 - scores[i][j].Score
+		}
+	}
+
+	for i, j := range assignment.Solve(cost, capacity) {
+		if j < 0 {
+			continue
+		}
+		rideOffer, rideRequest := rideOffers[j], rideRequests[i]
+
+		key := fmt.Sprintf("ride:proposal:%s:%s", rideOffer.ID, rideRequest.ID)
+		ok, err := s.redisClient.SetNX(ctx, key, 1, window).Result()
+		if err != nil {
+			log.Printf("Failed to record the proposal of ride offer %s to ride request %s: %v", rideOffer.ID, rideRequest.ID, err)
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		// Hold the seat for the ride request until the proposal is answered or expires
+		if err := s.redisClient.Set(ctx, reservationKey(rideRequest.ID), rideOffer.ID.String(), window).Err(); err != nil {
+			log.Printf("Failed to reserve a seat of ride offer %s for ride request %s: %v", rideOffer.ID, rideRequest.ID, err)
+		}
+
+		res := schemas.MatchProposalResponse{
+			RideOfferID:   rideOffer.ID,
+			RideRequestID: rideRequest.ID,
+			VehicleID:     rideOffer.VehicleID,
+			Driver:        toUserInfo(rideOffer.User),
+			Hitcher:       toUserInfo(rideRequest.User),
+			StartAddress:  rideRequest.StartAddress,
+			EndAddress:    rideRequest.EndAddress,
+			StartTime:     rideRequest.StartTime,
+			SegmentFare:   helper.CalculateSegmentFare(rideOffer, rideRequest),
+			Match:         scores[i][j],
+		}
+
+		res.ReceiverID = rideOffer.UserID
+		s.notify(rideRequest.User, "match-proposal", res, "Chúng tôi đã tìm được tài xế cho bạn",
+			fmt.Sprintf("Tài xế %s có thể chở bạn, hãy xác nhận để đặt chuyến", rideOffer.User.FullName))
+
+		res.ReceiverID = rideRequest.UserID
+		s.notify(rideOffer.User, "match-proposal", res, "Chúng tôi đã tìm được người đi nhờ cho bạn",
+			fmt.Sprintf("%s có thể đi cùng chuyến của bạn, hãy xác nhận để nhận chuyến", rideRequest.User.FullName))
+	}
+
+	return nil
+}
+
+// reservationKey is the key of the ride offer proposed to the ride request
+func reservationKey(rideRequestID uuid.UUID) string {
+	return fmt.Sprintf("ride:proposal:request:%s", rideRequestID)
+}
+
+// getReservedSeats returns the ride offer (by index) proposed to each ride request (by index) whose proposal is
+// still unanswered. A ride request proposed to a ride offer that is no longer open is free again
+func (s *MatchAlertService) getReservedSeats(ctx context.Context, rideOffers []migration.RideOffer, rideRequests []migration.RideRequest) map[int]int {
+	reserved := make(map[int]int)
+
+	keys := make([]string, len(rideRequests))
+	for i, rideRequest := range rideRequests {
+		keys[i] = reservationKey(rideRequest.ID)
+	}
+	values, err := s.redisClient.MGet(ctx, keys...).Result()
+	if err != nil {
+		log.Printf("Failed to get the reserved seats of the proposals: %v", err)
+		return reserved
+	}
+
+	offerIndex := make(map[string]int, len(rideOffers))
+	for j, rideOffer := range rideOffers {
+		offerIndex[rideOffer.ID.String()] = j
+	}
+	for i, value := range values {
+		rideOfferID, ok := value.(string)
+		if !ok {
+			continue
+		}
+		if j, ok := offerIndex[rideOfferID]; ok {
+			reserved[i] = j
+		}
+	}
+
+	return reserved
+}
+
+// notify sends the match or the proposal to the user through the websocket and FCM queues
+func (s *MatchAlertService) notify(user migration.User, messageType string, res interface{}, title, body string) {
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  user.ID.String(),
+		Type:    messageType,
+		Payload: res,
+	}
+	if err := s.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+		log.Printf("Failed to enqueue websocket message: %v", err)
+	}
+
+	if user.DeviceToken == "" {
+		return
+	}
+
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: messageType,
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notification := schemas.Notification{
+		Title: title,
+		Body:  body,
+		Token: user.DeviceToken,
+		Data:  notificationPayloadMap,
+	}
+	if err := s.asyncClient.EnqueueFCMNotification(notification); err != nil {
+		log.Printf("Failed to enqueue FCM notification: %v", err)
+	}
+}
+
+// toUserInfo returns the public details of the user shown with a match
+func toUserInfo(user migration.User) schemas.UserInfo {
+	return schemas.UserInfo{
+		ID:            user.ID,
+		FullName:      user.FullName,
+		PhoneNumber:   user.PhoneNumber,
+		AvatarURL:     user.AvatarURL,
+		Gender:        user.Gender,
+		IsMomoLinked:  user.IsMomoLinked,
+		BalanceInApp:  user.BalanceInApp,
+		AverageRating: user.AverageRating,
+	}
+}
+
+// Make sure MatchAlertService implements IMatchAlertService
+var _ IMatchAlertService = (*MatchAlertService)(nil)
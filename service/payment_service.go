@@ -11,10 +11,13 @@ import (
 	"encoding/hex"
 	"encoding/json"
 	"encoding/pem"
+	"errors"
 	"fmt"
 	"net/http"
 	"time"
 
+	"shareway/helper"
+	"shareway/infra/db/migration"
 	"shareway/infra/ws"
 	"shareway/repository"
 	"shareway/schemas"
@@ -22,6 +25,7 @@ import (
 
 	"github.com/google/uuid"
 	"github.com/rs/zerolog/log"
+	"gorm.io/gorm"
 )
 
 type PaymentService struct {
@@ -34,7 +38,8 @@ type IPaymentService interface {
 	LinkMomoWallet(userID uuid.UUID, walletPhoneNumber string) (schemas.LinkWalletResponse, error)
 	CheckoutRide(userID uuid.UUID, req schemas.CheckoutRideRequest) error
 	encryptRSA(data interface{}) (string, error)
-	RefundRide(userID uuid.UUID, req schemas.RefundMomoRequest) error
+	RefundRide(userID uuid.UUID, req schemas.RefundMomoRequest, fee int64) error
+	RefundTransaction(transaction migration.Transaction) error
 	WithdrawMomoWallet(userID uuid.UUID) error
 }
 
@@ -181,6 +186,25 @@ func (p *PaymentService) CheckoutRide(userID uuid.UUID, req schemas.CheckoutRide
 		return fmt.Errorf("failed to get ride offer details: %w", err)
 	}
 
+	// Get ride request details
+	rideRequest, err := p.repo.GetRideRequestByID(req.RideRequestID)
+	if err != nil {
+		log.Error().Err(err).Str("rideRequestID", req.RideRequestID.String()).Msg("Failed to get ride request details")
+		return fmt.Errorf("failed to get ride request details: %w", err)
+	}
+
+	// The hitcher pays the fare stored when the ride was booked, the route of the ride offer changes with every hitcher
+	// added to it. Before the ride is booked the hitcher pays for the part of the route they ride on
+	fare := helper.CalculateSegmentFare(rideOffer, rideRequest)
+	transaction, err := p.repo.GetRideTransaction(req.RideOfferID, req.RideRequestID)
+	if err != nil && !errors.Is(err, gorm.ErrRecordNotFound) {
+		log.Error().Err(err).Str("rideRequestID", req.RideRequestID.String()).Msg("Failed to get transaction details")
+		return fmt.Errorf("failed to get transaction details: %w", err)
+	}
+	if err == nil {
+		fare = transaction.Amount
+	}
+
 	// Generate request ID
 	requestID := uuid.New().String()
 
@@ -215,7 +239,7 @@ func (p *PaymentService) CheckoutRide(userID uuid.UUID, req schemas.CheckoutRide
 	rawSignature.WriteString("accessKey=")
 	rawSignature.WriteString(p.cfg.MomoAccessKey)
 	rawSignature.WriteString("&amount=")
-	rawSignature.WriteString(fmt.Sprintf("%d", rideOffer.Fare)) // momo requires amount in integer
+	rawSignature.WriteString(fmt.Sprintf("%d", fare)) // momo requires amount in integer
 	rawSignature.WriteString("&extraData=")
 	rawSignature.WriteString(extraDataBase64)
 	rawSignature.WriteString("&orderId=")
@@ -241,7 +265,7 @@ func (p *PaymentService) CheckoutRide(userID uuid.UUID, req schemas.CheckoutRide
 		PartnerClientID: userID.String(),
 		PartnerCode:     p.cfg.MomoPartnerCode,
 		RequestID:       requestID,
-		Amount:          rideOffer.Fare,
+		Amount:          fare,
 		OrderID:         requestID,
 		OrderInfo:       "Thanh toán chuyến đi",
 		RedirectURL:     "",
@@ -334,12 +358,10 @@ func (p *PaymentService) encryptRSA(data interface{}) (string, error) {
 	return hash, nil
 }
 
-func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequest) error {
+// RefundRide refunds the hitcher the fare of the ride minus the fee kept by the cancellation policy (0 for a full refund)
+func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequest, fee int64) error {
 	log.Info().Msg("Starting RefundRide process")
 
-	// Generate a new requestId
-	requestID := uuid.New().String()
-
 	// Get the ride request details
 	rideRequest, err := p.repo.GetRideRequestByID(req.RideRequestID)
 	if err != nil {
@@ -347,19 +369,46 @@ func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequ
 		return fmt.Errorf("failed to get ride request details: %w", err)
 	}
 
-	// Get ride offer details
-	rideOffer, err := p.repo.GetRideOfferByID(req.RideOfferID)
+	// Get the transaction of the ride
+	transaction, err := p.repo.GetRideTransaction(req.RideOfferID, req.RideRequestID)
 	if err != nil {
-		log.Error().Err(err).Str("rideOfferID", req.RideOfferID.String()).Msg("Failed to get ride offer details")
-		return fmt.Errorf("failed to get ride offer details: %w", err)
+		log.Error().Err(err).Str("rideRequestID", req.RideRequestID.String()).Msg("Failed to get transaction details")
+		return fmt.Errorf("failed to get transaction details: %w", err)
 	}
 
+	// Refund the amount the hitcher was charged at checkout, minus the cancellation fee (a share of the same amount)
+	fare := transaction.Amount - fee
+	if fare <= 0 {
+		log.Info().Int64("fee", fee).Msg("Nothing left to refund after the cancellation fee")
+		return nil
+	}
+
+	// Generate a new requestId
+	return p.refundMomo(uuid.New().String(), rideRequest.MomoTransID, fare)
+}
+
+// RefundTransaction refunds the hitcher the refund amount recorded on a refunded MoMo transaction and records that the
+// refund went through. The ID of the transaction is the order ID of the refund so MoMo rejects it when sent twice
+func (p *PaymentService) RefundTransaction(transaction migration.Transaction) error {
+	log.Info().Str("transactionID", transaction.ID.String()).Msg("Starting RefundTransaction process")
+
+	if transaction.RefundAmount > 0 {
+		if err := p.refundMomo(transaction.ID.String(), transaction.MomoTransID, transaction.RefundAmount); err != nil {
+			return err
+		}
+	}
+
+	return p.repo.MarkTransactionRefunded(transaction.ID)
+}
+
+// refundMomo sends a refund of the amount of a MoMo payment, the request ID is also the order ID of the refund
+func (p *PaymentService) refundMomo(requestID string, transID int64, fare int64) error {
 	// Build request signature
 	var rawSignature bytes.Buffer
 	rawSignature.WriteString("accessKey=")
 	rawSignature.WriteString(p.cfg.MomoAccessKey)
 	rawSignature.WriteString("&amount=")
-	rawSignature.WriteString(fmt.Sprintf("%d", rideOffer.Fare))
+	rawSignature.WriteString(fmt.Sprintf("%d", fare))
 	rawSignature.WriteString("&description=")
 	rawSignature.WriteString("Hoàn tiền chuyến đi")
 	rawSignature.WriteString("&orderId=")
@@ -369,7 +418,7 @@ func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequ
 	rawSignature.WriteString("&requestId=")
 	rawSignature.WriteString(requestID)
 	rawSignature.WriteString("&transId=")
-	rawSignature.WriteString(fmt.Sprintf("%d", rideRequest.MomoTransID))
+	rawSignature.WriteString(fmt.Sprintf("%d", transID))
 
 	log.Debug().Str("rawSignature", rawSignature.String()).Msg("Built raw signature")
 
@@ -385,8 +434,8 @@ func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequ
 		PartnerCode: p.cfg.MomoPartnerCode,
 		OrderID:     requestID,
 		RequestID:   requestID,
-		Amount:      rideOffer.Fare,
-		TransID:     rideRequest.MomoTransID,
+		Amount:      fare,
+		TransID:     transID,
 		Lang:        "vi",
 		Description: "Hoàn tiền chuyến đi",
 		Signature:   signature,
@@ -443,7 +492,7 @@ func (p *PaymentService) RefundRide(userID uuid.UUID, req schemas.RefundMomoRequ
 	// 	return fmt.Errorf("failed to update refund status: %w", err)
 	// }
 
-	log.Info().Msg("Successfully completed RefundRide process")
+	log.Info().Msg("Successfully completed refund process")
 	return nil
 }
 
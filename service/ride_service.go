@@ -1,19 +1,31 @@
 package service
 
 import (
+	"errors"
+	"time"
+
+	"shareway/helper"
 	"shareway/infra/db/migration"
 	"shareway/infra/ws"
 	"shareway/repository"
 	"shareway/schemas"
 	"shareway/util"
+	"shareway/util/cancellation"
+	"shareway/util/statemachine"
 
 	"github.com/google/uuid"
 )
 
+var (
+	ErrOutsideGeofence  = errors.New("current location is too far from the expected point of the ride")
+	ErrRideNotScheduled = errors.New("ride is not scheduled")
+)
+
 type RideService struct {
-	repo repository.IRideRepository
-	hub  *ws.Hub
-	cfg  util.Config
+	repo   repository.IRideRepository
+	hub    *ws.Hub
+	policy *cancellation.Policy
+	cfg    util.Config
 }
 
 type IRideService interface {
@@ -22,12 +34,16 @@ type IRideService interface {
 	GetRideOfferByID(rideOfferID uuid.UUID) (migration.RideOffer, error)
 	GetRideRequestByID(rideRequestID uuid.UUID) (migration.RideRequest, error)
 	GetTransactionByRideID(rideID uuid.UUID) (migration.Transaction, error)
-	AcceptRideRequest(rideOfferID, rideRequestID, vehicleID uuid.UUID) (migration.Ride, error)
+	AcceptRideRequest(rideOfferID, rideRequestID, vehicleID, userID uuid.UUID) (migration.Ride, error)
+	BookItinerary(itinerary repository.Itinerary, userID uuid.UUID) (migration.Ride, migration.Ride, error)
+	GetItineraryLegRides(rideRequestID uuid.UUID) ([]migration.Ride, error)
 	CreateRideTransaction(rideID uuid.UUID, Fare int64, paymentMethod string, payerID uuid.UUID, receiverID uuid.UUID) (migration.Transaction, error)
 	StartRide(req schemas.StartRideRequest, userID uuid.UUID) (migration.Ride, error)
 	EndRide(req schemas.EndRideRequest, userID uuid.UUID) (migration.Ride, error)
 	UpdateRideLocation(req schemas.UpdateRideLocationRequest, userID uuid.UUID) (migration.Ride, error)
-	CancelRide(req schemas.CancelRideRequest, userID uuid.UUID) (migration.Ride, error)
+	CancelRide(req schemas.CancelRideRequest, userID uuid.UUID, decision cancellation.Decision) (migration.Ride, error)
+	EvaluateCancellation(ride migration.Ride, userID uuid.UUID) (cancellation.Decision, error)
+	EvaluateNoShow(ride migration.Ride, req schemas.ReportNoShowRequest, userID uuid.UUID) (cancellation.Decision, error)
 	GetAllPendingRide(userID uuid.UUID) ([]migration.RideOffer, []migration.RideRequest, error)
 	GetRideByID(rideID uuid.UUID) (migration.Ride, error)
 	RatingRideHitcher(req schemas.RatingRideHitcherRequest, userID uuid.UUID) error
@@ -36,13 +52,16 @@ type IRideService interface {
 	GetTotalRidesForUser(userID uuid.UUID) (int64, error)
 	GetTotalRidesForVehicle(vehicleID uuid.UUID) (int64, error)
 	GetScheduledAndOngoingRide(userID uuid.UUID) ([]migration.Ride, error)
+	GetRideTimeline(rideID uuid.UUID) ([]schemas.RideEventDetail, error)
+	GetRideReplay(ride migration.Ride) (schemas.GetRideReplayResponse, error)
 }
 
-func NewRideService(repo repository.IRideRepository, hub *ws.Hub, cfg util.Config) IRideService {
+func NewRideService(repo repository.IRideRepository, hub *ws.Hub, policy *cancellation.Policy, cfg util.Config) IRideService {
 	return &RideService{
-		repo: repo,
-		hub:  hub,
-		cfg:  cfg,
+		repo:   repo,
+		hub:    hub,
+		policy: policy,
+		cfg:    cfg,
 	}
 }
 
@@ -62,8 +81,18 @@ func (s *RideService) GetRideRequestByID(rideRequestID uuid.UUID) (migration.Rid
 }
 
 // AcceptGiveRideRequest accepts a give ride request
-func (s *RideService) AcceptRideRequest(rideOfferID, rideRequestID, vehicleID uuid.UUID) (migration.Ride, error) {
-	return s.repo.AcceptRideRequest(rideOfferID, rideRequestID, vehicleID)
+func (s *RideService) AcceptRideRequest(rideOfferID, rideRequestID, vehicleID, userID uuid.UUID) (migration.Ride, error) {
+	return s.repo.AcceptRideRequest(rideOfferID, rideRequestID, vehicleID, userID)
+}
+
+// BookItinerary books both legs of an itinerary for the hitcher with their cash transactions, or none of them
+func (s *RideService) BookItinerary(itinerary repository.Itinerary, userID uuid.UUID) (migration.Ride, migration.Ride, error) {
+	return s.repo.BookItinerary(itinerary, userID)
+}
+
+// GetItineraryLegRides returns the scheduled rides of the other legs of the itinerary the ride request is a leg of
+func (s *RideService) GetItineraryLegRides(rideRequestID uuid.UUID) ([]migration.Ride, error) {
+	return s.repo.GetItineraryLegRides(rideRequestID)
 }
 
 // CreateRideTransaction creates a transaction for a ride
@@ -71,8 +100,18 @@ func (s *RideService) CreateRideTransaction(rideID uuid.UUID, Fare int64, paymen
 	return s.repo.CreateRideTransaction(rideID, Fare, paymentMethod, payerID, receiverID)
 }
 
-// StartRide starts a ride
+// StartRide starts a ride, the driver must be within the geofence radius of the pickup point unless the geofence is overridden
 func (s *RideService) StartRide(req schemas.StartRideRequest, userID uuid.UUID) (migration.Ride, error) {
+	inside, err := s.withinGeofence(req.RideID, statemachine.StatusScheduled, req.CurrentLocation, helper.PickupPoint)
+	if err != nil {
+		return migration.Ride{}, err
+	}
+	if !inside && !req.OverrideGeofence {
+		return migration.Ride{}, ErrOutsideGeofence
+	}
+
+	// Only audit the override when the geofence was actually bypassed
+	req.OverrideGeofence = !inside
 	return s.repo.StartRide(req, userID)
 }
 
@@ -81,19 +120,118 @@ func (s *RideService) GetTransactionByRideID(rideID uuid.UUID) (migration.Transa
 	return s.repo.GetTransactionByRideID(rideID)
 }
 
-// EndRide ends a ride
+// EndRide ends a ride, the driver must be within the geofence radius of the drop-off point unless the geofence is overridden
 func (s *RideService) EndRide(req schemas.EndRideRequest, userID uuid.UUID) (migration.Ride, error) {
+	inside, err := s.withinGeofence(req.RideID, statemachine.StatusOngoing, req.CurrentLocation, func(_ migration.Ride, rideRequest migration.RideRequest) schemas.Point {
+		return schemas.Point{Lat: rideRequest.EndLatitude, Lng: rideRequest.EndLongitude}
+	})
+	if err != nil {
+		return migration.Ride{}, err
+	}
+	if !inside && !req.OverrideGeofence {
+		return migration.Ride{}, ErrOutsideGeofence
+	}
+
+	// Only audit the override when the geofence was actually bypassed
+	req.OverrideGeofence = !inside
 	return s.repo.EndRide(req, userID)
 }
 
+// withinGeofence reports whether the current location is within the geofence radius of the expected point of the ride.
+// Rides that are not in the expected status are reported as inside so the status check of the repository rejects them
+func (s *RideService) withinGeofence(rideID uuid.UUID, status string, current schemas.Point, expected func(migration.Ride, migration.RideRequest) schemas.Point) (bool, error) {
+	ride, err := s.repo.GetRideByID(rideID)
+	if err != nil {
+		return false, err
+	}
+	if ride.Status != status {
+		return true, nil
+	}
+
+	rideRequest, err := s.repo.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		return false, err
+	}
+
+	return helper.DistanceInMeters(current, expected(ride, rideRequest)) <= float64(s.cfg.GeofenceRadius), nil
+}
+
 // UpdateRideLocation updates the location of a ride
 func (s *RideService) UpdateRideLocation(req schemas.UpdateRideLocationRequest, userID uuid.UUID) (migration.Ride, error) {
 	return s.repo.UpdateRideLocation(req, userID)
 }
 
-// CancelRideByDriver cancels a ride by the driver
-func (s *RideService) CancelRide(req schemas.CancelRideRequest, userID uuid.UUID) (migration.Ride, error) {
-	return s.repo.CancelRide(req, userID)
+// CancelRide cancels a ride by the driver or the hitcher with the outcome of the cancellation policy
+func (s *RideService) CancelRide(req schemas.CancelRideRequest, userID uuid.UUID, decision cancellation.Decision) (migration.Ride, error) {
+	return s.repo.CancelRide(req, userID, decision)
+}
+
+// EvaluateCancellation applies the cancellation policy to a ride the user wants to cancel
+func (s *RideService) EvaluateCancellation(ride migration.Ride, userID uuid.UUID) (cancellation.Decision, error) {
+	policyRide, err := s.policyRide(ride, userID)
+	if err != nil {
+		return cancellation.Decision{}, err
+	}
+
+	return s.policy.Cancel(policyRide, userID, time.Now()), nil
+}
+
+// EvaluateNoShow applies the cancellation policy to a no-show reported on a scheduled ride,
+// the user reporting it must be waiting within the geofence radius of the pickup point
+func (s *RideService) EvaluateNoShow(ride migration.Ride, req schemas.ReportNoShowRequest, userID uuid.UUID) (cancellation.Decision, error) {
+	policyRide, err := s.policyRide(ride, userID)
+	if err != nil {
+		return cancellation.Decision{}, err
+	}
+
+	if ride.Status != statemachine.StatusScheduled {
+		return cancellation.Decision{}, ErrRideNotScheduled
+	}
+
+	rideRequest, err := s.repo.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		return cancellation.Decision{}, err
+	}
+	if helper.DistanceInMeters(req.CurrentLocation, helper.PickupPoint(ride, rideRequest)) > float64(s.cfg.GeofenceRadius) {
+		return cancellation.Decision{}, ErrOutsideGeofence
+	}
+
+	return s.policy.NoShow(policyRide, userID, time.Now())
+}
+
+// policyRide collects what the cancellation policy needs to know about a ride, the user must be the driver or the hitcher
+func (s *RideService) policyRide(ride migration.Ride, userID uuid.UUID) (cancellation.Ride, error) {
+	rideOffer, err := s.repo.GetRideOfferByID(ride.RideOfferID)
+	if err != nil {
+		return cancellation.Ride{}, err
+	}
+	rideRequest, err := s.repo.GetRideRequestByID(ride.RideRequestID)
+	if err != nil {
+		return cancellation.Ride{}, err
+	}
+	if userID != rideOffer.UserID && userID != rideRequest.UserID {
+		return cancellation.Ride{}, ErrNotRideParticipant
+	}
+
+	// The fee is a share of what the hitcher paid, fall back to the fare of the ride when there is no transaction yet.
+	// It is only kept from a MoMo payment, a cash fare is never collected
+	fare := ride.Fare
+	prepaid := false
+	transaction, err := s.repo.GetTransactionByRideID(ride.ID)
+	if err == nil {
+		fare = transaction.Amount
+		prepaid = transaction.PaymentMethod == "momo"
+	}
+
+	return cancellation.Ride{
+		BookedAt:  ride.CreatedAt,
+		StartTime: ride.StartTime,
+		Fare:      fare,
+		DriverID:  rideOffer.UserID,
+		HitcherID: rideRequest.UserID,
+		Started:   ride.Status == statemachine.StatusOngoing,
+		Prepaid:   prepaid,
+	}, nil
 }
 
 func (s *RideService) GetChatRoomByUserIDs(userID1, userID2 uuid.UUID) (migration.Room, error) {
@@ -132,5 +270,44 @@ func (s *RideService) GetScheduledAndOngoingRide(userID uuid.UUID) ([]migration.
 	return s.repo.GetScheduledAndOngoingRide(userID)
 }
 
+// GetRideTimeline fetches every event of a ride in chronological order
+func (s *RideService) GetRideTimeline(rideID uuid.UUID) ([]schemas.RideEventDetail, error) {
+	return s.repo.GetRideTimeline(rideID)
+}
+
+// GetRideReplay returns the planned route of a ride along with the path the driver actually took
+func (s *RideService) GetRideReplay(ride migration.Ride) (schemas.GetRideReplayResponse, error) {
+	breadcrumbs, err := s.repo.GetRideBreadcrumbs(ride.ID)
+	if err != nil {
+		return schemas.GetRideReplayResponse{}, err
+	}
+
+	points := helper.BreadcrumbPoints(breadcrumbs)
+	details := make([]schemas.RideBreadcrumbDetail, 0, len(breadcrumbs))
+	for _, breadcrumb := range breadcrumbs {
+		details = append(details, schemas.RideBreadcrumbDetail{
+			Latitude:   breadcrumb.Latitude,
+			Longitude:  breadcrumb.Longitude,
+			RecordedAt: breadcrumb.CreatedAt,
+		})
+	}
+
+	// The actual distance is only stored once the ride ends, compute it from the path recorded so far before that
+	actualDistance := ride.ActualDistance
+	if ride.Status != statemachine.StatusCompleted {
+		actualDistance = helper.PathDistance(points)
+	}
+
+	return schemas.GetRideReplayResponse{
+		RideID:          ride.ID,
+		Status:          ride.Status,
+		PlannedPolyline: string(ride.EncodedPolyline),
+		PlannedDistance: ride.Distance,
+		ActualPolyline:  helper.EncodePolyline(points),
+		ActualDistance:  actualDistance,
+		Breadcrumbs:     details,
+	}, nil
+}
+
 // Make sure the RideService implements the IRideService interface
 var _ IRideService = (*RideService)(nil)
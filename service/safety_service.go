@@ -0,0 +1,433 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+	"time"
+
+	"shareway/helper"
+	"shareway/infra/db/migration"
+	"shareway/infra/otp"
+	"shareway/infra/task"
+	"shareway/repository"
+	"shareway/schemas"
+	"shareway/util"
+	"shareway/util/statemachine"
+	"shareway/util/token"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+// Types of the safety alerts raised by the monitor and the SOS button
+const (
+	SafetyAlertRouteDeviation = "route_deviation"
+	SafetyAlertLongStop       = "long_stop"
+	SafetyAlertSOS            = "sos"
+)
+
+const (
+	// SafetyAlertCooldown is how long a ride waits before raising another alert of the same type
+	SafetyAlertCooldown = 15 * time.Minute
+	// safetyStopRadius is the distance in meters the driver can move while still being considered stopped
+	safetyStopRadius = 50.0
+	// safetyStopExpiration is how long the last moving location of a ride is kept
+	safetyStopExpiration = 24 * time.Hour
+	// MaxTrustedContacts is the number of trusted contacts a user can register
+	MaxTrustedContacts = 5
+)
+
+var (
+	ErrNotRideParticipant     = errors.New("user is not a participant of the ride")
+	ErrRideNotOngoing         = errors.New("ride is not ongoing")
+	ErrTooManyTrustedContacts = errors.New("maximum number of trusted contacts reached")
+	ErrRideNotActive          = errors.New("ride is not scheduled or ongoing")
+	ErrInvalidShareToken      = errors.New("invalid ride share token")
+)
+
+type ISafetyService interface {
+	MonitorLocation(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, location schemas.Point) error
+	RespondSafetyCheck(req schemas.RespondSafetyCheckRequest, userID uuid.UUID) (migration.SafetyAlert, error)
+	EscalateUnansweredSafetyAlerts() error
+	GetSafetyAlertList(req schemas.SafetyAlertListRequest) ([]migration.SafetyAlert, int64, int64, error)
+	ResolveSafetyAlert(req schemas.ResolveSafetyAlertRequest, adminID uuid.UUID) (migration.SafetyAlert, error)
+	TriggerSOS(req schemas.SOSRequest, userID uuid.UUID) (schemas.SOSResponse, error)
+	AddTrustedContact(req schemas.AddTrustedContactRequest, userID uuid.UUID) (migration.TrustedContact, error)
+	GetTrustedContacts(userID uuid.UUID) ([]migration.TrustedContact, error)
+	DeleteTrustedContact(contactID, userID uuid.UUID) error
+	ShareRide(req schemas.ShareRideRequest, userID uuid.UUID) (schemas.ShareRideResponse, error)
+	TrackSharedRide(shareToken string) (schemas.SharedRideLocation, error)
+}
+
+// SafetyService watches the location updates of the ongoing rides, asks the hitcher whether they are OK when the
+// driver leaves the planned route or stops for too long and escalates the alerts nobody answered to the admins.
+// It also handles the SOS button, the trusted contacts who are told by SMS when it is pressed and the read-only
+// links the participants share so their family can follow the ride
+type SafetyService struct {
+	repo        repository.ISafetyRepository
+	redisClient *redis.Client
+	asyncClient *task.AsyncClient
+	sms         otp.SMSSender
+	maker       *token.PasetoMaker
+	cfg         util.Config
+}
+
+func NewSafetyService(repo repository.ISafetyRepository, redisClient *redis.Client, asyncClient *task.AsyncClient, sms otp.SMSSender, maker *token.PasetoMaker, cfg util.Config) ISafetyService {
+	return &SafetyService{
+		repo:        repo,
+		redisClient: redisClient,
+		asyncClient: asyncClient,
+		sms:         sms,
+		maker:       maker,
+		cfg:         cfg,
+	}
+}
+
+// MonitorLocation checks a location update of an ongoing ride and raises a safety alert when the driver is too far
+// from the planned route or has not moved for SafetyStopDuration minutes
+func (s *SafetyService) MonitorLocation(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, location schemas.Point) error {
+	if ride.Status != statemachine.StatusOngoing {
+		return nil
+	}
+
+	route := helper.DecodePolyline(string(ride.EncodedPolyline))
+	if len(route) > 0 {
+		distance := helper.DistanceFromRoute(route, location)
+		if distance > float64(s.cfg.SafetyDeviationDistance) {
+			if err := s.raiseAlert(ctx, ride, rideRequest, SafetyAlertRouteDeviation, location, distance); err != nil {
+				return err
+			}
+		}
+	}
+
+	stopped, err := s.trackStop(ctx, ride.ID, location)
+	if err != nil {
+		return err
+	}
+	if stopped {
+		return s.raiseAlert(ctx, ride, rideRequest, SafetyAlertLongStop, location, 0)
+	}
+
+	return nil
+}
+
+// trackStop remembers where and since when the driver of the ride has been stopped and reports whether
+// the driver has been stopped for longer than SafetyStopDuration minutes
+func (s *SafetyService) trackStop(ctx context.Context, rideID uuid.UUID, location schemas.Point) (bool, error) {
+	key := fmt.Sprintf("ride:safety:stop:%s", rideID)
+	anchor, err := s.redisClient.HGetAll(ctx, key).Result()
+	if err != nil {
+		return false, err
+	}
+
+	lat, _ := strconv.ParseFloat(anchor["lat"], 64)
+	lng, _ := strconv.ParseFloat(anchor["lng"], 64)
+	since, _ := strconv.ParseInt(anchor["since"], 10, 64)
+
+	// The driver moved, start watching from the new location
+	if len(anchor) == 0 || helper.DistanceInMeters(schemas.Point{Lat: lat, Lng: lng}, location) > safetyStopRadius {
+		if err := s.redisClient.HSet(ctx, key, "lat", location.Lat, "lng", location.Lng, "since", time.Now().Unix()).Err(); err != nil {
+			return false, err
+		}
+		return false, s.redisClient.Expire(ctx, key, safetyStopExpiration).Err()
+	}
+
+	return time.Since(time.Unix(since, 0)) >= time.Duration(s.cfg.SafetyStopDuration)*time.Minute, nil
+}
+
+// raiseAlert creates a safety alert and asks the hitcher whether they are OK, a ride raises at most one alert
+// of each type per SafetyAlertCooldown
+func (s *SafetyService) raiseAlert(ctx context.Context, ride migration.Ride, rideRequest migration.RideRequest, alertType string, location schemas.Point, distance float64) error {
+	cooldownKey := fmt.Sprintf("ride:safety:%s:%s", ride.ID, alertType)
+	ok, err := s.redisClient.SetNX(ctx, cooldownKey, 1, SafetyAlertCooldown).Result()
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+
+	alert, err := s.repo.CreateSafetyAlert(migration.SafetyAlert{
+		RideID:    ride.ID,
+		UserID:    rideRequest.UserID,
+		Type:      alertType,
+		Latitude:  location.Lat,
+		Longitude: location.Lng,
+		Distance:  distance,
+	})
+	if err != nil {
+		return err
+	}
+
+	body := "Tài xế đã đi lệch khỏi lộ trình, bạn có ổn không?"
+	if alertType == SafetyAlertLongStop {
+		body = "Xe đã dừng lại khá lâu, bạn có ổn không?"
+	}
+	s.notify(alert, "safety-check", "Bạn có ổn không?", body)
+
+	return nil
+}
+
+// RespondSafetyCheck records the answer of the hitcher to a safety check
+func (s *SafetyService) RespondSafetyCheck(req schemas.RespondSafetyCheckRequest, userID uuid.UUID) (migration.SafetyAlert, error) {
+	return s.repo.RespondSafetyAlert(req.AlertID, userID, *req.Safe)
+}
+
+// EscalateUnansweredSafetyAlerts escalates to the admins the safety alerts the hitcher did not answer within
+// SafetyCheckTimeout minutes and lets the hitcher know. It is run periodically by the scheduler
+func (s *SafetyService) EscalateUnansweredSafetyAlerts() error {
+	before := time.Now().Add(-time.Duration(s.cfg.SafetyCheckTimeout) * time.Minute)
+
+	alerts, err := s.repo.GetUnansweredSafetyAlerts(before)
+	if err != nil {
+		return err
+	}
+	for _, alert := range alerts {
+		escalated, err := s.repo.EscalateSafetyAlert(alert.ID)
+		if err != nil {
+			log.Printf("Failed to escalate safety alert %s: %v", alert.ID, err)
+			continue
+		}
+
+		s.notify(escalated, "safety-alert-escalated", "Đã báo cho quản trị viên",
+			"Bạn chưa phản hồi, chúng tôi đã thông báo cho quản trị viên để hỗ trợ bạn")
+	}
+
+	return nil
+}
+
+// GetSafetyAlertList gets the list of safety alerts for the admin panel
+func (s *SafetyService) GetSafetyAlertList(req schemas.SafetyAlertListRequest) ([]migration.SafetyAlert, int64, int64, error) {
+	return s.repo.GetSafetyAlertList(req)
+}
+
+// ResolveSafetyAlert closes a safety alert once an admin has handled it
+func (s *SafetyService) ResolveSafetyAlert(req schemas.ResolveSafetyAlertRequest, adminID uuid.UUID) (migration.SafetyAlert, error) {
+	return s.repo.ResolveSafetyAlert(req.AlertID, adminID, req.Note)
+}
+
+// TriggerSOS raises a high priority alert for the admins on an ongoing ride, records it in the ride timeline
+// and tells the trusted contacts of the user by SMS where they were last seen
+func (s *SafetyService) TriggerSOS(req schemas.SOSRequest, userID uuid.UUID) (schemas.SOSResponse, error) {
+	ride, err := s.repo.GetRideWithParticipants(req.RideID)
+	if err != nil {
+		return schemas.SOSResponse{}, err
+	}
+
+	var user migration.User
+	switch userID {
+	case ride.RideOffer.UserID:
+		user = ride.RideOffer.User
+	case ride.RideRequest.UserID:
+		user = ride.RideRequest.User
+	default:
+		return schemas.SOSResponse{}, ErrNotRideParticipant
+	}
+
+	if ride.Status != statemachine.StatusOngoing {
+		return schemas.SOSResponse{}, ErrRideNotOngoing
+	}
+
+	// Fall back to the last location the driver sent when the app could not get the current one
+	location := req.CurrentLocation
+	if location.Lat == 0 && location.Lng == 0 {
+		location = schemas.Point{Lat: ride.RideOffer.DriverCurrentLatitude, Lng: ride.RideOffer.DriverCurrentLongitude}
+	}
+
+	alert, err := s.repo.CreateSOSAlert(migration.SafetyAlert{
+		RideID:    ride.ID,
+		UserID:    userID,
+		Type:      SafetyAlertSOS,
+		Latitude:  location.Lat,
+		Longitude: location.Lng,
+	})
+	if err != nil {
+		return schemas.SOSResponse{}, err
+	}
+
+	contacts, err := s.repo.GetTrustedContacts(userID)
+	if err != nil {
+		log.Printf("Failed to get the trusted contacts of user %s: %v", userID, err)
+	}
+
+	body := fmt.Sprintf("[ShareWay] KHẨN CẤP: %s vừa bấm nút SOS trong một chuyến đi. Vị trí cuối cùng: https://maps.google.com/?q=%f,%f",
+		user.FullName, location.Lat, location.Lng)
+	notified := 0
+	for _, contact := range contacts {
+		if err := s.sms.SendSMS(contact.PhoneNumber, body); err != nil {
+			log.Printf("Failed to queue SOS SMS to trusted contact %s: %v", contact.ID, err)
+			continue
+		}
+		notified++
+	}
+
+	return schemas.SOSResponse{
+		AlertID:          alert.ID,
+		RideID:           ride.ID,
+		Latitude:         alert.Latitude,
+		Longitude:        alert.Longitude,
+		NotifiedContacts: notified,
+		CreatedAt:        alert.CreatedAt,
+	}, nil
+}
+
+// AddTrustedContact registers a trusted contact for the user, up to MaxTrustedContacts
+func (s *SafetyService) AddTrustedContact(req schemas.AddTrustedContactRequest, userID uuid.UUID) (migration.TrustedContact, error) {
+	count, err := s.repo.CountTrustedContacts(userID)
+	if err != nil {
+		return migration.TrustedContact{}, err
+	}
+	if count >= MaxTrustedContacts {
+		return migration.TrustedContact{}, ErrTooManyTrustedContacts
+	}
+
+	return s.repo.CreateTrustedContact(migration.TrustedContact{
+		UserID:       userID,
+		FullName:     req.FullName,
+		PhoneNumber:  req.PhoneNumber,
+		Relationship: req.Relationship,
+	})
+}
+
+// GetTrustedContacts fetches the trusted contacts of the user
+func (s *SafetyService) GetTrustedContacts(userID uuid.UUID) ([]migration.TrustedContact, error) {
+	return s.repo.GetTrustedContacts(userID)
+}
+
+// DeleteTrustedContact removes a trusted contact of the user
+func (s *SafetyService) DeleteTrustedContact(contactID, userID uuid.UUID) error {
+	return s.repo.DeleteTrustedContact(contactID, userID)
+}
+
+// ShareRide issues a read-only token that lets anyone follow the ride until it expires or the ride ends
+func (s *SafetyService) ShareRide(req schemas.ShareRideRequest, userID uuid.UUID) (schemas.ShareRideResponse, error) {
+	ride, err := s.repo.GetRideWithParticipants(req.RideID)
+	if err != nil {
+		return schemas.ShareRideResponse{}, err
+	}
+
+	if userID != ride.RideOffer.UserID && userID != ride.RideRequest.UserID {
+		return schemas.ShareRideResponse{}, ErrNotRideParticipant
+	}
+
+	if ride.Status != statemachine.StatusScheduled && ride.Status != statemachine.StatusOngoing {
+		return schemas.ShareRideResponse{}, ErrRideNotActive
+	}
+
+	shareToken, payload, err := s.maker.CreateRideShareToken(ride.ID, userID, time.Duration(s.cfg.RideShareDuration)*time.Second)
+	if err != nil {
+		return schemas.ShareRideResponse{}, err
+	}
+
+	return schemas.ShareRideResponse{
+		RideID:    ride.ID,
+		Token:     shareToken,
+		ExpiredAt: payload.ExpiredAt,
+	}, nil
+}
+
+// TrackSharedRide returns the last known location of the driver, the vehicle and the ETA of a shared ride.
+// The link stops working once the ride is completed or cancelled even when the token has not expired yet
+func (s *SafetyService) TrackSharedRide(shareToken string) (schemas.SharedRideLocation, error) {
+	payload, err := s.maker.VerifyRideShareToken(shareToken)
+	if err != nil {
+		return schemas.SharedRideLocation{}, fmt.Errorf("%w: %v", ErrInvalidShareToken, err)
+	}
+
+	ride, err := s.repo.GetRideWithParticipants(payload.RideID)
+	if err != nil {
+		return schemas.SharedRideLocation{}, err
+	}
+
+	if ride.Status != statemachine.StatusScheduled && ride.Status != statemachine.StatusOngoing {
+		return schemas.SharedRideLocation{}, ErrRideNotActive
+	}
+
+	location := schemas.Point{Lat: ride.RideOffer.DriverCurrentLatitude, Lng: ride.RideOffer.DriverCurrentLongitude}
+	res := schemas.SharedRideLocation{
+		RideID:       ride.ID,
+		Status:       ride.Status,
+		DriverName:   ride.RideOffer.User.FullName,
+		VehicleName:  ride.Vehicle.Name,
+		LicensePlate: ride.Vehicle.LicensePlate,
+		Latitude:     location.Lat,
+		Longitude:    location.Lng,
+		Target:       WaypointTypePickup,
+		UpdatedAt:    ride.RideOffer.UpdatedAt,
+	}
+	target := schemas.Point{Lat: ride.RideRequest.StartLatitude, Lng: ride.RideRequest.StartLongitude}
+	if ride.Status == statemachine.StatusOngoing {
+		res.Target = WaypointTypeDropoff
+		target = schemas.Point{Lat: ride.RideRequest.EndLatitude, Lng: ride.RideRequest.EndLongitude}
+	}
+
+	// Estimate along the stored route so following a ride does not call the maps API on every update
+	res.Distance, res.Duration = helper.EstimateAlongRoute(helper.DecodePolyline(string(ride.EncodedPolyline)), location, target, ride.Distance, ride.Duration)
+	res.ETA = time.Now().Add(time.Duration(res.Duration) * time.Second)
+
+	return res, nil
+}
+
+// notify sends the safety alert to the hitcher through the websocket and FCM queues
+func (s *SafetyService) notify(alert migration.SafetyAlert, messageType, title, body string) {
+	res := schemas.SafetyCheckResponse{
+		AlertID:   alert.ID,
+		RideID:    alert.RideID,
+		Type:      alert.Type,
+		Status:    alert.Status,
+		Latitude:  alert.Latitude,
+		Longitude: alert.Longitude,
+		Distance:  alert.Distance,
+		CreatedAt: alert.CreatedAt,
+	}
+
+	wsMessage := schemas.WebSocketMessage{
+		UserID:  alert.UserID.String(),
+		Type:    messageType,
+		Payload: res,
+	}
+
+	go func() {
+		if err := s.asyncClient.EnqueueWebsocketMessage(wsMessage); err != nil {
+			log.Printf("Failed to enqueue websocket message: %v", err)
+		}
+	}()
+
+	if alert.User.DeviceToken == "" {
+		return
+	}
+
+	resMap, err := helper.ConvertToStringMap(res)
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notificationPayloadMap, err := helper.ConvertToStringMap(schemas.NotificationPayload{
+		Type: messageType,
+		Data: resMap,
+	})
+	if err != nil {
+		log.Printf("Failed to convert struct to map: %v", err)
+		return
+	}
+
+	notification := schemas.Notification{
+		Title: title,
+		Body:  body,
+		Token: alert.User.DeviceToken,
+		Data:  notificationPayloadMap,
+	}
+
+	go func() {
+		if err := s.asyncClient.EnqueueFCMNotification(notification); err != nil {
+			log.Printf("Failed to enqueue FCM notification: %v", err)
+		}
+	}()
+}
+
+// Make sure the SafetyService implements the ISafetyService interface
+var _ ISafetyService = (*SafetyService)(nil)
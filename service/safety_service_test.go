@@ -0,0 +1,172 @@
+package service
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"shareway/infra/db/migration"
+	"shareway/repository"
+	"shareway/schemas"
+	"shareway/util"
+	"shareway/util/statemachine"
+
+	"github.com/google/uuid"
+)
+
+// fakeSMSSender records the text messages instead of sending them, the numbers in fail are rejected
+type fakeSMSSender struct {
+	sent []schemas.SMS
+	fail map[string]bool
+}
+
+func (s *fakeSMSSender) SendSMS(to, body string) error {
+	if s.fail[to] {
+		return errors.New("sms provider unavailable")
+	}
+	s.sent = append(s.sent, schemas.SMS{To: to, Body: body})
+	return nil
+}
+
+// fakeSafetyRepository serves one ride and the trusted contacts of its participants, the other methods are not used
+type fakeSafetyRepository struct {
+	repository.ISafetyRepository
+	ride     migration.Ride
+	contacts []migration.TrustedContact
+	alerts   []migration.SafetyAlert
+}
+
+func (r *fakeSafetyRepository) GetRideWithParticipants(rideID uuid.UUID) (migration.Ride, error) {
+	if rideID != r.ride.ID {
+		return migration.Ride{}, repository.ErrRideNotFound
+	}
+	return r.ride, nil
+}
+
+func (r *fakeSafetyRepository) CreateSOSAlert(alert migration.SafetyAlert) (migration.SafetyAlert, error) {
+	alert.ID = uuid.New()
+	r.alerts = append(r.alerts, alert)
+	return alert, nil
+}
+
+func (r *fakeSafetyRepository) GetTrustedContacts(userID uuid.UUID) ([]migration.TrustedContact, error) {
+	var contacts []migration.TrustedContact
+	for _, contact := range r.contacts {
+		if contact.UserID == userID {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts, nil
+}
+
+func newSOSFixture(status string) (*fakeSafetyRepository, *fakeSMSSender, ISafetyService) {
+	driver := migration.User{ID: uuid.New(), FullName: "Nguyễn Văn Tài"}
+	hitcher := migration.User{ID: uuid.New(), FullName: "Trần Thị Khách"}
+	repo := &fakeSafetyRepository{
+		ride: migration.Ride{
+			ID:     uuid.New(),
+			Status: status,
+			RideOffer: migration.RideOffer{
+				UserID:                 driver.ID,
+				User:                   driver,
+				DriverCurrentLatitude:  10.7769,
+				DriverCurrentLongitude: 106.7009,
+			},
+			RideRequest: migration.RideRequest{UserID: hitcher.ID, User: hitcher},
+		},
+		contacts: []migration.TrustedContact{
+			{ID: uuid.New(), UserID: hitcher.ID, PhoneNumber: "+84901111111"},
+			{ID: uuid.New(), UserID: hitcher.ID, PhoneNumber: "+84902222222"},
+			{ID: uuid.New(), UserID: driver.ID, PhoneNumber: "+84903333333"},
+		},
+	}
+	sms := &fakeSMSSender{fail: map[string]bool{}}
+
+	return repo, sms, NewSafetyService(repo, nil, nil, sms, nil, util.Config{})
+}
+
+func TestTriggerSOSTextsTrustedContacts(t *testing.T) {
+	repo, sms, svc := newSOSFixture(statemachine.StatusOngoing)
+	hitcher := repo.ride.RideRequest.User
+
+	res, err := svc.TriggerSOS(schemas.SOSRequest{
+		RideID:          repo.ride.ID,
+		CurrentLocation: schemas.Point{Lat: 10.8231, Lng: 106.6297},
+	}, hitcher.ID)
+	if err != nil {
+		t.Fatalf("TriggerSOS() error = %v", err)
+	}
+
+	if res.NotifiedContacts != 2 || len(sms.sent) != 2 {
+		t.Fatalf("TriggerSOS() notified %d contacts and sent %d SMS, want 2", res.NotifiedContacts, len(sms.sent))
+	}
+	for i, want := range []string{"+84901111111", "+84902222222"} {
+		if sms.sent[i].To != want {
+			t.Errorf("SMS %d sent to %s, want %s", i, sms.sent[i].To, want)
+		}
+		if !strings.Contains(sms.sent[i].Body, hitcher.FullName) || !strings.Contains(sms.sent[i].Body, "10.823100,106.629700") {
+			t.Errorf("SMS %d body = %q, want the name and the location of the hitcher", i, sms.sent[i].Body)
+		}
+	}
+	if len(repo.alerts) != 1 || repo.alerts[0].UserID != hitcher.ID || repo.alerts[0].Type != SafetyAlertSOS {
+		t.Errorf("TriggerSOS() created alerts %+v, want one SOS alert of the hitcher", repo.alerts)
+	}
+}
+
+func TestTriggerSOSCountsOnlyQueuedMessages(t *testing.T) {
+	repo, sms, svc := newSOSFixture(statemachine.StatusOngoing)
+	sms.fail["+84901111111"] = true
+
+	res, err := svc.TriggerSOS(schemas.SOSRequest{RideID: repo.ride.ID}, repo.ride.RideRequest.UserID)
+	if err != nil {
+		t.Fatalf("TriggerSOS() error = %v", err)
+	}
+	if res.NotifiedContacts != 1 {
+		t.Errorf("TriggerSOS() notified %d contacts, want 1", res.NotifiedContacts)
+	}
+}
+
+func TestTriggerSOSFallsBackToDriverLocation(t *testing.T) {
+	repo, sms, svc := newSOSFixture(statemachine.StatusOngoing)
+
+	res, err := svc.TriggerSOS(schemas.SOSRequest{RideID: repo.ride.ID}, repo.ride.RideOffer.UserID)
+	if err != nil {
+		t.Fatalf("TriggerSOS() error = %v", err)
+	}
+	if res.Latitude != 10.7769 || res.Longitude != 106.7009 {
+		t.Errorf("TriggerSOS() location = %f,%f, want the last location of the driver", res.Latitude, res.Longitude)
+	}
+	if len(sms.sent) != 1 || sms.sent[0].To != "+84903333333" {
+		t.Errorf("TriggerSOS() sent %+v, want one SMS to the trusted contact of the driver", sms.sent)
+	}
+}
+
+func TestTriggerSOSRejectsInvalidRides(t *testing.T) {
+	tests := []struct {
+		name     string
+		status   string
+		outsider bool
+		want     error
+	}{
+		{"not a participant", statemachine.StatusOngoing, true, ErrNotRideParticipant},
+		{"ride not started", statemachine.StatusScheduled, false, ErrRideNotOngoing},
+		{"ride completed", statemachine.StatusCompleted, false, ErrRideNotOngoing},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			repo, sms, svc := newSOSFixture(tt.status)
+			userID := repo.ride.RideRequest.UserID
+			if tt.outsider {
+				userID = uuid.New()
+			}
+
+			_, err := svc.TriggerSOS(schemas.SOSRequest{RideID: repo.ride.ID}, userID)
+			if !errors.Is(err, tt.want) {
+				t.Errorf("TriggerSOS() error = %v, want %v", err, tt.want)
+			}
+			if len(sms.sent) != 0 || len(repo.alerts) != 0 {
+				t.Errorf("TriggerSOS() sent %d SMS and created %d alerts, want none", len(sms.sent), len(repo.alerts))
+			}
+		})
+	}
+}
@@ -1,12 +1,16 @@
 package service
 
 import (
+	"time"
+
 	"shareway/infra/bucket"
 	"shareway/infra/fpt"
 	"shareway/infra/task"
 	"shareway/infra/ws"
 	"shareway/repository"
 	"shareway/util"
+	"shareway/util/cancellation"
+	"shareway/util/pricing"
 	"shareway/util/sanctum"
 	"shareway/util/token"
 
@@ -25,6 +29,10 @@ type ServiceContainer struct {
 	AdminService        IAdminService
 	PaymentService      IPaymentService
 	IPNService          IIPNService
+	ExpiryService       IExpiryService
+	GeofenceService     IGeofenceService
+	SafetyService       ISafetyService
+	MatchAlertService   IMatchAlertService
 }
 
 type ServiceFactory struct {
@@ -39,6 +47,8 @@ type ServiceFactory struct {
 	asynq        *task.AsyncClient
 	cloudinary   *bucket.CloudinaryService
 	sanctumToken *sanctum.SanctumToken
+	pricing      *pricing.Engine
+	cancellation *cancellation.Policy
 }
 
 func NewServiceFactory(db *gorm.DB, cfg util.Config, token *token.PasetoMaker, redisClient *redis.Client, hub *ws.Hub, asynq *task.AsyncClient, cloudinary *bucket.CloudinaryService, sanctumToken *sanctum.SanctumToken) *ServiceFactory {
@@ -49,6 +59,22 @@ func NewServiceFactory(db *gorm.DB, cfg util.Config, token *token.PasetoMaker, r
 	fptReader := fpt.NewFPTReader(cfg)
 	// Initialize encryptor
 	encryptor := util.NewEncryptor(cfg)
+	// Initialize pricing engine
+	pricingEngine := pricing.NewEngine(pricing.Config{
+		MinimumFare:      cfg.PricingMinimumFare,
+		Rounding:         cfg.PricingRounding,
+		PerKmRate:        cfg.PricingPerKmRate,
+		BaseFare:         cfg.PricingBaseFare,
+		ElectricityPrice: cfg.PricingElectricityPrice,
+	})
+	// Initialize cancellation policy
+	cancellationPolicy := cancellation.NewPolicy(cancellation.Config{
+		FreeAfterBooking: time.Duration(cfg.CancelFreeAfterBooking) * time.Minute,
+		FreeBeforeStart:  time.Duration(cfg.CancelFreeBeforeStart) * time.Minute,
+		LateCancelFee:    cfg.CancelLateFeePercent,
+		NoShowFee:        cfg.NoShowFeePercent,
+		NoShowWaitTime:   time.Duration(cfg.NoShowWaitTime) * time.Minute,
+	})
 
 	return &ServiceFactory{
 		repos:        repos,
@@ -61,6 +87,8 @@ func NewServiceFactory(db *gorm.DB, cfg util.Config, token *token.PasetoMaker, r
 		cloudinary:   cloudinary,
 		asynq:        asynq,
 		sanctumToken: sanctumToken,
+		pricing:      pricingEngine,
+		cancellation: cancellationPolicy,
 	}
 }
 
@@ -76,6 +104,10 @@ func (f *ServiceFactory) CreateServices() *ServiceContainer {
 		AdminService:        f.createAdminService(),
 		PaymentService:      f.createPaymentService(),
 		IPNService:          f.createIPNService(),
+		ExpiryService:       f.createExpiryService(),
+		GeofenceService:     f.createGeofenceService(),
+		SafetyService:       f.createSafetyService(),
+		MatchAlertService:   f.createMatchAlertService(),
 	}
 }
 
@@ -88,7 +120,7 @@ func (f *ServiceFactory) createUserService() IUsersService {
 }
 
 func (f *ServiceFactory) createMapsService() IMapService {
-	return NewMapService(f.repos.MapsRepository, f.cfg, f.redis)
+	return NewMapService(f.repos.MapsRepository, f.cfg, f.redis, f.pricing, f.asynq)
 }
 
 func (f *ServiceFactory) createVehicleService() IVehicleService {
@@ -96,7 +128,7 @@ func (f *ServiceFactory) createVehicleService() IVehicleService {
 }
 
 func (f *ServiceFactory) createRideService() IRideService {
-	return NewRideService(f.repos.RideRepository, f.hub, f.cfg)
+	return NewRideService(f.repos.RideRepository, f.hub, f.cancellation, f.cfg)
 }
 
 func (f *ServiceFactory) createNotificationService() INotificationService {
@@ -118,3 +150,19 @@ func (f *ServiceFactory) createPaymentService() IPaymentService {
 func (f *ServiceFactory) createIPNService() IIPNService {
 	return NewIPNService(f.repos.IPNRepository, f.hub, f.cfg)
 }
+
+func (f *ServiceFactory) createExpiryService() IExpiryService {
+	return NewExpiryService(f.repos.RideRepository, f.createPaymentService(), f.createMapsService(), f.asynq, f.cfg)
+}
+
+func (f *ServiceFactory) createGeofenceService() IGeofenceService {
+	return NewGeofenceService(f.redis, f.cfg)
+}
+
+func (f *ServiceFactory) createSafetyService() ISafetyService {
+	return NewSafetyService(f.repos.SafetyRepository, f.redis, f.asynq, task.NewQueuedSMSSender(f.asynq), f.maker, f.cfg)
+}
+
+func (f *ServiceFactory) createMatchAlertService() IMatchAlertService {
+	return NewMatchAlertService(f.repos.MapsRepository, f.redis, f.asynq, f.cfg)
+}
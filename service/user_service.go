@@ -39,6 +39,7 @@ type IUsersService interface {
 	UpdateUserProfile(userID uuid.UUID, fullName string, email string, gender string) error
 	UpdateAvatar(ctx context.Context, userID uuid.UUID, avatarImage *multipart.FileHeader) (string, error)
 	GetTotalTransactionsForUser(userID uuid.UUID) (int64, error)
+	UpdateMatchPreferences(userID uuid.UUID, preferences migration.MatchPreferences) error
 }
 
 // UsersService implements IUsersService and handles user-related business logic
@@ -211,5 +212,11 @@ func (s *UsersService) GetTotalTransactionsForUser(userID uuid.UUID) (int64, err
 	return s.repo.GetTotalTransactionsForUser(userID)
 }
 
+// UpdateMatchPreferences updates the default match preferences of the user, used by the rides the user creates
+// without their own preferences
+func (s *UsersService) UpdateMatchPreferences(userID uuid.UUID, preferences migration.MatchPreferences) error {
+	return s.repo.UpdateMatchPreferences(userID, preferences)
+}
+
 // Ensure UsersService implements IUsersService
 var _ IUsersService = (*UsersService)(nil)
@@ -0,0 +1,122 @@
+package assignment
+
+import "math"
+
+// Infeasible is the cost of a ride request that cannot ride with a ride offer
+var Infeasible = math.Inf(1)
+
+// Solve assigns each row (a ride request) to at most one column (a ride offer) so that no column gets more rows than
+// its capacity (the available seats). As many rows as possible are assigned to a feasible column, then the total cost
+// of the assigned pairs is the lowest possible. It returns the column of each row, -1 when the row is not assigned.
+// The Hungarian algorithm is run on the seats of the columns, ties are broken by the order of the rows and the columns
+// so the same input always gives the same assignment
+func Solve(cost [][]float64, capacity []int) []int {
+	assigned := make([]int, len(cost))
+	for i := range assigned {
+		assigned[i] = -1
+	}
+
+	// Each seat of a column is a column of the square matrix solved by the Hungarian algorithm
+	var seats []int
+	for column, seatCount := range capacity {
+		for k := 0; k < seatCount; k++ {
+			seats = append(seats, column)
+		}
+	}
+	if len(cost) == 0 || len(seats) == 0 {
+		return assigned
+	}
+
+	// An infeasible pair (or a padding row or column) costs more than any assignment of feasible pairs,
+	// so the solver first maximizes the number of feasible pairs
+	n := max(len(cost), len(seats))
+	highest := 0.0
+	for _, row := range cost {
+		for _, c := range row {
+			if !math.IsInf(c, 1) && c > highest {
+				highest = c
+			}
+		}
+	}
+	penalty := (highest+1)*float64(n) + 1
+
+	// 1-indexed square matrix, as in the usual formulation of the algorithm
+	a := make([][]float64, n+1)
+	for i := 1; i <= n; i++ {
+		a[i] = make([]float64, n+1)
+		for j := 1; j <= n; j++ {
+			a[i][j] = penalty
+			if i <= len(cost) && j <= len(seats) {
+				if c := cost[i-1][seats[j-1]]; !math.IsInf(c, 1) {
+					a[i][j] = c
+				}
+			}
+		}
+	}
+
+	// u and v are the potentials of the rows and the columns, p[j] is the row assigned to the column j
+	u := make([]float64, n+1)
+	v := make([]float64, n+1)
+	p := make([]int, n+1)
+	way := make([]int, n+1)
+	for i := 1; i <= n; i++ {
+		p[0] = i
+		j0 := 0
+		minv := make([]float64, n+1)
+		for j := range minv {
+			minv[j] = math.Inf(1)
+		}
+		used := make([]bool, n+1)
+
+		for {
+			used[j0] = true
+			i0 := p[j0]
+			delta := math.Inf(1)
+			j1 := 0
+			for j := 1; j <= n; j++ {
+				if used[j] {
+					continue
+				}
+				if cur := a[i0][j] - u[i0] - v[j]; cur < minv[j] {
+					minv[j] = cur
+					way[j] = j0
+				}
+				if minv[j] < delta {
+					delta = minv[j]
+					j1 = j
+				}
+			}
+			for j := 0; j <= n; j++ {
+				if used[j] {
+					u[p[j]] += delta
+					v[j] -= delta
+				} else {
+					minv[j] -= delta
+				}
+			}
+			j0 = j1
+			if p[j0] == 0 {
+				break
+			}
+		}
+
+		// Flip the augmenting path
+		for j0 != 0 {
+			j1 := way[j0]
+			p[j0] = p[j1]
+			j0 = j1
+		}
+	}
+
+	for j := 1; j <= len(seats); j++ {
+		row := p[j]
+		if row == 0 || row > len(cost) {
+			continue
+		}
+		if column := seats[j-1]; !math.IsInf(cost[row-1][column], 1) {
+			assigned[row-1] = column
+		}
+	}
+
+	return assigned
+}
@@ -0,0 +1,98 @@
+package assignment
+
+import (
+	"reflect"
+	"testing"
+)
+
+// inf marks the infeasible pairs of the fixtures
+var inf = Infeasible
+
+func TestSolve(t *testing.T) {
+	tests := []struct {
+		name     string
+		cost     [][]float64
+		capacity []int
+		want     []int
+	}{
+		{
+			name:     "lowest total cost",
+			cost:     [][]float64{{4, 1, 3}, {2, 0, 5}, {3, 2, 2}},
+			capacity: []int{1, 1, 1},
+			want:     []int{1, 0, 2},
+		},
+		{
+			name:     "greedy choice is not the lowest total cost",
+			cost:     [][]float64{{1, 2}, {1, 10}},
+			capacity: []int{1, 1},
+			want:     []int{1, 0},
+		},
+		{
+			name:     "column with several seats",
+			cost:     [][]float64{{1, 5}, {2, 6}, {3, 1}},
+			capacity: []int{2, 1},
+			want:     []int{0, 0, 1},
+		},
+		{
+			name:     "more rows than seats leaves the most expensive row out",
+			cost:     [][]float64{{3}, {1}, {2}},
+			capacity: []int{2},
+			want:     []int{-1, 0, 0},
+		},
+		{
+			name:     "infeasible pairs are never assigned",
+			cost:     [][]float64{{inf, 1}, {inf, inf}},
+			capacity: []int{1, 1},
+			want:     []int{1, -1},
+		},
+		{
+			name:     "more feasible pairs before a lower cost",
+			cost:     [][]float64{{1, 50}, {2, inf}},
+			capacity: []int{1, 1},
+			want:     []int{1, 0},
+		},
+		{
+			name:     "column without seats",
+			cost:     [][]float64{{1, 2}},
+			capacity: []int{0, 1},
+			want:     []int{1},
+		},
+		{
+			name:     "ties are broken by the order of the rows",
+			cost:     [][]float64{{1}, {1}},
+			capacity: []int{1},
+			want:     []int{0, -1},
+		},
+		{
+			name:     "no seats",
+			cost:     [][]float64{{1}},
+			capacity: []int{0},
+			want:     []int{-1},
+		},
+		{
+			name:     "no rows",
+			cost:     [][]float64{},
+			capacity: []int{1},
+			want:     []int{},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Solve(tt.cost, tt.capacity); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Solve() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSolveIsDeterministic(t *testing.T) {
+	cost := [][]float64{{2, 2, inf}, {2, 2, 2}, {inf, 2, 2}, {2, inf, 2}}
+	capacity := []int{1, 2, 1}
+
+	want := Solve(cost, capacity)
+	for i := 0; i < 10; i++ {
+		if got := Solve(cost, capacity); !reflect.DeepEqual(got, want) {
+			t.Fatalf("Solve() = %v, then %v", want, got)
+		}
+	}
+}
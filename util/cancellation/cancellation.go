@@ -0,0 +1,105 @@
+package cancellation
+
+import (
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Outcomes stored on a cancelled ride
+const (
+	OutcomeFree   = "free"    // Cancelled inside a free cancellation window, nobody is charged
+	OutcomeLate   = "late"    // Cancelled too close to the start time
+	OutcomeNoShow = "no_show" // The driver or the hitcher did not show up at the pickup point
+)
+
+var (
+	ErrTooEarlyForNoShow = errors.New("no-show can only be reported after waiting at the pickup point")
+)
+
+// Config holds the free cancellation windows and the fees of the policy
+type Config struct {
+	FreeAfterBooking time.Duration // Cancelling within this time after the ride was booked is free
+	FreeBeforeStart  time.Duration // Cancelling at least this long before the start time is free
+	LateCancelFee    int64         // Percent of the fare kept from the refund of a hitcher who cancels late
+	NoShowFee        int64         // Percent of the fare kept from the refund of a hitcher who does not show up
+	NoShowWaitTime   time.Duration // How long after the start time a no-show can be reported
+}
+
+// Ride holds what the policy needs to know about the cancelled ride
+type Ride struct {
+	BookedAt  time.Time
+	StartTime time.Time
+	Fare      int64 // VND
+	DriverID  uuid.UUID
+	HitcherID uuid.UUID
+	Started   bool // The driver already picked the hitcher up
+	Prepaid   bool // The hitcher paid the fare by MoMo, a fee can only be kept from a prepaid fare
+}
+
+// Decision is the outcome of a cancellation or a no-show report
+type Decision struct {
+	Outcome     string
+	Fee         int64     // VND kept from the refund of the hitcher, 0 when the fare is paid in cash
+	HitcherID   uuid.UUID // Hitcher who is refunded the fare minus the fee
+	Responsible uuid.UUID // User whose reliability is lowered, uuid.Nil when the cancellation is free
+	Rematch     bool      // The ride request of the hitcher goes back to the matching pool and the payment is kept on hold
+	Reopen      bool      // The ride offer stays open for other hitchers even when no hitcher is left on it
+}
+
+// Policy decides who is charged when a ride does not happen, the user given to it must be the driver or the hitcher
+type Policy struct {
+	cfg Config
+}
+
+func NewPolicy(cfg Config) *Policy {
+	return &Policy{cfg: cfg}
+}
+
+// Cancel decides the outcome of a ride cancelled by the driver or the hitcher. Cancelling is free right after booking
+// or long enough before the start time, otherwise it counts against the reliability of the user and a hitcher who paid
+// by MoMo is charged the late cancellation fee. A driver is never charged because the fee can only be kept from the
+// refund of the hitcher, and neither is a hitcher paying in cash since there is nothing to keep it from.
+// When the driver cancels before the pickup the hitcher is matched again instead of being refunded
+func (p *Policy) Cancel(ride Ride, cancelledBy uuid.UUID, now time.Time) Decision {
+	decision := Decision{Outcome: OutcomeFree, HitcherID: ride.HitcherID}
+
+	// A hitcher left stranded by the driver before the pickup is matched again
+	decision.Rematch = cancelledBy == ride.DriverID && !ride.Started
+
+	if now.Sub(ride.BookedAt) <= p.cfg.FreeAfterBooking || ride.StartTime.Sub(now) >= p.cfg.FreeBeforeStart {
+		return decision
+	}
+
+	decision.Outcome = OutcomeLate
+	decision.Responsible = cancelledBy
+	if cancelledBy == ride.HitcherID && ride.Prepaid {
+		decision.Fee = fee(ride.Fare, p.cfg.LateCancelFee)
+	}
+
+	return decision
+}
+
+// NoShow decides the outcome of a no-show reported by the driver or the hitcher after waiting at the pickup point,
+// the other participant is held responsible. A hitcher who paid by MoMo and does not show up is charged the no-show fee
+func (p *Policy) NoShow(ride Ride, reportedBy uuid.UUID, now time.Time) (Decision, error) {
+	if now.Before(ride.StartTime.Add(p.cfg.NoShowWaitTime)) {
+		return Decision{}, ErrTooEarlyForNoShow
+	}
+
+	decision := Decision{Outcome: OutcomeNoShow, HitcherID: ride.HitcherID, Responsible: ride.DriverID}
+	if reportedBy == ride.DriverID {
+		decision.Responsible = ride.HitcherID
+		if ride.Prepaid {
+			decision.Fee = fee(ride.Fare, p.cfg.NoShowFee)
+		}
+	}
+
+	return decision, nil
+}
+
+// fee returns the given percent of the fare rounded down to 1000 VND, the smallest currency unit
+func fee(fare, percent int64) int64 {
+	return fare * percent / 100 / 1000 * 1000
+}
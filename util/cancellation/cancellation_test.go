@@ -0,0 +1,112 @@
+package cancellation
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+func testPolicy() *Policy {
+	return NewPolicy(Config{
+		FreeAfterBooking: 5 * time.Minute,
+		FreeBeforeStart:  time.Hour,
+		LateCancelFee:    20,
+		NoShowFee:        50,
+		NoShowWaitTime:   10 * time.Minute,
+	})
+}
+
+func testRide(now time.Time, prepaid bool) Ride {
+	return Ride{
+		BookedAt:  now.Add(-2 * time.Hour),
+		StartTime: now.Add(30 * time.Minute),
+		Fare:      57000,
+		DriverID:  uuid.New(),
+		HitcherID: uuid.New(),
+		Prepaid:   prepaid,
+	}
+}
+
+func TestCancel(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name            string
+		prepaid         bool
+		byDriver        bool
+		startIn         time.Duration
+		wantOutcome     string
+		wantFee         int64
+		wantResponsible bool
+		wantRematch     bool
+	}{
+		{"free long before the start", true, false, 2 * time.Hour, OutcomeFree, 0, false, false},
+		{"late hitcher paid by MoMo", true, false, 30 * time.Minute, OutcomeLate, 11000, true, false},
+		{"late hitcher paying in cash", false, false, 30 * time.Minute, OutcomeLate, 0, true, false},
+		{"late driver", true, true, 30 * time.Minute, OutcomeLate, 0, true, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ride := testRide(now, tt.prepaid)
+			ride.StartTime = now.Add(tt.startIn)
+			cancelledBy := ride.HitcherID
+			if tt.byDriver {
+				cancelledBy = ride.DriverID
+			}
+
+			decision := testPolicy().Cancel(ride, cancelledBy, now)
+			if decision.Outcome != tt.wantOutcome || decision.Fee != tt.wantFee || decision.Rematch != tt.wantRematch {
+				t.Errorf("Cancel() = %+v, want outcome %s, fee %d and rematch %v", decision, tt.wantOutcome, tt.wantFee, tt.wantRematch)
+			}
+			if responsible := decision.Responsible == cancelledBy; responsible != tt.wantResponsible {
+				t.Errorf("Cancel() responsible = %s, want the user who cancelled: %v", decision.Responsible, tt.wantResponsible)
+			}
+		})
+	}
+}
+
+func TestNoShow(t *testing.T) {
+	now := time.Now()
+
+	tests := []struct {
+		name       string
+		prepaid    bool
+		byDriver   bool
+		wantFee    int64
+		wantDriver bool
+	}{
+		{"hitcher paid by MoMo", true, true, 28000, false},
+		{"hitcher paying in cash", false, true, 0, false},
+		{"driver", true, false, 0, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ride := testRide(now, tt.prepaid)
+			ride.StartTime = now.Add(-15 * time.Minute)
+			reportedBy := ride.HitcherID
+			if tt.byDriver {
+				reportedBy = ride.DriverID
+			}
+
+			decision, err := testPolicy().NoShow(ride, reportedBy, now)
+			if err != nil {
+				t.Fatalf("NoShow() error = %v", err)
+			}
+			if decision.Fee != tt.wantFee || (decision.Responsible == ride.DriverID) != tt.wantDriver {
+				t.Errorf("NoShow() = %+v, want fee %d", decision, tt.wantFee)
+			}
+		})
+	}
+}
+
+func TestNoShowTooEarly(t *testing.T) {
+	now := time.Now()
+	ride := testRide(now, true)
+	ride.StartTime = now.Add(-5 * time.Minute)
+
+	if _, err := testPolicy().NoShow(ride, ride.DriverID, now); !errors.Is(err, ErrTooEarlyForNoShow) {
+		t.Errorf("NoShow() error = %v, want %v", err, ErrTooEarlyForNoShow)
+	}
+}
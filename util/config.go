@@ -26,6 +26,7 @@ type Config struct {
 	TwilioAccountSID               string `mapstructure:"TWILIO_ACCOUNT_SID"`
 	TwilioAuthToken                string `mapstructure:"TWILIO_AUTH_TOKEN"`
 	TwilioServiceSID               string `mapstructure:"TWILIO_SERVICE_SID"`
+	TwilioPhoneNumber              string `mapstructure:"TWILIO_PHONE_NUMBER"`
 	RedisHost                      string `mapstructure:"REDIS_HOST"`
 	RedisPort                      int    `mapstructure:"REDIS_PORT"`
 	RedisPassword                  string `mapstructure:"REDIS_PASSWORD"`
@@ -73,6 +74,35 @@ type Config struct {
 	OpenRouterAPIURL               string `mapstructure:"OPENROUTER_API_URL"`
 	SanctumSecretKey               string `mapstructure:"SANCTUM_SECRET_KEY"`
 	WsUrl                          string `mapstructure:"WS_URL"`
+	RideExpiryGracePeriod          int    `mapstructure:"RIDE_EXPIRY_GRACE_PERIOD"` // in minutes
+	DriverETAInterval              int    `mapstructure:"DRIVER_ETA_INTERVAL"`      // in seconds
+	GeofenceRadius                 int    `mapstructure:"GEOFENCE_RADIUS"`          // in meters
+	GeofenceArrivingRadius         int    `mapstructure:"GEOFENCE_ARRIVING_RADIUS"` // in meters
+	RematchSuggestionLimit         int    `mapstructure:"REMATCH_SUGGESTION_LIMIT"` // ride offers pushed to a hitcher whose driver cancelled
+	BatchMatchingWindow            int    `mapstructure:"BATCH_MATCHING_WINDOW"`    // in minutes, how far ahead the batch matcher looks
+	TransferMaxWait                int    `mapstructure:"TRANSFER_MAX_WAIT"`        // in minutes, longest wait for the second driver of an itinerary
+	TransferMaxWalk                int    `mapstructure:"TRANSFER_MAX_WALK"`        // in meters, longest walk between the two drivers of an itinerary
+
+	// Pricing of the ride offers (see util/pricing)
+	PricingMinimumFare      int64   `mapstructure:"PRICING_MINIMUM_FARE"`      // in VND
+	PricingRounding         int64   `mapstructure:"PRICING_ROUNDING"`          // in VND
+	PricingPerKmRate        float64 `mapstructure:"PRICING_PER_KM_RATE"`       // in VND per kilometer
+	PricingBaseFare         float64 `mapstructure:"PRICING_BASE_FARE"`         // in VND
+	PricingElectricityPrice float64 `mapstructure:"PRICING_ELECTRICITY_PRICE"` // in VND per kWh
+
+	// Safety monitor of the ongoing rides
+	SafetyDeviationDistance int `mapstructure:"SAFETY_DEVIATION_DISTANCE"` // in meters
+	SafetyStopDuration      int `mapstructure:"SAFETY_STOP_DURATION"`      // in minutes
+	SafetyCheckTimeout      int `mapstructure:"SAFETY_CHECK_TIMEOUT"`      // in minutes
+	RideShareDuration       int `mapstructure:"RIDE_SHARE_DURATION"`       // in seconds
+	RideShareInterval       int `mapstructure:"RIDE_SHARE_INTERVAL"`       // in seconds
+
+	// Cancellation policy of the rides (see util/cancellation)
+	CancelFreeAfterBooking int   `mapstructure:"CANCEL_FREE_AFTER_BOOKING"` // in minutes
+	CancelFreeBeforeStart  int   `mapstructure:"CANCEL_FREE_BEFORE_START"`  // in minutes
+	CancelLateFeePercent   int64 `mapstructure:"CANCEL_LATE_FEE_PERCENT"`   // percent of the fare
+	NoShowFeePercent       int64 `mapstructure:"NO_SHOW_FEE_PERCENT"`       // percent of the fare
+	NoShowWaitTime         int   `mapstructure:"NO_SHOW_WAIT_TIME"`         // in minutes
 }
 
 func LoadConfig(path string) (config Config, err error) {
@@ -93,6 +123,31 @@ func LoadConfig(path string) (config Config, err error) {
 	viper.SetDefault("LOG_MAX_AGE", 28)
 	viper.SetDefault("LOG_COMPRESS", true)
 
+	viper.SetDefault("RIDE_EXPIRY_GRACE_PERIOD", 30)
+	viper.SetDefault("DRIVER_ETA_INTERVAL", 30)
+	viper.SetDefault("GEOFENCE_RADIUS", 200)
+	viper.SetDefault("GEOFENCE_ARRIVING_RADIUS", 1000)
+	viper.SetDefault("REMATCH_SUGGESTION_LIMIT", 3)
+	viper.SetDefault("BATCH_MATCHING_WINDOW", 120)
+	viper.SetDefault("TRANSFER_MAX_WAIT", 20)
+	viper.SetDefault("TRANSFER_MAX_WALK", 300)
+
+	viper.SetDefault("PRICING_MINIMUM_FARE", 1000)
+	viper.SetDefault("PRICING_ROUNDING", 1000)
+	viper.SetDefault("PRICING_PER_KM_RATE", 3000)
+	viper.SetDefault("PRICING_BASE_FARE", 10000)
+	viper.SetDefault("PRICING_ELECTRICITY_PRICE", 3500)
+	viper.SetDefault("SAFETY_DEVIATION_DISTANCE", 500)
+	viper.SetDefault("SAFETY_STOP_DURATION", 5)
+	viper.SetDefault("SAFETY_CHECK_TIMEOUT", 3)
+	viper.SetDefault("RIDE_SHARE_DURATION", 14400)
+	viper.SetDefault("RIDE_SHARE_INTERVAL", 5)
+	viper.SetDefault("CANCEL_FREE_AFTER_BOOKING", 5)
+	viper.SetDefault("CANCEL_FREE_BEFORE_START", 60)
+	viper.SetDefault("CANCEL_LATE_FEE_PERCENT", 20)
+	viper.SetDefault("NO_SHOW_FEE_PERCENT", 50)
+	viper.SetDefault("NO_SHOW_WAIT_TIME", 10)
+
 	// Read config
 	err = viper.ReadInConfig()
 	if err != nil {
@@ -0,0 +1,142 @@
+package pricing
+
+import (
+	"errors"
+	"fmt"
+	"math"
+)
+
+// Strategy names stored on a vehicle type to select how its ride offers are priced
+const (
+	StrategyFuel      = "fuel"        // Cost of the fuel burnt on the route
+	StrategyPerKm     = "per_km"      // Fixed rate per kilometer
+	StrategyFlatPerKm = "flat_per_km" // Flat base fare plus a rate per kilometer
+	StrategyElectric  = "electric"    // Cost of the electricity used on the route
+)
+
+// Fuel types of the crawled fuel prices, vehicle types that do not set one use DefaultFuelType
+const (
+	DefaultFuelType = "Xăng RON 95-III"
+	DieselFuelType  = "Dầu DO 0,05S-II"
+)
+
+var (
+	ErrUnknownStrategy = errors.New("unknown pricing strategy")
+)
+
+// Trip holds everything the strategies need to price a ride offer
+type Trip struct {
+	Distance       float64 // kilometers
+	FuelConsumed   float64 // liters per 100 kilometers
+	FuelPrice      float64 // VND per liter
+	EnergyConsumed float64 // kWh per 100 kilometers
+}
+
+// Strategy calculates the raw fare of a trip in VND, before the minimum fare and rounding are applied
+type Strategy interface {
+	Price(trip Trip) float64
+}
+
+// FuelCost prices a trip by the fuel the vehicle burns on it
+type FuelCost struct{}
+
+func (FuelCost) Price(trip Trip) float64 {
+	return trip.FuelConsumed / 100 * trip.FuelPrice * trip.Distance
+}
+
+// PerKm prices a trip by a fixed rate per kilometer
+type PerKm struct {
+	Rate float64 // VND per kilometer
+}
+
+func (s PerKm) Price(trip Trip) float64 {
+	return s.Rate * trip.Distance
+}
+
+// FlatPerKm prices a trip by a flat base fare plus a rate per kilometer
+type FlatPerKm struct {
+	BaseFare float64 // VND
+	Rate     float64 // VND per kilometer
+}
+
+func (s FlatPerKm) Price(trip Trip) float64 {
+	return s.BaseFare + s.Rate*trip.Distance
+}
+
+// Electric prices a trip by the electricity the vehicle uses on it
+type Electric struct {
+	PricePerKWh float64 // VND per kWh
+}
+
+func (s Electric) Price(trip Trip) float64 {
+	return trip.EnergyConsumed / 100 * s.PricePerKWh * trip.Distance
+}
+
+// Config holds the rates of the strategies and the rules applied to every fare
+type Config struct {
+	MinimumFare      int64   // VND, no fare is lower than this
+	Rounding         int64   // VND, fares are rounded to the nearest multiple of this
+	PerKmRate        float64 // VND per kilometer, used by the per_km and flat_per_km strategies
+	BaseFare         float64 // VND, used by the flat_per_km strategy
+	ElectricityPrice float64 // VND per kWh, used by the electric strategy
+}
+
+// Engine selects the pricing strategy of a vehicle type and applies the minimum fare and rounding
+type Engine struct {
+	strategies  map[string]Strategy
+	minimumFare int64
+	rounding    int64
+}
+
+func NewEngine(cfg Config) *Engine {
+	return &Engine{
+		strategies: map[string]Strategy{
+			StrategyFuel:      FuelCost{},
+			StrategyPerKm:     PerKm{Rate: cfg.PerKmRate},
+			StrategyFlatPerKm: FlatPerKm{BaseFare: cfg.BaseFare, Rate: cfg.PerKmRate},
+			StrategyElectric:  Electric{PricePerKWh: cfg.ElectricityPrice},
+		},
+		minimumFare: cfg.MinimumFare,
+		rounding:    cfg.Rounding,
+	}
+}
+
+// Strategy returns the strategy registered under the given name, vehicle types without a strategy are priced by fuel
+func (e *Engine) Strategy(name string) (Strategy, error) {
+	if name == "" {
+		name = StrategyFuel
+	}
+
+	strategy, ok := e.strategies[name]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrUnknownStrategy, name)
+	}
+	return strategy, nil
+}
+
+// Calculate prices the trip with the given strategy and returns the final fare in VND
+func (e *Engine) Calculate(name string, trip Trip) (int64, error) {
+	strategy, err := e.Strategy(name)
+	if err != nil {
+		return 0, err
+	}
+	return e.Round(strategy.Price(trip)), nil
+}
+
+// Round rounds the fare to the nearest rounding unit and makes sure it is not lower than the minimum fare
+func (e *Engine) Round(fare float64) int64 {
+	if e.rounding > 0 {
+		fare = math.Round(fare/float64(e.rounding)) * float64(e.rounding)
+	}
+
+	rounded := int64(math.Round(fare))
+	if rounded < e.minimumFare {
+		rounded = e.minimumFare
+	}
+	return rounded
+}
+
+// UsesFuelPrice reports whether the strategy needs the price of the vehicle's fuel type
+func UsesFuelPrice(name string) bool {
+	return name == "" || name == StrategyFuel
+}
@@ -0,0 +1,117 @@
+package pricing
+
+import (
+	"errors"
+	"math"
+	"testing"
+)
+
+func testEngine() *Engine {
+	return NewEngine(Config{
+		MinimumFare:      1000,
+		Rounding:         1000,
+		PerKmRate:        3000,
+		BaseFare:         10000,
+		ElectricityPrice: 3500,
+	})
+}
+
+func TestStrategyPrice(t *testing.T) {
+	trip := Trip{
+		Distance:       12.5,
+		FuelConsumed:   2,
+		FuelPrice:      20000,
+		EnergyConsumed: 15,
+	}
+
+	tests := []struct {
+		name     string
+		strategy Strategy
+		want     float64
+	}{
+		{"fuel", FuelCost{}, 2.0 / 100 * 20000 * 12.5},
+		{"per km", PerKm{Rate: 3000}, 3000 * 12.5},
+		{"flat per km", FlatPerKm{BaseFare: 10000, Rate: 3000}, 10000 + 3000*12.5},
+		{"electric", Electric{PricePerKWh: 3500}, 15.0 / 100 * 3500 * 12.5},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.strategy.Price(trip); math.Abs(got-tt.want) > 1e-9 {
+				t.Errorf("Price() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineCalculate(t *testing.T) {
+	engine := testEngine()
+
+	tests := []struct {
+		name     string
+		strategy string
+		trip     Trip
+		want     int64
+	}{
+		{"empty strategy is priced by fuel", "", Trip{Distance: 10, FuelConsumed: 2, FuelPrice: 21000}, 4000},
+		{"fuel rounds to the nearest 1000", StrategyFuel, Trip{Distance: 12.5, FuelConsumed: 2, FuelPrice: 21000}, 5000},
+		{"per km", StrategyPerKm, Trip{Distance: 3.2}, 10000},
+		{"flat per km", StrategyFlatPerKm, Trip{Distance: 3.2}, 20000},
+		{"electric", StrategyElectric, Trip{Distance: 40, EnergyConsumed: 15}, 21000},
+		{"minimum fare", StrategyFuel, Trip{Distance: 0.3, FuelConsumed: 2, FuelPrice: 21000}, 1000},
+		{"sub-kilometer distance is priced", StrategyPerKm, Trip{Distance: 1.9}, 6000},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := engine.Calculate(tt.strategy, tt.trip)
+			if err != nil {
+				t.Fatalf("Calculate() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("Calculate() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestEngineCalculateUnknownStrategy(t *testing.T) {
+	_, err := testEngine().Calculate("taxi_meter", Trip{Distance: 10})
+	if !errors.Is(err, ErrUnknownStrategy) {
+		t.Errorf("Calculate() error = %v, want %v", err, ErrUnknownStrategy)
+	}
+}
+
+func TestEngineRound(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		fare   float64
+		want   int64
+	}{
+		{"rounds down", Config{MinimumFare: 1000, Rounding: 1000}, 12499, 12000},
+		{"rounds up", Config{MinimumFare: 1000, Rounding: 1000}, 12500, 13000},
+		{"minimum fare", Config{MinimumFare: 1000, Rounding: 1000}, 200, 1000},
+		{"no rounding unit", Config{MinimumFare: 0, Rounding: 0}, 12345.6, 12346},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewEngine(tt.config).Round(tt.fare); got != tt.want {
+				t.Errorf("Round(%v) = %d, want %d", tt.fare, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUsesFuelPrice(t *testing.T) {
+	tests := map[string]bool{
+		"":                true,
+		StrategyFuel:      true,
+		StrategyPerKm:     false,
+		StrategyFlatPerKm: false,
+		StrategyElectric:  false,
+	}
+	for name, want := range tests {
+		if got := UsesFuelPrice(name); got != want {
+			t.Errorf("UsesFuelPrice(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package statemachine
+
+import (
+	"errors"
+	"fmt"
+)
+
+// Entity is the kind of record whose status is managed by the state machine
+type Entity string
+
+const (
+	EntityRideOffer   Entity = "ride_offer"
+	EntityRideRequest Entity = "ride_request"
+	EntityRide        Entity = "ride"
+	EntityTransaction Entity = "transaction"
+	EntitySafetyAlert Entity = "safety_alert"
+)
+
+// Statuses used by ride offers, ride requests, rides, transactions and safety alerts
+const (
+	StatusCreated   = "created"
+	StatusMatched   = "matched"
+	StatusScheduled = "scheduled"
+	StatusOngoing   = "ongoing"
+	StatusCompleted = "completed"
+	StatusCancelled = "cancelled"
+	StatusPending   = "pending"
+	StatusRefunded  = "refunded"
+	StatusOnHold    = "on_hold"
+	StatusExpired   = "expired"
+	StatusSafe      = "safe"
+	StatusEscalated = "escalated"
+	StatusResolved  = "resolved"
+)
+
+var (
+	ErrInvalidTransition = errors.New("invalid status transition")
+	ErrUnknownEntity     = errors.New("unknown entity")
+)
+
+// TransitionError describes a status change that is not allowed by the state machine
+type TransitionError struct {
+	Entity Entity
+	From   string
+	To     string
+}
+
+func (e *TransitionError) Error() string {
+	return fmt.Sprintf("%s cannot change status from %s to %s", e.Entity, e.From, e.To)
+}
+
+// Unwrap lets callers match any transition error with errors.Is(err, ErrInvalidTransition)
+func (e *TransitionError) Unwrap() error {
+	return ErrInvalidTransition
+}
+
+// transitions lists for every entity the statuses that can be reached from each status
+var transitions = map[Entity]map[string][]string{
+	// A ride offer stays created while it still has seats left, becomes matched once every seat is booked
+	// and goes back to created when a booked seat is released.
+	// Ride offers, ride requests and rides that are still waiting when their time window has passed are expired
+	EntityRideOffer: {
+		StatusCreated: {StatusMatched, StatusOngoing, StatusCancelled, StatusExpired},
+		StatusMatched: {StatusCreated, StatusOngoing, StatusCancelled, StatusExpired},
+		StatusOngoing: {StatusCompleted, StatusCancelled},
+	},
+	EntityRideRequest: {
+		StatusCreated: {StatusMatched, StatusCancelled, StatusExpired},
+		StatusMatched: {StatusCreated, StatusOngoing, StatusCancelled, StatusExpired},
+		StatusOngoing: {StatusCompleted, StatusCancelled},
+	},
+	EntityRide: {
+		StatusScheduled: {StatusOngoing, StatusCancelled, StatusExpired},
+		StatusOngoing:   {StatusCompleted, StatusCancelled},
+	},
+	// A MoMo payment is kept on hold when the driver cancels before the pickup, it goes back to pending
+	// once the ride request of the hitcher is matched again or is refunded when the ride request expires
+	EntityTransaction: {
+		StatusPending: {StatusCompleted, StatusRefunded, StatusOnHold},
+		StatusOnHold:  {StatusPending, StatusRefunded},
+	},
+	// A safety alert waits for the hitcher to answer, it is escalated to the admins when the hitcher
+	// is not safe or does not answer in time and an admin may resolve it at any point
+	EntitySafetyAlert: {
+		StatusPending:   {StatusSafe, StatusEscalated, StatusResolved},
+		StatusEscalated: {StatusResolved},
+	},
+}
+
+// CanTransition reports whether the entity is allowed to change from one status to another
+func CanTransition(entity Entity, from, to string) bool {
+	for _, next := range transitions[entity][from] {
+		if next == to {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate returns a *TransitionError if the status change is not allowed
+func Validate(entity Entity, from, to string) error {
+	if _, ok := transitions[entity]; !ok {
+		return fmt.Errorf("%w: %s", ErrUnknownEntity, entity)
+	}
+	if !CanTransition(entity, from, to) {
+		return &TransitionError{Entity: entity, From: from, To: to}
+	}
+	return nil
+}
+
+// IsFinal reports whether no more status changes are allowed from the given status
+func IsFinal(entity Entity, status string) bool {
+	return len(transitions[entity][status]) == 0
+}
@@ -12,6 +12,9 @@ import (
 	"github.com/o1egl/paseto"
 )
 
+// RideShareFooter is the footer of the read-only tokens used to follow a ride, it keeps them from being used as access tokens
+const RideShareFooter = "ride-share"
+
 // PasetoMaker is responsible for creating and verifying PASETO tokens
 type PasetoMaker struct {
 	paseto       *paseto.V2
@@ -56,12 +59,18 @@ func (maker *PasetoMaker) CreateToken(phoneNumber string, userID uuid.UUID, dura
 // VerifyToken checks if a token is valid and returns the payload
 func (maker *PasetoMaker) VerifyToken(token string) (*schemas.Payload, error) {
 	var payload schemas.Payload
+	var footer string
 
 	// Decrypt the token
-	if err := maker.paseto.Decrypt(token, maker.symmetricKey, &payload, nil); err != nil {
+	if err := maker.paseto.Decrypt(token, maker.symmetricKey, &payload, &footer); err != nil {
 		return nil, fmt.Errorf("invalid token: %w", err)
 	}
 
+	// Access tokens have no footer
+	if footer != "" {
+		return nil, ErrInvalidToken
+	}
+
 	// Validate the payload
 	if err := ValidatePayload(&payload); err != nil {
 		return nil, fmt.Errorf("invalid payload: %w", err)
@@ -69,3 +78,42 @@ func (maker *PasetoMaker) VerifyToken(token string) (*schemas.Payload, error) {
 
 	return &payload, nil
 }
+
+// CreateRideShareToken generates a read-only token to follow a ride for the given duration
+func (maker *PasetoMaker) CreateRideShareToken(rideID, sharedBy uuid.UUID, duration time.Duration) (string, *schemas.RideSharePayload, error) {
+	// Create a new payload
+	payload, err := NewRideSharePayload(rideID, sharedBy, duration)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create payload: %w", err)
+	}
+
+	// Encrypt the payload with the ride share footer and return the token
+	token, err := maker.paseto.Encrypt(maker.symmetricKey, payload, RideShareFooter)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return token, payload, nil
+}
+
+// VerifyRideShareToken checks if a ride share token is valid and returns the payload
+func (maker *PasetoMaker) VerifyRideShareToken(token string) (*schemas.RideSharePayload, error) {
+	var payload schemas.RideSharePayload
+	var footer string
+
+	// Decrypt the token
+	if err := maker.paseto.Decrypt(token, maker.symmetricKey, &payload, &footer); err != nil {
+		return nil, fmt.Errorf("invalid token: %w", err)
+	}
+
+	if footer != RideShareFooter {
+		return nil, ErrInvalidToken
+	}
+
+	// Validate the payload
+	if payload.ExpiredAt.Before(time.Now().UTC()) {
+		return nil, ErrExpiredToken
+	}
+
+	return &payload, nil
+}
@@ -12,6 +12,7 @@ import (
 
 var (
 	ErrExpiredToken = errors.New("token has expired")
+	ErrInvalidToken = errors.New("token is invalid")
 )
 
 // NewPayload creates a new token payload
@@ -47,3 +48,22 @@ func ValidatePayload(payload *schemas.Payload) error {
 	}
 	return nil
 }
+
+// NewRideSharePayload creates a new payload for a read-only token to follow a ride
+func NewRideSharePayload(rideID, sharedBy uuid.UUID, duration time.Duration) (*schemas.RideSharePayload, error) {
+	// Generate a new random UUID for the token
+	tokenID, err := uuid.NewRandom()
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate token ID: %w", err)
+	}
+
+	now := time.Now().UTC()
+
+	return &schemas.RideSharePayload{
+		ID:        tokenID,
+		RideID:    rideID,
+		SharedBy:  sharedBy,
+		CreatedAt: now,
+		ExpiredAt: now.Add(duration),
+	}, nil
+}